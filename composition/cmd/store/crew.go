@@ -0,0 +1,135 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// certification reads ROLE:LICENCE:CAPACITY:EXPIRES, where ROLE is captain
+// or officer and the expiry comes last so that it may hold a time of day.
+func certification(text string) (store.Certification, error) {
+	parts := strings.SplitN(text, ":", 4)
+	if len(parts) != 4 {
+		return store.Certification{}, fmt.Errorf("-cert must be ROLE:LICENCE:CAPACITY:EXPIRES, not %q", text)
+	}
+	cert := store.Certification{ Licence: parts[1] }
+	switch strings.ToLower(parts[0]) {
+	case "captain":
+		cert.Role = store.Captain
+	case "officer", "first-officer":
+		cert.Role = store.FirstOfficer
+	default:
+		return cert, fmt.Errorf("%q is not a role; use captain or officer", parts[0])
+	}
+	capacity, err := strconv.Atoi(parts[2])
+	if err != nil || capacity < 1 {
+		return cert, fmt.Errorf("%q is not a number of people", parts[2])
+	}
+	cert.MaxCapacity = capacity
+	if cert.Expires, err = parseTime("cert", parts[3]); err != nil {
+		return cert, err
+	}
+	return cert, nil
+}
+
+func addCrew(app *app, args []string) error {
+	id, args, err := positional(args, "ID")
+	if err != nil {
+		return err
+	}
+	set := flags("crew add")
+	name := set.String("name", "", "name of the crew member")
+	certs := []store.Certification{}
+	set.Func("cert", "ROLE:LICENCE:CAPACITY:EXPIRES, once for each certification", func(text string) error {
+		cert, err := certification(text)
+		certs = append(certs, cert)
+		return err
+	})
+	set.Parse(args)
+	if err := required(set, "name"); err != nil {
+		return err
+	}
+	if err = app.data.Crew.Add(id, *name, certs...); err != nil {
+		return err
+	}
+	return app.showCrew(id)
+}
+
+func certifyCrew(app *app, args []string) error {
+	id, args, err := positional(args, "ID")
+	if err != nil {
+		return err
+	}
+	set := flags("crew certify")
+	text := set.String("cert", "", "ROLE:LICENCE:CAPACITY:EXPIRES")
+	set.Parse(args)
+	if err := required(set, "cert"); err != nil {
+		return err
+	}
+	cert, err := certification(*text)
+	if err != nil {
+		return err
+	}
+	if err = app.data.Crew.Certify(id, cert); err != nil {
+		return err
+	}
+	return app.showCrew(id)
+}
+
+func crewLeave(app *app, args []string) error {
+	id, args, err := positional(args, "ID")
+	if err != nil {
+		return err
+	}
+	set := flags("crew leave")
+	startText := set.String("start", "", "start of the time off")
+	endText := set.String("end", "", "end of the time off")
+	set.Parse(args)
+	if err := required(set, "start", "end"); err != nil {
+		return err
+	}
+	start, err := parseTime("start", *startText)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime("end", *endText)
+	if err != nil {
+		return err
+	}
+	return app.data.Crew.SetUnavailable(id, start, end)
+}
+
+func listCrew(app *app, args []string) error {
+	flags("crew list").Parse(args)
+	return app.showCrew("")
+}
+
+// showCrew prints the member with id, or every member if id is empty.
+func (app *app) showCrew(id string) error {
+	members := []store.CrewMember{}
+	for _, member := range app.data.Crew.Members() {
+		if id == "" || member.ID == id {
+			members = append(members, member)
+		}
+	}
+	if app.output == "json" {
+		return printJSON(members)
+	}
+	rows := [][]string{}
+	for _, member := range members {
+		if len(member.Certifications) == 0 {
+			rows = append(rows, []string{ member.ID, member.Name, "", "", "", "" })
+		}
+		for i, cert := range member.Certifications {
+			row := []string{ "", "", string(cert.Role), cert.Licence, strconv.Itoa(cert.MaxCapacity),
+				cert.Expires.Local().Format("2006-01-02") }
+			if i == 0 {
+				row[0], row[1] = member.ID, member.Name
+			}
+			rows = append(rows, row)
+		}
+	}
+	return printTable([]string{ "ID", "NAME", "ROLE", "LICENCE", "CAPACITY", "EXPIRES" }, rows)
+}
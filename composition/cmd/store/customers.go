@@ -0,0 +1,208 @@
+package main
+
+import (
+	"composition/store"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// addressFlags reads one address from -address, given once for each line,
+// -country and -region. The address is nil unless a line was given.
+func addressFlags(set *flag.FlagSet) func() []store.Address {
+	address := store.Address{}
+	set.Func("address", "a line of the address, once for each line", func(line string) error {
+		address.Lines = append(address.Lines, line)
+		return nil
+	})
+	set.StringVar(&address.Country, "country", "", "country of the address")
+	set.StringVar(&address.Region, "region", "", "region or state of the address")
+	return func() []store.Address {
+		if len(address.Lines) == 0 {
+			return nil
+		}
+		return []store.Address{ address }
+	}
+}
+
+func addCustomer(app *app, args []string) error {
+	set := flags("customer add")
+	name := set.String("name", "", "customer's name")
+	email := set.String("email", "", "email address, which must not have an account already")
+	addresses := addressFlags(set)
+	set.Parse(args)
+	if err := required(set, "name", "email"); err != nil {
+		return err
+	}
+	customer, err := app.data.Customers.Register(*name, *email, addresses()...)
+	if err != nil {
+		return err
+	}
+	return app.showCustomers([]store.Customer{ customer })
+}
+
+func updateCustomer(app *app, args []string) error {
+	id, args, err := positional(args, "customer ID")
+	if err != nil {
+		return err
+	}
+	customer, found := app.data.Customers.Get(id)
+	if !found {
+		return fmt.Errorf("no customer %v", id)
+	}
+	set := flags("customer update")
+	set.StringVar(&customer.Name, "name", customer.Name, "customer's name")
+	set.StringVar(&customer.Email, "email", customer.Email, "email address")
+	addresses := addressFlags(set)
+	set.Parse(args)
+	if given := addresses(); given != nil {
+		customer.Addresses = given
+	}
+	if customer, err = app.data.Customers.Update(id, customer.Name, customer.Email, customer.Addresses...); err != nil {
+		return err
+	}
+	return app.showCustomers([]store.Customer{ customer })
+}
+
+func listCustomers(app *app, args []string) error {
+	set := flags("customer list")
+	tier := set.String("tier", "", "only customers in this tier")
+	set.Parse(args)
+	customers := []store.Customer{}
+	for _, customer := range app.data.Customers.List() {
+		if *tier == "" || strings.EqualFold(customer.Tier, *tier) {
+			customers = append(customers, customer)
+		}
+	}
+	return app.showCustomers(customers)
+}
+
+func (app *app) showCustomers(customers []store.Customer) error {
+	if app.output == "json" {
+		return printJSON(customers)
+	}
+	rows := [][]string{}
+	for _, customer := range customers {
+		rows = append(rows, []string{ customer.ID, customer.Name, customer.Email, customer.Tier,
+			customer.Spend.String(), strconv.Itoa(customer.Points), strconv.Itoa(len(customer.Orders)) })
+	}
+	return printTable([]string{ "ID", "NAME", "EMAIL", "TIER", "SPEND", "POINTS", "ORDERS" }, rows)
+}
+
+// showCustomer prints an account with its addresses, orders and the history
+// of its points.
+func showCustomer(app *app, args []string) error {
+	id, args, err := positional(args, "customer ID")
+	if err != nil {
+		return err
+	}
+	flags("customer show").Parse(args)
+	customer, found := app.data.Customers.Get(id)
+	if !found {
+		return fmt.Errorf("no customer %v", id)
+	}
+	if app.output == "json" {
+		return printJSON(customer)
+	}
+	fmt.Fprintf(stdout, "%v  %v <%v>\n", customer.ID, customer.Name, customer.Email)
+	fmt.Fprintf(stdout, "Joined %v, %v tier, %v spent, %v points\n", customer.Joined.Local().Format("2006-01-02"),
+		customer.Tier, customer.Spend, customer.Points)
+	for _, address := range customer.Addresses {
+		fmt.Fprintf(stdout, "Address: %v, %v\n", strings.Join(address.Lines, ", "),
+			strings.Trim(address.Country + " " + address.Region, " "))
+	}
+	if len(customer.Orders) > 0 {
+		fmt.Fprintln(stdout, "Orders:", strings.Join(customer.Orders, ", "))
+	}
+	fmt.Fprintln(stdout)
+	rows := [][]string{}
+	for _, entry := range customer.Ledger {
+		expires := ""
+		if entry.Expires != nil {
+			expires = entry.Expires.Local().Format("2006-01-02")
+		}
+		rows = append(rows, []string{ entry.Time.Local().Format("2006-01-02 15:04"), string(entry.Kind),
+			strconv.Itoa(entry.Points), entry.Order, expires })
+	}
+	return printTable([]string{ "TIME", "KIND", "POINTS", "ORDER", "EXPIRES" }, rows)
+}
+
+func showLoyalty(app *app, args []string) error {
+	flags("loyalty show").Parse(args)
+	program := app.data.Customers.Program()
+	if app.output == "json" {
+		return printJSON(program)
+	}
+	expiry := "never"
+	if program.Expiry > 0 {
+		expiry = fmt.Sprintf("after %v days", int(program.Expiry / store.Day))
+	}
+	fmt.Fprintf(stdout, "Points: %v%% of spend before tax, worth 1 minor unit of %v each, expiring %v\n\n",
+		strconv.FormatFloat(program.EarnRate * 100, 'f', -1, 64), program.Currency, expiry)
+	rows := [][]string{}
+	for _, tier := range program.Tiers {
+		rows = append(rows, []string{ tier.Name, tier.MinSpend.String(),
+			strconv.FormatFloat(tier.Discount * 100, 'f', -1, 64) + "%" })
+	}
+	return printTable([]string{ "TIER", "FROM SPEND", "DISCOUNT" }, rows)
+}
+
+// setLoyalty changes the loyalty program. Tiers are given as NAME:SPEND:RATE,
+// such as "Silver:1000:0.05", and replace all the tiers when any is given.
+func setLoyalty(app *app, args []string) error {
+	program := app.data.Customers.Program()
+	set := flags("loyalty set")
+	set.StringVar(&program.Currency, "currency", program.Currency, "currency points and spend are counted in")
+	set.Float64Var(&program.EarnRate, "earn", program.EarnRate, "share of the spend before tax given back in points")
+	days := set.Int("expiry-days", int(program.Expiry / store.Day), "days points last, or 0 to keep them for good")
+	tiers := []string{}
+	set.Func("tier", "NAME:SPEND:RATE, once for each tier", func(text string) error {
+		tiers = append(tiers, text)
+		return nil
+	})
+	set.Parse(args)
+	program.Currency = strings.ToUpper(program.Currency)
+	program.Expiry = time.Duration(*days) * store.Day
+	if len(tiers) > 0 {
+		program.Tiers = []store.LoyaltyTier{}
+	}
+	for _, text := range tiers {
+		parts := strings.Split(text, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("-tier must be NAME:SPEND:RATE, not %q", text)
+		}
+		spend, err := store.ParseAmount(parts[1], program.Currency)
+		if err != nil {
+			return err
+		}
+		rate, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a rate", parts[2])
+		}
+		program.Tiers = append(program.Tiers, store.LoyaltyTier{ Name: parts[0], MinSpend: spend, Discount: rate })
+	}
+	if err := app.data.Customers.SetProgram(program); err != nil {
+		return err
+	}
+	return showLoyalty(app, nil)
+}
+
+// expirePoints takes away points whose time is up, as the storefront does
+// hourly.
+func expirePoints(app *app, args []string) error {
+	flags("loyalty expire").Parse(args)
+	expired, err := app.data.Customers.Expire()
+	if err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(expired)
+	}
+	rows := [][]string{}
+	for _, entry := range expired {
+		rows = append(rows, []string{ entry.Customer, strconv.Itoa(entry.Points) })
+	}
+	return printTable([]string{ "CUSTOMER", "EXPIRED" }, rows)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func createDeal(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("deal create")
+	name := set.String("name", "", "name of the deal")
+	off := set.String("off", "", "amount off each unit")
+	percent := set.Float64("percent", 0, "percentage off the price")
+	buy := set.Int("buy", 0, "units paid for in a buy x get y offer")
+	free := set.Int("free", 0, "units given free in a buy x get y offer")
+	floor := set.String("floor", "", "lowest unit price the deal may reach")
+	exclusive := set.Bool("exclusive", false, "use the best single discount instead of stacking them")
+	from := set.String("from", "", "time the discounts start")
+	until := set.String("until", "", "time the discounts end")
+	set.Parse(args)
+	if err := required(set, "name"); err != nil {
+		return err
+	}
+	item, found := app.data.Catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("no product with SKU %v", sku)
+	}
+	currency := item.BaseProduct().Price(store.NoTax, store.Location{}).Currency()
+
+	discounts := []store.Discount{}
+	if *off != "" {
+		amount, err := store.ParseAmount(*off, currency)
+		if err != nil {
+			return err
+		}
+		discounts = append(discounts, store.FixedAmount{ Label: *name, Off: amount })
+	}
+	if *percent != 0 {
+		if *percent < 0 || *percent > 100 {
+			return fmt.Errorf("-percent must be between 0 and 100")
+		}
+		discounts = append(discounts, store.Percentage{ Label: fmt.Sprintf("%v %v%%", *name, *percent), Rate: *percent / 100 })
+	}
+	if *buy != 0 || *free != 0 {
+		if *buy < 1 || *free < 1 {
+			return fmt.Errorf("-buy and -free must both be at least 1")
+		}
+		discounts = append(discounts, store.BuyXGetY{ Label: fmt.Sprintf("Buy %v get %v free", *buy, *free),
+			Buy: *buy, Free: *free })
+	}
+	if len(discounts) == 0 {
+		return fmt.Errorf("give at least one of -off, -percent or -buy and -free")
+	}
+	var start, end time.Time
+	if *from != "" {
+		if start, err = parseTime("from", *from); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if end, err = parseTime("until", *until); err != nil {
+			return err
+		}
+	}
+
+	stacking := store.Stackable
+	if *exclusive {
+		stacking = store.Exclusive
+	}
+	deal, err := store.NewDiscountDeal(*name, item.BaseProduct())
+	if err != nil {
+		return err
+	}
+	for _, d := range discounts {
+		if !start.IsZero() || !end.IsZero() {
+			d = store.ValidBetween(d, start, end)
+		}
+		if err = deal.AddDiscount(d, stacking); err != nil {
+			return err
+		}
+	}
+	if *floor != "" {
+		amount, err := store.ParseAmount(*floor, currency)
+		if err != nil {
+			return err
+		}
+		if err = deal.SetFloor(amount); err != nil {
+			return err
+		}
+	}
+	if err = app.data.Deals.Put(sku, deal); err != nil {
+		return err
+	}
+	return app.showDeals(store.DealEntry{ SKU: sku, Deal: deal })
+}
+
+func listDeals(app *app, args []string) error {
+	flags("deal list").Parse(args)
+	return app.showDeals(app.data.Deals.Entries()...)
+}
+
+func deleteDeal(app *app, args []string) error {
+	sku, _, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	return app.data.Deals.Remove(sku)
+}
+
+func (app *app) showDeals(entries ...store.DealEntry) error {
+	if app.output == "json" {
+		return printJSON(entries)
+	}
+	rows := [][]string{}
+	for _, entry := range entries {
+		price, applied, err := entry.Deal.Quote(time.Now(), 1)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, d := range applied {
+			names = append(names, d.Name)
+		}
+		rows = append(rows, []string{ entry.SKU, entry.Deal.Name,
+			entry.Deal.Product.Price(store.NoTax, store.Location{}).String(), price.String(), strings.Join(names, ", ") })
+	}
+	return printTable([]string{ "SKU", "DEAL", "PRICE", "DEAL PRICE", "DISCOUNTS NOW" }, rows)
+}
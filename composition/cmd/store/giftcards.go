@@ -0,0 +1,176 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func issueGiftCard(app *app, args []string) error {
+	set := flags("giftcard issue")
+	amount := set.String("amount", "", "value of the card, such as $50")
+	expires := set.String("expires", "", "time the card expires, never if not given")
+	note := set.String("note", "", "note kept with the card")
+	set.Parse(args)
+	if err := required(set, "amount"); err != nil {
+		return err
+	}
+	value, err := store.ParseMoney(*amount)
+	if err != nil {
+		return err
+	}
+	var until *time.Time
+	if *expires != "" {
+		at, err := parseTime("expires", *expires)
+		if err != nil {
+			return err
+		}
+		until = &at
+	}
+	card, err := app.data.GiftCards.Issue(value, until, *note)
+	if err != nil {
+		return err
+	}
+	return app.showGiftCards([]store.GiftCard{ card })
+}
+
+func issueStoreCredit(app *app, args []string) error {
+	customer, args, err := positional(args, "customer ID")
+	if err != nil {
+		return err
+	}
+	set := flags("giftcard credit")
+	amount := set.String("amount", "", "credit to give, such as $25")
+	note := set.String("note", "", "reason for the credit")
+	set.Parse(args)
+	if err := required(set, "amount"); err != nil {
+		return err
+	}
+	if _, found := app.data.Customers.Get(customer); !found {
+		return fmt.Errorf("no customer %v", customer)
+	}
+	value, err := store.ParseMoney(*amount)
+	if err != nil {
+		return err
+	}
+	card, err := app.data.GiftCards.IssueCredit(customer, value, *note)
+	if err != nil {
+		return err
+	}
+	return app.showGiftCards([]store.GiftCard{ card })
+}
+
+// listGiftCards masks the codes, which are only shown in full when a card
+// is issued.
+func listGiftCards(app *app, args []string) error {
+	set := flags("giftcard list")
+	customer := set.String("customer", "", "only store credit of this customer")
+	set.Parse(args)
+	cards := []store.GiftCard{}
+	for _, card := range app.data.GiftCards.List() {
+		if *customer == "" || card.Customer == *customer {
+			card.Code = store.MaskGiftCardCode(card.Code)
+			cards = append(cards, card)
+		}
+	}
+	return app.showGiftCards(cards)
+}
+
+func (app *app) showGiftCards(cards []store.GiftCard) error {
+	if app.output == "json" {
+		return printJSON(cards)
+	}
+	rows := [][]string{}
+	for _, card := range cards {
+		expires := "never"
+		if card.Expires != nil {
+			expires = card.Expires.Local().Format("2006-01-02")
+		}
+		rows = append(rows, []string{ card.Code, card.Initial.String(), card.Balance.String(),
+			card.Issued.Local().Format("2006-01-02"), expires, card.Customer, card.Note })
+	}
+	return printTable([]string{ "CODE", "INITIAL", "BALANCE", "ISSUED", "EXPIRES", "CUSTOMER", "NOTE" }, rows)
+}
+
+// showGiftCard answers a balance inquiry, listing the card's movements.
+func showGiftCard(app *app, args []string) error {
+	code, args, err := positional(args, "gift card code")
+	if err != nil {
+		return err
+	}
+	flags("giftcard show").Parse(args)
+	card, found := app.data.GiftCards.Get(code)
+	if !found {
+		return fmt.Errorf("no gift card %v", code)
+	}
+	movements := app.data.GiftCards.Movements(card.Code)
+	if app.output == "json" {
+		return printJSON(map[string]interface{}{ "card": card, "movements": movements })
+	}
+	state := "balance"
+	if card.Expired(time.Now()) {
+		state = "expired, balance"
+	}
+	fmt.Fprintf(stdout, "%v  %v %v of %v\n\n", card.Code, state, card.Balance, card.Initial)
+	return printMovements(movements)
+}
+
+func printMovements(movements []store.GiftCardPosting) error {
+	rows := [][]string{}
+	for _, posting := range movements {
+		rows = append(rows, []string{ strconv.Itoa(posting.Entry), posting.Time.Local().Format("2006-01-02 15:04"),
+			string(posting.Movement), posting.Account, posting.Amount.String(), posting.Order })
+	}
+	return printTable([]string{ "ENTRY", "TIME", "MOVEMENT", "ACCOUNT", "AMOUNT", "ORDER" }, rows)
+}
+
+// giftCardReport reconciles the ledger, failing if it does not balance.
+func giftCardReport(app *app, args []string) error {
+	set := flags("giftcard report")
+	ledger := set.Bool("ledger", false, "list every posting as well")
+	set.Parse(args)
+	report := app.data.GiftCards.Reconcile()
+	if app.output == "json" {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		rows := [][]string{}
+		for _, r := range report {
+			rows = append(rows, []string{ r.Currency, r.Issued.String(), r.Refunded.String(), r.Redeemed.String(),
+				r.Expired.String(), r.Outstanding.String(), r.Difference.String() })
+		}
+		header := []string{ "CURRENCY", "ISSUED", "REFUNDED", "REDEEMED", "EXPIRED", "OUTSTANDING", "DIFFERENCE" }
+		if err := printTable(header, rows); err != nil {
+			return err
+		}
+		if *ledger {
+			fmt.Fprintln(stdout)
+			if err := printMovements(app.data.GiftCards.Movements("")); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range report {
+		if !r.Balanced() {
+			return fmt.Errorf("the %v ledger does not balance; cards out: %v", r.Currency, strings.Join(r.Mismatched, ", "))
+		}
+	}
+	return nil
+}
+
+// expireGiftCards writes off the balance of cards whose time is up, as the
+// storefront does hourly.
+func expireGiftCards(app *app, args []string) error {
+	flags("giftcard expire").Parse(args)
+	expired, err := app.data.GiftCards.Expire()
+	if err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(expired)
+	}
+	return printMovements(expired)
+}
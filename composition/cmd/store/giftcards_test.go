@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"composition/store"
+	"strings"
+	"testing"
+)
+
+// run runs a command against data and returns what it printed.
+func run(t *testing.T, data *store.DataDir, group, action string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	previous := stdout
+	stdout = &out
+	defer func() { stdout = previous }()
+	for _, c := range commands {
+		if c.group == group && c.action == action {
+			err := c.run(&app{ data, "table" }, args)
+			return out.String(), err
+		}
+	}
+	t.Fatalf("no command %v %v", group, action)
+	return "", nil
+}
+
+func TestGiftCardCommands(t *testing.T) {
+	data, err := store.OpenDataDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, data, "giftcard", "issue", "-amount", "$50", "-note", "Raffle"); err != nil {
+		t.Fatal(err)
+	}
+	code := data.GiftCards.List()[0].Code
+	masked := store.MaskGiftCardCode(code)
+	tests := []struct {
+		args []string
+		shows, hides string
+	}{
+		{ []string{ "giftcard", "list" }, masked, code },
+		{ []string{ "giftcard", "show", strings.ToLower(code) }, "$50.00 of $50.00", "" },
+		{ []string{ "giftcard", "report" }, "REFUNDED", "" },
+	}
+	for _, test := range tests {
+		out, err := run(t, data, test.args[0], test.args[1], test.args[2:]...)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if !strings.Contains(out, test.shows) || (test.hides != "" && strings.Contains(out, test.hides)) {
+			t.Errorf("%v printed %q, want %q without %q", test.args, out, test.shows, test.hides)
+		}
+	}
+}
+
+func TestIssueShowsFullCode(t *testing.T) {
+	data, err := store.OpenDataDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer, err := data.Customers.Register("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		action string
+		args []string
+	}{
+		{ "issue", []string{ "-amount", "$25" } },
+		{ "credit", []string{ customer.ID, "-amount", "$10" } },
+	}
+	for i, test := range tests {
+		out, err := run(t, data, "giftcard", test.action, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := data.GiftCards.List()[i].Code; !strings.Contains(out, code) {
+			t.Errorf("giftcard %v printed %q, want the code %v", test.action, out, code)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"composition/store"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func setSeller(app *app, args []string) error {
+	seller := app.data.Invoices.Seller()
+	set := flags("invoice seller")
+	set.StringVar(&seller.Name, "name", seller.Name, "name of the business")
+	set.StringVar(&seller.TaxID, "tax-id", seller.TaxID, "VAT or other tax number")
+	set.StringVar(&seller.Email, "email", seller.Email, "email address")
+	address := []string{}
+	set.Func("address", "a line of the address, once for each line", func(line string) error {
+		address = append(address, line)
+		return nil
+	})
+	set.Parse(args)
+	if len(address) > 0 {
+		seller.Address = address
+	}
+	if err := app.data.Invoices.SetSeller(seller); err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(seller)
+	}
+	fmt.Fprintln(stdout, strings.Join(append(append([]string{ seller.Name }, seller.Address...), seller.TaxID, seller.Email), "\n"))
+	return nil
+}
+
+func listInvoices(app *app, args []string) error {
+	set := flags("invoice list")
+	order := set.String("order", "", "only documents for this order")
+	set.Parse(args)
+	invoices := []*store.Invoice{}
+	for _, invoice := range app.data.Invoices.List() {
+		if *order == "" || invoice.Order == *order {
+			invoices = append(invoices, invoice)
+		}
+	}
+	if app.output == "json" {
+		return printJSON(invoices)
+	}
+	rows := [][]string{}
+	for _, invoice := range invoices {
+		rows = append(rows, []string{ invoice.Number, string(invoice.Kind), invoice.Issued.Local().Format("2006-01-02"),
+			invoice.Order, invoice.Original, invoice.Customer.Name, invoice.Total.String() })
+	}
+	return printTable([]string{ "NUMBER", "KIND", "ISSUED", "ORDER", "REFUNDS", "CUSTOMER", "TOTAL" }, rows)
+}
+
+// showInvoice prints an invoice or credit note, or writes it to a file.
+func showInvoice(app *app, args []string) error {
+	number, args, err := positional(args, "invoice number")
+	if err != nil {
+		return err
+	}
+	set := flags("invoice show")
+	format := set.String("format", "text", "text, html, pdf or json")
+	path := set.String("out", "", "file to write to instead of the standard output")
+	set.Parse(args)
+	invoice, found := app.data.Invoices.Get(number)
+	if !found {
+		return fmt.Errorf("no invoice %v", number)
+	}
+	var out io.Writer = stdout
+	if *path != "" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	switch *format {
+	case "text":
+		return invoice.WriteText(out)
+	case "html":
+		return invoice.WriteHTML(out)
+	case "pdf":
+		return invoice.WritePDF(out)
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(invoice)
+	}
+	return fmt.Errorf("unknown format %q, use text, html, pdf or json", *format)
+}
+
+// creditInvoice refunds lines of an invoice, or all of it when no lines are
+// given.
+func creditInvoice(app *app, args []string) error {
+	number, args, err := positional(args, "invoice number")
+	if err != nil {
+		return err
+	}
+	set := flags("invoice credit")
+	reason := set.String("reason", "", "why the refund is given")
+	lines := []store.CreditLine{}
+	set.Func("line", "SKU:quantity to refund, once for each line", func(text string) error {
+		sku, quantity, _ := strings.Cut(text, ":")
+		n, err := strconv.Atoi(quantity)
+		if err != nil {
+			return fmt.Errorf("use SKU:quantity, not %q", text)
+		}
+		lines = append(lines, store.CreditLine{ SKU: strings.TrimSpace(sku), Quantity: n })
+		return nil
+	})
+	set.Parse(args)
+	if err := required(set, "reason"); err != nil {
+		return err
+	}
+	note, err := app.data.Invoices.Credit(number, *reason, lines...)
+	if err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(note)
+	}
+	return note.WriteText(stdout)
+}
@@ -0,0 +1,162 @@
+// Command store manages the shop data used by the storefront service.
+//
+//	store [-data dir] [-output table|json] <command> <action> [arguments] [flags]
+//
+// Commands that act on one item take its SKU, or a booking ID, as the first
+// argument, for example:
+//
+//	store product add KAY-1 -name Kayak -category Watersports -price 279
+//	store product update KAY-1 -price 299
+//	store product add LIF -name Lifejacket -price 45 -axes size,colour
+//	store product variant LIF -sku LIF-M-RED -option size=M -option colour=red -delta 5
+//	store price schedule KAY-1 -price 289 -from 2024-07-01
+//	store rate set USD EUR -rate 0.92 -from 2024-07-01
+//	store deal create KAY-1 -name "Kayak Saver" -off 25
+//	store invoice show INV-000001 -format pdf -out INV-000001.pdf
+//	store customer add -name "Ann Lee" -email ann@example.com -address "1 Quay St" -country GB
+//	store giftcard issue -amount '$50' -expires 2025-12-31
+//	store stock receive KAY-1 -quantity 5 -location Warehouse
+//	store supplier offer ACME -sku KAY-1 -cost '$180' -lead-days 5 -min 4
+//	store loyalty set -earn 0.05 -expiry-days 365 -tier Bronze:0:0 -tier Silver:1000:0.05
+//	store crew add C1 -name Bob -cert captain:YM-101:8:2026-12-31
+//	store boat list -available -start 2024-06-01T09:00:00Z -end 2024-06-02T09:00:00Z
+//	store rental book RENT-2 -customer Alice -start 2024-06-01T09:00:00Z -end 2024-06-03T09:00:00Z
+package main
+
+import (
+	"composition/store"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type command struct {
+	group, action, usage string
+	run func(app *app, args []string) error
+}
+
+var commands = []command{
+	{ "product", "add", "SKU: add a product, boat or rental boat", addProduct },
+	{ "product", "list", "list catalog items", listProducts },
+	{ "product", "update", "SKU: change fields of a catalog item", updateProduct },
+	{ "product", "delete", "SKU: remove a catalog item", deleteProduct },
+	{ "product", "variant", "SKU: add a variant to a product with option axes", addVariant },
+	{ "deal", "create", "SKU: put a deal on a catalog item", createDeal },
+	{ "deal", "list", "list deals", listDeals },
+	{ "deal", "delete", "SKU: remove the deal on a catalog item", deleteDeal },
+	{ "price", "schedule", "SKU: change a price now or from a later time", schedulePrice },
+	{ "price", "cancel", "SKU: cancel a scheduled price change", cancelPrice },
+	{ "price", "history", "SKU: list the prices of a catalog item", priceHistory },
+	{ "price", "report", "list price changes in a period by category", priceReport },
+	{ "rate", "set", "FROM TO: set an exchange rate", setRate },
+	{ "rate", "list", "list exchange rates", listRates },
+	{ "rate", "convert", "AMOUNT or SKU: convert into another currency", convertAmount },
+	{ "invoice", "seller", "set the seller's details printed on invoices", setSeller },
+	{ "invoice", "list", "list invoices and credit notes", listInvoices },
+	{ "invoice", "show", "NUMBER: print an invoice as text, HTML, PDF or JSON", showInvoice },
+	{ "invoice", "credit", "NUMBER: refund lines of an invoice with a credit note", creditInvoice },
+	{ "customer", "add", "open a customer account", addCustomer },
+	{ "customer", "update", "ID: change a customer's name, email or address", updateCustomer },
+	{ "customer", "list", "list customer accounts", listCustomers },
+	{ "customer", "show", "ID: show an account with its orders and points", showCustomer },
+	{ "loyalty", "show", "show the loyalty program", showLoyalty },
+	{ "loyalty", "set", "change points, expiry and tiers of the loyalty program", setLoyalty },
+	{ "loyalty", "expire", "take away points whose time is up", expirePoints },
+	{ "giftcard", "issue", "issue a gift card", issueGiftCard },
+	{ "giftcard", "credit", "CUSTOMER: give a customer store credit", issueStoreCredit },
+	{ "giftcard", "list", "list gift cards and store credit", listGiftCards },
+	{ "giftcard", "show", "CODE: show the balance and movements of a gift card", showGiftCard },
+	{ "giftcard", "report", "reconcile the gift card ledger", giftCardReport },
+	{ "giftcard", "expire", "write off the balance of expired gift cards", expireGiftCards },
+	{ "stock", "receive", "SKU: book stock in at a location, or write it off", receiveStock },
+	{ "stock", "list", "list stock on hand and reserved", listStock },
+	{ "stock", "threshold", "SKU: set the level at which stock is low", setStockThreshold },
+	{ "stock", "alternate", "SKU: name the SKU to suggest when one runs out", setStockAlternate },
+	{ "stock", "reorder", "SKU: set when and how much of a SKU to order", setReorderPolicy },
+	{ "supplier", "add", "ID: add or change a supplier", addSupplier },
+	{ "supplier", "offer", "ID: record what a supplier charges for a SKU", addOffer },
+	{ "supplier", "list", "list suppliers, or the offers for a SKU", listSuppliers },
+	{ "purchase", "replenish", "order the SKUs at their reorder point", replenish },
+	{ "purchase", "list", "list purchase orders", listPurchaseOrders },
+	{ "purchase", "receive", "NUMBER: book the goods on a purchase order into stock", receivePurchaseOrder },
+	{ "purchase", "cancel", "NUMBER: cancel an open purchase order", cancelPurchaseOrder },
+	{ "boat", "list", "list boats, or rental boats free for a period", listBoats },
+	{ "crew", "add", "ID: add a crew member for rentals that include crew", addCrew },
+	{ "crew", "certify", "ID: add a certification to a crew member", certifyCrew },
+	{ "crew", "leave", "ID: mark a crew member unavailable for a period", crewLeave },
+	{ "crew", "list", "list crew and their certifications", listCrew },
+	{ "rental", "rates", "SKU: set the rates for a rental boat", setRates },
+	{ "rental", "book", "SKU: book a rental boat", bookRental },
+	{ "rental", "cancel", "ID: cancel a booking", cancelRental },
+	{ "rental", "list", "list bookings", listBookings },
+}
+
+type app struct {
+	data *store.DataDir
+	output string
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: store [-data dir] [-output table|json] <command> <action> [arguments] [flags]")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8v %-7v %v\n", c.group, c.action, c.usage)
+	}
+}
+
+func main() {
+	dir := flag.String("data", ".", "directory holding the shop data")
+	output := flag.String("output", "table", "output format, table or json")
+	flag.Usage = usage
+	flag.Parse()
+	if *output != "table" && *output != "json" {
+		fail(fmt.Errorf("unknown output format %q", *output))
+	}
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.group == args[0] && c.action == args[1] {
+			data, err := store.OpenDataDir(*dir)
+			if err != nil {
+				fail(err)
+			}
+			if err = c.run(&app{ data, *output }, args[2:]); err != nil {
+				fail(err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "store: unknown command %v %v\n", args[0], args[1])
+	usage()
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "store:", strings.TrimPrefix(err.Error(), "store: "))
+	os.Exit(1)
+}
+
+// flags makes the flag set for a command; parse errors end the program.
+func flags(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
+
+// positional takes the first argument, which must come before any flags.
+func positional(args []string, name string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("a %v is required before the flags", name)
+	}
+	return args[0], args[1:], nil
+}
+
+func required(set *flag.FlagSet, names ...string) error {
+	for _, name := range names {
+		if set.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("-%v is required", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"composition/store"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stdout is where commands print what they show.
+var stdout io.Writer = os.Stdout
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func printTable(header []string, rows [][]string) error {
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+// optional prints an amount that may not have been set, such as a rate a
+// rental does not offer, as a dash rather than a bare 0.00.
+func optional(amount store.Money) string {
+	if amount.Currency() == "" {
+		return "—"
+	}
+	return amount.String()
+}
+
+// timeLayouts are the formats accepted for times on the command line; those
+// without a zone are read as local time.
+var timeLayouts = []string{ time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02" }
+
+func parseTime(name, text string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("-%v must be a time such as 2024-06-01T09:00", name)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"time"
+)
+
+func schedulePrice(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	item, found := app.data.Catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("no product with SKU %v", sku)
+	}
+	set := flags("price schedule")
+	price := set.String("price", "", "new price as a decimal amount")
+	from := set.String("from", "", "time the price takes effect, now if not given")
+	set.Parse(args)
+	if err := required(set, "price"); err != nil {
+		return err
+	}
+	amount, err := store.ParseAmount(*price, item.BaseProduct().Price(store.NoTax, store.Location{}).Currency())
+	if err != nil {
+		return err
+	}
+	var effective time.Time
+	if *from != "" {
+		if effective, err = parseTime("from", *from); err != nil {
+			return err
+		}
+	}
+	if err = app.data.Prices.Schedule(sku, amount, effective); err != nil {
+		return err
+	}
+	return app.showPrices(sku, app.data.Prices.Versions(sku))
+}
+
+func cancelPrice(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("price cancel")
+	from := set.String("from", "", "time of the change to cancel")
+	set.Parse(args)
+	if err := required(set, "from"); err != nil {
+		return err
+	}
+	effective, err := parseTime("from", *from)
+	if err != nil {
+		return err
+	}
+	if err = app.data.Prices.Cancel(sku, effective); err != nil {
+		return err
+	}
+	return app.showPrices(sku, app.data.Prices.Versions(sku))
+}
+
+func priceHistory(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("price history")
+	at := set.String("at", "", "only show the price in force at this time")
+	set.Parse(args)
+	if _, found := app.data.Catalog.Get(sku); !found {
+		return fmt.Errorf("no product with SKU %v", sku)
+	}
+	if *at == "" {
+		return app.showPrices(sku, app.data.Prices.Versions(sku))
+	}
+	when, err := parseTime("at", *at)
+	if err != nil {
+		return err
+	}
+	price, _ := app.data.Prices.PriceAt(sku, when)
+	if app.output == "json" {
+		return printJSON(store.PriceVersion{ Price: price, Effective: when })
+	}
+	return printTable([]string{ "SKU", "AT", "PRICE" },
+		[][]string{ { sku, when.Local().Format("2006-01-02 15:04"), price.String() } })
+}
+
+func (app *app) showPrices(sku string, versions []store.PriceVersion) error {
+	if app.output == "json" {
+		return printJSON(versions)
+	}
+	rows := [][]string{}
+	for _, version := range versions {
+		from, state := "always", ""
+		if !version.Effective.IsZero() {
+			from = version.Effective.Local().Format("2006-01-02 15:04")
+		}
+		if version.Effective.After(time.Now()) {
+			state = "scheduled"
+		}
+		rows = append(rows, []string{ sku, from, version.Price.String(), state })
+	}
+	return printTable([]string{ "SKU", "FROM", "PRICE", "" }, rows)
+}
+
+func priceReport(app *app, args []string) error {
+	set := flags("price report")
+	from := set.String("from", "", "start of the period")
+	until := set.String("to", "", "end of the period, not included")
+	set.Parse(args)
+	if err := required(set, "from", "to"); err != nil {
+		return err
+	}
+	start, err := parseTime("from", *from)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime("to", *until)
+	if err != nil {
+		return err
+	}
+	report := app.data.Prices.Report(start, end)
+	if app.output == "json" {
+		return printJSON(report)
+	}
+	rows := [][]string{}
+	for _, category := range report {
+		for _, change := range category.Changes {
+			rows = append(rows, []string{ category.Category, change.SKU, change.Name,
+				change.Effective.Local().Format("2006-01-02 15:04"), change.Old.String(), change.New.String() })
+		}
+		rows = append(rows, []string{ category.Category, "", fmt.Sprintf("%v up, %v down",
+			category.Increases, category.Decreases), "", "", "" })
+	}
+	return printTable([]string{ "CATEGORY", "SKU", "NAME", "FROM", "OLD", "NEW" }, rows)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"composition/store"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fields are the editable parts of a catalog item, whatever its type.
+type fields struct {
+	itemType, name, category string
+	price store.Money
+	capacity int
+	motorized, includeCrew bool
+	captain, firstOfficer string
+	axes []string
+	variants []*store.Variant
+	components []store.BundleComponent
+	discount string
+}
+
+func fieldsOf(item store.Item) fields {
+	p := item.BaseProduct()
+	f := fields{ itemType: store.ItemType(item), name: p.Name, category: p.Category,
+		price: p.Price(store.NoTax, store.Location{}) }
+	if rental, isRental := item.(*store.RentalBoat); isRental {
+		f.includeCrew = rental.IncludeCrew
+		if rental.Crew != nil {
+			f.captain, f.firstOfficer = rental.Captain, rental.FirstOfficer
+		}
+	}
+	if product, hasVariants := item.(*store.VariantProduct); hasVariants {
+		f.axes, f.variants = product.Axes, product.Variants()
+	}
+	if bundle, isBundle := item.(*store.Bundle); isBundle {
+		f.components = bundle.Components
+		if !bundle.FixedPrice {
+			f.discount = bundle.Discount.Amount()
+		}
+	}
+	if boat, isVessel := item.(interface{ Vessel() *store.Boat }); isVessel {
+		f.capacity, f.motorized = boat.Vessel().Capacity, boat.Vessel().Motorized
+	}
+	return f
+}
+
+// item builds the catalog item, keeping any variants, through the JSON wire
+// format so it gets the same validation as items read from the data files.
+func (f fields) item() (store.Item, error) {
+	var item store.Item
+	switch f.itemType {
+	case store.TypeProduct:
+		item = store.NewProduct(f.name, f.category, f.price)
+		if len(f.axes) > 0 {
+			product := store.NewVariantProduct(f.name, f.category, f.price, f.axes...)
+			for _, v := range f.variants {
+				if _, err := product.AddVariant(v.SKU, v.Options, v.Delta, v.Stock); err != nil {
+					return nil, err
+				}
+			}
+			item = product
+		}
+	case store.TypeBundle:
+		item = store.NewBundle(f.name, f.category, f.price, f.components...)
+		if f.discount != "" {
+			discount, err := store.ParseAmount(f.discount, f.price.Currency())
+			if err != nil {
+				return nil, err
+			}
+			item = store.NewDiscountBundle(f.name, f.category, discount, f.components...)
+		}
+	case store.TypeBoat:
+		boat := store.NewBoat(f.name, f.price, f.capacity, f.motorized)
+		boat.Category, item = f.category, boat
+	case store.TypeRental:
+		rental, err := store.NewRentalBoat(f.name, f.price, f.capacity, f.motorized, f.includeCrew, f.captain, f.firstOfficer)
+		if err != nil {
+			return nil, err
+		}
+		rental.Category, item = f.category, rental
+	default:
+		return nil, fmt.Errorf("unknown type %q, use product, boat, rental or bundle", f.itemType)
+	}
+	data, err := store.MarshalItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return store.UnmarshalItem(data)
+}
+
+// bind registers the item flags on set, defaulting to the values in f.
+func (f *fields) bind(set *flag.FlagSet, price, currency *string) {
+	set.Func("axes", "comma separated option axes of a product sold in variants", func(text string) error {
+		if len(f.variants) > 0 {
+			return fmt.Errorf("the axes cannot change once variants are listed")
+		}
+		f.axes = strings.Split(text, ",")
+		return nil
+	})
+	set.StringVar(&f.itemType, "type", f.itemType, "product, boat, rental or bundle")
+	set.StringVar(&f.name, "name", f.name, "name")
+	set.StringVar(&f.category, "category", f.category, "category")
+	set.StringVar(price, "price", *price, "price as a decimal amount")
+	set.StringVar(currency, "currency", *currency, "currency code of the price")
+	set.IntVar(&f.capacity, "capacity", f.capacity, "people a boat carries")
+	set.BoolVar(&f.motorized, "motorized", f.motorized, "the boat has a motor")
+	set.BoolVar(&f.includeCrew, "crew", f.includeCrew, "the rental comes with crew")
+	set.StringVar(&f.captain, "captain", f.captain, "named captain of a rental")
+	set.StringVar(&f.firstOfficer, "first-officer", f.firstOfficer, "named first officer of a rental")
+	set.Func("components", "what a bundle holds, as SKU:quantity,SKU:quantity", func(text string) error {
+		f.components = nil
+		for _, part := range strings.Split(text, ",") {
+			sku, quantity, _ := strings.Cut(part, ":")
+			n, err := strconv.Atoi(quantity)
+			if err != nil {
+				return fmt.Errorf("use SKU:quantity, not %q", part)
+			}
+			f.components = append(f.components, store.BundleComponent{ SKU: strings.TrimSpace(sku), Quantity: n })
+		}
+		return nil
+	})
+	set.StringVar(&f.discount, "discount", f.discount, "price a bundle at its components' total less this amount")
+}
+
+// setItem parses the item flags over the defaults in f and stores the result
+// under sku.
+func (app *app) setItem(name, sku string, f fields, args []string) error {
+	price, currency := "", "USD"
+	if f.price.Currency() != "" {
+		price, currency = f.price.Amount(), f.price.Currency()
+	}
+	set := flags(name)
+	f.bind(set, &price, &currency)
+	set.Parse(args)
+	// Bundles with a discount take their price from their components.
+	if f.itemType == store.TypeBundle && f.discount != "" && price == "" {
+		price = "0"
+	}
+	if err := required(set, "name", "price"); err != nil {
+		return err
+	}
+	var err error
+	if f.price, err = store.ParseAmount(price, currency); err != nil {
+		return err
+	}
+	item, err := f.item()
+	if err != nil {
+		return err
+	}
+	if err = app.data.Prices.Put(sku, item); err != nil {
+		return err
+	}
+	return app.showEntries(store.CatalogEntry{ SKU: sku, Item: item })
+}
+
+func addProduct(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	if _, exists := app.data.Catalog.Get(sku); exists {
+		return fmt.Errorf("%v already exists, use product update", sku)
+	}
+	return app.setItem("product add", sku, fields{ itemType: store.TypeProduct }, args)
+}
+
+// updateProduct changes only the fields given as flags.
+func updateProduct(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	current, found := app.data.Catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("no product with SKU %v", sku)
+	}
+	return app.setItem("product update", sku, fieldsOf(current), args)
+}
+
+func deleteProduct(app *app, args []string) error {
+	sku, _, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	if _, found := app.data.Deals.Get(sku); found {
+		if err := app.data.Deals.Remove(sku); err != nil {
+			return err
+		}
+	}
+	return app.data.Catalog.Remove(sku)
+}
+
+func listProducts(app *app, args []string) error {
+	set := flags("product list")
+	query := store.ProductQuery{}
+	set.StringVar(&query.Type, "type", "", "only items of this type")
+	set.StringVar(&query.Category, "category", "", "only items in this category")
+	set.StringVar(&query.SortBy, "sort", store.SortBySKU, "sku, name, price or capacity")
+	set.BoolVar(&query.Descending, "desc", false, "sort in descending order")
+	set.Parse(args)
+	return app.showEntries(app.data.Catalog.Query(query).Entries...)
+}
+
+func (app *app) showEntries(entries ...store.CatalogEntry) error {
+	if app.output == "json" {
+		return printJSON(entries)
+	}
+	rows := [][]string{}
+	for _, entry := range entries {
+		f := fieldsOf(entry.Item)
+		capacity, crew := "", ""
+		vessel := f.itemType == store.TypeBoat || f.itemType == store.TypeRental
+		if vessel {
+			capacity = strconv.Itoa(f.capacity)
+		}
+		if f.includeCrew {
+			crew = f.captain + ", " + f.firstOfficer
+		}
+		rows = append(rows, []string{ entry.SKU, f.itemType, f.name, f.category, f.price.String(), capacity,
+			yesNo(vessel && f.motorized), crew })
+		for _, v := range f.variants {
+			rows = append(rows, []string{ "  " + v.SKU, "variant", v.Label(), v.Category,
+				v.Price(store.NoTax, store.Location{}).String(), "", "", "" })
+		}
+		for _, component := range f.components {
+			name := ""
+			if item, found := app.data.Catalog.Get(component.SKU); found {
+				name = item.BaseProduct().Name
+			}
+			rows = append(rows, []string{ "  " + component.SKU, "component",
+				fmt.Sprintf("%v x %v", component.Quantity, name), "", "", "", "", "" })
+		}
+	}
+	return printTable([]string{ "SKU", "TYPE", "NAME", "CATEGORY", "PRICE", "CAPACITY", "MOTOR", "CREW" }, rows)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return ""
+}
+
+// addVariant lists a new variant of a product sold in variants.
+func addVariant(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	item, _ := app.data.Catalog.Get(sku)
+	current, hasVariants := item.(*store.VariantProduct)
+	if !hasVariants {
+		return fmt.Errorf("%v is not a product with option axes, set them with product update -axes", sku)
+	}
+	set := flags("product variant")
+	variantSKU := set.String("sku", "", "SKU of the variant")
+	delta := set.String("delta", "0", "amount added to the product's price")
+	stock := set.Int("stock", 0, "units in stock")
+	options := store.Options{}
+	set.Func("option", "axis=value, once for each axis", func(text string) error {
+		axis, value, found := strings.Cut(text, "=")
+		if !found {
+			return fmt.Errorf("use axis=value")
+		}
+		options[strings.TrimSpace(axis)] = value
+		return nil
+	})
+	set.Parse(args)
+	if err := required(set, "sku"); err != nil {
+		return err
+	}
+	amount, err := store.ParseAmount(*delta, current.Price(store.NoTax, store.Location{}).Currency())
+	if err != nil {
+		return err
+	}
+	if _, err = current.AddVariant(*variantSKU, options, amount, *stock); err != nil {
+		return err
+	}
+	if err = app.data.Catalog.Put(sku, current); err != nil {
+		return err
+	}
+	return app.showEntries(store.CatalogEntry{ SKU: sku, Item: current })
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+func addSupplier(app *app, args []string) error {
+	id, args, err := positional(args, "supplier ID")
+	if err != nil {
+		return err
+	}
+	set := flags("supplier add")
+	name := set.String("name", "", "supplier's name")
+	city := set.String("city", "", "city the supplier ships from")
+	set.Parse(args)
+	if err := required(set, "name"); err != nil {
+		return err
+	}
+	if err := app.data.Purchasing.AddSupplier(store.Supplier{ ID: id, Name: *name, City: *city }); err != nil {
+		return err
+	}
+	return listSuppliers(app, nil)
+}
+
+// addOffer records what a supplier charges for a SKU.
+func addOffer(app *app, args []string) error {
+	id, args, err := positional(args, "supplier ID")
+	if err != nil {
+		return err
+	}
+	set := flags("supplier offer")
+	sku := set.String("sku", "", "SKU the supplier offers")
+	cost := set.String("cost", "", "unit cost, such as $180")
+	days := set.Int("lead-days", 0, "days from ordering to delivery")
+	minimum := set.Int("min", 0, "smallest quantity the supplier ships")
+	preferred := set.Bool("preferred", false, "order from this supplier first")
+	set.Parse(args)
+	if err := required(set, "sku", "cost"); err != nil {
+		return err
+	}
+	if _, found := app.data.Catalog.Get(*sku); !found {
+		return fmt.Errorf("no product with SKU %v", *sku)
+	}
+	amount, err := store.ParseMoney(*cost)
+	if err != nil {
+		return err
+	}
+	offer := store.SupplierOffer{ SupplierID: id, SKU: *sku, Cost: amount,
+		LeadTime: time.Duration(*days) * store.Day, MinOrder: *minimum, Preferred: *preferred }
+	if err := app.data.Purchasing.AddOffer(offer); err != nil {
+		return err
+	}
+	return app.showOffers(app.data.Purchasing.Offers(*sku))
+}
+
+func listSuppliers(app *app, args []string) error {
+	set := flags("supplier list")
+	sku := set.String("sku", "", "list the offers for this SKU instead")
+	set.Parse(args)
+	if *sku != "" {
+		return app.showOffers(app.data.Purchasing.Offers(*sku))
+	}
+	suppliers := app.data.Purchasing.Suppliers()
+	if app.output == "json" {
+		return printJSON(suppliers)
+	}
+	rows := [][]string{}
+	for _, supplier := range suppliers {
+		rows = append(rows, []string{ supplier.ID, supplier.Name, supplier.City })
+	}
+	return printTable([]string{ "ID", "NAME", "CITY" }, rows)
+}
+
+func setReorderPolicy(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("stock reorder")
+	point := set.Int("point", -1, "units available and on order at or below which to order more")
+	quantity := set.Int("quantity", 0, "units to order")
+	set.Parse(args)
+	if *point < 0 || *quantity < 1 {
+		return fmt.Errorf("-point and -quantity are required")
+	}
+	return app.data.Purchasing.SetReorderPolicy(sku, store.ReorderPolicy{ Point: *point, Quantity: *quantity })
+}
+
+// replenish raises purchase orders for the SKUs at their reorder point.
+func replenish(app *app, args []string) error {
+	flags("purchase replenish").Parse(args)
+	orders, err := app.data.Purchasing.Replenish(app.data.Inventory)
+	if len(orders) > 0 {
+		if showErr := app.showPurchaseOrders(orders); showErr != nil {
+			return showErr
+		}
+	}
+	return err
+}
+
+func listPurchaseOrders(app *app, args []string) error {
+	set := flags("purchase list")
+	status := set.String("status", "", "only orders that are open, received or cancelled")
+	set.Parse(args)
+	orders := []*store.PurchaseOrder{}
+	for _, order := range app.data.Purchasing.Orders() {
+		if *status == "" || string(order.Status) == *status {
+			orders = append(orders, order)
+		}
+	}
+	return app.showPurchaseOrders(orders)
+}
+
+func receivePurchaseOrder(app *app, args []string) error {
+	number, args, err := positional(args, "order number")
+	if err != nil {
+		return err
+	}
+	set := flags("purchase receive")
+	location := set.String("location", "Warehouse", "location the goods are booked in at")
+	set.Parse(args)
+	return app.data.Purchasing.Receive(number, app.data.Inventory.Inventory, *location)
+}
+
+func cancelPurchaseOrder(app *app, args []string) error {
+	number, args, err := positional(args, "order number")
+	if err != nil {
+		return err
+	}
+	flags("purchase cancel").Parse(args)
+	return app.data.Purchasing.Cancel(number)
+}
+
+func (app *app) showOffers(offers []store.SupplierOffer) error {
+	if app.output == "json" {
+		return printJSON(offers)
+	}
+	rows := [][]string{}
+	for _, offer := range offers {
+		preferred := ""
+		if offer.Preferred {
+			preferred = "yes"
+		}
+		rows = append(rows, []string{ offer.SupplierID, offer.SKU, offer.Cost.String(),
+			strconv.Itoa(int(offer.LeadTime / store.Day)), strconv.Itoa(offer.MinOrder), preferred })
+	}
+	return printTable([]string{ "SUPPLIER", "SKU", "COST", "LEAD DAYS", "MIN", "PREFERRED" }, rows)
+}
+
+func (app *app) showPurchaseOrders(orders []*store.PurchaseOrder) error {
+	if app.output == "json" {
+		return printJSON(orders)
+	}
+	rows := [][]string{}
+	for _, order := range orders {
+		rows = append(rows, []string{ order.Number, order.Supplier.Name, string(order.Status),
+			strconv.Itoa(len(order.Lines)), order.Total.String(), order.Expected.Format("2006-01-02") })
+	}
+	return printTable([]string{ "NUMBER", "SUPPLIER", "STATUS", "LINES", "TOTAL", "EXPECTED" }, rows)
+}
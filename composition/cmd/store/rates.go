@@ -0,0 +1,100 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func setRate(app *app, args []string) error {
+	from, args, err := positional(args, "FROM")
+	if err != nil {
+		return err
+	}
+	to, args, err := positional(args, "TO")
+	if err != nil {
+		return err
+	}
+	set := flags("rate set")
+	rate := set.Float64("rate", 0, "units of TO that one unit of FROM buys")
+	effective := set.String("from", "", "time the rate takes effect, now if not given")
+	set.Parse(args)
+	if err := required(set, "rate"); err != nil {
+		return err
+	}
+	entry := store.ExchangeRate{ From: strings.ToUpper(from), To: strings.ToUpper(to), Rate: *rate,
+		Effective: time.Now() }
+	if *effective != "" {
+		if entry.Effective, err = parseTime("from", *effective); err != nil {
+			return err
+		}
+	}
+	if err = app.data.Rates.Set(entry); err != nil {
+		return err
+	}
+	return app.showRates([]store.ExchangeRate{ entry })
+}
+
+func listRates(app *app, args []string) error {
+	set := flags("rate list")
+	currency := set.String("currency", "", "only rates from or to this currency")
+	set.Parse(args)
+	rates := []store.ExchangeRate{}
+	for _, rate := range app.data.Rates.Rates() {
+		if *currency == "" || strings.EqualFold(rate.From, *currency) || strings.EqualFold(rate.To, *currency) {
+			rates = append(rates, rate)
+		}
+	}
+	return app.showRates(rates)
+}
+
+func (app *app) showRates(rates []store.ExchangeRate) error {
+	if app.output == "json" {
+		return printJSON(rates)
+	}
+	rows := [][]string{}
+	for _, rate := range rates {
+		rows = append(rows, []string{ rate.From, rate.To, strconv.FormatFloat(rate.Rate, 'f', -1, 64),
+			rate.Effective.Local().Format("2006-01-02 15:04") })
+	}
+	return printTable([]string{ "FROM", "TO", "RATE", "EFFECTIVE" }, rows)
+}
+
+// convertAmount converts an amount such as "USD 100" or "$100", or the price
+// of a catalog item, into another currency.
+func convertAmount(app *app, args []string) error {
+	text, args, err := positional(args, "AMOUNT or SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("rate convert")
+	to := set.String("to", "", "currency to convert into")
+	at := set.String("at", "", "use the rates in force at this time instead of now")
+	set.Parse(args)
+	if err := required(set, "to"); err != nil {
+		return err
+	}
+	when := time.Now()
+	if *at != "" {
+		if when, err = parseTime("at", *at); err != nil {
+			return err
+		}
+	}
+	var amount store.Money
+	if item, found := app.data.Catalog.Get(text); found {
+		amount = item.BaseProduct().Price(store.NoTax, store.Location{})
+	} else if amount, err = store.ParseMoney(text); err != nil {
+		return fmt.Errorf("%q is neither an amount with a currency nor a SKU", text)
+	}
+	converted, rate, err := app.data.Rates.Convert(amount, strings.ToUpper(*to), when)
+	if err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(map[string]interface{}{ "amount": amount, "converted": converted, "rate": rate })
+	}
+	return printTable([]string{ "AMOUNT", "CONVERTED", "RATE", "EFFECTIVE" }, [][]string{ { amount.String(),
+		converted.String(), strconv.FormatFloat(rate.Rate, 'f', -1, 64), rate.Effective.Local().Format("2006-01-02 15:04") } })
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+)
+
+func listBoats(app *app, args []string) error {
+	set := flags("boat list")
+	available := set.Bool("available", false, "only rental boats free for the whole period")
+	from := set.String("start", "", "start of the period")
+	until := set.String("end", "", "end of the period")
+	capacity := set.Int("capacity", 0, "people the boat must carry")
+	set.Parse(args)
+	if !*available {
+		entries := []store.CatalogEntry{}
+		for _, itemType := range []string{ store.TypeBoat, store.TypeRental } {
+			query := store.ProductQuery{ Type: itemType, MinCapacity: *capacity }
+			entries = append(entries, app.data.Catalog.Query(query).Entries...)
+		}
+		return app.showEntries(entries...)
+	}
+	if err := required(set, "start", "end"); err != nil {
+		return err
+	}
+	start, err := parseTime("start", *from)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime("end", *until)
+	if err != nil {
+		return err
+	}
+	if !end.After(start) {
+		return fmt.Errorf("-end must be after -start")
+	}
+	entries := []store.CatalogEntry{}
+	for _, sku := range app.data.Calendar.Available(start, end, *capacity) {
+		if item, found := app.data.Catalog.Get(sku); found {
+			entries = append(entries, store.CatalogEntry{ SKU: sku, Item: item })
+		}
+	}
+	return app.showEntries(entries...)
+}
+
+func setRates(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	item, _ := app.data.Catalog.Get(sku)
+	rental, isRental := item.(*store.RentalBoat)
+	if !isRental {
+		return fmt.Errorf("no rental boat with SKU %v", sku)
+	}
+	set := flags("rental rates")
+	hourly := set.String("hourly", "", "price per hour")
+	daily := set.String("daily", "", "price per day")
+	weekly := set.String("weekly", "", "price per week")
+	set.Parse(args)
+	currency := rental.Price(store.NoTax, store.Location{}).Currency()
+	rates := store.RentalRates{}
+	for _, rate := range []struct {
+		text string
+		target *store.Money
+	}{ { *hourly, &rates.Hourly }, { *daily, &rates.Daily }, { *weekly, &rates.Weekly } } {
+		if rate.text == "" {
+			continue
+		}
+		if *rate.target, err = store.ParseAmount(rate.text, currency); err != nil {
+			return err
+		}
+	}
+	if err = app.data.Calendar.AddBoat(sku, rental, rates); err != nil {
+		return err
+	}
+	if app.output == "json" {
+		return printJSON(rates)
+	}
+	return printTable([]string{ "SKU", "HOURLY", "DAILY", "WEEKLY" },
+		[][]string{ { sku, optional(rates.Hourly), optional(rates.Daily), optional(rates.Weekly) } })
+}
+
+func bookRental(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("rental book")
+	customer := set.String("customer", "", "who the booking is for")
+	from := set.String("start", "", "start of the rental")
+	until := set.String("end", "", "end of the rental")
+	set.Parse(args)
+	if err := required(set, "customer", "start", "end"); err != nil {
+		return err
+	}
+	start, err := parseTime("start", *from)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime("end", *until)
+	if err != nil {
+		return err
+	}
+	booking, err := app.data.Calendar.Book(sku, *customer, start, end)
+	if err != nil {
+		return err
+	}
+	return app.showBookings(*booking)
+}
+
+func cancelRental(app *app, args []string) error {
+	id, _, err := positional(args, "booking ID")
+	if err != nil {
+		return err
+	}
+	if _, err := app.data.Calendar.Cancel(id); err != nil {
+		return err
+	}
+	booking, _ := app.data.Calendar.Booking(id)
+	return app.showBookings(booking)
+}
+
+func listBookings(app *app, args []string) error {
+	set := flags("rental list")
+	sku := set.String("sku", "", "only bookings for this boat")
+	set.Parse(args)
+	return app.showBookings(app.data.Calendar.Bookings(*sku)...)
+}
+
+func (app *app) showBookings(bookings ...store.Booking) error {
+	if app.output == "json" {
+		return printJSON(bookings)
+	}
+	rows := [][]string{}
+	for _, booking := range bookings {
+		crew := ""
+		if booking.Crew != nil {
+			crew = booking.Crew.Captain + ", " + booking.Crew.FirstOfficer
+		}
+		refund := ""
+		if booking.Status == store.BookingCancelled {
+			refund = booking.Refund.String()
+		}
+		rows = append(rows, []string{ booking.ID, booking.SKU, booking.Customer,
+			booking.Start.Format("2006-01-02 15:04"), booking.End.Format("2006-01-02 15:04"),
+			booking.Price.String(), string(booking.Status), refund, crew })
+	}
+	return printTable([]string{ "ID", "SKU", "CUSTOMER", "START", "END", "PRICE", "STATUS", "REFUND", "CREW" }, rows)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"composition/store"
+	"fmt"
+	"strconv"
+)
+
+// receiveStock books stock in at a location, or writes it off when the
+// quantity is negative.
+func receiveStock(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("stock receive")
+	quantity := set.Int("quantity", 0, "units received, or written off if negative")
+	location := set.String("location", "Warehouse", "location the stock is kept at")
+	set.Parse(args)
+	if *quantity == 0 {
+		return fmt.Errorf("-quantity is required")
+	}
+	if _, found := app.data.Catalog.Get(sku); !found {
+		return fmt.Errorf("no product with SKU %v", sku)
+	}
+	if err = app.data.Inventory.Receive(sku, *location, *quantity); err != nil {
+		return err
+	}
+	return app.showStock(app.data.Inventory.Levels(sku))
+}
+
+func listStock(app *app, args []string) error {
+	set := flags("stock list")
+	sku := set.String("sku", "", "only the stock of this SKU")
+	set.Parse(args)
+	if *sku != "" {
+		return app.showStock(app.data.Inventory.Levels(*sku))
+	}
+	return app.showStock(app.data.Inventory.Stock())
+}
+
+func setStockThreshold(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("stock threshold")
+	level := set.Int("level", -1, "units available at or below which stock is low")
+	set.Parse(args)
+	if *level < 0 {
+		return fmt.Errorf("-level is required and may not be negative")
+	}
+	return app.data.Inventory.SetLowStockThreshold(sku, *level)
+}
+
+func setStockAlternate(app *app, args []string) error {
+	sku, args, err := positional(args, "SKU")
+	if err != nil {
+		return err
+	}
+	set := flags("stock alternate")
+	alternate := set.String("sku", "", "SKU to suggest when this one runs out")
+	set.Parse(args)
+	if err := required(set, "sku"); err != nil {
+		return err
+	}
+	return app.data.Inventory.SetAlternate(sku, *alternate)
+}
+
+func (app *app) showStock(levels []store.StockLevel) error {
+	if app.output == "json" {
+		return printJSON(levels)
+	}
+	rows := [][]string{}
+	for _, level := range levels {
+		rows = append(rows, []string{ level.SKU, level.Location, strconv.Itoa(level.OnHand),
+			strconv.Itoa(level.Reserved), strconv.Itoa(level.Available()) })
+	}
+	return printTable([]string{ "SKU", "LOCATION", "ON HAND", "RESERVED", "AVAILABLE" }, rows)
+}
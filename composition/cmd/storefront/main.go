@@ -0,0 +1,49 @@
+// Command storefront serves the shop's catalog, rentals, deals, carts and
+// orders over HTTP. Routes that change the shop need the key in
+// STOREFRONT_ADMIN_KEY as a bearer token.
+package main
+
+import (
+	"composition/store"
+	"composition/storefront"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":4000", "address to listen on")
+	dir := flag.String("data", ".", "directory holding the shop data")
+	flag.Parse()
+
+	data, err := store.OpenDataDir(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	checkout := store.NewCheckout(data.Inventory, data.OrderNumbers("ORD"))
+	checkout.Prices = data.Prices
+	checkout.Accounts = data.Customers
+	checkout.GiftCards = data.GiftCards
+	server := storefront.NewServer(data.Catalog, data.Deals, checkout, data.Calendar, data.Prices)
+	server.Rates = data.Rates.ExchangeRates
+	server.Orders = data.Orders
+	server.Invoices = data.Invoices
+	server.Returns = data.Returns.ReturnDesk
+	server.Customers = data.Customers.Customers
+	server.GiftCards = data.GiftCards.GiftCards
+	server.AdminKey = os.Getenv("STOREFRONT_ADMIN_KEY")
+	if server.AdminKey == "" {
+		log.Print("STOREFRONT_ADMIN_KEY is not set; routes that change the shop are refused")
+	}
+	server.Purchasing = data.Purchasing.Purchasing
+	server.Inventory = data.Inventory.Inventory
+	data.Purchasing.Report = func(err error) { log.Printf("Ordering stock: %v", err) }
+	go data.Prices.Run(time.Minute, nil, func(err error) { log.Printf("Updating prices: %v", err) })
+	go data.Customers.Run(time.Hour, nil, func(err error) { log.Printf("Expiring points: %v", err) })
+	go data.GiftCards.Run(time.Hour, nil, func(err error) { log.Printf("Expiring gift cards: %v", err) })
+
+	log.Printf("Serving the store API on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -1,47 +1,322 @@
 package main
 
 import ( 
+	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 	"composition/store"
 )
 func main() {
 	fmt.Println("Hello, Composition!")
 
-	kayak := store.NewProduct("Kayak", "Watersports", 275)
+	taxes, err := store.NewRuleTaxPolicy(
+		store.TaxRule{ Name: "VAT", Rate: 0.2, Country: "UK" },
+		store.TaxRule{ Name: "Hire levy", Rate: 0.05, Country: "UK", SaleTypes: []store.SaleType{ store.Rental }, Compound: true },
+	)
+	if err != nil {
+		panic(err)
+	}
+	home := store.Location{ Country: "UK" }
+
+	kayak := store.NewProduct("Kayak", "Watersports", store.MustParseMoney("$275"))
 	lifejacket := &store.Product{ Name: "Lifejacket", Category: "Watersports"}
 
 	for _, p := range []*store.Product { kayak, lifejacket } {
-		fmt.Println("Name:", p.Name, "Category:", p.Category, "Price:", p.Price(0.2))
+		fmt.Println("Name:", p.Name, "Category:", p.Category, "Price:", p.Price(taxes, home))
 	}
 
 	boats := []*store.Boat {
-		store.NewBoat("Kayak", 275, 1, false),
-		store.NewBoat("Canoe", 400, 3, false),
-		store.NewBoat("Tender", 650.25, 2, true),
+		store.NewBoat("Kayak", store.MustParseMoney("$275"), 1, false),
+		store.NewBoat("Canoe", store.MustParseMoney("$400"), 3, false),
+		store.NewBoat("Tender", store.MustParseMoney("$650.25"), 2, true),
 	}
 
 	for _, b := range boats {
 		fmt.Println("Conventional:", b.Product.Name, "Direct:", b.Name)
-		fmt.Println("Boat:", b.Name, "Price:", b.Price(0.2))
+		fmt.Println("Boat:", b.Name, "Price:", b.Price(taxes, home))
 	}
 
-	rentals := []*store.RentalBoat {
-		store.NewRentalBoat("Rubber Ring", 10, 1, false, false, "N/A", "N/A"),
-		store.NewRentalBoat("Yacht", 5000, 5, true, true, "Bob", "Alice"),
-		store.NewRentalBoat("Super Yacht", 100000, 15, true, true, "Dora", "Charlie"),
+	rentals := []*store.RentalBoat {}
+	for _, r := range []struct {
+		name, price string
+		capacity int
+		motorized, crewed bool
+		captain, firstOfficer string
+	}{
+		{ "Rubber Ring", "$10", 1, false, false, "", "" },
+		{ "Yacht", "$5000", 5, true, true, "Bob", "Alice" },
+		{ "Super Yacht", "$100000", 15, true, true, "Dora", "Charlie" },
+	} {
+		rental, err := store.NewRentalBoat(r.name, store.MustParseMoney(r.price), r.capacity, r.motorized, r.crewed,
+			r.captain, r.firstOfficer)
+		if err != nil {
+			panic(err)
+		}
+		rentals = append(rentals, rental)
 	}
 
 	for _, r := range rentals {
-		fmt.Println("Rental Boat:", r.Name, "Rental Price:", r.Price(0.2), "Captain:", r.Captain)
+		fmt.Println("Rental Boat:", r.Name, "Rental Price:", r.Price(taxes, home), "Captain:", r.Captain)
 	}
 
-	product := store.NewProduct("Kayak", "Watersports", 279)
+	for _, line := range rentals[1].PriceBreakdown(taxes, home).Lines {
+		fmt.Println("Tax:", line.Name, "Rate:", line.Rate, "On:", line.Base, "Amount:", line.Amount)
+	}
 
-	deal := store.NewSpecialDeal("Weekend Special", product, 50)
+	product := store.NewProduct("Kayak", "Watersports", store.MustParseMoney("$279"))
 
-	Name, price, Price := deal.GetDetails()
+	deal, err := store.NewSpecialDeal("Weekend Special", product, store.MustParseMoney("$50"))
+	if err != nil {
+		panic(err)
+	}
+
+	Name, price, Price, applied, err := deal.GetDetails()
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println("Name:", Name)
 	fmt.Println("Price field:", price)
 	fmt.Println("Price method:", Price)
+	for _, d := range applied {
+		fmt.Println("Discount:", d.Name, d.Amount)
+	}
+
+	catalog := store.NewMemoryCatalog()
+	catalog.Put("KAY-1", kayak)
+	catalog.Put("LIF-1", store.NewProduct("Lifejacket", "Watersports", store.MustParseMoney("$48.95")))
+	catalog.Put("BALL-1", store.NewProduct("Soccer Ball", "Soccer", store.MustParseMoney("$19.50")))
+	for i, b := range boats {
+		catalog.Put(fmt.Sprintf("BOAT-%v", i + 1), b)
+	}
+	for i, r := range rentals {
+		catalog.Put(fmt.Sprintf("RENT-%v", i + 1), r)
+	}
+
+	motorized := true
+	result := catalog.Query(store.ProductQuery{ MinCapacity: 2, Motorized: &motorized,
+		SortBy: store.SortByPrice, Limit: 2 })
+	fmt.Println("Motorized boats for 2+:", result.Total)
+	for _, entry := range result.Entries {
+		fmt.Println("SKU:", entry.SKU, "Name:", entry.Item.BaseProduct().Name)
+	}
+
+	yachtJSON, _ := json.Marshal(store.CatalogEntry{ SKU: "RENT-2", Item: rentals[1] })
+	fmt.Println("JSON:", string(yachtJSON))
+	store.WriteCatalogCSV(os.Stdout, catalog.Query(store.ProductQuery{ Category: "Soccer" }).Entries)
+
+	jackets := store.NewVariantProduct("Lifejacket", "Watersports", store.MustParseMoney("$45"), "size", "colour")
+	jackets.AddVariant("LJ-M-RED", store.Options{ "size": "M", "colour": "red" }, store.Money{}, 4)
+	jackets.AddVariant("LJ-XL-RED", store.Options{ "size": "XL", "colour": "red" }, store.MustParseMoney("$5"), 1)
+	catalog.Put("LJ", jackets)
+	for _, v := range jackets.Variants() {
+		fmt.Println("Variant:", v.SKU, v.Name, "Price:", v.Price(taxes, home), "Stock:", v.Stock)
+	}
+
+	catalog.Put("PAD-1", store.NewProduct("Paddle", "Watersports", store.MustParseMoney("$30")))
+	catalog.Put("PACK-1", store.NewDiscountBundle("Kayak Pack", "Watersports", store.MustParseMoney("$40"),
+		store.BundleComponent{ SKU: "KAY-1", Quantity: 1 }, store.BundleComponent{ SKU: "PAD-1", Quantity: 2 },
+		store.BundleComponent{ SKU: "LJ-M-RED", Quantity: 1 }))
+	if pack, found := catalog.Get("PACK-1"); found {
+		fmt.Println("Bundle:", pack.BaseProduct().Name, "Price:", pack.BaseProduct().Price(taxes, home))
+	}
+
+	cart := store.NewCart(catalog, taxes, home)
+	cart.Add("KAY-1", 2)
+	cart.Add("LIF-1", 2)
+	saver, err := store.NewSpecialDeal("Kayak Saver", kayak, store.MustParseMoney("$25"))
+	if err != nil {
+		panic(err)
+	}
+	cart.ApplyDeal("KAY-1", saver)
+
+	inventory := store.NewInventory(store.LowStockFunc(func(sku string, available, threshold int) {
+		fmt.Println("Low stock:", sku, "Available:", available, "Threshold:", threshold)
+	}))
+	inventory.Receive("KAY-1", "Warehouse", 3)
+	inventory.Receive("KAY-1", "Shop", 1)
+	inventory.Receive("LIF-1", "Shop", 2)
+	inventory.Receive("LIF-2", "Shop", 10)
+	inventory.SetAlternate("LIF-1", "LIF-2")
+	inventory.SetLowStockThreshold("KAY-1", 2)
+
+	checkout := store.NewCheckout(inventory, store.NewOrderSequence("ORD", 0))
+	order, err := checkout.Place(cart)
+	if err != nil {
+		fmt.Println("Checkout failed:", err)
+	} else {
+		for _, line := range order.Lines() {
+			fmt.Println("Order", order.Number(), "Line:", line.Quantity, line.Name, "Net:", line.Net, "Gross:", line.Gross)
+		}
+		totals := order.Totals()
+		fmt.Println("Order", order.Number(), order.Status(), "Discount:", totals.Discount, "Tax:", totals.Tax, "Total:", totals.Total)
+
+		book := store.NewInvoiceBook(store.Party{ Name: "Composition Watersports", Address: []string{ "1 Quay Street", "Bristol" },
+			TaxID: "GB123456789" })
+		invoice, _ := book.Issue(order, store.Party{ Name: "Alice Smith", Email: "alice@example.com" })
+		invoice.WriteText(os.Stdout)
+		if note, err := book.Credit(invoice.Number, "Damaged in transit", store.CreditLine{ SKU: "LIF-1", Quantity: 1 }); err == nil {
+			balance, _ := book.Balance(invoice.Number)
+			fmt.Println(note.Title(), "Refunded:", note.Total, "Balance:", balance)
+		}
+
+		desk := store.NewReturnDesk(catalog)
+		desk.Stock, desk.Invoices = inventory, book
+		request, _ := desk.Request(order, "Too heavy", store.ReturnLine{ SKU: "KAY-1", Quantity: 1 })
+		desk.Approve(request.ID)
+		desk.Receive(request.ID, nil)
+		if settled, err := desk.Complete(request.ID); err != nil {
+			fmt.Println("Return failed:", err)
+		} else {
+			fmt.Println("Return", settled.ID, settled.Status, "Refund:", settled.Refund, "Credit note:", settled.CreditNote,
+				"Restocked:", settled.Restocked)
+		}
+	}
+
+	fmt.Println("Kayaks left:", inventory.Available("KAY-1"), "Lifejackets left:", inventory.Available("LIF-1"))
+
+	rates, _ := store.NewExchangeRates(store.RoundHalfEven,
+		store.ExchangeRate{ From: "USD", To: "EUR", Rate: 0.92 },
+		store.ExchangeRate{ From: "GBP", To: "USD", Rate: 1.27 })
+	euros, rate, _ := kayak.PriceIn("EUR", rates, time.Now(), taxes, home)
+	fmt.Println("Kayak in EUR:", euros, "Rate:", rate.Rate)
+	abroad := store.NewCart(catalog, taxes, home)
+	abroad.UseCurrency("GBP", rates, time.Now())
+	abroad.Add("KAY-1", 1)
+	if order, err := checkout.Place(abroad); err != nil {
+		fmt.Println("Checkout failed:", err)
+	} else {
+		totals := order.Totals()
+		fmt.Println("Order", order.Number(), "Total:", totals.Total, "At:", order.Rates()[0].From, "to", order.Rates()[0].To,
+			order.Rates()[0].Rate)
+	}
+
+	more := store.NewCart(catalog, taxes, home)
+	more.Add("LIF-1", 1)
+	if _, err := checkout.Place(more); err != nil {
+		fmt.Println("Checkout failed:", err)
+	}
+
+	program, _ := store.NewLoyaltyProgram("USD", 0.05, 365 * store.Day,
+		store.LoyaltyTier{ Name: "Gold", MinSpend: store.MustParseMoney("$250"), Discount: 0.1 })
+	customers, _ := store.NewCustomers(program)
+	checkout.Accounts = customers
+	ann, _ := customers.Register("Ann Lee", "ann@example.com", store.Address{ Lines: []string{ "1 Quay Street" }, Country: "US" })
+	first := store.NewCart(catalog, taxes, home)
+	first.Add("KAY-1", 1)
+	customers.Apply(first, ann.ID, 0)
+	checkout.Place(first)
+	ann, _ = customers.Get(ann.ID)
+	fmt.Println(ann.Name, "Tier:", ann.Tier, "Spend:", ann.Spend, "Points:", ann.Points)
+	second := store.NewCart(catalog, taxes, home)
+	second.Add("KAY-1", 1)
+	customers.Apply(second, ann.ID, ann.Points)
+	if order, err := checkout.Place(second); err != nil {
+		fmt.Println("Checkout failed:", err)
+	} else {
+		for _, d := range order.Lines()[0].Discounts {
+			fmt.Println("Discount:", d.Name, d.Amount)
+		}
+		ann, _ = customers.Get(ann.ID)
+		fmt.Println("Order", order.Number(), "Total:", order.Totals().Total, "Points left:", ann.Points)
+	}
+
+	giftCards := store.NewGiftCards()
+	checkout.GiftCards = giftCards
+	gift, _ := giftCards.Issue(store.MustParseMoney("$50"), nil, "Birthday")
+	inventory.Receive("KAY-1", "Shop", 1)
+	paid := store.NewCart(catalog, taxes, home)
+	paid.Add("KAY-1", 1)
+	giftCards.Apply(paid, gift.Code, store.Money{})
+	if order, err := checkout.Place(paid); err != nil {
+		fmt.Println("Checkout failed:", err)
+	} else {
+		gift, _ = giftCards.Get(gift.Code)
+		fmt.Println("Order", order.Number(), "Total:", order.Totals().Total, "Due:", order.Totals().Due,
+			"Gift card left:", gift.Balance)
+	}
+	for _, r := range giftCards.Reconcile() {
+		fmt.Println("Gift cards", r.Currency, "Issued:", r.Issued, "Redeemed:", r.Redeemed, "Outstanding:", r.Outstanding,
+			"Balanced:", r.Balanced())
+	}
+
+	catalog.Put("KAY-1", store.NewProduct("Kayak", "Watersports", store.MustParseMoney("$299")))
+	if _, err := checkout.Place(cart); err != nil {
+		fmt.Println("Checkout failed:", err)
+	}
+
+	purchasing := store.NewPurchasing()
+	purchasing.AddSupplier(store.Supplier{ ID: "ACME", Name: "Acme Co", City: "New York" })
+	purchasing.AddSupplier(store.Supplier{ ID: "BOB", Name: "Bob's Boats", City: "Chicago" })
+	purchasing.AddOffer(store.SupplierOffer{ SupplierID: "ACME", SKU: "KAY-1",
+		Cost: store.MustParseMoney("$180"), LeadTime: 5 * store.Day, MinOrder: 4 })
+	purchasing.AddOffer(store.SupplierOffer{ SupplierID: "BOB", SKU: "KAY-1",
+		Cost: store.MustParseMoney("$195"), LeadTime: 2 * store.Day, Preferred: true })
+	purchasing.AddOffer(store.SupplierOffer{ SupplierID: "ACME", SKU: "LIF-1",
+		Cost: store.MustParseMoney("$20"), LeadTime: 5 * store.Day, MinOrder: 10 })
+	purchasing.SetReorderPolicy("KAY-1", store.ReorderPolicy{ Point: 2, Quantity: 3 })
+	purchasing.SetReorderPolicy("LIF-1", store.ReorderPolicy{ Point: 1, Quantity: 5 })
+	purchaseOrders, _ := purchasing.Replenish(inventory)
+	for _, po := range purchaseOrders {
+		fmt.Println("Purchase order", po.Number, po.Supplier.Name, "Lines:", len(po.Lines),
+			"Total:", po.Total, "Due:", po.Expected.Format("2006-01-02"))
+	}
+	if len(purchaseOrders) > 0 {
+		purchasing.Receive(purchaseOrders[0].Number, inventory, "Warehouse")
+		fmt.Println("Kayaks after delivery:", inventory.Available("KAY-1"))
+	}
+
+	calendar := store.NewBookingCalendar(2 * time.Hour,
+		store.RefundRule{ Notice: 7 * store.Day, Percent: 100 },
+		store.RefundRule{ Notice: 2 * store.Day, Percent: 50 })
+	calendar.AddBoat("RENT-2", rentals[1], store.RentalRates{ Hourly: store.MustParseMoney("$400"),
+		Daily: store.MustParseMoney("$2500"), Weekly: store.MustParseMoney("$12000") })
+	calendar.AddBoat("RENT-3", rentals[2], store.RentalRates{ Daily: store.MustParseMoney("$40000") })
+
+	calendar.Crew = store.NewCrewRoster()
+	licenceEnd := time.Now().AddDate(1, 0, 0)
+	calendar.Crew.Add("C1", "Bob", store.Certification{ Role: store.Captain, Licence: "YM-101", Expires: licenceEnd, MaxCapacity: 8 })
+	calendar.Crew.Add("C2", "Alice", store.Certification{ Role: store.FirstOfficer, Licence: "DH-202", Expires: licenceEnd, MaxCapacity: 20 })
+	calendar.Crew.Add("C3", "Dora", store.Certification{ Role: store.Captain, Licence: "YM-303", Expires: licenceEnd, MaxCapacity: 30 })
+
+	start := time.Now().Truncate(time.Hour).Add(10 * store.Day)
+	booking, err := calendar.Book("RENT-2", "Alice", start, start.Add(2 * store.Day + 3 * time.Hour))
+	if err == nil {
+		fmt.Println("Booked:", booking.ID, booking.SKU, "Price:", booking.Price, "Captain:", booking.Crew.Captain)
+	}
+	if _, err := calendar.Book("RENT-3", "Charlie", start, start.Add(store.Day)); err != nil {
+		fmt.Println("Booking failed:", err)
+	}
+	if _, err := calendar.Book("RENT-2", "Bob", start.Add(2 * store.Day + 4 * time.Hour), start.Add(3 * store.Day)); err != nil {
+		fmt.Println("Booking failed:", err)
+	}
+	fmt.Println("Free for 10:", calendar.Available(start, start.Add(store.Day), 10))
+	refund, _ := calendar.Cancel(booking.ID)
+	fmt.Println("Cancelled:", booking.ID, "Refund:", refund)
+
+	bulk, err := store.NewDiscountDeal("Club Offer", product)
+	if err != nil {
+		panic(err)
+	}
+	for _, err := range []error{
+		bulk.AddDiscount(store.BuyXGetY{ Label: "3 for 2", Buy: 2, Free: 1 }, store.Exclusive),
+		bulk.AddDiscount(store.Percentage{ Label: "Members 10%", Rate: 0.1 }, store.Stackable),
+		bulk.AddDiscount(store.ValidBetween(store.FixedAmount{ Label: "Launch", Off: store.MustParseMoney("$20") },
+			time.Time{}, time.Now().AddDate(0, 1, 0)), store.Stackable),
+		bulk.SetFloor(store.MustParseMoney("$200")),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	total, bulkApplied, err := bulk.Quote(time.Now(), 3)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Club Offer x3:", total)
+	for _, d := range bulkApplied {
+		fmt.Println("Discount:", d.Name, d.Amount)
+	}
 }
\ No newline at end of file
@@ -6,7 +6,7 @@ type Boat struct {
 	Motorized bool
 }
 
-func NewBoat(name string, price float64, capacity int, motorized bool) *Boat {
+func NewBoat(name string, price Money, capacity int, motorized bool) *Boat {
 	return &Boat{
 		NewProduct(name, "Watersports", price), capacity, motorized,
 	}
@@ -0,0 +1,374 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	Day = 24 * time.Hour
+	Week = 7 * Day
+)
+
+// RentalRates prices a rental by the hour, day and week. A zero rate means
+// the boat is not let for that period; partial periods are charged in full
+// at whichever combination of rates is cheapest.
+type RentalRates struct {
+	Hourly Money `json:"hourly"`
+	Daily Money `json:"daily"`
+	Weekly Money `json:"weekly"`
+}
+
+func (rates RentalRates) Price(duration time.Duration) (Money, error) {
+	if duration <= 0 {
+		return Money{}, fmt.Errorf("store: a rental must last longer than zero")
+	}
+	cost, ok := rates.weeks(duration)
+	if !ok {
+		return Money{}, fmt.Errorf("store: no rate covers a rental of %v", duration)
+	}
+	return cost, nil
+}
+
+func cheaper(a Money, aok bool, b Money, bok bool) (Money, bool) {
+	if !aok {
+		return b, bok
+	}
+	if !bok || a.minor <= b.minor {
+		return a, true
+	}
+	return b, true
+}
+
+func periods(duration, period time.Duration) int64 {
+	return int64((duration + period - 1) / period)
+}
+
+func (rates RentalRates) hours(duration time.Duration) (Money, bool) {
+	if duration <= 0 {
+		return Money{ 0, rates.currency() }, true
+	}
+	if rates.Hourly.IsZero() {
+		return Money{}, false
+	}
+	return rates.Hourly.Multiply(periods(duration, time.Hour)), true
+}
+
+func (rates RentalRates) days(duration time.Duration) (Money, bool) {
+	best, ok := rates.hours(duration)
+	if !rates.Daily.IsZero() {
+		whole := duration / Day
+		rest, restOK := rates.hours(duration - whole * Day)
+		if restOK {
+			best, ok = cheaper(best, ok, rates.Daily.Multiply(int64(whole)).Add(rest), true)
+		}
+		best, ok = cheaper(best, ok, rates.Daily.Multiply(periods(duration, Day)), true)
+	}
+	return best, ok
+}
+
+func (rates RentalRates) weeks(duration time.Duration) (Money, bool) {
+	best, ok := rates.days(duration)
+	if !rates.Weekly.IsZero() {
+		whole := duration / Week
+		rest, restOK := rates.days(duration - whole * Week)
+		if restOK {
+			best, ok = cheaper(best, ok, rates.Weekly.Multiply(int64(whole)).Add(rest), true)
+		}
+		best, ok = cheaper(best, ok, rates.Weekly.Multiply(periods(duration, Week)), true)
+	}
+	return best, ok
+}
+
+func (rates RentalRates) currency() string {
+	for _, rate := range []Money{ rates.Hourly, rates.Daily, rates.Weekly } {
+		if rate.currency != "" {
+			return rate.currency
+		}
+	}
+	return ""
+}
+
+// RefundRule refunds Percent of the price when a booking is cancelled at
+// least Notice before it starts.
+type RefundRule struct {
+	Notice time.Duration `json:"notice"`
+	Percent float64 `json:"percent"`
+}
+
+type BookingStatus string
+
+const (
+	BookingConfirmed BookingStatus = "confirmed"
+	BookingCancelled BookingStatus = "cancelled"
+)
+
+type Booking struct {
+	ID string `json:"id"`
+	SKU string `json:"sku"`
+	Customer string `json:"customer"`
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+	Price Money `json:"price"`
+	Status BookingStatus `json:"status"`
+	Refund Money `json:"refund"`
+	Crew *Crew `json:"crew,omitempty"`
+	// CrewIDs holds the roster IDs of the captain and first officer named
+	// in Crew, which unlike their names are unique.
+	CrewIDs []string `json:"crewIds,omitempty"`
+}
+
+type BookingConflictError struct {
+	SKU string
+	Existing Booking
+}
+
+func (err *BookingConflictError) Error() string {
+	return fmt.Sprintf("store: %v is booked from %v to %v", err.SKU,
+		err.Existing.Start.Format(time.RFC3339), err.Existing.End.Format(time.RFC3339))
+}
+
+// Calendar is implemented by BookingCalendar and FileCalendar.
+type Calendar interface {
+	AddBoat(sku string, boat *RentalBoat, rates RentalRates) error
+	Rates(sku string) (RentalRates, bool)
+	Quote(sku string, start, end time.Time) (Money, error)
+	Book(sku, customer string, start, end time.Time) (*Booking, error)
+	Cancel(id string) (Money, error)
+	Booking(id string) (Booking, bool)
+	Bookings(sku string) []Booking
+	Available(start, end time.Time, capacity int) []string
+}
+
+type rentalListing struct {
+	boat *RentalBoat
+	rates RentalRates
+}
+
+// BookingCalendar lets rental boats out for periods of time. Buffer is kept
+// free after each booking for cleaning before the boat can go out again.
+// Bookings for boats that include crew are staffed from Crew.
+type BookingCalendar struct {
+	mutex sync.Mutex
+	listings map[string]rentalListing
+	bookings []*Booking
+	nextID int
+	Buffer time.Duration
+	RefundRules []RefundRule
+	Crew *CrewRoster
+	Now func() time.Time
+}
+
+func NewBookingCalendar(buffer time.Duration, refunds ...RefundRule) *BookingCalendar {
+	return &BookingCalendar{ listings: map[string]rentalListing{}, Buffer: buffer,
+		RefundRules: refunds, Now: time.Now }
+}
+
+func (calendar *BookingCalendar) AddBoat(sku string, boat *RentalBoat, rates RentalRates) error {
+	if boat == nil {
+		return fmt.Errorf("store: no boat given for %v", sku)
+	}
+	if rates.currency() == "" {
+		return fmt.Errorf("store: %v needs at least one rental rate", sku)
+	}
+	for _, rate := range []Money{ rates.Hourly, rates.Daily, rates.Weekly } {
+		if rate.currency != "" && rate.currency != rates.currency() {
+			return fmt.Errorf("store: the rates for %v use more than one currency", sku)
+		}
+	}
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	calendar.listings[sku] = rentalListing{ boat, rates }
+	return nil
+}
+
+func (calendar *BookingCalendar) Rates(sku string) (RentalRates, bool) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	listing, found := calendar.listings[sku]
+	return listing.rates, found
+}
+
+// conflict returns the first confirmed booking that, with its cleaning
+// buffer, overlaps the period from start to end.
+func (calendar *BookingCalendar) conflict(sku string, start, end time.Time) *Booking {
+	for _, booking := range calendar.bookings {
+		if booking.SKU != sku || booking.Status != BookingConfirmed {
+			continue
+		}
+		if start.Before(booking.End.Add(calendar.Buffer)) && booking.Start.Before(end.Add(calendar.Buffer)) {
+			return booking
+		}
+	}
+	return nil
+}
+
+func (calendar *BookingCalendar) Quote(sku string, start, end time.Time) (Money, error) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	listing, found := calendar.listings[sku]
+	if !found {
+		return Money{}, fmt.Errorf("store: %v is not available for rental", sku)
+	}
+	return listing.rates.Price(end.Sub(start))
+}
+
+func (calendar *BookingCalendar) Book(sku, customer string, start, end time.Time) (*Booking, error) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	listing, found := calendar.listings[sku]
+	if !found {
+		return nil, fmt.Errorf("store: %v is not available for rental", sku)
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("store: a booking must end after it starts")
+	}
+	if start.Before(calendar.Now()) {
+		return nil, fmt.Errorf("store: bookings cannot start in the past")
+	}
+	price, err := listing.rates.Price(end.Sub(start))
+	if err != nil {
+		return nil, err
+	}
+	if existing := calendar.conflict(sku, start, end); existing != nil {
+		return nil, &BookingConflictError{ sku, *existing }
+	}
+	booking := &Booking{
+		ID: fmt.Sprintf("BK-%06d", calendar.nextID + 1), SKU: sku, Customer: customer,
+		Start: start, End: end, Price: price, Status: BookingConfirmed,
+	}
+	if listing.boat.IncludeCrew {
+		if calendar.Crew == nil {
+			return nil, fmt.Errorf("store: %v needs crew but no roster is set", sku)
+		}
+		if booking.Crew, booking.CrewIDs, err = calendar.Crew.assign(booking.ID, listing.boat.Boat, start, end); err != nil {
+			return nil, err
+		}
+	}
+	calendar.nextID++
+	calendar.bookings = append(calendar.bookings, booking)
+	copied := *booking
+	return &copied, nil
+}
+
+// refundPercent finds the most generous rule whose notice period is met.
+func (calendar *BookingCalendar) refundPercent(notice time.Duration) float64 {
+	percent := 0.0
+	for _, rule := range calendar.RefundRules {
+		if notice >= rule.Notice && rule.Percent > percent {
+			percent = rule.Percent
+		}
+	}
+	return percent
+}
+
+// unbook takes back a booking just made, freeing its crew.
+func (calendar *BookingCalendar) unbook(id string) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	for i, booking := range calendar.bookings {
+		if booking.ID != id {
+			continue
+		}
+		calendar.bookings = append(calendar.bookings[:i], calendar.bookings[i+1:]...)
+		if booking.ID == fmt.Sprintf("BK-%06d", calendar.nextID) {
+			calendar.nextID--
+		}
+		if booking.Crew != nil {
+			calendar.Crew.release(booking.ID)
+		}
+		return
+	}
+}
+
+// uncancel confirms a booking just cancelled again, holding its crew.
+func (calendar *BookingCalendar) uncancel(id string) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	for _, booking := range calendar.bookings {
+		if booking.ID == id && booking.Status == BookingCancelled {
+			booking.Status, booking.Refund = BookingConfirmed, Money{}
+			if booking.Crew != nil {
+				calendar.Crew.hold(booking.ID, booking.CrewIDs, booking.Start, booking.End)
+			}
+			return
+		}
+	}
+}
+
+// Cancel frees the boat and returns the refund due under the refund rules.
+// Bookings that have already started cannot be cancelled.
+func (calendar *BookingCalendar) Cancel(id string) (Money, error) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	for _, booking := range calendar.bookings {
+		if booking.ID != id {
+			continue
+		}
+		if booking.Status != BookingConfirmed {
+			return Money{}, fmt.Errorf("store: booking %v is already %v", id, booking.Status)
+		}
+		notice := booking.Start.Sub(calendar.Now())
+		if notice <= 0 {
+			return Money{}, fmt.Errorf("store: booking %v has already started", id)
+		}
+		percent := calendar.refundPercent(notice)
+		if !(percent >= 0 && percent <= 100) {
+			return Money{}, fmt.Errorf("store: a refund rule gives back %v percent", percent)
+		}
+		booking.Status = BookingCancelled
+		if booking.Crew != nil {
+			calendar.Crew.release(booking.ID)
+		}
+		booking.Refund = booking.Price.MultiplyByRate(percent / 100, RoundHalfEven)
+		return booking.Refund, nil
+	}
+	return Money{}, fmt.Errorf("store: no booking %v", id)
+}
+
+func (calendar *BookingCalendar) Booking(id string) (Booking, bool) {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	for _, booking := range calendar.bookings {
+		if booking.ID == id {
+			return *booking, true
+		}
+	}
+	return Booking{}, false
+}
+
+// Bookings lists the bookings for sku, or for every boat if sku is empty,
+// in order of start time.
+func (calendar *BookingCalendar) Bookings(sku string) []Booking {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	bookings := []Booking{}
+	for _, booking := range calendar.bookings {
+		if sku == "" || booking.SKU == sku {
+			bookings = append(bookings, *booking)
+		}
+	}
+	sort.SliceStable(bookings, func(i, j int) bool { return bookings[i].Start.Before(bookings[j].Start) })
+	return bookings
+}
+
+// Available returns the SKUs of boats that hold at least capacity people and
+// are free from start to end, with a crew free to sail them if they need one.
+func (calendar *BookingCalendar) Available(start, end time.Time, capacity int) []string {
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	skus := []string{}
+	for sku, listing := range calendar.listings {
+		if listing.boat.Capacity < capacity || calendar.conflict(sku, start, end) != nil {
+			continue
+		}
+		if listing.boat.IncludeCrew && (calendar.Crew == nil || !calendar.Crew.staffed(listing.boat.Boat, start, end)) {
+			continue
+		}
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	return skus
+}
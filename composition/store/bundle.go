@@ -0,0 +1,166 @@
+package store
+
+import "fmt"
+
+// BundleComponent is a catalog item in a bundle and how many of it each
+// bundle holds.
+type BundleComponent struct {
+	SKU string `json:"sku"`
+	Quantity int `json:"quantity"`
+}
+
+// Bundle sells several catalog items as one, such as a kayak with a paddle
+// and a lifejacket. A fixed price bundle keeps the price it was made with;
+// otherwise the catalog prices it at the components' combined price less
+// Discount, and prices it again whenever a component changes.
+type Bundle struct {
+	*Product
+	Components []BundleComponent
+	Discount Money
+	FixedPrice bool
+}
+
+func NewBundle(name, category string, price Money, components ...BundleComponent) *Bundle {
+	return &Bundle{ Product: NewProduct(name, category, price), Components: components, FixedPrice: true }
+}
+
+// NewDiscountBundle makes a bundle priced from its components. It has no
+// price until it is put in a catalog.
+func NewDiscountBundle(name, category string, discount Money, components ...BundleComponent) *Bundle {
+	return &Bundle{ Product: NewProduct(name, category, Money{ 0, discount.currency }), Components: components,
+		Discount: discount }
+}
+
+// withPrice copies the bundle at a new price, leaving the original, which
+// carts may still hold, untouched.
+func (b *Bundle) withPrice(price Money) *Bundle {
+	copied := *b
+	copied.Product = &Product{ b.Name, b.Category, price }
+	return &copied
+}
+
+func (b *Bundle) uses(skus ...string) bool {
+	for _, component := range b.Components {
+		for _, sku := range skus {
+			if component.SKU == sku {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Available works out how many whole bundles the components in stock can
+// make.
+func (b *Bundle) Available(stock StockChecker) int {
+	available := -1
+	for _, component := range b.Components {
+		if made := stock.Available(component.SKU) / component.Quantity; available < 0 || made < available {
+			available = made
+		}
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// Unbundle lists the components in quantity bundles.
+func (b *Bundle) Unbundle(quantity int) []BundleComponent {
+	components := make([]BundleComponent, len(b.Components))
+	for i, component := range b.Components {
+		components[i] = BundleComponent{ component.SKU, component.Quantity * quantity }
+	}
+	return components
+}
+
+// bundlePrice checks that every component of the bundle under sku is in the
+// catalog and works out what the bundle should cost. The catalog must be
+// locked.
+func (catalog *MemoryCatalog) bundlePrice(sku string, b *Bundle) (Money, error) {
+	if len(b.Components) == 0 {
+		return Money{}, fmt.Errorf("store: bundle %v has no components", b.Name)
+	}
+	currency := b.price.currency
+	if !b.FixedPrice {
+		currency = b.Discount.currency
+	}
+	total := Money{ 0, currency }
+	seen := map[string]bool{}
+	for _, component := range b.Components {
+		if component.Quantity < 1 {
+			return Money{}, fmt.Errorf("store: bundle %v cannot hold %v of %v", b.Name, component.Quantity, component.SKU)
+		}
+		if component.SKU == sku || seen[component.SKU] {
+			return Money{}, fmt.Errorf("store: bundle %v lists %v more than once", b.Name, component.SKU)
+		}
+		seen[component.SKU] = true
+		item, found := catalog.get(component.SKU)
+		if !found {
+			return Money{}, fmt.Errorf("store: bundle %v needs %v, which is not in the catalog", b.Name, component.SKU)
+		}
+		switch item.(type) {
+		case *Bundle, *VariantProduct, *RentalBoat:
+			return Money{}, fmt.Errorf("store: %v cannot go in bundle %v, only items sold as they are",
+				component.SKU, b.Name)
+		}
+		price := item.BaseProduct().price
+		if price.currency != currency {
+			return Money{}, fmt.Errorf("store: %v is priced in %v but bundle %v is in %v", component.SKU,
+				price.currency, b.Name, currency)
+		}
+		total = total.Add(price.Multiply(int64(component.Quantity)))
+	}
+	if b.FixedPrice {
+		return b.price, nil
+	}
+	if total = total.Sub(b.Discount); total.IsNegative() {
+		return Money{}, fmt.Errorf("store: the discount on bundle %v is more than its components cost", b.Name)
+	}
+	return total, nil
+}
+
+// repriceBundles prices every bundle again after a change to the catalog,
+// replacing those whose price moved. If any bundle is no longer valid the
+// catalog is left as it was.
+func (catalog *MemoryCatalog) repriceBundles(changed ...string) error {
+	replacements := map[string]*Bundle{}
+	for sku, item := range catalog.items {
+		b, isBundle := item.(*Bundle)
+		if !isBundle || !b.uses(changed...) {
+			continue
+		}
+		price, err := catalog.bundlePrice(sku, b)
+		if err != nil {
+			return err
+		}
+		if price != b.price {
+			replacements[sku] = b.withPrice(price)
+		}
+	}
+	for sku, b := range replacements {
+		catalog.items[sku] = b
+	}
+	return nil
+}
+
+// usedBy names the bundles that hold any of skus.
+func (catalog *MemoryCatalog) usedBy(skus ...string) []string {
+	names := []string{}
+	for sku, item := range catalog.items {
+		if b, isBundle := item.(*Bundle); isBundle && b.uses(skus...) {
+			names = append(names, sku)
+		}
+	}
+	return names
+}
+
+func skusOf(sku string, item Item) []string {
+	skus := []string{ sku }
+	if product, hasVariants := item.(*VariantProduct); hasVariants {
+		for _, variant := range product.variants {
+			skus = append(skus, variant.SKU)
+		}
+	}
+	return skus
+}
@@ -0,0 +1,419 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CartLine struct {
+	SKU string
+	Quantity int
+	UnitPrice Money
+}
+
+// Cart collects items from a catalog. Each line remembers the unit price at
+// the time it was added so checkout can detect prices that changed since.
+// Unit prices stay in the catalog's currencies; a cart set to the customer's
+// own currency converts them when it is totalled.
+type Cart struct {
+	catalog Catalog
+	taxes TaxPolicy
+	Location Location
+	deals map[string]*SpecialDeal
+	lines []*CartLine
+	reservation string
+	paying string
+	rates *ExchangeRates
+	exchange map[string]ExchangeRate
+	customer string
+	points int
+	credit Money
+	memberDiscounts []Discount
+	giftCards []GiftCardPayment
+}
+
+func NewCart(catalog Catalog, taxes TaxPolicy, location Location) *Cart {
+	return &Cart{ catalog: catalog, taxes: taxes, Location: location, deals: map[string]*SpecialDeal{} }
+}
+
+func saleTypeOf(item Item) SaleType {
+	if _, isRental := item.(*RentalBoat); isRental {
+		return Rental
+	}
+	return Sale
+}
+
+// UseCurrency prices the cart in currency, converting lines priced in other
+// currencies at the rates in rates in force at time at.
+func (cart *Cart) UseCurrency(currency string, rates *ExchangeRates, at time.Time) error {
+	if !isCurrencyCode(currency) {
+		return fmt.Errorf("store: %q is not a currency code", currency)
+	}
+	previous, previousRates := cart.paying, cart.rates
+	cart.paying, cart.rates = currency, rates
+	if err := cart.updateRates(at); err != nil {
+		cart.paying, cart.rates = previous, previousRates
+		return err
+	}
+	return nil
+}
+
+// updateRates takes the rates in force at time at for every currency the
+// cart's lines are priced in.
+func (cart *Cart) updateRates(at time.Time) error {
+	if cart.paying == "" {
+		return nil
+	}
+	exchange := map[string]ExchangeRate{}
+	for _, line := range cart.lines {
+		from := line.UnitPrice.currency
+		if _, found := exchange[from]; found {
+			continue
+		}
+		rate, err := cart.rates.Rate(from, cart.paying, at)
+		if err != nil {
+			return err
+		}
+		exchange[from] = rate
+	}
+	cart.exchange = exchange
+	return nil
+}
+
+// Currency is the currency the cart is totalled in.
+func (cart *Cart) Currency() string {
+	return cart.currency()
+}
+
+func (cart *Cart) currency() string {
+	if cart.paying != "" {
+		return cart.paying
+	}
+	if len(cart.lines) == 0 {
+		return ""
+	}
+	return cart.lines[0].UnitPrice.currency
+}
+
+// SetCustomer prices the cart for a customer. Their discounts apply to every
+// line after any deal, and credit, what the points they redeem are worth in
+// the cart's currency, comes off the lines in proportion to their net.
+func (cart *Cart) SetCustomer(id string, points int, credit Money, discounts ...Discount) {
+	cart.customer, cart.points, cart.credit = id, points, credit
+	cart.memberDiscounts = append([]Discount{}, discounts...)
+}
+
+func (cart *Cart) Customer() string {
+	return cart.customer
+}
+
+// PayWithGiftCard pays up to limit of the cart's total from a gift card,
+// replacing any amount already set for that card. Cards pay in the order
+// they were added.
+func (cart *Cart) PayWithGiftCard(code string, limit Money) error {
+	if limit.currency != cart.currency() || limit.minor <= 0 {
+		return fmt.Errorf("store: a gift card must pay more than nothing in %v", cart.currency())
+	}
+	for i := range cart.giftCards {
+		if cart.giftCards[i].Code == code {
+			cart.giftCards[i].Amount = limit
+			return nil
+		}
+	}
+	cart.giftCards = append(cart.giftCards, GiftCardPayment{ code, limit })
+	return nil
+}
+
+func (cart *Cart) RemoveGiftCard(code string) error {
+	for i, payment := range cart.giftCards {
+		if payment.Code == code {
+			cart.giftCards = append(cart.giftCards[:i:i], cart.giftCards[i + 1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("store: gift card %v is not paying for the cart", code)
+}
+
+func (cart *Cart) line(sku string) *CartLine {
+	for _, line := range cart.lines {
+		if line.SKU == sku {
+			return line
+		}
+	}
+	return nil
+}
+
+func (cart *Cart) Add(sku string, quantity int) error {
+	if quantity < 1 {
+		return fmt.Errorf("store: cannot add %v of %v", quantity, sku)
+	}
+	item, found := cart.catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	if product, hasVariants := item.(*VariantProduct); hasVariants {
+		return fmt.Errorf("store: %v comes in variants, choose a %v", sku, strings.Join(product.Axes, " and "))
+	}
+	price := item.BaseProduct().price
+	if _, held := cart.exchange[price.currency]; cart.paying != "" && !held {
+		rate, err := cart.rates.Rate(price.currency, cart.paying, time.Now())
+		if err != nil {
+			return err
+		}
+		cart.exchange[price.currency] = rate
+	} else if currency := cart.currency(); cart.paying == "" && currency != "" && price.currency != currency {
+		return fmt.Errorf("store: %v is priced in %v but the cart is in %v", sku, price.currency, currency)
+	}
+	if line := cart.line(sku); line != nil {
+		line.Quantity += quantity
+		return nil
+	}
+	cart.lines = append(cart.lines, &CartLine{ sku, quantity, price })
+	return nil
+}
+
+// AddVariant adds the variant of the product under sku that has the given
+// options. The line is held under the variant's own SKU.
+func (cart *Cart) AddVariant(sku string, options Options, quantity int) (*Variant, error) {
+	variant, err := ResolveVariant(cart.catalog, sku, options)
+	if err != nil {
+		return nil, err
+	}
+	return variant, cart.Add(variant.SKU, quantity)
+}
+
+// SetQuantity changes the quantity of a line, removing it when quantity is
+// zero.
+func (cart *Cart) SetQuantity(sku string, quantity int) error {
+	if quantity < 0 {
+		return fmt.Errorf("store: cannot set %v of %v", quantity, sku)
+	}
+	for i, line := range cart.lines {
+		if line.SKU == sku {
+			if quantity == 0 {
+				cart.lines = append(cart.lines[:i], cart.lines[i+1:]...)
+			} else {
+				line.Quantity = quantity
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("store: %v is not in the cart", sku)
+}
+
+func (cart *Cart) Remove(sku string) error {
+	return cart.SetQuantity(sku, 0)
+}
+
+func (cart *Cart) Lines() []CartLine {
+	lines := make([]CartLine, len(cart.lines))
+	for i, line := range cart.lines {
+		lines[i] = *line
+	}
+	return lines
+}
+
+// ApplyDeal prices the line for sku using deal. The deal must be for the
+// product the catalog holds under that SKU.
+func (cart *Cart) ApplyDeal(sku string, deal *SpecialDeal) error {
+	item, found := cart.catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	if deal.Product != item.BaseProduct() {
+		return fmt.Errorf("store: deal %v is not for %v", deal.Name, sku)
+	}
+	if err := deal.check(); err != nil {
+		return err
+	}
+	cart.deals[sku] = deal
+	return nil
+}
+
+// stockQuantities totals the units of each SKU the cart takes from stock,
+// counting bundles as their components.
+func (cart *Cart) stockQuantities() map[string]int {
+	quantities := map[string]int{}
+	for _, line := range cart.lines {
+		item, _ := cart.catalog.Get(line.SKU)
+		if b, isBundle := item.(*Bundle); isBundle {
+			for _, component := range b.Unbundle(line.Quantity) {
+				quantities[component.SKU] += component.Quantity
+			}
+			continue
+		}
+		quantities[line.SKU] += line.Quantity
+	}
+	return quantities
+}
+
+// PricedLine is a cart line with its discounts and tax. For a bundle,
+// Components lists what the line's bundles hold, as they leave the stock.
+type PricedLine struct {
+	SKU, Name, Category string
+	Quantity int
+	Components []BundleComponent
+	UnitPrice, Subtotal Money
+	Discounts []AppliedDiscount
+	Net Money
+	Tax TaxBreakdown
+	Gross Money
+}
+
+// CartTotals lists any exchange rates used to convert the lines in Rates,
+// sorted by the currency converted from. Points is the number of loyalty
+// points redeemed against the cart. GiftCards lists what gift cards pay of
+// the total, leaving Due to be paid some other way.
+type CartTotals struct {
+	Lines []PricedLine
+	Subtotal, Discount, Net, Tax, Total Money
+	Rates []ExchangeRate
+	Points int
+	GiftCards []GiftCardPayment
+	Due Money
+}
+
+// GiftCardPayment is an amount paid from a gift card.
+type GiftCardPayment struct {
+	Code string `json:"code"`
+	Amount Money `json:"amount"`
+}
+
+// PointsDiscount names the share of redeemed loyalty points on a line.
+const PointsDiscount = "Loyalty points"
+
+// memberDiscount applies the customer's discounts to a line after its deal.
+func (cart *Cart) memberDiscount(priced *PricedLine, at time.Time) {
+	for _, d := range cart.memberDiscounts {
+		amount := d.Amount(DiscountContext{ priced.UnitPrice, priced.Quantity, priced.Net, at })
+		if amount.Cmp(priced.Net) > 0 {
+			amount = priced.Net
+		}
+		if amount.minor <= 0 {
+			continue
+		}
+		priced.Net = priced.Net.Sub(amount)
+		priced.Discounts = append(priced.Discounts, AppliedDiscount{ d.Name(), amount })
+	}
+}
+
+// redeem spreads the cart's credit over the lines by their net. Credit worth
+// more than the lines, or in another currency, is not used at all.
+func (cart *Cart) redeem(lines []PricedLine) bool {
+	if cart.points <= 0 || cart.credit.currency != cart.currency() {
+		return false
+	}
+	ratios := make([]int, len(lines))
+	net := Money{ 0, cart.credit.currency }
+	for i, line := range lines {
+		ratios[i] = int(line.Net.minor)
+		net = net.Add(line.Net)
+	}
+	if cart.credit.Cmp(net) > 0 {
+		return false
+	}
+	for i, share := range cart.credit.Allocate(ratios...) {
+		if share.minor > 0 {
+			lines[i].Net = lines[i].Net.Sub(share)
+			lines[i].Discounts = append(lines[i].Discounts, AppliedDiscount{ PointsDiscount, share })
+		}
+	}
+	return true
+}
+
+// convert turns an amount in one of the catalog's currencies into the cart's
+// currency at the rate the cart holds for it.
+func (cart *Cart) convert(amount Money) Money {
+	rate, found := cart.exchange[amount.currency]
+	if !found || rate.From == rate.To {
+		return amount
+	}
+	converted, _ := rate.Convert(amount, cart.rates.Rounding)
+	return converted
+}
+
+// convertDiscounts converts each discount on a line. The last takes up any
+// difference from rounding so the discounts still add up to total.
+func (cart *Cart) convertDiscounts(discounts []AppliedDiscount, total Money) []AppliedDiscount {
+	converted := make([]AppliedDiscount, len(discounts))
+	for i, d := range discounts {
+		converted[i] = AppliedDiscount{ d.Name, cart.convert(d.Amount) }
+		total = total.Sub(converted[i].Amount)
+	}
+	if len(converted) > 0 {
+		last := &converted[len(converted) - 1]
+		last.Amount = last.Amount.Add(total)
+	}
+	return converted
+}
+
+// Totals prices every line at time at using the prices held in the cart. In
+// a cart with a currency of its own, unit prices and the net after discounts
+// are converted, and tax is charged on the converted net. A customer's
+// discounts and points are taken off before tax.
+func (cart *Cart) Totals(at time.Time) CartTotals {
+	zero := Money{ 0, cart.currency() }
+	totals := CartTotals{ Lines: []PricedLine{}, Subtotal: zero, Discount: zero, Net: zero, Tax: zero, Total: zero }
+	for _, rate := range cart.exchange {
+		if rate.From != rate.To {
+			totals.Rates = append(totals.Rates, rate)
+		}
+	}
+	sort.Slice(totals.Rates, func(i, j int) bool { return totals.Rates[i].From < totals.Rates[j].From })
+	sales := make([]SaleType, len(cart.lines))
+	for i, line := range cart.lines {
+		item, _ := cart.catalog.Get(line.SKU)
+		unit := cart.convert(line.UnitPrice)
+		priced := PricedLine{ SKU: line.SKU, Quantity: line.Quantity, UnitPrice: unit,
+			Subtotal: unit.Multiply(int64(line.Quantity)), Discounts: []AppliedDiscount{} }
+		category, sale := "", Sale
+		if item != nil {
+			priced.Name, category, sale = item.BaseProduct().Name, item.BaseProduct().Category, saleTypeOf(item)
+		}
+		priced.Category, sales[i] = category, sale
+		if b, isBundle := item.(*Bundle); isBundle {
+			priced.Components = b.Unbundle(line.Quantity)
+		}
+		priced.Net = priced.Subtotal
+		// A deal that no longer suits its product is left out of the price.
+		if deal, found := cart.deals[line.SKU]; found {
+			if net, discounts, err := deal.Quote(at, line.Quantity); err == nil {
+				priced.Net, priced.Discounts = net, discounts
+				if cart.paying != "" {
+					priced.Net = cart.convert(priced.Net)
+					priced.Discounts = cart.convertDiscounts(priced.Discounts, priced.Subtotal.Sub(priced.Net))
+				}
+			}
+		}
+		cart.memberDiscount(&priced, at)
+		totals.Lines = append(totals.Lines, priced)
+	}
+	if cart.redeem(totals.Lines) {
+		totals.Points = cart.points
+	}
+	for i := range totals.Lines {
+		priced := &totals.Lines[i]
+		priced.Tax = cart.taxes.Apply(priced.Net, priced.Category, cart.Location, sales[i])
+		priced.Gross = priced.Tax.Gross
+
+		totals.Subtotal = totals.Subtotal.Add(priced.Subtotal)
+		totals.Discount = totals.Discount.Add(priced.Subtotal.Sub(priced.Net))
+		totals.Net = totals.Net.Add(priced.Net)
+		totals.Tax = totals.Tax.Add(priced.Tax.Tax)
+		totals.Total = totals.Total.Add(priced.Gross)
+	}
+	totals.Due = totals.Total
+	for _, payment := range cart.giftCards {
+		if payment.Amount.currency != totals.Due.currency || totals.Due.minor == 0 {
+			continue
+		}
+		if payment.Amount.Cmp(totals.Due) > 0 {
+			payment.Amount = totals.Due
+		}
+		totals.GiftCards = append(totals.GiftCards, payment)
+		totals.Due = totals.Due.Sub(payment.Amount)
+	}
+	return totals
+}
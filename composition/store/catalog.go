@@ -0,0 +1,319 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Item is anything the catalog can hold: a Product, or a Boat or RentalBoat
+// through their embedded Product.
+type Item interface {
+	BaseProduct() *Product
+}
+
+func (p *Product) BaseProduct() *Product {
+	return p
+}
+
+// Vessel gives access to the boat details of a Boat or RentalBoat.
+func (b *Boat) Vessel() *Boat {
+	return b
+}
+
+type vessel interface {
+	Vessel() *Boat
+}
+
+const (
+	TypeProduct = "product"
+	TypeBoat = "boat"
+	TypeRental = "rental"
+	TypeBundle = "bundle"
+)
+
+// ItemType names the concrete type of a catalog item, as used by the wire
+// formats and by ProductQuery.Type.
+func ItemType(item Item) string {
+	switch item.(type) {
+	case *Bundle:
+		return TypeBundle
+	case *RentalBoat:
+		return TypeRental
+	case *Boat:
+		return TypeBoat
+	}
+	return TypeProduct
+}
+
+type CatalogEntry struct {
+	SKU string
+	Item Item
+}
+
+const (
+	SortBySKU = "sku"
+	SortByName = "name"
+	SortByPrice = "price"
+	SortByCapacity = "capacity"
+)
+
+// ProductQuery selects catalog entries. Zero-valued fields do not filter;
+// MinPrice and MaxPrice only match items priced in the same currency.
+type ProductQuery struct {
+	Type string
+	Category string
+	MinPrice, MaxPrice Money
+	MinCapacity int
+	Motorized *bool
+	SortBy string
+	Descending bool
+	Offset, Limit int
+}
+
+type QueryResult struct {
+	Entries []CatalogEntry
+	Total int
+}
+
+type Catalog interface {
+	Put(sku string, item Item) error
+	Get(sku string) (Item, bool)
+	Remove(sku string) error
+	Query(query ProductQuery) QueryResult
+}
+
+func isUnset(m Money) bool {
+	return m.minor == 0 && m.currency == ""
+}
+
+func (query ProductQuery) matches(item Item) bool {
+	p := item.BaseProduct()
+	if query.Type != "" && query.Type != ItemType(item) {
+		return false
+	}
+	if query.Category != "" && !strings.EqualFold(query.Category, p.Category) {
+		return false
+	}
+	if !isUnset(query.MinPrice) && (query.MinPrice.currency != p.price.currency || p.price.minor < query.MinPrice.minor) {
+		return false
+	}
+	if !isUnset(query.MaxPrice) && (query.MaxPrice.currency != p.price.currency || p.price.minor > query.MaxPrice.minor) {
+		return false
+	}
+	if query.MinCapacity > 0 || query.Motorized != nil {
+		v, isVessel := item.(vessel)
+		if !isVessel {
+			return false
+		}
+		boat := v.Vessel()
+		if boat.Capacity < query.MinCapacity {
+			return false
+		}
+		if query.Motorized != nil && boat.Motorized != *query.Motorized {
+			return false
+		}
+	}
+	return true
+}
+
+func capacity(item Item) int {
+	if v, isVessel := item.(vessel); isVessel {
+		return v.Vessel().Capacity
+	}
+	return 0
+}
+
+func (query ProductQuery) less(a, b CatalogEntry) bool {
+	pa, pb := a.Item.BaseProduct(), b.Item.BaseProduct()
+	switch query.SortBy {
+	case SortByName:
+		if !strings.EqualFold(pa.Name, pb.Name) {
+			return strings.ToLower(pa.Name) < strings.ToLower(pb.Name)
+		}
+	case SortByPrice:
+		if pa.price.currency != pb.price.currency {
+			return pa.price.currency < pb.price.currency
+		}
+		if pa.price.minor != pb.price.minor {
+			return pa.price.minor < pb.price.minor
+		}
+	case SortByCapacity:
+		if capacity(a.Item) != capacity(b.Item) {
+			return capacity(a.Item) < capacity(b.Item)
+		}
+	}
+	return a.SKU < b.SKU
+}
+
+// run applies the query to entries, which may be reordered.
+func (query ProductQuery) run(entries []CatalogEntry) QueryResult {
+	matched := []CatalogEntry{}
+	for _, entry := range entries {
+		if query.matches(entry.Item) {
+			matched = append(matched, entry)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if query.Descending {
+			return query.less(matched[j], matched[i])
+		}
+		return query.less(matched[i], matched[j])
+	})
+	result := QueryResult{ Total: len(matched) }
+	start := query.Offset
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := len(matched)
+	if query.Limit > 0 && start + query.Limit < end {
+		end = start + query.Limit
+	}
+	result.Entries = matched[start:end]
+	return result
+}
+
+// MemoryCatalog indexes the SKUs of product variants so Get finds them, but
+// only whole products appear in queries.
+type MemoryCatalog struct {
+	mutex sync.RWMutex
+	items map[string]Item
+	variants map[string]*Variant
+}
+
+func NewMemoryCatalog() *MemoryCatalog {
+	return &MemoryCatalog{ items: map[string]Item{}, variants: map[string]*Variant{} }
+}
+
+func validateEntry(sku string, item Item) error {
+	if strings.TrimSpace(sku) == "" {
+		return fmt.Errorf("store: a SKU is required")
+	}
+	if item == nil || item.BaseProduct() == nil {
+		return fmt.Errorf("store: no product given for SKU %v", sku)
+	}
+	if variant, isVariant := item.(*Variant); isVariant {
+		return fmt.Errorf("store: %v is a variant of %v, put the whole product instead", sku, variant.Parent.Name)
+	}
+	return nil
+}
+
+// checkSKUs reports SKUs that item would share with other catalog entries.
+func (catalog *MemoryCatalog) checkSKUs(sku string, item Item) error {
+	if variant, found := catalog.variants[sku]; found {
+		return fmt.Errorf("store: %v is already a variant of %v", sku, variant.Parent.Name)
+	}
+	product, hasVariants := item.(*VariantProduct)
+	if !hasVariants {
+		return nil
+	}
+	for _, variant := range product.variants {
+		if variant.SKU == sku {
+			return fmt.Errorf("store: variant %v has the same SKU as its product", sku)
+		}
+		if _, found := catalog.items[variant.SKU]; found {
+			return fmt.Errorf("store: %v is already in the catalog", variant.SKU)
+		}
+		if other, found := catalog.variants[variant.SKU]; found && other.Parent != catalog.items[sku] {
+			return fmt.Errorf("store: %v is already a variant of %v", variant.SKU, other.Parent.Name)
+		}
+	}
+	return nil
+}
+
+func (catalog *MemoryCatalog) unindex(sku string) {
+	if product, hasVariants := catalog.items[sku].(*VariantProduct); hasVariants {
+		for _, variant := range product.variants {
+			delete(catalog.variants, variant.SKU)
+		}
+	}
+}
+
+// Put adds an item or replaces the item already stored under sku. Bundles
+// priced from their components are priced here, and again whenever one of
+// their components is replaced.
+func (catalog *MemoryCatalog) Put(sku string, item Item) error {
+	if err := validateEntry(sku, item); err != nil {
+		return err
+	}
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	if err := catalog.checkSKUs(sku, item); err != nil {
+		return err
+	}
+	if b, isBundle := item.(*Bundle); isBundle {
+		price, err := catalog.bundlePrice(sku, b)
+		if err != nil {
+			return err
+		}
+		item = b.withPrice(price)
+	}
+	previous, replacing := catalog.items[sku]
+	catalog.set(sku, item)
+	if replacing {
+		if err := catalog.repriceBundles(skusOf(sku, previous)...); err != nil {
+			catalog.set(sku, previous)
+			return err
+		}
+	}
+	return nil
+}
+
+func (catalog *MemoryCatalog) set(sku string, item Item) {
+	catalog.unindex(sku)
+	catalog.items[sku] = item
+	if product, hasVariants := item.(*VariantProduct); hasVariants {
+		for _, variant := range product.variants {
+			catalog.variants[variant.SKU] = variant
+		}
+	}
+}
+
+// Get returns the item stored under sku, or the variant with that SKU.
+func (catalog *MemoryCatalog) Get(sku string) (Item, bool) {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	return catalog.get(sku)
+}
+
+func (catalog *MemoryCatalog) get(sku string) (Item, bool) {
+	if item, found := catalog.items[sku]; found {
+		return item, true
+	}
+	if variant, found := catalog.variants[sku]; found {
+		return variant, true
+	}
+	return nil, false
+}
+
+// Remove deletes the item under sku, unless a bundle still holds it.
+func (catalog *MemoryCatalog) Remove(sku string) error {
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	item, found := catalog.items[sku]
+	if !found {
+		return fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	if bundles := catalog.usedBy(skusOf(sku, item)...); len(bundles) > 0 {
+		sort.Strings(bundles)
+		return fmt.Errorf("store: %v is part of bundle %v", sku, strings.Join(bundles, ", "))
+	}
+	catalog.unindex(sku)
+	delete(catalog.items, sku)
+	return nil
+}
+
+func (catalog *MemoryCatalog) entries() []CatalogEntry {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	entries := make([]CatalogEntry, 0, len(catalog.items))
+	for sku, item := range catalog.items {
+		entries = append(entries, CatalogEntry{ sku, item })
+	}
+	return entries
+}
+
+func (catalog *MemoryCatalog) Query(query ProductQuery) QueryResult {
+	return query.run(catalog.entries())
+}
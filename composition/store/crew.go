@@ -0,0 +1,280 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CrewRole string
+
+const (
+	Captain CrewRole = "captain"
+	FirstOfficer CrewRole = "first officer"
+)
+
+// Certification qualifies a crew member for a role on vessels carrying up
+// to MaxCapacity people, until the licence expires.
+type Certification struct {
+	Role CrewRole
+	Licence string
+	Expires time.Time
+	MaxCapacity int
+}
+
+type period struct {
+	start, end time.Time
+	booking string
+}
+
+func (p period) overlaps(start, end time.Time) bool {
+	return start.Before(p.end) && p.start.Before(end)
+}
+
+type CrewMember struct {
+	ID, Name string
+	Certifications []Certification
+	busy []period
+}
+
+// qualification returns the certification that lets the member fill role on
+// boat for a voyage ending at end.
+func (member *CrewMember) qualification(role CrewRole, boat *Boat, end time.Time) (Certification, bool) {
+	for _, cert := range member.Certifications {
+		if cert.Role == role && cert.MaxCapacity >= boat.Capacity && end.Before(cert.Expires) {
+			return cert, true
+		}
+	}
+	return Certification{}, false
+}
+
+func (member *CrewMember) free(start, end time.Time) bool {
+	for _, p := range member.busy {
+		if p.overlaps(start, end) {
+			return false
+		}
+	}
+	return true
+}
+
+type CrewRoster struct {
+	mutex sync.Mutex
+	members map[string]*CrewMember
+	keep func() error
+	acquire func() (func(), error)
+}
+
+func NewCrewRoster() *CrewRoster {
+	return &CrewRoster{ members: map[string]*CrewMember{},
+		keep: func() error { return nil }, acquire: func() (func(), error) { return func() {}, nil } }
+}
+
+// lock locks the roster for a change or an assignment. For a roster kept in
+// a file it also locks the file and reads back what another process saved.
+func (roster *CrewRoster) lock() (func(), error) {
+	roster.mutex.Lock()
+	release, err := roster.acquire()
+	if err != nil {
+		roster.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		roster.mutex.Unlock()
+	}, nil
+}
+
+// view locks the roster to read it, first reading back the file if another
+// process has changed it. If the file cannot be read, what was last read is
+// used.
+func (roster *CrewRoster) view() func() {
+	if unlock, err := roster.lock(); err == nil {
+		return unlock
+	}
+	roster.mutex.Lock()
+	return roster.mutex.Unlock
+}
+
+func (roster *CrewRoster) Add(id, name string, certifications ...Certification) error {
+	if id == "" || name == "" {
+		return fmt.Errorf("store: crew members need an id and a name")
+	}
+	unlock, err := roster.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, exists := roster.members[id]; exists {
+		return fmt.Errorf("store: crew member %v already exists", id)
+	}
+	roster.members[id] = &CrewMember{ ID: id, Name: name, Certifications: certifications }
+	if err := roster.keep(); err != nil {
+		delete(roster.members, id)
+		return err
+	}
+	return nil
+}
+
+func (roster *CrewRoster) Certify(id string, certification Certification) error {
+	unlock, err := roster.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	member, found := roster.members[id]
+	if !found {
+		return fmt.Errorf("store: no crew member %v", id)
+	}
+	member.Certifications = append(member.Certifications, certification)
+	if err := roster.keep(); err != nil {
+		member.Certifications = member.Certifications[:len(member.Certifications) - 1]
+		return err
+	}
+	return nil
+}
+
+// Members lists the crew, ordered by ID.
+func (roster *CrewRoster) Members() []CrewMember {
+	defer roster.view()()
+	members := make([]CrewMember, 0, len(roster.members))
+	for _, member := range roster.members {
+		copied := *member
+		copied.Certifications = append([]Certification{}, member.Certifications...)
+		copied.busy = nil
+		members = append(members, copied)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// SetUnavailable records leave or other time a crew member cannot work.
+func (roster *CrewRoster) SetUnavailable(id string, start, end time.Time) error {
+	if !end.After(start) {
+		return fmt.Errorf("store: unavailability must end after it starts")
+	}
+	unlock, err := roster.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	member, found := roster.members[id]
+	if !found {
+		return fmt.Errorf("store: no crew member %v", id)
+	}
+	member.busy = append(member.busy, period{ start, end, "" })
+	if err := roster.keep(); err != nil {
+		member.busy = member.busy[:len(member.busy) - 1]
+		return err
+	}
+	return nil
+}
+
+// Available lists the members free to fill role on boat between start and
+// end, in the order they would be assigned.
+func (roster *CrewRoster) Available(role CrewRole, boat *Boat, start, end time.Time) []CrewMember {
+	defer roster.view()()
+	available := []CrewMember{}
+	for _, member := range roster.candidates(role, boat, start, end, "") {
+		copied := *member
+		copied.busy = nil
+		available = append(available, copied)
+	}
+	return available
+}
+
+// candidates orders qualified, free crew so that those certified for the
+// smallest vessels are used first, keeping senior crew for larger boats.
+func (roster *CrewRoster) candidates(role CrewRole, boat *Boat, start, end time.Time, except string) []*CrewMember {
+	type candidate struct {
+		member *CrewMember
+		capacity int
+	}
+	found := []candidate{}
+	for _, member := range roster.members {
+		if member.ID == except || !member.free(start, end) {
+			continue
+		}
+		if cert, qualified := member.qualification(role, boat, end); qualified {
+			found = append(found, candidate{ member, cert.MaxCapacity })
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].capacity != found[j].capacity {
+			return found[i].capacity < found[j].capacity
+		}
+		return found[i].member.ID < found[j].member.ID
+	})
+	members := make([]*CrewMember, len(found))
+	for i, c := range found {
+		members[i] = c.member
+	}
+	return members
+}
+
+// assign books a qualified captain and first officer for a booking, or
+// reports why it cannot. It returns their names and their IDs, captain
+// first.
+func (roster *CrewRoster) assign(booking string, boat *Boat, start, end time.Time) (*Crew, []string, error) {
+	unlock, err := roster.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	captain, officer, err := roster.pick(boat, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, member := range []*CrewMember{ captain, officer } {
+		member.busy = append(member.busy, period{ start, end, booking })
+	}
+	return &Crew{ captain.Name, officer.Name }, []string{ captain.ID, officer.ID }, nil
+}
+
+// pick chooses the captain and first officer assign would book. The roster
+// must be locked.
+func (roster *CrewRoster) pick(boat *Boat, start, end time.Time) (*CrewMember, *CrewMember, error) {
+	captains := roster.candidates(Captain, boat, start, end, "")
+	if len(captains) == 0 {
+		return nil, nil, fmt.Errorf("store: no qualified captain is free for %v", boat.Name)
+	}
+	for _, captain := range captains {
+		if officers := roster.candidates(FirstOfficer, boat, start, end, captain.ID); len(officers) > 0 {
+			return captain, officers[0], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("store: no qualified first officer is free for %v", boat.Name)
+}
+
+// staffed reports whether a crew could be assigned to boat from start to end.
+func (roster *CrewRoster) staffed(boat *Boat, start, end time.Time) bool {
+	defer roster.view()()
+	_, _, err := roster.pick(boat, start, end)
+	return err == nil
+}
+
+func (roster *CrewRoster) release(booking string) {
+	roster.mutex.Lock()
+	defer roster.mutex.Unlock()
+	for _, member := range roster.members {
+		kept := member.busy[:0]
+		for _, p := range member.busy {
+			if p.booking != booking {
+				kept = append(kept, p)
+			}
+		}
+		member.busy = kept
+	}
+}
+
+// hold marks the crew members with ids busy for a booking again, as assign
+// did when the booking was made.
+func (roster *CrewRoster) hold(booking string, ids []string, start, end time.Time) {
+	roster.mutex.Lock()
+	defer roster.mutex.Unlock()
+	for _, id := range ids {
+		if member, found := roster.members[id]; found {
+			member.busy = append(member.busy, period{ start, end, booking })
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package store
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var catalogColumns = []string{ "sku", "type", "name", "category", "price", "currency",
+	"capacity", "motorized", "include_crew", "captain", "first_officer", "axes", "variant_of", "options", "stock",
+	"components", "discount" }
+
+// typeVariant marks the CSV lines that list the variants of the product
+// named in their variant_of column.
+const typeVariant = "variant"
+
+var dealColumns = []string{ "deal", "product", "category", "price", "currency", "floor",
+	"discount", "label", "stacking", "off", "rate", "buy", "free", "tiers", "valid_from", "valid_until" }
+
+// csvRow gives access to a CSV record by column name.
+type csvRow struct {
+	line int
+	columns map[string]int
+	values []string
+}
+
+func (row csvRow) get(column string) string {
+	if i, found := row.columns[column]; found && i < len(row.values) {
+		return strings.TrimSpace(row.values[i])
+	}
+	return ""
+}
+
+func (row csvRow) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("store: line %v: %v", row.line, fmt.Sprintf(format, args...))
+}
+
+func (row csvRow) wrap(err error) error {
+	return row.errorf("%v", strings.TrimPrefix(err.Error(), "store: "))
+}
+
+func (row csvRow) int(column string) (int, error) {
+	text := row.get(column)
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, row.errorf("invalid %v %q", column, text)
+	}
+	return value, nil
+}
+
+func (row csvRow) bool(column string) (bool, error) {
+	text := row.get(column)
+	if text == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(text)
+	if err != nil {
+		return false, row.errorf("invalid %v %q", column, text)
+	}
+	return value, nil
+}
+
+func (row csvRow) money(column string) (Money, error) {
+	text := row.get(column)
+	if text == "" {
+		return Money{}, nil
+	}
+	m, err := ParseAmount(text, row.get("currency"))
+	if err != nil {
+		return Money{}, row.wrap(err)
+	}
+	return m, nil
+}
+
+func (row csvRow) time(column string) (*time.Time, error) {
+	text := row.get(column)
+	if text == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return nil, row.errorf("invalid %v %q", column, text)
+	}
+	return &t, nil
+}
+
+// readCSV reads a file with a header line, checking that the required
+// columns are present. Columns may appear in any order.
+func readCSV(r io.Reader, required ...string) ([]csvRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("store: the CSV file is empty")
+	} else if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range required {
+		if _, found := columns[name]; !found {
+			return nil, fmt.Errorf("store: the CSV file has no %v column", name)
+		}
+	}
+	rows := []csvRow{}
+	for line := 2; ; line++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rows = append(rows, csvRow{ line, columns, values })
+	}
+}
+
+func writeCSV(w io.Writer, header []string, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	writer.WriteAll(rows)
+	return writer.Error()
+}
+
+func formatInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+func formatAmount(m Money) string {
+	if m.currency == "" {
+		return ""
+	}
+	return m.Amount()
+}
+
+// formatComponents writes bundle components as "KAY-1:1;PAD-1:2".
+func formatComponents(components []BundleComponent) string {
+	parts := make([]string, len(components))
+	for i, component := range components {
+		parts[i] = fmt.Sprintf("%v:%v", component.SKU, component.Quantity)
+	}
+	return strings.Join(parts, ";")
+}
+
+func (row csvRow) components(column string) ([]BundleComponent, error) {
+	var components []BundleComponent
+	for _, part := range strings.Split(row.get(column), ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		sku, text, _ := strings.Cut(part, ":")
+		quantity, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || strings.TrimSpace(sku) == "" {
+			return nil, row.errorf("invalid %v %q, use SKU:quantity", column, part)
+		}
+		components = append(components, BundleComponent{ strings.TrimSpace(sku), quantity })
+	}
+	return components, nil
+}
+
+func formatOptions(axes []string, options Options) string {
+	parts := make([]string, len(axes))
+	for i, axis := range axes {
+		parts[i] = axis + "=" + options[axis]
+	}
+	return strings.Join(parts, ";")
+}
+
+func (row csvRow) options(column string) (Options, error) {
+	options := Options{}
+	for _, part := range strings.Split(row.get(column), ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		axis, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, row.errorf("invalid %v %q, use axis=value", column, part)
+		}
+		options[strings.TrimSpace(axis)] = strings.TrimSpace(value)
+	}
+	return options, nil
+}
+
+// WriteCatalogCSV writes entries one per line under a header, with prices
+// as decimal amounts. Columns that do not apply to an item are left empty.
+// Each variant follows its product on a line of type "variant" that gives
+// the variant's full price.
+func WriteCatalogCSV(w io.Writer, entries []CatalogEntry) error {
+	rows := [][]string{}
+	for _, entry := range entries {
+		record := toRecord(entry.SKU, entry.Item)
+		crew, discount := Crew{}, ""
+		if record.Crew != nil {
+			crew = *record.Crew
+		}
+		if record.Discount != nil {
+			discount = record.Discount.Amount()
+		}
+		rows = append(rows, []string{ record.SKU, record.Type, record.Name, record.Category,
+			record.Price.Amount(), record.Price.currency, formatInt(record.Capacity),
+			strconv.FormatBool(record.Motorized), strconv.FormatBool(record.IncludeCrew),
+			crew.Captain, crew.FirstOfficer, strings.Join(record.Axes, ";"), "", "", "",
+			formatComponents(record.Components), discount })
+		if product, hasVariants := entry.Item.(*VariantProduct); hasVariants {
+			for _, v := range product.variants {
+				rows = append(rows, []string{ v.SKU, typeVariant, v.Name, v.Category, v.price.Amount(),
+					v.price.currency, "", "", "", "", "", "", entry.SKU, formatOptions(product.Axes, v.Options),
+					strconv.Itoa(v.Stock), "", "" })
+			}
+		}
+	}
+	return writeCSV(w, catalogColumns, rows)
+}
+
+// ReadCatalogCSV reads entries written by WriteCatalogCSV, reporting the
+// first invalid line.
+func ReadCatalogCSV(r io.Reader) ([]CatalogEntry, error) {
+	rows, err := readCSV(r, "sku", "type", "name", "price", "currency")
+	if err != nil {
+		return nil, err
+	}
+	records := []catalogRecord{}
+	lines := []csvRow{}
+	products := map[string]int{}
+	for _, row := range rows {
+		record := catalogRecord{ SKU: row.get("sku"), Type: row.get("type"), Name: row.get("name"),
+			Category: row.get("category") }
+		if record.Price, err = row.money("price"); err != nil {
+			return nil, err
+		}
+		if record.Type == typeVariant {
+			parent, found := products[row.get("variant_of")]
+			if !found {
+				return nil, row.errorf("variant %v must follow its product %q", record.SKU, row.get("variant_of"))
+			}
+			if err = addVariantRecord(row, &records[parent], record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if record.Capacity, err = row.int("capacity"); err != nil {
+			return nil, err
+		}
+		if record.Motorized, err = row.bool("motorized"); err != nil {
+			return nil, err
+		}
+		if record.IncludeCrew, err = row.bool("include_crew"); err != nil {
+			return nil, err
+		}
+		if captain, officer := row.get("captain"), row.get("first_officer"); captain != "" || officer != "" {
+			if captain == "" {
+				return nil, row.errorf("a crew needs a captain")
+			}
+			record.Crew = &Crew{ captain, officer }
+		}
+		if record.Components, err = row.components("components"); err != nil {
+			return nil, err
+		}
+		if row.get("discount") != "" {
+			discount, err := row.money("discount")
+			if err != nil {
+				return nil, err
+			}
+			record.Discount = &discount
+		}
+		if axes := row.get("axes"); axes != "" {
+			for _, axis := range strings.Split(axes, ";") {
+				record.Axes = append(record.Axes, strings.TrimSpace(axis))
+			}
+		}
+		products[record.SKU] = len(records)
+		records = append(records, record)
+		lines = append(lines, row)
+	}
+	entries := []CatalogEntry{}
+	for i, record := range records {
+		item, err := record.item()
+		if err == nil {
+			err = validateEntry(record.SKU, item)
+		}
+		if err != nil {
+			return nil, lines[i].wrap(err)
+		}
+		entries = append(entries, CatalogEntry{ record.SKU, item })
+	}
+	return entries, nil
+}
+
+// addVariantRecord adds the variant on row to its product, turning the
+// variant's full price into a delta from the product's price.
+func addVariantRecord(row csvRow, product *catalogRecord, variant catalogRecord) error {
+	if len(product.Axes) == 0 {
+		return row.errorf("%v has no axes for variant %v", product.SKU, variant.SKU)
+	}
+	if variant.Price.currency != product.Price.currency {
+		return row.errorf("variant %v must be priced in %v like %v", variant.SKU, product.Price.currency, product.SKU)
+	}
+	options, err := row.options("options")
+	if err != nil {
+		return err
+	}
+	stock, err := row.int("stock")
+	if err != nil {
+		return err
+	}
+	product.Variants = append(product.Variants,
+		variantRecord{ variant.SKU, options, variant.Price.Sub(product.Price), stock })
+	return nil
+}
+
+func formatTiers(tiers []Tier) string {
+	parts := make([]string, len(tiers))
+	for i, tier := range tiers {
+		parts[i] = fmt.Sprintf("%v:%v", tier.MinQuantity, tier.UnitPrice.Amount())
+	}
+	return strings.Join(parts, ";")
+}
+
+func (row csvRow) tiers(column string) ([]Tier, error) {
+	tiers := []Tier{}
+	for _, part := range strings.Split(row.get(column), ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		quantity, amount, _ := strings.Cut(part, ":")
+		minimum, err := strconv.Atoi(strings.TrimSpace(quantity))
+		if err != nil {
+			return nil, row.errorf("invalid tier %q", part)
+		}
+		price, err := ParseAmount(amount, row.get("currency"))
+		if err != nil {
+			return nil, row.errorf("invalid tier %q", part)
+		}
+		tiers = append(tiers, Tier{ minimum, price })
+	}
+	return tiers, nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// WriteDealsCSV writes one line per discount, repeating the deal and its
+// product on each; a deal without discounts is written as a single line with
+// an empty discount column. Tiers are written as "quantity:price" pairs
+// separated by semicolons.
+func WriteDealsCSV(w io.Writer, deals []*SpecialDeal) error {
+	rows := [][]string{}
+	for _, deal := range deals {
+		p := deal.Product
+		prefix := []string{ deal.Name, p.Name, p.Category, p.price.Amount(), p.price.currency, formatAmount(deal.floor) }
+		if len(deal.discounts) == 0 {
+			rows = append(rows, append(prefix, make([]string, len(dealColumns) - len(prefix))...))
+		}
+		for _, d := range deal.discounts {
+			record, err := toDiscountRecord(d)
+			if err != nil {
+				return err
+			}
+			off, rate := "", ""
+			if record.Off != nil {
+				off = record.Off.Amount()
+			}
+			if record.Rate != 0 {
+				rate = strconv.FormatFloat(record.Rate, 'f', -1, 64)
+			}
+			rows = append(rows, append(append([]string{}, prefix...), record.Kind, record.Label, record.Stacking,
+				off, rate, formatInt(record.Buy), formatInt(record.Free), formatTiers(record.Tiers),
+				formatTime(record.ValidFrom), formatTime(record.ValidUntil)))
+		}
+	}
+	return writeCSV(w, dealColumns, rows)
+}
+
+// ReadDealsCSV reads deals written by WriteDealsCSV. Consecutive lines with
+// the same deal name make up one deal. As with JSON, each deal gets its own
+// copy of its product.
+func ReadDealsCSV(r io.Reader) ([]*SpecialDeal, error) {
+	rows, err := readCSV(r, "deal", "product", "price", "currency", "discount")
+	if err != nil {
+		return nil, err
+	}
+	deals := []*SpecialDeal{}
+	var deal *SpecialDeal
+	for _, row := range rows {
+		if deal == nil || deal.Name != row.get("deal") {
+			if row.get("deal") == "" {
+				return nil, row.errorf("a deal name is required")
+			}
+			record := catalogRecord{ Type: TypeProduct, Name: row.get("product"), Category: row.get("category") }
+			if record.Price, err = row.money("price"); err != nil {
+				return nil, err
+			}
+			if err = record.validate(); err != nil {
+				return nil, row.wrap(err)
+			}
+			item, _ := record.item()
+			floor, err := row.money("floor")
+			if err != nil {
+				return nil, err
+			}
+			deal = &SpecialDeal{ Name: row.get("deal"), Product: item.(*Product), floor: floor }
+			deals = append(deals, deal)
+		}
+		if row.get("discount") == "" {
+			continue
+		}
+		record := discountRecord{ Kind: row.get("discount"), Label: row.get("label"), Stacking: row.get("stacking") }
+		if off, err := row.money("off"); err != nil {
+			return nil, err
+		} else if row.get("off") != "" {
+			record.Off = &off
+		}
+		if text := row.get("rate"); text != "" {
+			if record.Rate, err = strconv.ParseFloat(text, 64); err != nil {
+				return nil, row.errorf("invalid rate %q", text)
+			}
+		}
+		if record.Buy, err = row.int("buy"); err != nil {
+			return nil, err
+		}
+		if record.Free, err = row.int("free"); err != nil {
+			return nil, err
+		}
+		if record.Tiers, err = row.tiers("tiers"); err != nil {
+			return nil, err
+		}
+		if record.ValidFrom, err = row.time("valid_from"); err != nil {
+			return nil, err
+		}
+		if record.ValidUntil, err = row.time("valid_until"); err != nil {
+			return nil, err
+		}
+		d, err := record.discount()
+		if err != nil {
+			return nil, row.wrap(err)
+		}
+		deal.discounts = append(deal.discounts, d)
+	}
+	return deals, nil
+}
@@ -0,0 +1,713 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Address struct {
+	Label string `json:"label,omitempty"`
+	Lines []string `json:"lines"`
+	Country string `json:"country"`
+	Region string `json:"region,omitempty"`
+}
+
+// Location is where the address is for tax.
+func (address Address) Location() Location {
+	return Location{ address.Country, address.Region }
+}
+
+type PointsKind string
+
+const (
+	PointsEarned PointsKind = "earned"
+	PointsRedeemed PointsKind = "redeemed"
+	PointsExpired PointsKind = "expired"
+	PointsRefunded PointsKind = "refunded"
+)
+
+// PointsEntry is one change to a customer's points. Points are negative when
+// they are taken off. Earned points, and points given back by a return, keep
+// Remaining, the part of them not yet redeemed or expired; redemptions use
+// the oldest points first. Order is the return's ID for refunded points.
+type PointsEntry struct {
+	Time time.Time `json:"time"`
+	Kind PointsKind `json:"kind"`
+	Points int `json:"points"`
+	Order string `json:"order,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Remaining int `json:"remaining,omitempty"`
+}
+
+// Customer is a customer account. Spend is what the customer has spent
+// before tax, in the loyalty program's currency, and decides their tier.
+type Customer struct {
+	ID string `json:"id"`
+	Name string `json:"name"`
+	Email string `json:"email"`
+	Addresses []Address `json:"addresses"`
+	Joined time.Time `json:"joined"`
+	Orders []string `json:"orders"`
+	Spend Money `json:"spend"`
+	Tier string `json:"tier"`
+	Points int `json:"points"`
+	Ledger []PointsEntry `json:"ledger"`
+	held int
+}
+
+func (customer Customer) copied() Customer {
+	customer.Addresses = append([]Address{}, customer.Addresses...)
+	customer.Orders = append([]string{}, customer.Orders...)
+	customer.Ledger = append([]PointsEntry{}, customer.Ledger...)
+	return customer
+}
+
+// Party names the customer on an invoice at their first address.
+func (customer Customer) Party() Party {
+	party := Party{ Name: customer.Name, Email: customer.Email }
+	if len(customer.Addresses) > 0 {
+		party.Address = append([]string{}, customer.Addresses[0].Lines...)
+	}
+	return party
+}
+
+// consume takes points from the oldest earned entries that have some left.
+func (customer *Customer) consume(points int) {
+	for i := range customer.Ledger {
+		entry := &customer.Ledger[i]
+		if points == 0 {
+			return
+		}
+		if entry.Remaining == 0 {
+			continue
+		}
+		used := entry.Remaining
+		if used > points {
+			used = points
+		}
+		entry.Remaining -= used
+		points -= used
+	}
+}
+
+// LoyaltyTier is reached once a customer has spent MinSpend and takes
+// Discount, a fraction such as 0.05, off every line they buy.
+type LoyaltyTier struct {
+	Name string `json:"name"`
+	MinSpend Money `json:"minSpend"`
+	Discount float64 `json:"discount"`
+}
+
+// LoyaltyProgram gives customers points worth one minor unit of Currency
+// each. An order earns EarnRate of its net, the spend after discounts and
+// before tax, and points lapse Expiry after they are earned; a zero Expiry
+// keeps them for good.
+type LoyaltyProgram struct {
+	Currency string `json:"currency"`
+	EarnRate float64 `json:"earnRate"`
+	Expiry time.Duration `json:"expiry"`
+	Tiers []LoyaltyTier `json:"tiers"`
+}
+
+// NewLoyaltyProgram orders tiers by the spend they need.
+func NewLoyaltyProgram(currency string, earnRate float64, expiry time.Duration,
+	tiers ...LoyaltyTier) (LoyaltyProgram, error) {
+	program := LoyaltyProgram{ currency, earnRate, expiry, append([]LoyaltyTier{}, tiers...) }
+	return program, program.validate()
+}
+
+func (program *LoyaltyProgram) validate() error {
+	if !isCurrencyCode(program.Currency) {
+		return fmt.Errorf("store: %q is not a currency code", program.Currency)
+	}
+	if !(program.EarnRate >= 0 && program.EarnRate <= 1) {
+		return fmt.Errorf("store: earn rate %v is not between 0 and 1", program.EarnRate)
+	}
+	if program.Expiry < 0 {
+		return fmt.Errorf("store: points cannot expire before they are earned")
+	}
+	names := map[string]bool{}
+	for _, tier := range program.Tiers {
+		switch {
+		case tier.Name == "" || names[tier.Name]:
+			return fmt.Errorf("store: tier names must be given and different")
+		case tier.MinSpend.currency != program.Currency || tier.MinSpend.IsNegative():
+			return fmt.Errorf("store: tier %v needs a spend in %v", tier.Name, program.Currency)
+		case !(tier.Discount >= 0 && tier.Discount < 1):
+			return fmt.Errorf("store: tier %v has a discount of %v", tier.Name, tier.Discount)
+		}
+		names[tier.Name] = true
+	}
+	sort.SliceStable(program.Tiers, func(i, j int) bool { return program.Tiers[i].MinSpend.Cmp(program.Tiers[j].MinSpend) < 0 })
+	return nil
+}
+
+// tier returns the highest tier spend reaches, or none.
+func (program LoyaltyProgram) tier(spend Money) (LoyaltyTier, bool) {
+	reached, found := LoyaltyTier{}, false
+	for _, tier := range program.Tiers {
+		if spend.Cmp(tier.MinSpend) >= 0 {
+			reached, found = tier, true
+		}
+	}
+	return reached, found
+}
+
+// Customers holds customer accounts and their loyalty points. Orders in
+// another currency than the program's are converted through Rates to earn
+// points, and points are spent in other currencies the same way; without
+// Rates such orders neither earn nor redeem.
+type Customers struct {
+	mutex sync.Mutex
+	program LoyaltyProgram
+	customers map[string]*Customer
+	last int
+	Rates *ExchangeRates
+	Now func() time.Time
+	keep func() error
+	acquire func() (func(), error)
+}
+
+func NewCustomers(program LoyaltyProgram) (*Customers, error) {
+	if err := program.validate(); err != nil {
+		return nil, err
+	}
+	return &Customers{ program: program, customers: map[string]*Customer{}, Now: time.Now,
+		keep: func() error { return nil }, acquire: func() (func(), error) { return func() {}, nil } }, nil
+}
+
+// lock locks the customers for a change. For customers kept in a file it
+// also locks the file and reads back what another process saved there.
+func (customers *Customers) lock() (func(), error) {
+	customers.mutex.Lock()
+	release, err := customers.acquire()
+	if err != nil {
+		customers.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		customers.mutex.Unlock()
+	}, nil
+}
+
+func (customers *Customers) Program() LoyaltyProgram {
+	customers.mutex.Lock()
+	defer customers.mutex.Unlock()
+	program := customers.program
+	program.Tiers = append([]LoyaltyTier{}, program.Tiers...)
+	return program
+}
+
+// SetProgram changes the loyalty program and moves every customer to the
+// tier their spend now reaches. Spend already recorded in another currency
+// cannot be carried over, so the currency is fixed once anyone has spent.
+func (customers *Customers) SetProgram(program LoyaltyProgram) error {
+	if err := program.validate(); err != nil {
+		return err
+	}
+	unlock, err := customers.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, customer := range customers.customers {
+		if !customer.Spend.IsZero() && customer.Spend.currency != program.Currency {
+			return fmt.Errorf("store: customers have spent in %v already", customer.Spend.currency)
+		}
+	}
+	previous := customers.program
+	customers.program = program
+	tiers := map[string]string{}
+	for id, customer := range customers.customers {
+		tiers[id] = customer.Tier
+		customer.Spend = Money{ customer.Spend.minor, program.Currency }
+		customer.Tier = customers.tierName(customer.Spend)
+	}
+	if err := customers.keep(); err != nil {
+		customers.program = previous
+		for id, customer := range customers.customers {
+			customer.Tier = tiers[id]
+			customer.Spend = Money{ customer.Spend.minor, previous.Currency }
+		}
+		return err
+	}
+	return nil
+}
+
+func (customers *Customers) tierName(spend Money) string {
+	tier, _ := customers.program.tier(spend)
+	return tier.Name
+}
+
+func validEmail(email string) bool {
+	at := strings.Index(email, "@")
+	return at > 0 && at == strings.LastIndex(email, "@") && at < len(email) - 1 && !strings.ContainsAny(email, " \t\n")
+}
+
+func validateAddresses(addresses []Address) error {
+	for _, address := range addresses {
+		if len(address.Lines) == 0 || address.Country == "" {
+			return fmt.Errorf("store: an address needs at least one line and a country")
+		}
+	}
+	return nil
+}
+
+// Register opens an account. Email addresses are kept unique, ignoring case.
+func (customers *Customers) Register(name, email string, addresses ...Address) (Customer, error) {
+	name, email = strings.TrimSpace(name), strings.TrimSpace(email)
+	if name == "" {
+		return Customer{}, fmt.Errorf("store: a customer needs a name")
+	}
+	if !validEmail(email) {
+		return Customer{}, fmt.Errorf("store: %q is not an email address", email)
+	}
+	if err := validateAddresses(addresses); err != nil {
+		return Customer{}, err
+	}
+	unlock, err := customers.lock()
+	if err != nil {
+		return Customer{}, err
+	}
+	defer unlock()
+	if _, taken := customers.byEmail(email); taken {
+		return Customer{}, fmt.Errorf("store: %v already has an account", email)
+	}
+	customers.last++
+	customer := &Customer{ ID: fmt.Sprintf("CUS-%06d", customers.last), Name: name, Email: email,
+		Addresses: append([]Address{}, addresses...), Joined: customers.Now(), Orders: []string{},
+		Spend: Money{ 0, customers.program.Currency }, Ledger: []PointsEntry{} }
+	customer.Tier = customers.tierName(customer.Spend)
+	customers.customers[customer.ID] = customer
+	if err := customers.keep(); err != nil {
+		delete(customers.customers, customer.ID)
+		customers.last--
+		return Customer{}, err
+	}
+	return customer.copied(), nil
+}
+
+func (customers *Customers) byEmail(email string) (*Customer, bool) {
+	for _, customer := range customers.customers {
+		if strings.EqualFold(customer.Email, email) {
+			return customer, true
+		}
+	}
+	return nil, false
+}
+
+// Update changes a customer's name, email and addresses.
+func (customers *Customers) Update(id, name, email string, addresses ...Address) (Customer, error) {
+	name, email = strings.TrimSpace(name), strings.TrimSpace(email)
+	if name == "" || !validEmail(email) {
+		return Customer{}, fmt.Errorf("store: a customer needs a name and an email address")
+	}
+	if err := validateAddresses(addresses); err != nil {
+		return Customer{}, err
+	}
+	unlock, err := customers.lock()
+	if err != nil {
+		return Customer{}, err
+	}
+	defer unlock()
+	customer, found := customers.customers[id]
+	if !found {
+		return Customer{}, fmt.Errorf("store: no customer %v", id)
+	}
+	if other, taken := customers.byEmail(email); taken && other != customer {
+		return Customer{}, fmt.Errorf("store: %v already has an account", email)
+	}
+	previous := *customer
+	customer.Name, customer.Email, customer.Addresses = name, email, append([]Address{}, addresses...)
+	if err := customers.keep(); err != nil {
+		*customer = previous
+		return Customer{}, err
+	}
+	return customer.copied(), nil
+}
+
+func (customers *Customers) Get(id string) (Customer, bool) {
+	customers.mutex.Lock()
+	defer customers.mutex.Unlock()
+	if customer, found := customers.customers[id]; found {
+		return customer.copied(), true
+	}
+	return Customer{}, false
+}
+
+func (customers *Customers) FindByEmail(email string) (Customer, bool) {
+	customers.mutex.Lock()
+	defer customers.mutex.Unlock()
+	if customer, found := customers.byEmail(email); found {
+		return customer.copied(), true
+	}
+	return Customer{}, false
+}
+
+// List returns the customers in the order they joined.
+func (customers *Customers) List() []Customer {
+	customers.mutex.Lock()
+	defer customers.mutex.Unlock()
+	list := make([]Customer, 0, len(customers.customers))
+	for _, customer := range customers.customers {
+		list = append(list, customer.copied())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Discounts are what the customer's tier takes off each line.
+func (customers *Customers) Discounts(id string) []Discount {
+	customers.mutex.Lock()
+	defer customers.mutex.Unlock()
+	customer, found := customers.customers[id]
+	if !found {
+		return nil
+	}
+	return customers.discounts(customer)
+}
+
+func (customers *Customers) discounts(customer *Customer) []Discount {
+	tier, found := customers.program.tier(customer.Spend)
+	if !found || tier.Discount == 0 {
+		return []Discount{}
+	}
+	return []Discount{ Percentage{ tier.Name + " member discount", tier.Discount } }
+}
+
+// value converts points into currency at time at.
+func (customers *Customers) value(points int, currency string, at time.Time) (Money, error) {
+	value := Money{ int64(points), customers.program.Currency }
+	if currency == value.currency {
+		return value, nil
+	}
+	if customers.Rates == nil {
+		return Money{}, fmt.Errorf("store: points cannot be spent in %v", currency)
+	}
+	converted, _, err := customers.Rates.Convert(value, currency, at)
+	return converted, err
+}
+
+// Apply prices cart for customer id with their tier's discounts and points
+// redeemed, which can be none.
+func (customers *Customers) Apply(cart *Cart, id string, points int) error {
+	if points < 0 {
+		return fmt.Errorf("store: cannot redeem %v points", points)
+	}
+	unlock, err := customers.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	customer, found := customers.customers[id]
+	if !found {
+		return fmt.Errorf("store: no customer %v", id)
+	}
+	if available := customer.Points - customer.held; points > available {
+		return fmt.Errorf("store: %v has %v points to redeem, not %v", id, available, points)
+	}
+	credit, err := customers.value(points, cart.Currency(), customers.Now())
+	if err != nil {
+		return err
+	}
+	cart.SetCustomer(id, points, credit, customers.discounts(customer)...)
+	return nil
+}
+
+// Check makes sure the customer still has the cart's points, that they are
+// valued in the cart's currency and that they do not come to more than the
+// cart. The customer's discounts are brought up to date, since their tier
+// may have changed.
+func (customers *Customers) Check(cart *Cart) error {
+	unlock, err := customers.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return customers.check(cart)
+}
+
+func (customers *Customers) check(cart *Cart) error {
+	customer, found := customers.customers[cart.customer]
+	if !found {
+		return fmt.Errorf("store: no customer %v", cart.customer)
+	}
+	cart.memberDiscounts = customers.discounts(customer)
+	if cart.points == 0 {
+		return nil
+	}
+	if available := customer.Points - customer.held; cart.points > available {
+		return fmt.Errorf("store: %v has %v points to redeem, not %v", customer.ID, available, cart.points)
+	}
+	if cart.credit.currency != cart.currency() {
+		return fmt.Errorf("store: the points were valued in %v but the cart is in %v", cart.credit.currency,
+			cart.currency())
+	}
+	if cart.Totals(customers.Now()).Points != cart.points {
+		return fmt.Errorf("store: %v points are worth more than the cart", cart.points)
+	}
+	return nil
+}
+
+// Hold sets the points redeemed in totals aside so they cannot be redeemed
+// twice.
+func (customers *Customers) Hold(cart *Cart, totals CartTotals) (func(), error) {
+	unlock, err := customers.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := customers.check(cart); err != nil {
+		return nil, err
+	}
+	id, points := cart.customer, totals.Points
+	customers.customers[id].held += points
+	return func() {
+		customers.mutex.Lock()
+		defer customers.mutex.Unlock()
+		customers.customers[id].held -= points
+	}, nil
+}
+
+// Record adds an order to its customer's history, redeems the points held
+// for it and credits the points and spend it earns.
+func (customers *Customers) Record(order *Order) error {
+	unlock, err := customers.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	customer, found := customers.customers[order.customer]
+	if !found {
+		return fmt.Errorf("store: no customer %v", order.customer)
+	}
+	previous := customer.copied()
+	totals := order.totals
+	if totals.Points > 0 {
+		customer.held -= totals.Points
+		if totals.Points > customer.Points {
+			totals.Points = customer.Points
+		}
+		customer.consume(totals.Points)
+		customer.Points -= totals.Points
+		customer.Ledger = append(customer.Ledger, PointsEntry{ Time: order.placed, Kind: PointsRedeemed,
+			Points: -totals.Points, Order: order.number })
+	}
+	customer.Orders = append(customer.Orders, order.number)
+	spend, err := customers.spend(totals.Net, order.placed)
+	if err == nil && spend.minor > 0 {
+		customer.Spend = customer.Spend.Add(spend)
+		customer.Tier = customers.tierName(customer.Spend)
+		if earned := earnedPoints(spend, customers.program.EarnRate); earned > 0 {
+			entry := PointsEntry{ Time: order.placed, Kind: PointsEarned, Points: earned, Order: order.number,
+				Remaining: earned }
+			if customers.program.Expiry > 0 {
+				expires := order.placed.Add(customers.program.Expiry)
+				entry.Expires = &expires
+			}
+			customer.Points += earned
+			customer.Ledger = append(customer.Ledger, entry)
+		}
+	}
+	if err := customers.keep(); err != nil {
+		held := customer.held
+		*customer = previous
+		customer.held = held
+		return err
+	}
+	return nil
+}
+
+// Refund gives back the points a return refunds, as an entry that lapses as
+// earned points do. Each return is refunded once, however often it is passed.
+func (customers *Customers) Refund(r Return) error {
+	if r.Points == 0 || r.Customer == "" {
+		return nil
+	}
+	unlock, err := customers.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	customer, found := customers.customers[r.Customer]
+	if !found {
+		return fmt.Errorf("store: no customer %v", r.Customer)
+	}
+	for _, entry := range customer.Ledger {
+		if entry.Kind == PointsRefunded && entry.Order == r.ID {
+			return nil
+		}
+	}
+	now := customers.Now()
+	entry := PointsEntry{ Time: now, Kind: PointsRefunded, Points: r.Points, Order: r.ID, Remaining: r.Points }
+	if customers.program.Expiry > 0 {
+		expires := now.Add(customers.program.Expiry)
+		entry.Expires = &expires
+	}
+	customer.Points += r.Points
+	customer.Ledger = append(customer.Ledger, entry)
+	if err := customers.keep(); err != nil {
+		customer.Points -= r.Points
+		customer.Ledger = customer.Ledger[:len(customer.Ledger) - 1]
+		return err
+	}
+	return nil
+}
+
+// earnedPoints rounds down, so no order earns part of a point it did not
+// spend.
+func earnedPoints(spend Money, rate float64) int {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(spend.minor), ratFromFloat(rate))
+	return int(new(big.Int).Quo(product.Num(), product.Denom()).Int64())
+}
+
+// spend converts an order's net into the program's currency.
+func (customers *Customers) spend(net Money, at time.Time) (Money, error) {
+	if net.currency == customers.program.Currency {
+		return net, nil
+	}
+	if customers.Rates == nil {
+		return Money{}, fmt.Errorf("store: no exchange rates for %v", net.currency)
+	}
+	converted, _, err := customers.Rates.Convert(net, customers.program.Currency, customers.Now())
+	return converted, err
+}
+
+// ExpiredPoints reports points that lapsed from a customer's account.
+type ExpiredPoints struct {
+	Customer string `json:"customer"`
+	Points int `json:"points"`
+}
+
+// Expire takes away earned points whose time is up. It is meant to be called
+// regularly, as by Run.
+func (customers *Customers) Expire() ([]ExpiredPoints, error) {
+	unlock, err := customers.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	now := customers.Now()
+	expired := []ExpiredPoints{}
+	previous := map[string]Customer{}
+	for id, customer := range customers.customers {
+		lapsed := 0
+		for i := range customer.Ledger {
+			entry := &customer.Ledger[i]
+			if entry.Remaining > 0 && entry.Expires != nil && !now.Before(*entry.Expires) {
+				if lapsed == 0 {
+					previous[id] = customer.copied()
+				}
+				lapsed += entry.Remaining
+				entry.Remaining = 0
+			}
+		}
+		if lapsed > 0 {
+			customer.Points -= lapsed
+			customer.Ledger = append(customer.Ledger, PointsEntry{ Time: now, Kind: PointsExpired, Points: -lapsed })
+			expired = append(expired, ExpiredPoints{ id, lapsed })
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	if err := customers.keep(); err != nil {
+		for id, customer := range previous {
+			held := customers.customers[id].held
+			*customers.customers[id] = customer
+			customers.customers[id].held = held
+		}
+		return nil, err
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Customer < expired[j].Customer })
+	return expired, nil
+}
+
+// Run calls Expire every interval until stop is closed, passing any error to
+// report.
+func (customers *Customers) Run(interval time.Duration, stop <-chan struct{}, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := customers.Expire(); err != nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+type customersFile struct {
+	Program LoyaltyProgram `json:"program"`
+	Customers []Customer `json:"customers"`
+}
+
+// FileCustomers keeps customer accounts in memory and rewrites a JSON file
+// after every change.
+type FileCustomers struct {
+	*Customers
+	file *sharedFile
+}
+
+// OpenFileCustomers reads the accounts at path. A new file starts with
+// program.
+func OpenFileCustomers(path string, program LoyaltyProgram) (*FileCustomers, error) {
+	customers, err := NewCustomers(program)
+	if err != nil {
+		return nil, err
+	}
+	book := &FileCustomers{ Customers: customers }
+	book.file = newSharedFile(path, book.load)
+	customers.keep, customers.acquire = book.save, book.file.hold
+	unlock, err := customers.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return book, nil
+}
+
+// load replaces the accounts with those in data, keeping the points this
+// process holds for checkouts. The customers must be locked.
+func (book *FileCustomers) load(data []byte) error {
+	file := customersFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if err := file.Program.validate(); err != nil {
+		return err
+	}
+	loaded, last := map[string]*Customer{}, 0
+	for i := range file.Customers {
+		customer := &file.Customers[i]
+		if previous, found := book.customers[customer.ID]; found {
+			customer.held = previous.held
+		}
+		loaded[customer.ID] = customer
+		var number int
+		if _, err := fmt.Sscanf(customer.ID, "CUS-%d", &number); err == nil && number > last {
+			last = number
+		}
+	}
+	book.program, book.customers, book.last = file.Program, loaded, last
+	return nil
+}
+
+// save writes the file. The customers must be locked.
+func (book *FileCustomers) save() error {
+	file := customersFile{ book.program, make([]Customer, 0, len(book.customers)) }
+	for _, customer := range book.customers {
+		file.Customers = append(file.Customers, *customer)
+	}
+	sort.Slice(file.Customers, func(i, j int) bool { return file.Customers[i].ID < file.Customers[j].ID })
+	return book.file.write(file)
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"path/filepath"
+	"time"
+)
+
+const (
+	CatalogFile = "catalog.json"
+	DealsFile = "deals.json"
+	CalendarFile = "calendar.json"
+	PricesFile = "prices.json"
+	RatesFile = "rates.json"
+	InvoicesFile = "invoices.json"
+	CustomersFile = "customers.json"
+	GiftCardsFile = "giftcards.json"
+	InventoryFile = "inventory.json"
+	CrewFile = "crew.json"
+	OrdersFile = "orders.json"
+	PurchasingFile = "purchasing.json"
+	ReturnsFile = "returns.json"
+)
+
+// DataDir is the shop data kept in one directory, shared by the storefront
+// service and the store command. Both can have it open at once: a change
+// locks the file it writes and first reads back what the other saved.
+type DataDir struct {
+	Catalog *FileCatalog
+	Deals *FileDeals
+	Calendar *FileCalendar
+	Prices *FilePriceHistory
+	Rates *FileExchangeRates
+	Invoices *FileInvoiceBook
+	Customers *FileCustomers
+	GiftCards *FileGiftCards
+	Inventory *FileInventory
+	Crew *FileCrewRoster
+	Orders *FileOrderBook
+	Purchasing *FilePurchasing
+	Returns *FileReturnDesk
+}
+
+// StarterProgram is the loyalty program a new data directory begins with: 5%
+// of the spend back in points that last a year, and tiers taking 5% and 10%
+// off for customers who have spent $1,000 and $5,000.
+var StarterProgram = LoyaltyProgram{ Currency: "USD", EarnRate: 0.05, Expiry: 365 * Day, Tiers: []LoyaltyTier{
+	{ "Bronze", NewMoney(0, "USD"), 0 },
+	{ "Silver", NewMoney(100000, "USD"), 0.05 },
+	{ "Gold", NewMoney(500000, "USD"), 0.1 },
+} }
+
+// OpenDataDir opens the files in dir. A new calendar keeps two hours free
+// after each rental for cleaning and refunds in full a week ahead and half
+// two days ahead. Price changes that fell due while the data was closed are
+// applied on opening. Currency conversions round half to even, which keeps
+// rounding from drifting one way over many orders. Customers earn and spend
+// points in other currencies at the same rates. Low stock raises purchase
+// orders for SKUs with a reorder policy. Returned goods go back into the
+// inventory, and refunds are credited on the invoices and give back points
+// and gift card payments.
+func OpenDataDir(dir string) (*DataDir, error) {
+	catalog, err := OpenFileCatalog(filepath.Join(dir, CatalogFile))
+	if err != nil {
+		return nil, err
+	}
+	deals, err := OpenFileDeals(filepath.Join(dir, DealsFile), catalog)
+	if err != nil {
+		return nil, err
+	}
+	crew, err := OpenFileCrewRoster(filepath.Join(dir, CrewFile))
+	if err != nil {
+		return nil, err
+	}
+	calendar, err := OpenFileCalendar(filepath.Join(dir, CalendarFile), catalog, crew.CrewRoster, 2 * time.Hour,
+		RefundRule{ Notice: 7 * Day, Percent: 100 }, RefundRule{ Notice: 2 * Day, Percent: 50 })
+	if err != nil {
+		return nil, err
+	}
+	prices, err := OpenFilePriceHistory(filepath.Join(dir, PricesFile), catalog)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = prices.Update(); err != nil {
+		return nil, err
+	}
+	rates, err := OpenFileExchangeRates(filepath.Join(dir, RatesFile), RoundHalfEven)
+	if err != nil {
+		return nil, err
+	}
+	invoices, err := OpenFileInvoiceBook(filepath.Join(dir, InvoicesFile))
+	if err != nil {
+		return nil, err
+	}
+	customers, err := OpenFileCustomers(filepath.Join(dir, CustomersFile), StarterProgram)
+	if err != nil {
+		return nil, err
+	}
+	customers.Rates = rates.ExchangeRates
+	giftCards, err := OpenFileGiftCards(filepath.Join(dir, GiftCardsFile))
+	if err != nil {
+		return nil, err
+	}
+	purchasing, err := OpenFilePurchasing(filepath.Join(dir, PurchasingFile))
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := OpenFileInventory(filepath.Join(dir, InventoryFile), purchasing)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := OpenFileOrderBook(filepath.Join(dir, OrdersFile))
+	if err != nil {
+		return nil, err
+	}
+	returns, err := OpenFileReturnDesk(filepath.Join(dir, ReturnsFile), catalog, orders)
+	if err != nil {
+		return nil, err
+	}
+	returns.Stock, returns.Invoices = inventory, invoices
+	returns.Accounts = []ReturnAccounts{ customers, giftCards }
+	return &DataDir{ catalog, deals, calendar, prices, rates, invoices, customers, giftCards, inventory, crew, orders,
+		purchasing, returns }, nil
+}
+
+// OrderNumbers continues the order numbers from the highest one kept in the
+// orders, the invoices or a customer's history, so that no number is handed
+// out twice, even for orders placed before the orders were kept.
+func (data *DataDir) OrderNumbers(prefix string) *OrderSequence {
+	numbers := []string{}
+	for _, order := range data.Orders.List() {
+		numbers = append(numbers, order.Number())
+	}
+	for _, invoice := range data.Invoices.List() {
+		numbers = append(numbers, invoice.Order)
+	}
+	for _, customer := range data.Customers.List() {
+		numbers = append(numbers, customer.Orders...)
+	}
+	return NewOrderSequence(prefix, lastNumber(prefix, numbers...))
+}
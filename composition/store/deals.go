@@ -0,0 +1,156 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type DealEntry struct {
+	SKU string `json:"sku"`
+	Deal *SpecialDeal `json:"deal"`
+}
+
+// Deals holds at most one deal for each catalog item.
+type Deals interface {
+	Put(sku string, deal *SpecialDeal) error
+	Get(sku string) (*SpecialDeal, bool)
+	Remove(sku string) error
+	Entries() []DealEntry
+}
+
+type MemoryDeals struct {
+	mutex sync.Mutex
+	catalog Catalog
+	deals map[string]*SpecialDeal
+}
+
+func NewMemoryDeals(catalog Catalog) *MemoryDeals {
+	return &MemoryDeals{ catalog: catalog, deals: map[string]*SpecialDeal{} }
+}
+
+// Put binds deal to the product the catalog holds for sku, so carts accept
+// it, and replaces any deal already on that SKU.
+func (deals *MemoryDeals) Put(sku string, deal *SpecialDeal) error {
+	item, found := deals.catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	product := item.BaseProduct()
+	if deal.Product != nil && deal.Product.price.currency != product.price.currency {
+		return fmt.Errorf("store: deal %v is priced in %v but %v is in %v", deal.Name,
+			deal.Product.price.currency, sku, product.price.currency)
+	}
+	deal.Product = product
+	if err := deal.check(); err != nil {
+		return err
+	}
+	deals.mutex.Lock()
+	defer deals.mutex.Unlock()
+	deals.deals[sku] = deal
+	return nil
+}
+
+// Get returns the deal on sku. Deals follow the item the catalog holds now,
+// so a price change that replaced it carries over to the deal.
+func (deals *MemoryDeals) Get(sku string) (*SpecialDeal, bool) {
+	deals.mutex.Lock()
+	defer deals.mutex.Unlock()
+	deal, found := deals.deals[sku]
+	if !found {
+		return nil, false
+	}
+	return deals.rebind(sku, deal), true
+}
+
+// rebind returns deal pointed at the current product for sku. Carts read
+// deals without a lock, so a deal that has to follow a new product is copied
+// and the copy stored in its place. The deals must be locked.
+func (deals *MemoryDeals) rebind(sku string, deal *SpecialDeal) *SpecialDeal {
+	item, found := deals.catalog.Get(sku)
+	if !found || item.BaseProduct() == deal.Product || item.BaseProduct().price.currency != deal.Product.price.currency {
+		return deal
+	}
+	rebound := *deal
+	rebound.Product = item.BaseProduct()
+	deals.deals[sku] = &rebound
+	return &rebound
+}
+
+func (deals *MemoryDeals) Remove(sku string) error {
+	deals.mutex.Lock()
+	defer deals.mutex.Unlock()
+	if _, found := deals.deals[sku]; !found {
+		return fmt.Errorf("store: no deal on %v", sku)
+	}
+	delete(deals.deals, sku)
+	return nil
+}
+
+// Entries lists the deals ordered by SKU.
+func (deals *MemoryDeals) Entries() []DealEntry {
+	deals.mutex.Lock()
+	defer deals.mutex.Unlock()
+	entries := []DealEntry{}
+	for sku, deal := range deals.deals {
+		entries = append(entries, DealEntry{ sku, deals.rebind(sku, deal) })
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SKU < entries[j].SKU })
+	return entries
+}
+
+// FileDeals keeps deals in memory and rewrites a JSON file after every
+// change. Deals for SKUs no longer in the catalog are dropped when the file
+// is read.
+type FileDeals struct {
+	*MemoryDeals
+	file *sharedFile
+}
+
+func OpenFileDeals(path string, catalog Catalog) (*FileDeals, error) {
+	deals := &FileDeals{ MemoryDeals: NewMemoryDeals(catalog) }
+	deals.file = newSharedFile(path, deals.load)
+	if err := deals.file.reload(); err != nil {
+		return nil, err
+	}
+	return deals, nil
+}
+
+// load replaces the deals with those in data.
+func (deals *FileDeals) load(data []byte) error {
+	entries := []DealEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	loaded := NewMemoryDeals(deals.catalog)
+	for _, entry := range entries {
+		if _, found := deals.catalog.Get(entry.SKU); found {
+			if err := loaded.Put(entry.SKU, entry.Deal); err != nil {
+				return err
+			}
+		}
+	}
+	deals.mutex.Lock()
+	defer deals.mutex.Unlock()
+	deals.deals = loaded.deals
+	return nil
+}
+
+func (deals *FileDeals) Put(sku string, deal *SpecialDeal) error {
+	return deals.file.change(func() error {
+		if err := deals.MemoryDeals.Put(sku, deal); err != nil {
+			return err
+		}
+		return deals.file.write(deals.Entries())
+	})
+}
+
+func (deals *FileDeals) Remove(sku string) error {
+	return deals.file.change(func() error {
+		if err := deals.MemoryDeals.Remove(sku); err != nil {
+			return err
+		}
+		return deals.file.write(deals.Entries())
+	})
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// DiscountContext describes the line a discount is being applied to. Price is
+// what the line costs after any discounts already applied.
+type DiscountContext struct {
+	UnitPrice Money
+	Quantity int
+	Price Money
+	At time.Time
+}
+
+// Discount works out how much to take off a line; a zero amount means the
+// discount does not apply.
+type Discount interface {
+	Name() string
+	Amount(ctx DiscountContext) Money
+}
+
+type FixedAmount struct {
+	Label string
+	Off Money
+}
+
+func (d FixedAmount) Name() string {
+	return d.Label
+}
+
+// Amount takes Off from each unit.
+func (d FixedAmount) Amount(ctx DiscountContext) Money {
+	return d.Off.Multiply(int64(ctx.Quantity))
+}
+
+type Percentage struct {
+	Label string
+	Rate float64
+}
+
+func (d Percentage) Name() string {
+	return d.Label
+}
+
+func (d Percentage) Amount(ctx DiscountContext) Money {
+	return ctx.Price.MultiplyByRate(d.Rate, RoundHalfEven)
+}
+
+// BuyXGetY gives Free units away for every Buy units paid for.
+type BuyXGetY struct {
+	Label string
+	Buy, Free int
+}
+
+func (d BuyXGetY) Name() string {
+	return d.Label
+}
+
+func (d BuyXGetY) Amount(ctx DiscountContext) Money {
+	if d.Buy < 1 || d.Free < 1 {
+		return Money{}
+	}
+	free := ctx.Quantity / (d.Buy + d.Free) * d.Free
+	return ctx.UnitPrice.Multiply(int64(free))
+}
+
+type Tier struct {
+	MinQuantity int `json:"minQuantity"`
+	UnitPrice Money `json:"unitPrice"`
+}
+
+// TieredPricing charges the unit price of the highest tier the quantity
+// reaches instead of the product's own price.
+type TieredPricing struct {
+	Label string
+	Tiers []Tier
+}
+
+func (d TieredPricing) Name() string {
+	return d.Label
+}
+
+func (d TieredPricing) Amount(ctx DiscountContext) Money {
+	best := -1
+	for i, tier := range d.Tiers {
+		if ctx.Quantity >= tier.MinQuantity && (best < 0 || tier.MinQuantity > d.Tiers[best].MinQuantity) {
+			best = i
+		}
+	}
+	if best < 0 || d.Tiers[best].UnitPrice.Cmp(ctx.UnitPrice) >= 0 {
+		return Money{}
+	}
+	return ctx.UnitPrice.Sub(d.Tiers[best].UnitPrice).Multiply(int64(ctx.Quantity))
+}
+
+type window struct {
+	Discount
+	start, end time.Time
+}
+
+// ValidBetween limits a discount to times from start up to, but not
+// including, end. A zero start or end leaves that side of the window open.
+func ValidBetween(d Discount, start, end time.Time) Discount {
+	return window{ d, start, end }
+}
+
+func (w window) Amount(ctx DiscountContext) Money {
+	if (!w.start.IsZero() && ctx.At.Before(w.start)) || (!w.end.IsZero() && !ctx.At.Before(w.end)) {
+		return Money{}
+	}
+	return w.Discount.Amount(ctx)
+}
+
+type Stacking int
+
+const (
+	// Stackable discounts are applied one after another, each to the price
+	// left by the ones before it.
+	Stackable Stacking = iota
+	// Exclusive discounts cannot be combined; the deal uses whichever single
+	// exclusive discount or set of stackable discounts saves the most.
+	Exclusive
+)
+
+type dealDiscount struct {
+	Discount
+	stacking Stacking
+}
+
+type AppliedDiscount struct {
+	Name string
+	Amount Money
+}
+
+// AddDiscount rejects a discount with amounts in another currency than the
+// product's, or with settings the discount cannot work with.
+func (deal *SpecialDeal) AddDiscount(d Discount, stacking Stacking) error {
+	if err := deal.checkDiscount(d); err != nil {
+		return err
+	}
+	deal.discounts = append(deal.discounts, dealDiscount{ d, stacking })
+	return nil
+}
+
+// SetFloor stops discounts taking the unit price below floor, which must be
+// in the product's currency.
+func (deal *SpecialDeal) SetFloor(floor Money) error {
+	if floor.currency != "" && floor.currency != deal.Product.price.currency {
+		return fmt.Errorf("store: the floor of deal %v is not in %v", deal.Name, deal.Product.price.currency)
+	}
+	deal.floor = floor
+	return nil
+}
+
+// checkDiscount validates the built in discounts through their wire form.
+// Discounts of other types are checked as they are applied instead.
+func (deal *SpecialDeal) checkDiscount(d Discount) error {
+	record, err := toDiscountRecord(dealDiscount{ d, Stackable })
+	if err != nil {
+		return nil
+	}
+	if !record.inCurrency(deal.Product.price.currency) {
+		return fmt.Errorf("store: discount %v of deal %v is not in %v", record.Label, deal.Name,
+			deal.Product.price.currency)
+	}
+	_, err = record.discount()
+	return err
+}
+
+// check confirms the deal still suits its product, which the deals follow
+// through price changes.
+func (deal *SpecialDeal) check() error {
+	if deal.Product == nil {
+		return fmt.Errorf("store: deal %v has no product", deal.Name)
+	}
+	if deal.floor.currency != "" && deal.floor.currency != deal.Product.price.currency {
+		return fmt.Errorf("store: the floor of deal %v is not in %v", deal.Name, deal.Product.price.currency)
+	}
+	for _, d := range deal.discounts {
+		if err := deal.checkDiscount(d.Discount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quote prices quantity units of the deal's product at time at, before tax,
+// and reports the discounts that were applied.
+func (deal *SpecialDeal) Quote(at time.Time, quantity int) (Money, []AppliedDiscount, error) {
+	if quantity < 1 {
+		return Money{}, nil, fmt.Errorf("store: cannot quote %v units of deal %v", quantity, deal.Name)
+	}
+	if err := deal.check(); err != nil {
+		return Money{}, nil, err
+	}
+	full := deal.Product.price.Multiply(int64(quantity))
+	minimum := deal.floor.Multiply(int64(quantity))
+	if minimum.IsNegative() || minimum.currency == "" {
+		minimum = Money{ 0, full.currency }
+	}
+
+	stackable := []dealDiscount{}
+	for _, d := range deal.discounts {
+		if d.stacking == Stackable {
+			stackable = append(stackable, d)
+		}
+	}
+	best, bestApplied, err := deal.apply(at, quantity, full, minimum, stackable)
+	if err != nil {
+		return Money{}, nil, err
+	}
+	for _, d := range deal.discounts {
+		if d.stacking == Exclusive {
+			price, applied, err := deal.apply(at, quantity, full, minimum, []dealDiscount{ d })
+			if err != nil {
+				return Money{}, nil, err
+			}
+			if price.Cmp(best) < 0 {
+				best, bestApplied = price, applied
+			}
+		}
+	}
+	return best, bestApplied, nil
+}
+
+// apply works through discounts in order, never letting the price fall below
+// minimum.
+func (deal *SpecialDeal) apply(at time.Time, quantity int, full, minimum Money,
+		discounts []dealDiscount) (Money, []AppliedDiscount, error) {
+	price := full
+	applied := []AppliedDiscount{}
+	for _, d := range discounts {
+		amount := d.Amount(DiscountContext{ deal.Product.price, quantity, price, at })
+		if amount.minor <= 0 {
+			continue
+		}
+		if amount.currency != price.currency {
+			return Money{}, nil, fmt.Errorf("store: discount %v took %v off a price in %v", d.Name(), amount,
+				price.currency)
+		}
+		if price.Sub(amount).Cmp(minimum) < 0 {
+			amount = price.Sub(minimum)
+		}
+		if amount.minor <= 0 {
+			continue
+		}
+		price = price.Sub(amount)
+		applied = append(applied, AppliedDiscount{ d.Name(), amount })
+	}
+	return price, applied, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuote(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		discounts []Discount
+		stacking Stacking
+		floor string
+		quantity int
+		want string
+		applied int
+	}{
+		{ "none", nil, Stackable, "", 2, "$200.00", 0 },
+		{ "fixed", []Discount{ FixedAmount{ "Ten off", MustParseMoney("$10") } }, Stackable, "", 3, "$270.00", 1 },
+		{ "percentage", []Discount{ Percentage{ "Fifth off", 0.2 } }, Stackable, "", 1, "$80.00", 1 },
+		{ "stacked", []Discount{ FixedAmount{ "Ten off", MustParseMoney("$10") }, Percentage{ "Half off", 0.5 } },
+			Stackable, "", 1, "$45.00", 2 },
+		{ "best exclusive", []Discount{ FixedAmount{ "Ten off", MustParseMoney("$10") }, BuyXGetY{ "3 for 2", 2, 1 } },
+			Exclusive, "", 3, "$200.00", 1 },
+		{ "floor", []Discount{ Percentage{ "Most off", 0.9 } }, Stackable, "$60", 2, "$120.00", 1 },
+		{ "tiered", []Discount{ TieredPricing{ "Bulk", []Tier{ { 5, MustParseMoney("$90") }, { 10, MustParseMoney("$80") } } } },
+			Stackable, "", 10, "$800.00", 1 },
+		{ "tier not reached", []Discount{ TieredPricing{ "Bulk", []Tier{ { 5, MustParseMoney("$90") } } } },
+			Stackable, "", 4, "$400.00", 0 },
+		{ "expired", []Discount{ ValidBetween(FixedAmount{ "Launch", MustParseMoney("$20") }, time.Time{}, now) },
+			Stackable, "", 1, "$100.00", 0 },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deal, err := NewDiscountDeal("Deal", NewProduct("Kayak", "Watersports", MustParseMoney("$100")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range test.discounts {
+				if err := deal.AddDiscount(d, test.stacking); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.floor != "" {
+				if err := deal.SetFloor(MustParseMoney(test.floor)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			price, applied, err := deal.Quote(now, test.quantity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if price.String() != test.want || len(applied) != test.applied {
+				t.Errorf("quoted %v with %v discounts, want %v with %v", price, len(applied), test.want, test.applied)
+			}
+		})
+	}
+}
+
+func TestDealRejectsInvalidDiscounts(t *testing.T) {
+	tests := []struct {
+		name string
+		discount Discount
+	}{
+		{ "fixed in euros", FixedAmount{ "Ten off", MustParseMoney("€10") } },
+		{ "tier in euros", TieredPricing{ "Bulk", []Tier{ { 5, MustParseMoney("€90") } } } },
+		{ "windowed in euros", ValidBetween(FixedAmount{ "Launch", MustParseMoney("€20") }, time.Time{}, time.Time{}) },
+		{ "rate above one", Percentage{ "Too much", 1.5 } },
+		{ "nothing free", BuyXGetY{ "3 for 3", 3, 0 } },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deal, err := NewDiscountDeal("Deal", NewProduct("Kayak", "Watersports", MustParseMoney("$100")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := deal.AddDiscount(test.discount, Stackable); err == nil {
+				t.Error("discount accepted")
+			}
+			if _, err := NewDiscountDeal("Deal", deal.Product, test.discount); err == nil {
+				t.Error("NewDiscountDeal accepted the discount")
+			}
+		})
+	}
+	deal, _ := NewDiscountDeal("Deal", NewProduct("Kayak", "Watersports", MustParseMoney("$100")))
+	if err := deal.SetFloor(MustParseMoney("€50")); err == nil {
+		t.Error("floor in euros accepted")
+	}
+	for _, quantity := range []int{ 0, -1 } {
+		if _, _, err := deal.Quote(time.Now(), quantity); err == nil {
+			t.Errorf("quoted %v units", quantity)
+		}
+	}
+}
+
+func TestDiscountAmount(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tiers := TieredPricing{ "Bulk", []Tier{ { 5, MustParseMoney("$90") }, { 10, MustParseMoney("$80") } } }
+	tests := []struct {
+		name string
+		discount Discount
+		quantity int
+		price string
+		want string // empty when the discount does not apply
+	}{
+		{ "fixed per unit", FixedAmount{ "Ten off", MustParseMoney("$10") }, 3, "$300", "$30.00" },
+		{ "percentage of what is left", Percentage{ "Fifth off", 0.2 }, 2, "$150", "$30.00" },
+		{ "percentage rounds half to even", Percentage{ "Half off", 0.5 }, 1, "$0.05", "$0.02" },
+		{ "buy 2 get 1", BuyXGetY{ "3 for 2", 2, 1 }, 7, "$700", "$200.00" },
+		{ "buy 2 get 1 short", BuyXGetY{ "3 for 2", 2, 1 }, 2, "$200", "" },
+		{ "buy nothing", BuyXGetY{ "Free", 0, 1 }, 3, "$300", "" },
+		{ "highest tier", tiers, 12, "$1200", "$240.00" },
+		{ "lower tier", tiers, 6, "$600", "$60.00" },
+		{ "no tier", tiers, 4, "$400", "" },
+		{ "in window", ValidBetween(FixedAmount{ "Launch", MustParseMoney("$5") }, now.Add(-time.Hour), now.Add(time.Hour)),
+			1, "$100", "$5.00" },
+		{ "window ends", ValidBetween(FixedAmount{ "Launch", MustParseMoney("$5") }, time.Time{}, now), 1, "$100", "" },
+		{ "window not open", ValidBetween(FixedAmount{ "Launch", MustParseMoney("$5") }, now.Add(time.Second), time.Time{}),
+			1, "$100", "" },
+	}
+	for _, test := range tests {
+		ctx := DiscountContext{ MustParseMoney("$100"), test.quantity, MustParseMoney(test.price), now }
+		got := test.discount.Amount(ctx)
+		if test.want == "" && !got.IsZero() || test.want != "" && got.String() != test.want {
+			t.Errorf("%v: %v off, want %v", test.name, got, test.want)
+		}
+	}
+}
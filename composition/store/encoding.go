@@ -0,0 +1,517 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type moneyRecord struct {
+	Amount string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes Money as {"amount": "279.00", "currency": "USD"}, or
+// null for the zero value with no currency.
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.currency == "" && m.minor == 0 {
+		return []byte("null"), nil
+	}
+	return json.Marshal(moneyRecord{ m.Amount(), m.currency })
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = Money{}
+		return nil
+	}
+	var record moneyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if !isCurrencyCode(record.Currency) {
+		return fmt.Errorf("store: invalid currency %q", record.Currency)
+	}
+	parsed, err := ParseAmount(record.Amount, record.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// catalogRecord is the wire form of every catalog item. Type tells readers
+// which concrete type to build: "product", "boat", "rental" or "bundle".
+// Bundles with a Discount are priced from their Components, so any price
+// given for them is ignored. Products with
+// Axes are sold in the listed Variants; Options is only written for a single
+// variant and is never read back.
+type catalogRecord struct {
+	SKU string `json:"sku,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Category string `json:"category"`
+	Price Money `json:"price"`
+	Capacity int `json:"capacity,omitempty"`
+	Motorized bool `json:"motorized,omitempty"`
+	IncludeCrew bool `json:"includeCrew,omitempty"`
+	Crew *Crew `json:"crew,omitempty"`
+	Axes []string `json:"axes,omitempty"`
+	Variants []variantRecord `json:"variants,omitempty"`
+	Options Options `json:"options,omitempty"`
+	Components []BundleComponent `json:"components,omitempty"`
+	Discount *Money `json:"discount,omitempty"`
+}
+
+type variantRecord struct {
+	SKU string `json:"sku"`
+	Options Options `json:"options"`
+	Delta Money `json:"delta"`
+	Stock int `json:"stock"`
+}
+
+func toRecord(sku string, item Item) catalogRecord {
+	p := item.BaseProduct()
+	record := catalogRecord{ SKU: sku, Type: ItemType(item), Name: p.Name, Category: p.Category, Price: p.price }
+	switch i := item.(type) {
+	case *RentalBoat:
+		record.Capacity, record.Motorized = i.Capacity, i.Motorized
+		record.IncludeCrew = i.IncludeCrew
+		if i.Crew != nil && (i.Captain != "" || i.FirstOfficer != "") {
+			crew := *i.Crew
+			record.Crew = &crew
+		}
+	case *Boat:
+		record.Capacity, record.Motorized = i.Capacity, i.Motorized
+	case *VariantProduct:
+		record.Axes = i.Axes
+		for _, v := range i.variants {
+			record.Variants = append(record.Variants, variantRecord{ v.SKU, v.Options, v.Delta, v.Stock })
+		}
+	case *Variant:
+		record.Options = i.Options
+	case *Bundle:
+		record.Components = i.Components
+		if !i.FixedPrice {
+			discount := i.Discount
+			record.Discount = &discount
+		}
+	}
+	return record
+}
+
+func (record catalogRecord) validate() error {
+	if strings.TrimSpace(record.Name) == "" {
+		return fmt.Errorf("store: a product name is required")
+	}
+	if record.Type == TypeBundle && record.Discount != nil {
+		if record.Discount.currency == "" || record.Discount.IsNegative() {
+			return fmt.Errorf("store: bundle %v needs a discount of zero or more in a currency", record.Name)
+		}
+	} else if record.Price.currency == "" || record.Price.IsNegative() {
+		return fmt.Errorf("store: %v needs a price of zero or more in a currency", record.Name)
+	}
+	if record.Type == TypeBundle && len(record.Components) == 0 {
+		return fmt.Errorf("store: bundle %v has no components", record.Name)
+	}
+	if record.Type != TypeBundle && (len(record.Components) > 0 || record.Discount != nil) {
+		return fmt.Errorf("store: only bundles have components, not %v", record.Name)
+	}
+	if (record.Type == TypeBoat || record.Type == TypeRental) && record.Capacity < 1 {
+		return fmt.Errorf("store: boat %v must carry at least one person", record.Name)
+	}
+	if record.Type != TypeRental && (record.IncludeCrew || record.Crew != nil) {
+		return fmt.Errorf("store: only rental boats have crew, not %v", record.Name)
+	}
+	if record.Type != TypeProduct && len(record.Axes) > 0 {
+		return fmt.Errorf("store: only products come in variants, not %v", record.Name)
+	}
+	if len(record.Variants) > 0 && len(record.Axes) == 0 {
+		return fmt.Errorf("store: the variants of %v need option axes", record.Name)
+	}
+	if len(record.Options) > 0 {
+		return fmt.Errorf("store: %v is a variant, change it through its product", record.Name)
+	}
+	return nil
+}
+
+func (record catalogRecord) item() (Item, error) {
+	switch record.Type {
+	case TypeProduct, TypeBoat, TypeRental, TypeBundle:
+	default:
+		return nil, fmt.Errorf("store: unknown product type %q for %v", record.Type, record.Name)
+	}
+	if err := record.validate(); err != nil {
+		return nil, err
+	}
+	switch record.Type {
+	case TypeBundle:
+		components := append([]BundleComponent{}, record.Components...)
+		if record.Discount != nil {
+			return NewDiscountBundle(record.Name, record.Category, *record.Discount, components...), nil
+		}
+		return NewBundle(record.Name, record.Category, record.Price, components...), nil
+	case TypeBoat:
+		boat := NewBoat(record.Name, record.Price, record.Capacity, record.Motorized)
+		boat.Category = record.Category
+		return boat, nil
+	case TypeRental:
+		crew := Crew{}
+		if record.Crew != nil {
+			crew = *record.Crew
+		}
+		rental, err := NewRentalBoat(record.Name, record.Price, record.Capacity, record.Motorized,
+			record.IncludeCrew, crew.Captain, crew.FirstOfficer)
+		if err != nil {
+			return nil, err
+		}
+		rental.Category = record.Category
+		return rental, nil
+	}
+	if len(record.Axes) > 0 {
+		product := NewVariantProduct(record.Name, record.Category, record.Price, record.Axes...)
+		for _, v := range record.Variants {
+			if _, err := product.AddVariant(v.SKU, v.Options, v.Delta, v.Stock); err != nil {
+				return nil, err
+			}
+		}
+		return product, nil
+	}
+	return NewProduct(record.Name, record.Category, record.Price), nil
+}
+
+// decodeItem reads a catalog record and checks it describes the type wanted.
+func decodeItem(data []byte, want string) (Item, error) {
+	var record catalogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if want != "" && record.Type != want {
+		return nil, fmt.Errorf("store: expected a %v but found a %q", want, record.Type)
+	}
+	return record.item()
+}
+
+func (p *Product) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord("", p))
+}
+
+func (p *Product) UnmarshalJSON(data []byte) error {
+	item, err := decodeItem(data, TypeProduct)
+	if err == nil {
+		*p = *item.BaseProduct()
+	}
+	return err
+}
+
+func (product *VariantProduct) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord("", product))
+}
+
+func (product *VariantProduct) UnmarshalJSON(data []byte) error {
+	item, err := decodeItem(data, TypeProduct)
+	if err != nil {
+		return err
+	}
+	decoded, hasVariants := item.(*VariantProduct)
+	if !hasVariants {
+		return fmt.Errorf("store: %v has no option axes", item.BaseProduct().Name)
+	}
+	*product = *decoded
+	for _, variant := range product.variants {
+		variant.Parent = product
+	}
+	return nil
+}
+
+func (b *Bundle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord("", b))
+}
+
+func (b *Bundle) UnmarshalJSON(data []byte) error {
+	item, err := decodeItem(data, TypeBundle)
+	if err == nil {
+		*b = *item.(*Bundle)
+	}
+	return err
+}
+
+// MarshalJSON writes a variant as a product of its own, with its options.
+func (v *Variant) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord(v.SKU, v))
+}
+
+func (b *Boat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord("", b))
+}
+
+func (b *Boat) UnmarshalJSON(data []byte) error {
+	item, err := decodeItem(data, TypeBoat)
+	if err == nil {
+		*b = *item.(*Boat)
+	}
+	return err
+}
+
+func (r *RentalBoat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord("", r))
+}
+
+func (r *RentalBoat) UnmarshalJSON(data []byte) error {
+	item, err := decodeItem(data, TypeRental)
+	if err == nil {
+		*r = *item.(*RentalBoat)
+	}
+	return err
+}
+
+type crewRecord struct {
+	Captain string `json:"captain"`
+	FirstOfficer string `json:"firstOfficer"`
+}
+
+func (crew Crew) MarshalJSON() ([]byte, error) {
+	return json.Marshal(crewRecord(crew))
+}
+
+func (crew *Crew) UnmarshalJSON(data []byte) error {
+	var record crewRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if strings.TrimSpace(record.Captain) == "" {
+		return fmt.Errorf("store: a crew needs a captain")
+	}
+	*crew = Crew(record)
+	return nil
+}
+
+// MarshalItem encodes any catalog item with its type, so UnmarshalItem can
+// rebuild the same concrete type.
+func MarshalItem(item Item) ([]byte, error) {
+	return json.Marshal(toRecord("", item))
+}
+
+func UnmarshalItem(data []byte) (Item, error) {
+	return decodeItem(data, "")
+}
+
+func (entry CatalogEntry) MarshalJSON() ([]byte, error) {
+	if entry.Item == nil {
+		return nil, fmt.Errorf("store: no product given for SKU %v", entry.SKU)
+	}
+	return json.Marshal(toRecord(entry.SKU, entry.Item))
+}
+
+func (entry *CatalogEntry) UnmarshalJSON(data []byte) error {
+	var record catalogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	item, err := record.item()
+	if err != nil {
+		return err
+	}
+	if err = validateEntry(record.SKU, item); err != nil {
+		return err
+	}
+	*entry = CatalogEntry{ record.SKU, item }
+	return nil
+}
+
+// discountRecord is the wire form of the built in discounts. Kind is one of
+// "fixed", "percentage", "buyxgety" or "tiered"; discounts limited with
+// ValidBetween carry their window in ValidFrom and ValidUntil.
+type discountRecord struct {
+	Kind string `json:"kind"`
+	Label string `json:"label"`
+	Stacking string `json:"stacking"`
+	Off *Money `json:"off,omitempty"`
+	Rate float64 `json:"rate,omitempty"`
+	Buy int `json:"buy,omitempty"`
+	Free int `json:"free,omitempty"`
+	Tiers []Tier `json:"tiers,omitempty"`
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+var stackingNames = map[Stacking]string{ Stackable: "stackable", Exclusive: "exclusive" }
+
+func toDiscountRecord(d dealDiscount) (discountRecord, error) {
+	record := discountRecord{ Label: d.Name(), Stacking: stackingNames[d.stacking] }
+	discount := d.Discount
+	if w, limited := discount.(window); limited {
+		if !w.start.IsZero() {
+			record.ValidFrom = &w.start
+		}
+		if !w.end.IsZero() {
+			record.ValidUntil = &w.end
+		}
+		discount = w.Discount
+	}
+	switch d := discount.(type) {
+	case FixedAmount:
+		off := d.Off
+		record.Kind, record.Off = "fixed", &off
+	case Percentage:
+		record.Kind, record.Rate = "percentage", d.Rate
+	case BuyXGetY:
+		record.Kind, record.Buy, record.Free = "buyxgety", d.Buy, d.Free
+	case TieredPricing:
+		record.Kind, record.Tiers = "tiered", d.Tiers
+	default:
+		return record, fmt.Errorf("store: cannot encode discount %q of type %T", d.Name(), d)
+	}
+	return record, nil
+}
+
+// inCurrency reports whether every amount in the discount is in currency.
+func (record discountRecord) inCurrency(currency string) bool {
+	if record.Off != nil && record.Off.currency != currency {
+		return false
+	}
+	for _, tier := range record.Tiers {
+		if tier.UnitPrice.currency != currency {
+			return false
+		}
+	}
+	return true
+}
+
+func (record discountRecord) discount() (dealDiscount, error) {
+	if strings.TrimSpace(record.Label) == "" {
+		return dealDiscount{}, fmt.Errorf("store: a discount label is required")
+	}
+	var d Discount
+	switch record.Kind {
+	case "fixed":
+		if record.Off == nil || record.Off.currency == "" || record.Off.IsNegative() {
+			return dealDiscount{}, fmt.Errorf("store: discount %v needs an amount off", record.Label)
+		}
+		d = FixedAmount{ record.Label, *record.Off }
+	case "percentage":
+		if !(record.Rate > 0 && record.Rate <= 1) {
+			return dealDiscount{}, fmt.Errorf("store: discount %v needs a rate above 0 and up to 1", record.Label)
+		}
+		d = Percentage{ record.Label, record.Rate }
+	case "buyxgety":
+		if record.Buy < 1 || record.Free < 1 {
+			return dealDiscount{}, fmt.Errorf("store: discount %v needs buy and free quantities", record.Label)
+		}
+		d = BuyXGetY{ record.Label, record.Buy, record.Free }
+	case "tiered":
+		if len(record.Tiers) == 0 {
+			return dealDiscount{}, fmt.Errorf("store: discount %v needs at least one tier", record.Label)
+		}
+		for _, tier := range record.Tiers {
+			if tier.MinQuantity < 1 || tier.UnitPrice.currency == "" || tier.UnitPrice.IsNegative() {
+				return dealDiscount{}, fmt.Errorf("store: discount %v has an invalid tier", record.Label)
+			}
+		}
+		d = TieredPricing{ record.Label, append([]Tier{}, record.Tiers...) }
+	default:
+		return dealDiscount{}, fmt.Errorf("store: unknown discount kind %q", record.Kind)
+	}
+	var start, end time.Time
+	if record.ValidFrom != nil {
+		start = *record.ValidFrom
+	}
+	if record.ValidUntil != nil {
+		end = *record.ValidUntil
+	}
+	if !start.IsZero() || !end.IsZero() {
+		if !start.IsZero() && !end.IsZero() && !end.After(start) {
+			return dealDiscount{}, fmt.Errorf("store: discount %v must end after it starts", record.Label)
+		}
+		d = ValidBetween(d, start, end)
+	}
+	switch record.Stacking {
+	case "", stackingNames[Stackable]:
+		return dealDiscount{ d, Stackable }, nil
+	case stackingNames[Exclusive]:
+		return dealDiscount{ d, Exclusive }, nil
+	}
+	return dealDiscount{}, fmt.Errorf("store: unknown stacking %q for %v", record.Stacking, record.Label)
+}
+
+type dealRecord struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Product *Product `json:"product"`
+	Floor Money `json:"floor"`
+	Discounts []discountRecord `json:"discounts"`
+}
+
+func (deal *SpecialDeal) MarshalJSON() ([]byte, error) {
+	record := dealRecord{ Type: "deal", Name: deal.Name, Product: deal.Product, Floor: deal.floor,
+		Discounts: []discountRecord{} }
+	for _, d := range deal.discounts {
+		discount, err := toDiscountRecord(d)
+		if err != nil {
+			return nil, err
+		}
+		record.Discounts = append(record.Discounts, discount)
+	}
+	return json.Marshal(record)
+}
+
+// UnmarshalJSON rebuilds a deal around its own copy of the product; use
+// Product to find the matching catalog entry before applying it to a cart.
+func (deal *SpecialDeal) UnmarshalJSON(data []byte) error {
+	var record dealRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if record.Type != "deal" {
+		return fmt.Errorf("store: expected a deal but found a %q", record.Type)
+	}
+	if strings.TrimSpace(record.Name) == "" || record.Product == nil {
+		return fmt.Errorf("store: a deal needs a name and a product")
+	}
+	if record.Floor.currency != "" && record.Floor.currency != record.Product.price.currency {
+		return fmt.Errorf("store: the floor of deal %v is not in %v", record.Name, record.Product.price.currency)
+	}
+	decoded := &SpecialDeal{ Name: record.Name, Product: record.Product, floor: record.Floor }
+	for _, r := range record.Discounts {
+		if !r.inCurrency(record.Product.price.currency) {
+			return fmt.Errorf("store: discount %v of deal %v is not in %v", r.Label, record.Name, record.Product.price.currency)
+		}
+		d, err := r.discount()
+		if err != nil {
+			return err
+		}
+		decoded.discounts = append(decoded.discounts, d)
+	}
+	*deal = *decoded
+	return nil
+}
+
+type orderRecord struct {
+	Number string `json:"number"`
+	Status OrderStatus `json:"status"`
+	Placed time.Time `json:"placed"`
+	Location Location `json:"location"`
+	Customer string `json:"customer,omitempty"`
+	Lines []PricedLine `json:"lines"`
+	Totals CartTotals `json:"totals"`
+}
+
+func (order *Order) MarshalJSON() ([]byte, error) {
+	return json.Marshal(orderRecord{ order.number, order.status, order.placed, order.location, order.customer,
+		order.lines, order.totals })
+}
+
+func (order *Order) UnmarshalJSON(data []byte) error {
+	var record orderRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if record.Number == "" {
+		return fmt.Errorf("store: an order needs a number")
+	}
+	record.Totals.Lines = nil
+	*order = Order{ record.Number, record.Status, record.Placed, record.Location, record.Lines, record.Totals,
+		record.Customer }
+	return nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExchangeRate says that one unit of From buys Rate units of To. It holds
+// from Effective until a later rate for the same pair takes over.
+type ExchangeRate struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Rate float64 `json:"rate"`
+	Effective time.Time `json:"effective"`
+}
+
+// Convert turns an amount in From into To, rounding to a whole minor unit of
+// To, so 100 USD at 151.37 is 15137 JPY and not 1513700.
+func (rate ExchangeRate) Convert(amount Money, mode RoundingMode) (Money, error) {
+	if amount.currency != rate.From {
+		return Money{}, fmt.Errorf("store: a %v to %v rate cannot convert %v", rate.From, rate.To, amount)
+	}
+	factor := ratFromFloat(rate.Rate)
+	shift := digits(rate.To) - digits(rate.From)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		factor.Mul(factor, scale)
+	} else {
+		factor.Quo(factor, scale)
+	}
+	return Money{ amount.multiplyByRat(factor, mode).minor, rate.To }, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (rate ExchangeRate) validate() error {
+	if !isCurrencyCode(rate.From) || !isCurrencyCode(rate.To) {
+		return fmt.Errorf("store: %q and %q are not both currency codes", rate.From, rate.To)
+	}
+	if rate.From == rate.To {
+		return fmt.Errorf("store: cannot set a rate from %v to itself", rate.From)
+	}
+	if !(rate.Rate > 0) || !IsFiniteRate(rate.Rate) || !IsFiniteRate(1 / rate.Rate) {
+		return fmt.Errorf("store: the %v to %v rate must be a finite number more than zero", rate.From, rate.To)
+	}
+	return nil
+}
+
+// ExchangeRates is a table of dated rates. A pair that is only quoted the
+// other way round is converted at the inverse rate, and conversions round
+// with Rounding.
+type ExchangeRates struct {
+	mutex sync.RWMutex
+	rates []ExchangeRate
+	Rounding RoundingMode
+}
+
+func NewExchangeRates(rounding RoundingMode, rates ...ExchangeRate) (*ExchangeRates, error) {
+	table := &ExchangeRates{ Rounding: rounding }
+	for _, rate := range rates {
+		if err := table.Set(rate); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// Set adds a rate, replacing any rate for the same pair and effective time.
+func (table *ExchangeRates) Set(rate ExchangeRate) error {
+	if err := rate.validate(); err != nil {
+		return err
+	}
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	for i, existing := range table.rates {
+		if existing.From == rate.From && existing.To == rate.To && existing.Effective.Equal(rate.Effective) {
+			table.rates[i] = rate
+			return nil
+		}
+	}
+	table.rates = append(table.rates, rate)
+	sort.SliceStable(table.rates, func(i, j int) bool {
+		a, b := table.rates[i], table.rates[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Effective.Before(b.Effective)
+	})
+	return nil
+}
+
+func (table *ExchangeRates) Rates() []ExchangeRate {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return append([]ExchangeRate{}, table.rates...)
+}
+
+func (table *ExchangeRates) latest(from, to string, at time.Time) (ExchangeRate, bool) {
+	var found *ExchangeRate
+	for i, rate := range table.rates {
+		if rate.From == from && rate.To == to && !rate.Effective.After(at) {
+			found = &table.rates[i]
+		}
+	}
+	if found == nil {
+		return ExchangeRate{}, false
+	}
+	return *found, true
+}
+
+// Rate finds the rate from one currency to another in force at time at. The
+// inverse of a rate quoted the other way is returned as a rate of its own,
+// so the rate recorded against a conversion always reproduces it.
+func (table *ExchangeRates) Rate(from, to string, at time.Time) (ExchangeRate, error) {
+	if from == to {
+		return ExchangeRate{ from, to, 1, time.Time{} }, nil
+	}
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	if rate, found := table.latest(from, to, at); found {
+		return rate, nil
+	}
+	if rate, found := table.latest(to, from, at); found {
+		return ExchangeRate{ from, to, 1 / rate.Rate, rate.Effective }, nil
+	}
+	return ExchangeRate{}, fmt.Errorf("store: no %v to %v exchange rate on %v", from, to, at.Format("2006-01-02"))
+}
+
+// Convert changes amount into currency at the rate in force at time at and
+// returns the rate it used.
+func (table *ExchangeRates) Convert(amount Money, currency string, at time.Time) (Money, ExchangeRate, error) {
+	rate, err := table.Rate(amount.currency, currency, at)
+	if err != nil {
+		return Money{}, rate, err
+	}
+	converted, err := rate.Convert(amount, table.Rounding)
+	return converted, rate, err
+}
+
+// FileExchangeRates keeps the rate table in a JSON file, a list of rates
+// that can also be written by hand or by a script fetching published rates.
+type FileExchangeRates struct {
+	*ExchangeRates
+	file *sharedFile
+}
+
+func OpenFileExchangeRates(path string, rounding RoundingMode) (*FileExchangeRates, error) {
+	table := &FileExchangeRates{ ExchangeRates: &ExchangeRates{ Rounding: rounding } }
+	table.file = newSharedFile(path, table.load)
+	if err := table.file.reload(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (table *FileExchangeRates) load(data []byte) error {
+	var rates []ExchangeRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return err
+	}
+	loaded, err := NewExchangeRates(table.Rounding, rates...)
+	if err != nil {
+		return err
+	}
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.rates = loaded.rates
+	return nil
+}
+
+func (table *FileExchangeRates) Set(rate ExchangeRate) error {
+	return table.file.change(func() error {
+		if err := table.ExchangeRates.Set(rate); err != nil {
+			return err
+		}
+		return table.file.write(table.Rates())
+	})
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+type listingRecord struct {
+	SKU string `json:"sku"`
+	Rates RentalRates `json:"rates"`
+}
+
+type calendarRecord struct {
+	Buffer time.Duration `json:"buffer"`
+	RefundRules []RefundRule `json:"refundRules"`
+	Listings []listingRecord `json:"listings"`
+	Bookings []Booking `json:"bookings"`
+	NextID int `json:"nextId"`
+}
+
+// FileCalendar is a BookingCalendar that rewrites a JSON file after every
+// change. Rental boats are looked up in the catalog when the file is read;
+// rates for SKUs that are no longer rentals are dropped. The crew of each
+// confirmed booking read back are held on the roster again, so they are not
+// given another rental at the same time.
+type FileCalendar struct {
+	*BookingCalendar
+	catalog Catalog
+	file *sharedFile
+}
+
+// OpenFileCalendar loads the calendar stored at path, staffing rentals that
+// include crew from crew, which may be nil if there are none. The buffer and
+// refund rules are used for a new file; an existing file keeps its own.
+func OpenFileCalendar(path string, catalog Catalog, crew *CrewRoster, buffer time.Duration,
+		refunds ...RefundRule) (*FileCalendar, error) {
+	calendar := &FileCalendar{ BookingCalendar: NewBookingCalendar(buffer, refunds...), catalog: catalog }
+	calendar.Crew = crew
+	calendar.file = newSharedFile(path, calendar.load)
+	if err := calendar.file.reload(); err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+// load replaces the listings and bookings with those in data, moving the
+// crew holds from the old bookings to the new.
+func (calendar *FileCalendar) load(data []byte) error {
+	var record calendarRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	loaded := NewBookingCalendar(record.Buffer, record.RefundRules...)
+	for _, listing := range record.Listings {
+		item, _ := calendar.catalog.Get(listing.SKU)
+		if rental, isRental := item.(*RentalBoat); isRental {
+			if err := loaded.AddBoat(listing.SKU, rental, listing.Rates); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range record.Bookings {
+		booking := record.Bookings[i]
+		loaded.bookings = append(loaded.bookings, &booking)
+	}
+	calendar.mutex.Lock()
+	defer calendar.mutex.Unlock()
+	if calendar.Crew != nil {
+		for _, booking := range calendar.bookings {
+			if booking.Crew != nil {
+				calendar.Crew.release(booking.ID)
+			}
+		}
+		for _, booking := range loaded.bookings {
+			if booking.Crew != nil && booking.Status == BookingConfirmed {
+				calendar.Crew.hold(booking.ID, booking.CrewIDs, booking.Start, booking.End)
+			}
+		}
+	}
+	calendar.Buffer, calendar.RefundRules = loaded.Buffer, loaded.RefundRules
+	calendar.listings, calendar.bookings, calendar.nextID = loaded.listings, loaded.bookings, record.NextID
+	return nil
+}
+
+// The reads below first read the calendar back if another process has
+// changed it. If the file cannot be read, they answer from what was last
+// read.
+
+func (calendar *FileCalendar) Rates(sku string) (RentalRates, bool) {
+	calendar.file.reload()
+	return calendar.BookingCalendar.Rates(sku)
+}
+
+func (calendar *FileCalendar) Quote(sku string, start, end time.Time) (Money, error) {
+	calendar.file.reload()
+	return calendar.BookingCalendar.Quote(sku, start, end)
+}
+
+func (calendar *FileCalendar) Booking(id string) (Booking, bool) {
+	calendar.file.reload()
+	return calendar.BookingCalendar.Booking(id)
+}
+
+func (calendar *FileCalendar) Bookings(sku string) []Booking {
+	calendar.file.reload()
+	return calendar.BookingCalendar.Bookings(sku)
+}
+
+func (calendar *FileCalendar) Available(start, end time.Time, capacity int) []string {
+	calendar.file.reload()
+	return calendar.BookingCalendar.Available(start, end, capacity)
+}
+
+func (calendar *FileCalendar) AddBoat(sku string, boat *RentalBoat, rates RentalRates) error {
+	return calendar.file.change(func() error {
+		if err := calendar.BookingCalendar.AddBoat(sku, boat, rates); err != nil {
+			return err
+		}
+		return calendar.save()
+	})
+}
+
+func (calendar *FileCalendar) Book(sku, customer string, start, end time.Time) (*Booking, error) {
+	var booking *Booking
+	err := calendar.file.change(func() error {
+		var err error
+		if booking, err = calendar.BookingCalendar.Book(sku, customer, start, end); err != nil {
+			return err
+		}
+		if err = calendar.save(); err != nil {
+			calendar.unbook(booking.ID)
+			booking = nil
+		}
+		return err
+	})
+	return booking, err
+}
+
+func (calendar *FileCalendar) Cancel(id string) (Money, error) {
+	var refund Money
+	err := calendar.file.change(func() error {
+		var err error
+		if refund, err = calendar.BookingCalendar.Cancel(id); err != nil {
+			return err
+		}
+		if err = calendar.save(); err != nil {
+			calendar.uncancel(id)
+			refund = Money{}
+		}
+		return err
+	})
+	return refund, err
+}
+
+func (calendar *FileCalendar) save() error {
+	calendar.mutex.Lock()
+	record := calendarRecord{ Buffer: calendar.Buffer, RefundRules: calendar.RefundRules,
+		Listings: []listingRecord{}, Bookings: []Booking{}, NextID: calendar.nextID }
+	for sku, listing := range calendar.listings {
+		record.Listings = append(record.Listings, listingRecord{ sku, listing.rates })
+	}
+	for _, booking := range calendar.bookings {
+		record.Bookings = append(record.Bookings, *booking)
+	}
+	calendar.mutex.Unlock()
+	sort.Slice(record.Listings, func(i, j int) bool { return record.Listings[i].SKU < record.Listings[j].SKU })
+	return calendar.file.write(record)
+}
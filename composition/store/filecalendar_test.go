@@ -0,0 +1,157 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCalendar opens a calendar with a crewed yacht and a rowing boat, and
+// a roster of one captain and one first officer.
+func testCalendar(t *testing.T) (*FileCalendar, *CrewRoster) {
+	t.Helper()
+	catalog := NewMemoryCatalog()
+	yacht, err := NewRentalBoat("Yacht", MustParseMoney("$5000"), 5, true, true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowing, err := NewRentalBoat("Rowing Boat", MustParseMoney("$50"), 2, false, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sku, boat := range map[string]*RentalBoat{ "RENT-1": yacht, "RENT-2": rowing } {
+		if err := catalog.Put(sku, boat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crew := NewCrewRoster()
+	expires := time.Now().AddDate(5, 0, 0)
+	if err := crew.Add("C1", "Bob", Certification{ Captain, "CAP-1", expires, 10 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := crew.Add("F1", "Alice", Certification{ FirstOfficer, "FO-1", expires, 10 }); err != nil {
+		t.Fatal(err)
+	}
+	calendar, err := OpenFileCalendar(filepath.Join(t.TempDir(), "calendar.json"), catalog, crew, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := RentalRates{ Daily: MustParseMoney("$100") }
+	for sku, boat := range map[string]*RentalBoat{ "RENT-1": yacht, "RENT-2": rowing } {
+		if err := calendar.AddBoat(sku, boat, rates); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return calendar, crew
+}
+
+func TestFileCalendarUndoesFailedBooking(t *testing.T) {
+	calendar, crew := testCalendar(t)
+	start := time.Now().Add(Day).Truncate(time.Hour)
+	end := start.Add(Day)
+	breakWrites(t, calendar.file)
+
+	if _, err := calendar.Book("RENT-1", "CUST-1", start, end); err == nil {
+		t.Fatal("booking saved to a file that cannot be written")
+	}
+	if bookings := calendar.Bookings(""); len(bookings) != 0 {
+		t.Errorf("failed booking kept: %+v", bookings)
+	}
+	if available := crew.Available(Captain, &Boat{ Capacity: 5 }, start, end); len(available) != 1 {
+		t.Errorf("failed booking kept its captain busy")
+	}
+	if calendar.nextID != 0 {
+		t.Errorf("failed booking used up an ID, next is %v", calendar.nextID + 1)
+	}
+}
+
+func TestAvailableNeedsCrew(t *testing.T) {
+	calendar, crew := testCalendar(t)
+	start := time.Now().Add(Day).Truncate(time.Hour)
+	end := start.Add(Day)
+	tests := []struct {
+		name string
+		prepare func() error
+		want []string
+	}{
+		{ "crew free", func() error { return nil }, []string{ "RENT-1", "RENT-2" } },
+		{ "captain on leave", func() error { return crew.SetUnavailable("C1", start, end) }, []string{ "RENT-2" } },
+	}
+	for _, test := range tests {
+		if err := test.prepare(); err != nil {
+			t.Fatal(err)
+		}
+		got := calendar.Available(start, end, 1)
+		if len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+			t.Errorf("%v: available %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReloadedBookingHoldsCrewByID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.json")
+	catalog := NewMemoryCatalog()
+	yacht, err := NewRentalBoat("Yacht", MustParseMoney("$5000"), 5, true, true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.Put("RENT-1", yacht); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().AddDate(5, 0, 0)
+	roster := func() *CrewRoster {
+		crew := NewCrewRoster()
+		// Two captains share a name; the one on the smaller licence sails first.
+		crew.Add("C1", "Bob", Certification{ Captain, "CAP-1", expires, 20 })
+		crew.Add("C2", "Bob", Certification{ Captain, "CAP-2", expires, 10 })
+		crew.Add("F1", "Alice", Certification{ FirstOfficer, "FO-1", expires, 10 })
+		return crew
+	}
+	calendar, err := OpenFileCalendar(path, catalog, roster(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := calendar.AddBoat("RENT-1", yacht, RentalRates{ Daily: MustParseMoney("$100") }); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(Day).Truncate(time.Hour)
+	booking, err := calendar.Book("RENT-1", "CUST-1", start, start.Add(Day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(booking.CrewIDs) != 2 || booking.CrewIDs[0] != "C2" || booking.CrewIDs[1] != "F1" {
+		t.Fatalf("crew IDs %v, want [C2 F1]", booking.CrewIDs)
+	}
+
+	crew := roster()
+	if _, err := OpenFileCalendar(path, catalog, crew, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	free := crew.Available(Captain, yacht.Boat, start, start.Add(Day))
+	if len(free) != 1 || free[0].ID != "C1" {
+		t.Errorf("free captains %v, want only C1", free)
+	}
+}
+
+func TestNewRentalBoatRejectsPlaceholderCrew(t *testing.T) {
+	tests := []struct {
+		captain, firstOfficer string
+		ok bool
+	}{
+		{ "", "", true },
+		{ "Bob", "Alice", true },
+		{ "Bob", "", true },
+		{ "Nathan", "Tobias", true },
+		{ "N/A", "N/A", false },
+		{ "n/a", "", false },
+		{ "Bob", "TBD", false },
+		{ "-", "", false },
+		{ "", "Alice", false },
+	}
+	for _, test := range tests {
+		_, err := NewRentalBoat("Yacht", MustParseMoney("$5000"), 5, true, true, test.captain, test.firstOfficer)
+		if (err == nil) != test.ok {
+			t.Errorf("crew %q and %q: error %v, want ok %v", test.captain, test.firstOfficer, err, test.ok)
+		}
+	}
+}
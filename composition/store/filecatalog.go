@@ -0,0 +1,90 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// FileCatalog keeps the catalog in memory and rewrites a JSON file after
+// every change, so the data survives restarts.
+type FileCatalog struct {
+	*MemoryCatalog
+	file *sharedFile
+}
+
+// OpenFileCatalog loads the catalog stored at path, starting empty if the
+// file does not exist yet.
+func OpenFileCatalog(path string) (*FileCatalog, error) {
+	catalog := &FileCatalog{ MemoryCatalog: NewMemoryCatalog() }
+	catalog.file = newSharedFile(path, catalog.load)
+	if err := catalog.file.reload(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// load replaces the catalog with the entries in data.
+func (catalog *FileCatalog) load(data []byte) error {
+	entries := []CatalogEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	// Bundles go in last, once the items they are made of are there.
+	sort.SliceStable(entries, func(i, j int) bool {
+		_, first := entries[i].Item.(*Bundle)
+		_, second := entries[j].Item.(*Bundle)
+		return !first && second
+	})
+	loaded := NewMemoryCatalog()
+	for _, entry := range entries {
+		if err := loaded.Put(entry.SKU, entry.Item); err != nil {
+			return err
+		}
+	}
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	catalog.items, catalog.variants = loaded.items, loaded.variants
+	return nil
+}
+
+// Get and Query first read the catalog back if another process has changed
+// it. If the file cannot be read, they answer from what was last read.
+func (catalog *FileCatalog) Get(sku string) (Item, bool) {
+	catalog.file.reload()
+	return catalog.MemoryCatalog.Get(sku)
+}
+
+func (catalog *FileCatalog) Query(query ProductQuery) QueryResult {
+	catalog.file.reload()
+	return catalog.MemoryCatalog.Query(query)
+}
+
+func (catalog *FileCatalog) Put(sku string, item Item) error {
+	return catalog.file.change(func() error {
+		if err := catalog.MemoryCatalog.Put(sku, item); err != nil {
+			return err
+		}
+		return catalog.save()
+	})
+}
+
+func (catalog *FileCatalog) Remove(sku string) error {
+	return catalog.file.change(func() error {
+		if err := catalog.MemoryCatalog.Remove(sku); err != nil {
+			return err
+		}
+		return catalog.save()
+	})
+}
+
+// Save writes the catalog to a temporary file and renames it over the
+// original so a failed write never leaves a truncated catalog behind.
+func (catalog *FileCatalog) Save() error {
+	return catalog.file.change(catalog.save)
+}
+
+func (catalog *FileCatalog) save() error {
+	entries := catalog.entries()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SKU < entries[j].SKU })
+	return catalog.file.write(entries)
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// FileCrewRoster keeps the crew, their certifications and their leave in a
+// JSON file. The time crew spend on rentals is not saved with them: the
+// calendar holds it again from its bookings when it is read.
+type FileCrewRoster struct {
+	*CrewRoster
+	file *sharedFile
+}
+
+type memberRecord struct {
+	ID string `json:"id"`
+	Name string `json:"name"`
+	Certifications []Certification `json:"certifications"`
+	Unavailable []leaveRecord `json:"unavailable,omitempty"`
+}
+
+type leaveRecord struct {
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+}
+
+// OpenFileCrewRoster reads the roster at path, starting with no crew if the
+// file does not exist yet.
+func OpenFileCrewRoster(path string) (*FileCrewRoster, error) {
+	roster := &FileCrewRoster{ CrewRoster: NewCrewRoster() }
+	roster.file = newSharedFile(path, roster.load)
+	roster.keep, roster.acquire = roster.save, roster.file.hold
+	unlock, err := roster.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return roster, nil
+}
+
+// load replaces the crew with those in data, keeping the rentals they are
+// held for in this process. The roster must be locked.
+func (roster *FileCrewRoster) load(data []byte) error {
+	records := []memberRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	loaded := map[string]*CrewMember{}
+	for _, record := range records {
+		member := &CrewMember{ ID: record.ID, Name: record.Name, Certifications: record.Certifications }
+		for _, leave := range record.Unavailable {
+			member.busy = append(member.busy, period{ leave.Start, leave.End, "" })
+		}
+		if previous, found := roster.members[record.ID]; found {
+			for _, p := range previous.busy {
+				if p.booking != "" {
+					member.busy = append(member.busy, p)
+				}
+			}
+		}
+		loaded[record.ID] = member
+	}
+	roster.members = loaded
+	return nil
+}
+
+// save writes the file. The roster must be locked.
+func (roster *FileCrewRoster) save() error {
+	records := []memberRecord{}
+	for _, member := range roster.members {
+		record := memberRecord{ ID: member.ID, Name: member.Name, Certifications: member.Certifications }
+		for _, p := range member.busy {
+			if p.booking == "" {
+				record.Unavailable = append(record.Unavailable, leaveRecord{ p.start, p.end })
+			}
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return roster.file.write(records)
+}
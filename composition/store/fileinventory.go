@@ -0,0 +1,92 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// FileInventory keeps stock levels in memory and rewrites a JSON file each
+// time stock is received, reserved, written off or sold. Reservations are
+// saved with the stock, so a process writing stock off sees what checkouts
+// in other processes are holding.
+type FileInventory struct {
+	*Inventory
+	file *sharedFile
+}
+
+type inventoryFile struct {
+	Levels []storedLevel
+	Reservations []*Reservation
+	NextReservation int
+	Alternates map[string]string
+	Thresholds map[string]int
+}
+
+type storedLevel struct {
+	SKU, Location string
+	OnHand int
+}
+
+// OpenFileInventory reads the stock at path, starting with none if the file
+// does not exist yet. listener is told of low stock as NewInventory's is.
+func OpenFileInventory(path string, listener LowStockListener) (*FileInventory, error) {
+	inventory := &FileInventory{ Inventory: NewInventory(listener) }
+	inventory.file = newSharedFile(path, inventory.load)
+	inventory.keep, inventory.acquire = inventory.save, inventory.file.hold
+	if err := inventory.lock(); err != nil {
+		return nil, err
+	}
+	inventory.unlock()
+	return inventory, nil
+}
+
+// load replaces the stock and reservations with those in data. The
+// inventory must be locked.
+func (inventory *FileInventory) load(data []byte) error {
+	file := inventoryFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	inventory.levels = map[stockKey]*StockLevel{}
+	for _, stored := range file.Levels {
+		inventory.level(stored.SKU, stored.Location).OnHand = stored.OnHand
+	}
+	inventory.reservations = map[string]*Reservation{}
+	for _, reservation := range file.Reservations {
+		inventory.reservations[reservation.ID] = reservation
+		for _, line := range reservation.Lines {
+			inventory.level(line.SKU, line.Location).Reserved += line.Quantity
+		}
+	}
+	inventory.nextReservation = file.NextReservation
+	inventory.alternates, inventory.thresholds = file.Alternates, file.Thresholds
+	if inventory.alternates == nil {
+		inventory.alternates = map[string]string{}
+	}
+	if inventory.thresholds == nil {
+		inventory.thresholds = map[string]int{}
+	}
+	return nil
+}
+
+// save writes the file. The inventory must be locked.
+func (inventory *FileInventory) save() error {
+	file := inventoryFile{ make([]storedLevel, 0, len(inventory.levels)), make([]*Reservation, 0, len(inventory.reservations)),
+		inventory.nextReservation, inventory.alternates, inventory.thresholds }
+	for _, level := range inventory.levels {
+		if level.OnHand != 0 {
+			file.Levels = append(file.Levels, storedLevel{ level.SKU, level.Location, level.OnHand })
+		}
+	}
+	for _, reservation := range inventory.reservations {
+		file.Reservations = append(file.Reservations, reservation)
+	}
+	sort.Slice(file.Reservations, func(i, j int) bool { return file.Reservations[i].ID < file.Reservations[j].ID })
+	sort.Slice(file.Levels, func(i, j int) bool {
+		if file.Levels[i].SKU != file.Levels[j].SKU {
+			return file.Levels[i].SKU < file.Levels[j].SKU
+		}
+		return file.Levels[i].Location < file.Levels[j].Location
+	})
+	return inventory.file.write(file)
+}
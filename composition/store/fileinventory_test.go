@@ -0,0 +1,56 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileInventorySharesReservations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	shop, err := OpenFileInventory(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warehouse, err := OpenFileInventory(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shop.Receive("KAY-1", "Shop", 5); err != nil {
+		t.Fatal(err)
+	}
+	reservation, err := shop.Reserve(map[string]int{ "KAY-1": 3 }, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := warehouse.Receive("KAY-1", "Shop", -3); err == nil {
+		t.Fatal("wrote off stock another process had reserved")
+	}
+	if err := warehouse.Receive("KAY-1", "Shop", -2); err != nil {
+		t.Fatal(err)
+	}
+	if err := shop.Commit(reservation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := warehouse.Receive("KAY-1", "Shop", 1); err != nil {
+		t.Fatal(err)
+	}
+	if level := warehouse.Level("KAY-1", "Shop"); level.OnHand != 1 || level.Reserved != 0 {
+		t.Errorf("level %+v, want 1 on hand and none reserved", level)
+	}
+
+	other, err := warehouse.Reserve(map[string]int{ "KAY-1": 1 }, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == reservation.ID {
+		t.Errorf("both processes issued reservation %v", other.ID)
+	}
+	if err := shop.Release(other.ID); err != nil {
+		t.Errorf("release of a reservation taken elsewhere: %v", err)
+	}
+	if err := warehouse.Receive("KAY-1", "Shop", -1); err != nil {
+		t.Errorf("released stock is still held: %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package store
+
+// lockFile does nothing where advisory locks are not available; only one
+// process should then change the data at a time.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// FilePurchasing keeps suppliers, their offers, reorder policies and purchase
+// orders in a JSON file. Whether an order is being received is not saved: a
+// process that finds the order cancelled or received by another when it has
+// booked the goods takes them out again.
+type FilePurchasing struct {
+	*Purchasing
+	file *sharedFile
+}
+
+type purchasingFile struct {
+	Suppliers []Supplier `json:"suppliers"`
+	Offers []SupplierOffer `json:"offers"`
+	Policies map[string]ReorderPolicy `json:"policies"`
+	Orders []*PurchaseOrder `json:"orders"`
+}
+
+// OpenFilePurchasing reads the purchasing data at path, starting with none if
+// the file does not exist yet.
+func OpenFilePurchasing(path string) (*FilePurchasing, error) {
+	purchasing := &FilePurchasing{ Purchasing: NewPurchasing() }
+	purchasing.file = newSharedFile(path, purchasing.load)
+	purchasing.keep, purchasing.acquire = purchasing.save, purchasing.file.hold
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return purchasing, nil
+}
+
+// load replaces the data with that in data, keeping note of the orders this
+// process is receiving. Purchasing must be locked.
+func (purchasing *FilePurchasing) load(data []byte) error {
+	file := purchasingFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	purchasing.suppliers = map[string]Supplier{}
+	for _, supplier := range file.Suppliers {
+		purchasing.suppliers[supplier.ID] = supplier
+	}
+	purchasing.offers, purchasing.policies = file.Offers, file.Policies
+	if purchasing.policies == nil {
+		purchasing.policies = map[string]ReorderPolicy{}
+	}
+	for _, order := range file.Orders {
+		if previous := purchasing.order(order.Number); previous != nil {
+			order.receiving = previous.receiving
+		}
+	}
+	purchasing.orders = file.Orders
+	purchasing.renumber()
+	return nil
+}
+
+// save writes the file. Purchasing must be locked.
+func (purchasing *FilePurchasing) save() error {
+	file := purchasingFile{ make([]Supplier, 0, len(purchasing.suppliers)), purchasing.offers,
+		purchasing.policies, purchasing.orders }
+	for _, supplier := range purchasing.suppliers {
+		file.Suppliers = append(file.Suppliers, supplier)
+	}
+	sort.Slice(file.Suppliers, func(i, j int) bool { return file.Suppliers[i].ID < file.Suppliers[j].ID })
+	return purchasing.file.write(file)
+}
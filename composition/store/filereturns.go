@@ -0,0 +1,59 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// FileReturnDesk keeps returns in a JSON file, so they and their numbers
+// last across restarts and every process using the file sees the same ones.
+// The orders of returns made elsewhere are looked up in Orders.
+type FileReturnDesk struct {
+	*ReturnDesk
+	file *sharedFile
+}
+
+type returnsFile struct {
+	Last int `json:"last"`
+	Returns []*Return `json:"returns"`
+}
+
+// OpenFileReturnDesk reads the returns at path, starting with none if the
+// file does not exist yet.
+func OpenFileReturnDesk(path string, catalog Catalog, orders Orders) (*FileReturnDesk, error) {
+	desk := &FileReturnDesk{ ReturnDesk: NewReturnDesk(catalog) }
+	desk.Orders = orders
+	desk.file = newSharedFile(path, desk.load)
+	desk.keep, desk.acquire = desk.save, desk.file.hold
+	unlock, err := desk.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return desk, nil
+}
+
+// load replaces the returns with those in data. The orders already found
+// for them are kept. The desk must be locked.
+func (desk *FileReturnDesk) load(data []byte) error {
+	file := returnsFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	desk.returns = map[string]*Return{}
+	for _, r := range file.Returns {
+		desk.returns[r.ID] = r
+	}
+	desk.last = file.Last
+	return nil
+}
+
+// save writes the file. The desk must be locked.
+func (desk *FileReturnDesk) save() error {
+	file := returnsFile{ desk.last, make([]*Return, 0, len(desk.returns)) }
+	for _, r := range desk.returns {
+		file.Returns = append(file.Returns, r)
+	}
+	sort.Slice(file.Returns, func(i, j int) bool { return file.Returns[i].ID < file.Returns[j].ID })
+	return desk.file.write(file)
+}
@@ -0,0 +1,643 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GiftCard is a prepaid balance spent at checkout. A card issued to a
+// customer, usually with no expiry, is store credit.
+type GiftCard struct {
+	Code string `json:"code"`
+	Initial Money `json:"initial"`
+	Balance Money `json:"balance"`
+	Issued time.Time `json:"issued"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Customer string `json:"customer,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+// Expired reports whether the card can no longer be spent at time at.
+func (card GiftCard) Expired(at time.Time) bool {
+	return card.Expires != nil && !at.Before(*card.Expires)
+}
+
+type GiftCardMovement string
+
+const (
+	GiftCardIssued GiftCardMovement = "issued"
+	GiftCardRedeemed GiftCardMovement = "redeemed"
+	GiftCardExpired GiftCardMovement = "expired"
+	GiftCardRefunded GiftCardMovement = "refunded"
+)
+
+// GiftCardPosting is one side of a movement in the gift card ledger. Every
+// movement posts to the card's own account, named by its code, and the
+// opposite amount to the account named after the movement, so the ledger
+// always adds up to zero. A card's postings add up to its balance.
+type GiftCardPosting struct {
+	Entry int `json:"entry"`
+	Time time.Time `json:"time"`
+	Movement GiftCardMovement `json:"movement"`
+	Account string `json:"account"`
+	Amount Money `json:"amount"`
+	Order string `json:"order,omitempty"`
+}
+
+// GiftCardReconciliation totals the ledger in one currency. What was issued
+// or refunded has been redeemed, has expired or is outstanding on cards, so
+// Difference, the sum of every posting, is zero and Mismatched, the cards
+// whose balance disagrees with their postings, is empty unless the ledger is
+// damaged.
+type GiftCardReconciliation struct {
+	Currency string `json:"currency"`
+	Issued Money `json:"issued"`
+	Refunded Money `json:"refunded"`
+	Redeemed Money `json:"redeemed"`
+	Expired Money `json:"expired"`
+	Outstanding Money `json:"outstanding"`
+	Difference Money `json:"difference"`
+	Mismatched []string `json:"mismatched"`
+}
+
+func (r GiftCardReconciliation) Balanced() bool {
+	return r.Difference.IsZero() && len(r.Mismatched) == 0
+}
+
+// codeAlphabet leaves out letters and digits that are easily mistaken for
+// each other. It has 32 symbols, so a random byte picks one without bias.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newGiftCardCode() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("store: cannot make a gift card code: %w", err)
+	}
+	var code strings.Builder
+	for i, b := range data {
+		if i > 0 && i % 4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(codeAlphabet[int(b) % len(codeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// NormalizeGiftCardCode accepts a code typed in lower case or without its
+// dashes.
+func NormalizeGiftCardCode(code string) string {
+	plain := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(plain) != 16 {
+		return plain
+	}
+	return plain[0:4] + "-" + plain[4:8] + "-" + plain[8:12] + "-" + plain[12:16]
+}
+
+// MaskGiftCardCode hides all but the last four symbols of a code, enough to
+// tell cards apart in a list without making them spendable.
+func MaskGiftCardCode(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' {
+			return r
+		}
+		return '*'
+	}, code[:len(code) - 4]) + code[len(code) - 4:]
+}
+
+// giftCardHold is an amount set aside on a card for a checkout under way. It
+// lapses at Expires if the checkout never finishes.
+type giftCardHold struct {
+	Code string `json:"code"`
+	Amount Money `json:"amount"`
+	Expires time.Time `json:"expires"`
+}
+
+// GiftCards issues gift cards and keeps their ledger. A checkout holds what
+// it will take from a card before placing the order, so two checkouts can
+// never spend the same balance. Holds are kept with the cards, so checkouts
+// in other processes see them too, and lapse after HoldTTL.
+type GiftCards struct {
+	mutex sync.Mutex
+	cards map[string]*GiftCard
+	ledger []GiftCardPosting
+	holds []giftCardHold
+	entries int
+	HoldTTL time.Duration
+	Now func() time.Time
+	keep func() error
+	acquire func() (func(), error)
+}
+
+func NewGiftCards() *GiftCards {
+	return &GiftCards{ cards: map[string]*GiftCard{}, ledger: []GiftCardPosting{}, HoldTTL: 15 * time.Minute,
+		Now: time.Now, keep: func() error { return nil }, acquire: func() (func(), error) { return func() {}, nil } }
+}
+
+// lock locks the cards for a change or a checkout. For cards kept in a file
+// it also locks the file and reads back what another process saved there.
+func (cards *GiftCards) lock() (func(), error) {
+	cards.mutex.Lock()
+	release, err := cards.acquire()
+	if err != nil {
+		cards.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		cards.mutex.Unlock()
+	}, nil
+}
+
+// view locks the cards to read them, first reading back the file if another
+// process has changed it. If the file cannot be read, what was last read is
+// used.
+func (cards *GiftCards) view() func() {
+	if unlock, err := cards.lock(); err == nil {
+		return unlock
+	}
+	cards.mutex.Lock()
+	return cards.mutex.Unlock
+}
+
+// post records a movement of amount onto card. The cards must be locked.
+func (cards *GiftCards) post(card *GiftCard, movement GiftCardMovement, amount Money, order string, at time.Time) {
+	cards.entries++
+	cards.ledger = append(cards.ledger,
+		GiftCardPosting{ cards.entries, at, movement, card.Code, amount, order },
+		GiftCardPosting{ cards.entries, at, movement, string(movement), amount.Neg(), order })
+	card.Balance = card.Balance.Add(amount)
+}
+
+// commit keeps the cards. If they cannot be kept, undo puts the cards back
+// and the ledger goes back to its first postings. The cards must be locked.
+func (cards *GiftCards) commit(postings int, undo func()) error {
+	if err := cards.keep(); err != nil {
+		undo()
+		cards.ledger, cards.entries = cards.ledger[:postings], 0
+		if postings > 0 {
+			cards.entries = cards.ledger[postings - 1].Entry
+		}
+		return err
+	}
+	return nil
+}
+
+// Issue makes a gift card worth amount. A nil expires gives a card that
+// never expires.
+func (cards *GiftCards) Issue(amount Money, expires *time.Time, note string) (GiftCard, error) {
+	return cards.issue(amount, expires, "", note)
+}
+
+// IssueCredit gives a customer store credit worth amount, on a card that
+// does not expire.
+func (cards *GiftCards) IssueCredit(customer string, amount Money, note string) (GiftCard, error) {
+	if customer == "" {
+		return GiftCard{}, fmt.Errorf("store: store credit needs a customer")
+	}
+	return cards.issue(amount, nil, customer, note)
+}
+
+func (cards *GiftCards) issue(amount Money, expires *time.Time, customer, note string) (GiftCard, error) {
+	if amount.minor <= 0 || amount.currency == "" {
+		return GiftCard{}, fmt.Errorf("store: a gift card must be worth more than nothing")
+	}
+	unlock, err := cards.lock()
+	if err != nil {
+		return GiftCard{}, err
+	}
+	defer unlock()
+	now := cards.Now()
+	if expires != nil && !expires.After(now) {
+		return GiftCard{}, fmt.Errorf("store: a gift card cannot expire before it is issued")
+	}
+	code := ""
+	for code == "" || cards.cards[code] != nil {
+		if code, err = newGiftCardCode(); err != nil {
+			return GiftCard{}, err
+		}
+	}
+	card := &GiftCard{ Code: code, Initial: amount, Balance: Money{ 0, amount.currency }, Issued: now,
+		Expires: expires, Customer: customer, Note: note }
+	cards.cards[code] = card
+	postings := len(cards.ledger)
+	cards.post(card, GiftCardIssued, amount, "", now)
+	if err := cards.commit(postings, func() { delete(cards.cards, code) }); err != nil {
+		return GiftCard{}, err
+	}
+	return *card, nil
+}
+
+// Get returns a card with its balance.
+func (cards *GiftCards) Get(code string) (GiftCard, bool) {
+	defer cards.view()()
+	if card, found := cards.cards[NormalizeGiftCardCode(code)]; found {
+		return *card, true
+	}
+	return GiftCard{}, false
+}
+
+// List returns the cards in the order they were issued.
+func (cards *GiftCards) List() []GiftCard {
+	defer cards.view()()
+	list := make([]GiftCard, 0, len(cards.cards))
+	for _, card := range cards.cards {
+		list = append(list, *card)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Issued.Equal(list[j].Issued) {
+			return list[i].Issued.Before(list[j].Issued)
+		}
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
+
+// Movements lists the postings to one card, or the whole ledger when code is
+// empty.
+func (cards *GiftCards) Movements(code string) []GiftCardPosting {
+	defer cards.view()()
+	code = NormalizeGiftCardCode(code)
+	postings := []GiftCardPosting{}
+	for _, posting := range cards.ledger {
+		if code == "" || posting.Account == code {
+			postings = append(postings, posting)
+		}
+	}
+	return postings
+}
+
+// available is what can still be taken from a card. The cards must be
+// locked.
+func (cards *GiftCards) available(code string, at time.Time) (*GiftCard, Money, error) {
+	card, found := cards.cards[code]
+	if !found {
+		return nil, Money{}, fmt.Errorf("store: no gift card %v", code)
+	}
+	if card.Expired(at) {
+		return nil, Money{}, fmt.Errorf("store: gift card %v expired on %v", code, card.Expires.Format("2006-01-02"))
+	}
+	return card, card.Balance.Sub(cards.held(code, at)), nil
+}
+
+// held is what checkouts have set aside on a card and not yet let go of at
+// time at. The cards must be locked.
+func (cards *GiftCards) held(code string, at time.Time) Money {
+	held := Money{ 0, cards.cards[code].Balance.currency }
+	for _, hold := range cards.holds {
+		if hold.Code == code && at.Before(hold.Expires) {
+			held = held.Add(hold.Amount)
+		}
+	}
+	return held
+}
+
+// Apply pays for cart from a gift card, up to amount or, when amount is
+// zero, as much as the card has.
+func (cards *GiftCards) Apply(cart *Cart, code string, amount Money) error {
+	unlock, err := cards.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	card, available, err := cards.available(NormalizeGiftCardCode(code), cards.Now())
+	if err != nil {
+		return err
+	}
+	if card.Balance.currency != cart.Currency() {
+		return fmt.Errorf("store: gift card %v is in %v but the cart is in %v", card.Code, card.Balance.currency,
+			cart.Currency())
+	}
+	if amount.IsZero() {
+		amount = available
+	}
+	if amount.currency != card.Balance.currency || amount.minor <= 0 {
+		return fmt.Errorf("store: cannot take %v from gift card %v", amount, card.Code)
+	}
+	if amount.Cmp(available) > 0 {
+		return fmt.Errorf("store: gift card %v has %v left", card.Code, available)
+	}
+	return cart.PayWithGiftCard(card.Code, amount)
+}
+
+// Check makes sure every card still has what the cart will take from it.
+func (cards *GiftCards) Check(cart *Cart) error {
+	unlock, err := cards.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = cards.check(cart)
+	return err
+}
+
+func (cards *GiftCards) check(cart *Cart) ([]GiftCardPayment, error) {
+	now := cards.Now()
+	payments := cart.Totals(now).GiftCards
+	return payments, cards.checkPayments(payments, now)
+}
+
+func (cards *GiftCards) checkPayments(payments []GiftCardPayment, now time.Time) error {
+	for _, payment := range payments {
+		_, available, err := cards.available(payment.Code, now)
+		if err != nil {
+			return err
+		}
+		if payment.Amount.Cmp(available) > 0 {
+			return fmt.Errorf("store: gift card %v has %v left", payment.Code, available)
+		}
+	}
+	return nil
+}
+
+// Hold sets aside the payments in totals, which the order placed with those
+// totals will record. What every checkout holds, in this process or another,
+// is checked against the balances under the same lock.
+func (cards *GiftCards) Hold(cart *Cart, totals CartTotals) (func(), error) {
+	unlock, err := cards.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	payments := append([]GiftCardPayment(nil), totals.GiftCards...)
+	now := cards.Now()
+	if err := cards.checkPayments(payments, now); err != nil {
+		return nil, err
+	}
+	holds := cards.holds
+	cards.hold(payments, now)
+	if err := cards.keep(); err != nil {
+		cards.holds = holds
+		return nil, err
+	}
+	return func() {
+		unlock, err := cards.lock()
+		if err != nil {
+			return
+		}
+		defer unlock()
+		cards.release(payments)
+		cards.keep()
+	}, nil
+}
+
+// hold sets payments aside until HoldTTL from now, dropping holds that have
+// lapsed. The cards must be locked.
+func (cards *GiftCards) hold(payments []GiftCardPayment, now time.Time) {
+	holds := []giftCardHold{}
+	for _, hold := range cards.holds {
+		if now.Before(hold.Expires) {
+			holds = append(holds, hold)
+		}
+	}
+	for _, payment := range payments {
+		holds = append(holds, giftCardHold{ payment.Code, payment.Amount, now.Add(cards.HoldTTL) })
+	}
+	cards.holds = holds
+}
+
+// release lets go of a hold for each payment. Holds for the same amount on a
+// card are alike, so whichever is found first goes. The cards must be locked.
+func (cards *GiftCards) release(payments []GiftCardPayment) {
+	holds := append([]giftCardHold(nil), cards.holds...)
+	for _, payment := range payments {
+		for i, hold := range holds {
+			if hold.Code == payment.Code && hold.Amount == payment.Amount {
+				holds = append(holds[:i], holds[i + 1:]...)
+				break
+			}
+		}
+	}
+	cards.holds = holds
+}
+
+// Record redeems the payments an order made by gift card from what was held
+// for it. The hold is let go first, so it is not left behind if the cards
+// cannot pay.
+func (cards *GiftCards) Record(order *Order) error {
+	unlock, err := cards.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	payments := order.totals.GiftCards
+	cards.release(payments)
+	for _, payment := range payments {
+		if card := cards.cards[payment.Code]; card == nil || payment.Amount.Cmp(card.Balance) > 0 {
+			cards.keep()
+			return fmt.Errorf("store: gift card %v cannot pay %v for order %v", payment.Code, payment.Amount, order.number)
+		}
+	}
+	postings := len(cards.ledger)
+	for _, payment := range payments {
+		cards.post(cards.cards[payment.Code], GiftCardRedeemed, payment.Amount.Neg(), order.number, order.placed)
+	}
+	return cards.commit(postings, func() {
+		for _, payment := range payments {
+			card := cards.cards[payment.Code]
+			card.Balance = card.Balance.Add(payment.Amount)
+		}
+	})
+}
+
+// Refund puts back on gift cards what a return gives back to them. A card
+// that has expired since is written off again by Expire, as its balance
+// would have been. Each return is refunded once, however often it is passed.
+func (cards *GiftCards) Refund(r Return) error {
+	if len(r.GiftCards) == 0 {
+		return nil
+	}
+	unlock, err := cards.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, posting := range cards.ledger {
+		if posting.Movement == GiftCardRefunded && posting.Order == r.ID {
+			return nil
+		}
+	}
+	for _, payment := range r.GiftCards {
+		if card := cards.cards[payment.Code]; card == nil || payment.Amount.currency != card.Balance.currency ||
+				payment.Amount.minor <= 0 {
+			return fmt.Errorf("store: cannot refund %v to gift card %v", payment.Amount, payment.Code)
+		}
+	}
+	postings, now := len(cards.ledger), cards.Now()
+	for _, payment := range r.GiftCards {
+		cards.post(cards.cards[payment.Code], GiftCardRefunded, payment.Amount, r.ID, now)
+	}
+	return cards.commit(postings, func() {
+		for _, payment := range r.GiftCards {
+			card := cards.cards[payment.Code]
+			card.Balance = card.Balance.Sub(payment.Amount)
+		}
+	})
+}
+
+// Expire takes the balance off cards whose time is up and returns the
+// postings to those cards. It is meant to be called regularly, as by Run.
+// Balances held for a checkout under way are left until it is done.
+func (cards *GiftCards) Expire() ([]GiftCardPosting, error) {
+	unlock, err := cards.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	now := cards.Now()
+	expired := []GiftCardPosting{}
+	changed := []*GiftCard{}
+	postings := len(cards.ledger)
+	for _, card := range cards.cards {
+		if !card.Expired(now) || cards.held(card.Code, now).minor > 0 || card.Balance.minor <= 0 {
+			continue
+		}
+		cards.post(card, GiftCardExpired, card.Balance.Neg(), "", now)
+		expired = append(expired, cards.ledger[len(cards.ledger) - 2])
+		changed = append(changed, card)
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	err = cards.commit(postings, func() {
+		for i, card := range changed {
+			card.Balance = card.Balance.Sub(expired[i].Amount)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Account < expired[j].Account })
+	return expired, nil
+}
+
+// Run calls Expire every interval until stop is closed, passing any error to
+// report.
+func (cards *GiftCards) Run(interval time.Duration, stop <-chan struct{}, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := cards.Expire(); err != nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// Reconcile totals the ledger for each currency in use.
+func (cards *GiftCards) Reconcile() []GiftCardReconciliation {
+	defer cards.view()()
+	byCurrency := map[string]*GiftCardReconciliation{}
+	get := func(currency string) *GiftCardReconciliation {
+		r, found := byCurrency[currency]
+		if !found {
+			zero := Money{ 0, currency }
+			r = &GiftCardReconciliation{ currency, zero, zero, zero, zero, zero, zero, []string{} }
+			byCurrency[currency] = r
+		}
+		return r
+	}
+	posted := map[string]Money{}
+	for _, posting := range cards.ledger {
+		r := get(posting.Amount.currency)
+		r.Difference = r.Difference.Add(posting.Amount)
+		switch GiftCardMovement(posting.Account) {
+		case GiftCardIssued:
+			r.Issued = r.Issued.Sub(posting.Amount)
+		case GiftCardRefunded:
+			r.Refunded = r.Refunded.Sub(posting.Amount)
+		case GiftCardRedeemed:
+			r.Redeemed = r.Redeemed.Add(posting.Amount)
+		case GiftCardExpired:
+			r.Expired = r.Expired.Add(posting.Amount)
+		default:
+			if total, found := posted[posting.Account]; found {
+				posting.Amount = posting.Amount.Add(total)
+			}
+			posted[posting.Account] = posting.Amount
+		}
+	}
+	for code, card := range cards.cards {
+		r := get(card.Balance.currency)
+		r.Outstanding = r.Outstanding.Add(card.Balance)
+		if posted[code] != card.Balance {
+			r.Mismatched = append(r.Mismatched, code)
+		}
+	}
+	list := []GiftCardReconciliation{}
+	for _, r := range byCurrency {
+		sort.Strings(r.Mismatched)
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
+
+type giftCardsFile struct {
+	Cards []GiftCard `json:"cards"`
+	Ledger []GiftCardPosting `json:"ledger"`
+	Holds []giftCardHold `json:"holds,omitempty"`
+}
+
+// FileGiftCards keeps gift cards, their ledger and what checkouts hold on
+// them in memory and rewrites a JSON file after every change.
+type FileGiftCards struct {
+	*GiftCards
+	file *sharedFile
+}
+
+func OpenFileGiftCards(path string) (*FileGiftCards, error) {
+	cards := &FileGiftCards{ GiftCards: NewGiftCards() }
+	cards.file = newSharedFile(path, cards.load)
+	cards.keep, cards.acquire = cards.save, cards.file.hold
+	unlock, err := cards.lock()
+	if err != nil {
+		return nil, err
+	}
+	unlock()
+	return cards, nil
+}
+
+// load replaces the cards, ledger and holds with those in data. The cards
+// must be locked.
+func (cards *FileGiftCards) load(data []byte) error {
+	file := giftCardsFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	loaded := map[string]*GiftCard{}
+	for i := range file.Cards {
+		card := &file.Cards[i]
+		loaded[card.Code] = card
+	}
+	entries := 0
+	for _, posting := range file.Ledger {
+		if posting.Entry > entries {
+			entries = posting.Entry
+		}
+	}
+	cards.cards, cards.ledger, cards.entries = loaded, append([]GiftCardPosting{}, file.Ledger...), entries
+	cards.holds = file.Holds
+	return nil
+}
+
+// save writes the file. The cards must be locked.
+func (cards *FileGiftCards) save() error {
+	file := giftCardsFile{ make([]GiftCard, 0, len(cards.cards)), cards.ledger, cards.holds }
+	for _, card := range cards.cards {
+		file.Cards = append(file.Cards, *card)
+	}
+	sort.Slice(file.Cards, func(i, j int) bool { return file.Cards[i].Code < file.Cards[j].Code })
+	return cards.file.write(file)
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// payments is cart totals that take amount from one gift card.
+func payments(code, amount string) CartTotals {
+	return CartTotals{ GiftCards: []GiftCardPayment{ { code, MustParseMoney(amount) } } }
+}
+
+func TestGiftCardHoldRecordExpire(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cards := NewGiftCards()
+	cards.Now = func() time.Time { return now }
+	cards.HoldTTL = 2 * Day
+	expires := now.Add(Day)
+	card, err := cards.Issue(MustParseMoney("$100"), &expires, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var release func()
+	tests := []struct {
+		name string
+		step func() error
+		fails bool
+		balance, available string
+	}{
+		{ "hold", func() (err error) { release, err = cards.Hold(nil, payments(card.Code, "$30")); return }, false,
+			"$100.00", "$70.00" },
+		{ "hold more than is left", func() error { _, err := cards.Hold(nil, payments(card.Code, "$80")); return err }, true,
+			"$100.00", "$70.00" },
+		{ "record", func() error {
+			return cards.Record(&Order{ number: "ORD-1", placed: now, totals: payments(card.Code, "$30") })
+		}, false, "$70.00", "$70.00" },
+		{ "hold the rest", func() (err error) { release, err = cards.Hold(nil, payments(card.Code, "$70")); return }, false,
+			"$70.00", "$0.00" },
+		{ "expire while held", func() error {
+			now = expires
+			_, err := cards.Expire()
+			return err
+		}, false, "$70.00", "$0.00" },
+		{ "expire once the hold lapses", func() error {
+			now = now.Add(cards.HoldTTL)
+			_, err := cards.Expire()
+			return err
+		}, false, "$0.00", "$0.00" },
+		{ "release after expiry", func() error { release(); return nil }, false, "$0.00", "$0.00" },
+	}
+	for _, test := range tests {
+		if err := test.step(); (err != nil) != test.fails {
+			t.Fatalf("%v: error %v, want failure %v", test.name, err, test.fails)
+		}
+		cards.mutex.Lock()
+		balance := cards.cards[card.Code].Balance
+		available := balance.Sub(cards.held(card.Code, now))
+		cards.mutex.Unlock()
+		if balance.String() != test.balance || available.String() != test.available {
+			t.Errorf("%v: balance %v with %v available, want %v with %v", test.name, balance, available,
+				test.balance, test.available)
+		}
+		for _, r := range cards.Reconcile() {
+			if !r.Balanced() {
+				t.Errorf("%v: ledger out by %v", test.name, r.Difference)
+			}
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		damage func(cards *GiftCards, code string)
+		difference string
+		mismatched bool
+	}{
+		{ "clean", func(*GiftCards, string) {}, "$0.00", false },
+		{ "balance changed", func(cards *GiftCards, code string) {
+			cards.cards[code].Balance = MustParseMoney("$1")
+		}, "$0.00", true },
+		{ "posting lost", func(cards *GiftCards, code string) {
+			cards.ledger = cards.ledger[:len(cards.ledger) - 1]
+		}, "-$20.00", false },
+	}
+	for _, test := range tests {
+		cards := NewGiftCards()
+		card, err := cards.Issue(MustParseMoney("$50"), nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cards.Record(&Order{ number: "ORD-1", totals: payments(card.Code, "$20") }); err != nil {
+			t.Fatal(err)
+		}
+		test.damage(cards, card.Code)
+		report := cards.Reconcile()
+		if len(report) != 1 {
+			t.Fatalf("%v: report for %v currencies", test.name, len(report))
+		}
+		r := report[0]
+		if r.Issued.String() != "$50.00" {
+			t.Errorf("%v: issued %v, want $50.00", test.name, r.Issued)
+		}
+		if r.Difference.String() != test.difference || (len(r.Mismatched) > 0) != test.mismatched {
+			t.Errorf("%v: out by %v with %v mismatched, want %v", test.name, r.Difference, r.Mismatched, test.difference)
+		}
+		if r.Balanced() != (test.name == "clean") {
+			t.Errorf("%v: balanced %v", test.name, r.Balanced())
+		}
+	}
+}
+
+func TestHoldsSharedBetweenProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "giftcards.json")
+	shop, err := OpenFileGiftCards(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := OpenFileGiftCards(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := shop.Issue(MustParseMoney("$50"), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := shop.Hold(nil, payments(card.Code, "$40"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := office.Hold(nil, payments(card.Code, "$40")); err == nil {
+		t.Fatal("another process held a balance already held")
+	}
+	release()
+	if _, err := office.Hold(nil, payments(card.Code, "$40")); err != nil {
+		t.Errorf("balance still held after release: %v", err)
+	}
+}
+
+func TestConcurrentApplyAndHold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "giftcards.json")
+	processes := [2]*FileGiftCards{}
+	for i := range processes {
+		cards, err := OpenFileGiftCards(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		processes[i] = cards
+	}
+	card, err := processes[0].Issue(MustParseMoney("$100"), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := NewMemoryCatalog()
+	catalog.Put("KAY-1", NewProduct("Kayak", "Watersports", MustParseMoney("$279")))
+	taxes, err := NewRuleTaxPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wait sync.WaitGroup
+	held := make(chan bool, 10)
+	for i := 0; i < cap(held); i++ {
+		wait.Add(1)
+		go func(cards *FileGiftCards) {
+			defer wait.Done()
+			cart := NewCart(catalog, taxes, Location{ Country: "UK" })
+			if err := cart.Add("KAY-1", 1); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := cards.Apply(cart, card.Code, MustParseMoney("$30")); err != nil {
+				held <- false
+				return
+			}
+			_, err := cards.Hold(cart, cart.Totals(time.Now()))
+			held <- err == nil
+		}(processes[i % 2])
+	}
+	wait.Wait()
+	close(held)
+	count := 0
+	for ok := range held {
+		if ok {
+			count++
+		}
+	}
+	if count != 3 {
+		t.Errorf("%v checkouts held $30 of a $100 card, want 3", count)
+	}
+}
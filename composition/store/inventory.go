@@ -0,0 +1,457 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type StockLevel struct {
+	SKU, Location string
+	OnHand, Reserved int
+}
+
+func (level StockLevel) Available() int {
+	return level.OnHand - level.Reserved
+}
+
+type ReservedLine struct {
+	SKU, Location string
+	Quantity int
+}
+
+// Reservation holds stock for a checkout until it is committed, released or
+// expires.
+type Reservation struct {
+	ID string
+	Lines []ReservedLine
+	Expires time.Time
+}
+
+// LowStockListener is told when the stock available for a SKU, across all
+// locations, falls to or below its threshold.
+type LowStockListener interface {
+	LowStock(sku string, available, threshold int)
+}
+
+type LowStockFunc func(sku string, available, threshold int)
+
+func (f LowStockFunc) LowStock(sku string, available, threshold int) {
+	f(sku, available, threshold)
+}
+
+type stockKey struct {
+	sku, location string
+}
+
+type lowStockAlert struct {
+	sku string
+	available, threshold int
+}
+
+type Inventory struct {
+	mutex sync.Mutex
+	levels map[stockKey]*StockLevel
+	reservations map[string]*Reservation
+	nextReservation int
+	alternates map[string]string
+	thresholds map[string]int
+	alerted map[string]bool
+	pending []lowStockAlert
+	listener LowStockListener
+	Now func() time.Time
+	keep func() error
+	acquire func() (func(), error)
+	release func()
+}
+
+func NewInventory(listener LowStockListener) *Inventory {
+	return &Inventory{
+		levels: map[stockKey]*StockLevel{},
+		reservations: map[string]*Reservation{},
+		alternates: map[string]string{},
+		thresholds: map[string]int{},
+		alerted: map[string]bool{},
+		listener: listener,
+		Now: time.Now,
+		keep: func() error { return nil },
+		acquire: func() (func(), error) { return func() {}, nil },
+	}
+}
+
+// lock locks the inventory for a change. For an inventory kept in a file it
+// also locks the file and reads back what another process saved there, until
+// unlock is called.
+func (inventory *Inventory) lock() error {
+	inventory.mutex.Lock()
+	release, err := inventory.acquire()
+	if err != nil {
+		inventory.mutex.Unlock()
+		return err
+	}
+	inventory.release = release
+	return nil
+}
+
+// view locks the inventory to read it, first reading back the file if another
+// process has changed it. If the file cannot be read, what was last read is
+// used. unlock ends it.
+func (inventory *Inventory) view() {
+	if err := inventory.lock(); err != nil {
+		inventory.mutex.Lock()
+	}
+}
+
+func (inventory *Inventory) level(sku, location string) *StockLevel {
+	key := stockKey{ sku, location }
+	level, found := inventory.levels[key]
+	if !found {
+		level = &StockLevel{ SKU: sku, Location: location }
+		inventory.levels[key] = level
+	}
+	return level
+}
+
+// Receive adds stock at a location; a negative quantity writes stock off.
+func (inventory *Inventory) Receive(sku, location string, quantity int) error {
+	if err := inventory.lock(); err != nil {
+		return err
+	}
+	defer inventory.unlock()
+	inventory.expire()
+	level := inventory.level(sku, location)
+	if level.OnHand + quantity < level.Reserved {
+		return fmt.Errorf("store: cannot remove %v of %v at %v, %v are reserved",
+			-quantity, sku, location, level.Reserved)
+	}
+	level.OnHand += quantity
+	if err := inventory.keep(); err != nil {
+		level.OnHand -= quantity
+		return err
+	}
+	inventory.checkLow(sku)
+	return nil
+}
+
+// ReceiveVariants books the listed stock of every variant of product in at
+// location, under the variants' own SKUs.
+func (inventory *Inventory) ReceiveVariants(product *VariantProduct, location string) error {
+	for _, variant := range product.variants {
+		if err := inventory.Receive(variant.SKU, location, variant.Stock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetAlternate names the SKU to suggest when sku is out of stock.
+func (inventory *Inventory) SetAlternate(sku, alternate string) error {
+	if err := inventory.lock(); err != nil {
+		return err
+	}
+	defer inventory.unlock()
+	previous, found := inventory.alternates[sku]
+	inventory.alternates[sku] = alternate
+	if err := inventory.keep(); err != nil {
+		if found {
+			inventory.alternates[sku] = previous
+		} else {
+			delete(inventory.alternates, sku)
+		}
+		return err
+	}
+	return nil
+}
+
+func (inventory *Inventory) SetLowStockThreshold(sku string, threshold int) error {
+	if err := inventory.lock(); err != nil {
+		return err
+	}
+	defer inventory.unlock()
+	previous, found := inventory.thresholds[sku]
+	inventory.thresholds[sku] = threshold
+	if err := inventory.keep(); err != nil {
+		if found {
+			inventory.thresholds[sku] = previous
+		} else {
+			delete(inventory.thresholds, sku)
+		}
+		return err
+	}
+	inventory.alerted[sku] = false
+	inventory.checkLow(sku)
+	return nil
+}
+
+func (inventory *Inventory) Level(sku, location string) StockLevel {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	if level, found := inventory.levels[stockKey{ sku, location }]; found {
+		return *level
+	}
+	return StockLevel{ SKU: sku, Location: location }
+}
+
+// Levels returns the stock of sku at every location, ordered by location.
+func (inventory *Inventory) Levels(sku string) []StockLevel {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	levels := []StockLevel{}
+	for _, level := range inventory.locations(sku) {
+		levels = append(levels, *level)
+	}
+	return levels
+}
+
+// Stock returns every stock level, ordered by SKU and then location.
+func (inventory *Inventory) Stock() []StockLevel {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	levels := make([]StockLevel, 0, len(inventory.levels))
+	for _, level := range inventory.levels {
+		levels = append(levels, *level)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if levels[i].SKU != levels[j].SKU {
+			return levels[i].SKU < levels[j].SKU
+		}
+		return levels[i].Location < levels[j].Location
+	})
+	return levels
+}
+
+func (inventory *Inventory) locations(sku string) []*StockLevel {
+	levels := []*StockLevel{}
+	for key, level := range inventory.levels {
+		if key.sku == sku {
+			levels = append(levels, level)
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Location < levels[j].Location })
+	return levels
+}
+
+func (inventory *Inventory) available(sku string) int {
+	total := 0
+	for _, level := range inventory.locations(sku) {
+		total += level.Available()
+	}
+	return total
+}
+
+// Available reports the unreserved stock of sku across all locations.
+func (inventory *Inventory) Available(sku string) int {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	return inventory.available(sku)
+}
+
+// Suggest returns the alternate for sku if sku cannot fill quantity and the
+// alternate can.
+func (inventory *Inventory) Suggest(sku string, quantity int) (string, bool) {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	return inventory.suggest(sku, quantity)
+}
+
+func (inventory *Inventory) suggest(sku string, quantity int) (string, bool) {
+	alternate, found := inventory.alternates[sku]
+	if !found || inventory.available(sku) >= quantity || inventory.available(alternate) < quantity {
+		return "", false
+	}
+	return alternate, true
+}
+
+// Reserve holds stock for every line or, if any line cannot be filled,
+// for none of them.
+func (inventory *Inventory) Reserve(quantities map[string]int, ttl time.Duration) (*Reservation, error) {
+	if err := inventory.lock(); err != nil {
+		return nil, err
+	}
+	defer inventory.unlock()
+	inventory.expire()
+
+	skus := make([]string, 0, len(quantities))
+	for sku := range quantities {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	for _, sku := range skus {
+		if available := inventory.available(sku); available < quantities[sku] {
+			alternate, _ := inventory.suggest(sku, quantities[sku])
+			return nil, &OutOfStockError{ sku, quantities[sku], available, alternate }
+		}
+	}
+
+	inventory.nextReservation++
+	reservation := &Reservation{
+		ID: fmt.Sprintf("RES-%06d", inventory.nextReservation),
+		Expires: inventory.Now().Add(ttl),
+	}
+	for _, sku := range skus {
+		needed := quantities[sku]
+		for _, level := range inventory.locations(sku) {
+			if needed == 0 {
+				break
+			}
+			take := level.Available()
+			if take > needed {
+				take = needed
+			}
+			if take <= 0 {
+				continue
+			}
+			level.Reserved += take
+			needed -= take
+			reservation.Lines = append(reservation.Lines, ReservedLine{ sku, level.Location, take })
+		}
+		inventory.checkLow(sku)
+	}
+	inventory.reservations[reservation.ID] = reservation
+	if err := inventory.keep(); err != nil {
+		delete(inventory.reservations, reservation.ID)
+		for _, line := range reservation.Lines {
+			inventory.levels[stockKey{ line.SKU, line.Location }].Reserved -= line.Quantity
+		}
+		for _, sku := range skus {
+			inventory.checkLow(sku)
+		}
+		return nil, err
+	}
+	copied := *reservation
+	copied.Lines = append([]ReservedLine{}, reservation.Lines...)
+	return &copied, nil
+}
+
+// ReserveCart reserves the quantities in a cart. Bundles are reserved as
+// their components.
+func (inventory *Inventory) ReserveCart(cart *Cart, ttl time.Duration) (*Reservation, error) {
+	return inventory.Reserve(cart.stockQuantities(), ttl)
+}
+
+func (inventory *Inventory) take(id string) (*Reservation, error) {
+	inventory.expire()
+	reservation, found := inventory.reservations[id]
+	if !found {
+		return nil, fmt.Errorf("store: reservation %v does not exist or has expired", id)
+	}
+	delete(inventory.reservations, id)
+	for _, line := range reservation.Lines {
+		inventory.levels[stockKey{ line.SKU, line.Location }].Reserved -= line.Quantity
+	}
+	return reservation, nil
+}
+
+// Commit removes reserved stock from hand once the sale has gone through.
+func (inventory *Inventory) Commit(id string) error {
+	if err := inventory.lock(); err != nil {
+		return err
+	}
+	defer inventory.unlock()
+	reservation, err := inventory.take(id)
+	if err != nil {
+		return err
+	}
+	for _, line := range reservation.Lines {
+		inventory.levels[stockKey{ line.SKU, line.Location }].OnHand -= line.Quantity
+	}
+	if err := inventory.keep(); err != nil {
+		inventory.reservations[id] = reservation
+		for _, line := range reservation.Lines {
+			level := inventory.levels[stockKey{ line.SKU, line.Location }]
+			level.OnHand += line.Quantity
+			level.Reserved += line.Quantity
+		}
+		return err
+	}
+	for _, line := range reservation.Lines {
+		inventory.checkLow(line.SKU)
+	}
+	return nil
+}
+
+// Release returns reserved stock without selling it.
+func (inventory *Inventory) Release(id string) error {
+	if err := inventory.lock(); err != nil {
+		return err
+	}
+	defer inventory.unlock()
+	reservation, err := inventory.take(id)
+	if err != nil {
+		return err
+	}
+	if err := inventory.keep(); err != nil {
+		inventory.reservations[id] = reservation
+		for _, line := range reservation.Lines {
+			inventory.levels[stockKey{ line.SKU, line.Location }].Reserved += line.Quantity
+		}
+		return err
+	}
+	for _, line := range reservation.Lines {
+		inventory.checkLow(line.SKU)
+	}
+	return nil
+}
+
+// Valid reports whether a reservation is still being held.
+func (inventory *Inventory) Valid(id string) bool {
+	inventory.view()
+	defer inventory.unlock()
+	inventory.expire()
+	_, found := inventory.reservations[id]
+	return found
+}
+
+func (inventory *Inventory) expire() {
+	now := inventory.Now()
+	for id, reservation := range inventory.reservations {
+		if !now.Before(reservation.Expires) {
+			delete(inventory.reservations, id)
+			for _, line := range reservation.Lines {
+				inventory.levels[stockKey{ line.SKU, line.Location }].Reserved -= line.Quantity
+			}
+			for _, line := range reservation.Lines {
+				inventory.checkLow(line.SKU)
+			}
+		}
+	}
+}
+
+// unlock releases the inventory and then delivers any low stock alerts, so
+// that listeners are free to call back into the inventory.
+func (inventory *Inventory) unlock() {
+	alerts := inventory.pending
+	inventory.pending = nil
+	if release := inventory.release; release != nil {
+		inventory.release = nil
+		release()
+	}
+	inventory.mutex.Unlock()
+	for _, alert := range alerts {
+		inventory.listener.LowStock(alert.sku, alert.available, alert.threshold)
+	}
+}
+
+// checkLow alerts the listener once each time sku drops to its threshold,
+// and rearms when stock is replenished above it.
+func (inventory *Inventory) checkLow(sku string) {
+	threshold, found := inventory.thresholds[sku]
+	if !found {
+		return
+	}
+	available := inventory.available(sku)
+	if available > threshold {
+		inventory.alerted[sku] = false
+		return
+	}
+	if !inventory.alerted[sku] && inventory.listener != nil {
+		inventory.alerted[sku] = true
+		inventory.pending = append(inventory.pending, lowStockAlert{ sku, available, threshold })
+	}
+}
@@ -0,0 +1,443 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Party is the seller or the customer named on an invoice.
+type Party struct {
+	Name string `json:"name"`
+	Address []string `json:"address,omitempty"`
+	TaxID string `json:"taxId,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type InvoiceKind string
+
+const (
+	KindInvoice InvoiceKind = "invoice"
+	KindCreditNote InvoiceKind = "credit note"
+)
+
+type InvoiceDiscount struct {
+	Name string `json:"name"`
+	Amount Money `json:"amount"`
+}
+
+// InvoiceTax is one tax charged on a line, or in an invoice's TaxSummary the
+// total of a tax at one rate across all lines.
+type InvoiceTax struct {
+	Name string `json:"name"`
+	Rate float64 `json:"rate"`
+	Base Money `json:"base"`
+	Amount Money `json:"amount"`
+}
+
+type InvoiceLine struct {
+	SKU string `json:"sku"`
+	Description string `json:"description"`
+	Quantity int `json:"quantity"`
+	UnitPrice Money `json:"unitPrice"`
+	Subtotal Money `json:"subtotal"`
+	Discounts []InvoiceDiscount `json:"discounts,omitempty"`
+	Net Money `json:"net"`
+	Taxes []InvoiceTax `json:"taxes,omitempty"`
+	Tax Money `json:"tax"`
+	Gross Money `json:"gross"`
+}
+
+// Invoice is an invoice for an order or a credit note refunding part of one.
+// A credit note names the invoice it refunds in Original, and its amounts
+// are what is given back, so they are positive like the invoice's. Lines are
+// charged at the prices in force when the order was placed, on Ordered, not
+// those of the day it is invoiced. Invoices are never changed once issued.
+type Invoice struct {
+	Number string `json:"number"`
+	Kind InvoiceKind `json:"kind"`
+	Issued time.Time `json:"issued"`
+	Order string `json:"order"`
+	Ordered time.Time `json:"ordered"`
+	Original string `json:"original,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Seller Party `json:"seller"`
+	Customer Party `json:"customer"`
+	Lines []InvoiceLine `json:"lines"`
+	Subtotal Money `json:"subtotal"`
+	Discount Money `json:"discount"`
+	Net Money `json:"net"`
+	Tax Money `json:"tax"`
+	Total Money `json:"total"`
+	TaxSummary []InvoiceTax `json:"taxSummary"`
+	Rates []ExchangeRate `json:"exchangeRates,omitempty"`
+}
+
+// total adds up the lines and groups their taxes by name and rate.
+func (invoice *Invoice) total(currency string) {
+	zero := Money{ 0, currency }
+	invoice.Subtotal, invoice.Discount, invoice.Net, invoice.Tax, invoice.Total = zero, zero, zero, zero, zero
+	invoice.TaxSummary = []InvoiceTax{}
+	for _, line := range invoice.Lines {
+		invoice.Subtotal = invoice.Subtotal.Add(line.Subtotal)
+		invoice.Discount = invoice.Discount.Add(line.Subtotal.Sub(line.Net))
+		invoice.Net = invoice.Net.Add(line.Net)
+		invoice.Tax = invoice.Tax.Add(line.Tax)
+		invoice.Total = invoice.Total.Add(line.Gross)
+		for _, tax := range line.Taxes {
+			found := false
+			for i, summary := range invoice.TaxSummary {
+				if summary.Name == tax.Name && summary.Rate == tax.Rate {
+					invoice.TaxSummary[i].Base = summary.Base.Add(tax.Base)
+					invoice.TaxSummary[i].Amount = summary.Amount.Add(tax.Amount)
+					found = true
+				}
+			}
+			if !found {
+				invoice.TaxSummary = append(invoice.TaxSummary, tax)
+			}
+		}
+	}
+}
+
+func invoiceLine(line PricedLine) InvoiceLine {
+	description := line.Name
+	if len(line.Components) > 0 {
+		parts := make([]string, len(line.Components))
+		for i, component := range line.Components {
+			parts[i] = fmt.Sprintf("%v x %v", component.Quantity, component.SKU)
+		}
+		description += " (" + strings.Join(parts, ", ") + ")"
+	}
+	invoiced := InvoiceLine{ SKU: line.SKU, Description: description, Quantity: line.Quantity,
+		UnitPrice: line.UnitPrice, Subtotal: line.Subtotal, Net: line.Net, Tax: line.Tax.Tax, Gross: line.Gross }
+	for _, d := range line.Discounts {
+		invoiced.Discounts = append(invoiced.Discounts, InvoiceDiscount{ d.Name, d.Amount })
+	}
+	for _, tax := range line.Tax.Lines {
+		invoiced.Taxes = append(invoiced.Taxes, InvoiceTax{ tax.Name, tax.Rate, tax.Base, tax.Amount })
+	}
+	return invoiced
+}
+
+// CreditLine asks for Quantity units of an invoice line to be refunded.
+type CreditLine struct {
+	SKU string `json:"sku"`
+	Quantity int `json:"quantity"`
+}
+
+// portion is the part of m that n of total units account for.
+func portion(m Money, n, total int) Money {
+	return m.multiplyByRat(big.NewRat(int64(n), int64(total)), RoundHalfUp)
+}
+
+// creditLine refunds quantity units of line when credited units have
+// already been refunded. Each amount is the difference between the portions
+// credited after and before, so crediting every unit, however many notes it
+// takes, gives back exactly what was invoiced.
+func creditLine(line InvoiceLine, credited, quantity int) InvoiceLine {
+	share := func(m Money) Money {
+		return portion(m, credited + quantity, line.Quantity).Sub(portion(m, credited, line.Quantity))
+	}
+	refunded := InvoiceLine{ SKU: line.SKU, Description: line.Description, Quantity: quantity,
+		UnitPrice: line.UnitPrice, Subtotal: share(line.Subtotal), Net: share(line.Net), Tax: share(line.Tax) }
+	for _, d := range line.Discounts {
+		refunded.Discounts = append(refunded.Discounts, InvoiceDiscount{ d.Name, share(d.Amount) })
+	}
+	for _, tax := range line.Taxes {
+		refunded.Taxes = append(refunded.Taxes, InvoiceTax{ tax.Name, tax.Rate, share(tax.Base), share(tax.Amount) })
+	}
+	refunded.Gross = refunded.Net.Add(refunded.Tax)
+	return refunded
+}
+
+// Invoices issues and keeps invoices and credit notes.
+type Invoices interface {
+	Issue(order *Order, customer Party) (*Invoice, error)
+	Credit(number, reason string, lines ...CreditLine) (*Invoice, error)
+	Get(number string) (*Invoice, bool)
+	List() []*Invoice
+}
+
+const (
+	InvoicePrefix = "INV"
+	CreditNotePrefix = "CN"
+)
+
+// InvoiceBook numbers invoices INV-000001, INV-000002 and so on, and credit
+// notes CN-000001 onwards. A number is only taken by a document that is
+// kept, so neither series has gaps.
+type InvoiceBook struct {
+	mutex sync.Mutex
+	seller Party
+	invoices []*Invoice
+	Now func() time.Time
+}
+
+func NewInvoiceBook(seller Party) *InvoiceBook {
+	return &InvoiceBook{ seller: seller, Now: time.Now }
+}
+
+func (book *InvoiceBook) Seller() Party {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.seller
+}
+
+// SetSeller changes the seller named on invoices issued from now on.
+func (book *InvoiceBook) SetSeller(seller Party) error {
+	if strings.TrimSpace(seller.Name) == "" {
+		return fmt.Errorf("store: the seller needs a name")
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.seller = seller
+	return nil
+}
+
+func (book *InvoiceBook) get(number string) (*Invoice, bool) {
+	for _, invoice := range book.invoices {
+		if invoice.Number == number {
+			return invoice, true
+		}
+	}
+	return nil, false
+}
+
+func (book *InvoiceBook) Get(number string) (*Invoice, bool) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.get(number)
+}
+
+// List returns the invoices and credit notes in the order they were issued.
+func (book *InvoiceBook) List() []*Invoice {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return append([]*Invoice{}, book.invoices...)
+}
+
+// CreditNotes lists the credit notes against an invoice.
+func (book *InvoiceBook) CreditNotes(number string) []*Invoice {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.creditNotes(number)
+}
+
+func (book *InvoiceBook) creditNotes(number string) []*Invoice {
+	notes := []*Invoice{}
+	for _, invoice := range book.invoices {
+		if invoice.Kind == KindCreditNote && invoice.Original == number {
+			notes = append(notes, invoice)
+		}
+	}
+	return notes
+}
+
+// record numbers the invoice and adds it to the book. If keep, which runs
+// with the book locked, fails, the invoice is dropped and its number is
+// handed out again.
+func (book *InvoiceBook) record(invoice *Invoice, keep func() error) error {
+	prefix, count := InvoicePrefix, 0
+	if invoice.Kind == KindCreditNote {
+		prefix = CreditNotePrefix
+	}
+	for _, issued := range book.invoices {
+		if issued.Kind == invoice.Kind {
+			count++
+		}
+	}
+	invoice.Number = fmt.Sprintf("%v-%06d", prefix, count + 1)
+	invoice.Issued = book.Now()
+	book.invoices = append(book.invoices, invoice)
+	if keep != nil {
+		if err := keep(); err != nil {
+			book.invoices = book.invoices[:len(book.invoices) - 1]
+			return err
+		}
+	}
+	return nil
+}
+
+func (book *InvoiceBook) Issue(order *Order, customer Party) (*Invoice, error) {
+	return book.issue(order, customer, nil)
+}
+
+func (book *InvoiceBook) issue(order *Order, customer Party, keep func() error) (*Invoice, error) {
+	if strings.TrimSpace(customer.Name) == "" {
+		return nil, fmt.Errorf("store: an invoice needs the customer's name")
+	}
+	if order.Status() == OrderCancelled {
+		return nil, fmt.Errorf("store: order %v was cancelled", order.Number())
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if book.seller.Name == "" {
+		return nil, fmt.Errorf("store: set the seller's details before issuing invoices")
+	}
+	for _, issued := range book.invoices {
+		if issued.Kind == KindInvoice && issued.Order == order.Number() {
+			return nil, fmt.Errorf("store: order %v is already invoiced as %v", order.Number(), issued.Number)
+		}
+	}
+	totals := order.Totals()
+	invoice := &Invoice{ Kind: KindInvoice, Order: order.Number(), Ordered: order.Placed(), Seller: book.seller,
+		Customer: customer, Lines: []InvoiceLine{}, Rates: totals.Rates }
+	for _, line := range totals.Lines {
+		invoice.Lines = append(invoice.Lines, invoiceLine(line))
+	}
+	invoice.total(totals.Total.currency)
+	if err := book.record(invoice, keep); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// Credit issues a credit note refunding lines of the invoice with the given
+// number. With no lines it refunds everything not refunded already.
+func (book *InvoiceBook) Credit(number, reason string, lines ...CreditLine) (*Invoice, error) {
+	return book.credit(number, reason, lines, nil)
+}
+
+func (book *InvoiceBook) credit(number, reason string, lines []CreditLine, keep func() error) (*Invoice, error) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	original, found := book.get(number)
+	if !found || original.Kind != KindInvoice {
+		return nil, fmt.Errorf("store: no invoice %v", number)
+	}
+	credited := map[string]int{}
+	for _, note := range book.creditNotes(number) {
+		for _, line := range note.Lines {
+			credited[line.SKU] += line.Quantity
+		}
+	}
+	if len(lines) == 0 {
+		for _, line := range original.Lines {
+			if left := line.Quantity - credited[line.SKU]; left > 0 {
+				lines = append(lines, CreditLine{ line.SKU, left })
+			}
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("store: invoice %v has been refunded in full", number)
+		}
+	}
+	note := &Invoice{ Kind: KindCreditNote, Order: original.Order, Ordered: original.Ordered, Original: number, Reason: reason,
+		Seller: original.Seller, Customer: original.Customer, Lines: []InvoiceLine{}, Rates: original.Rates }
+	requested := map[string]bool{}
+	for _, request := range lines {
+		var invoiced *InvoiceLine
+		for i := range original.Lines {
+			if original.Lines[i].SKU == request.SKU {
+				invoiced = &original.Lines[i]
+			}
+		}
+		if invoiced == nil {
+			return nil, fmt.Errorf("store: %v is not on invoice %v", request.SKU, number)
+		}
+		if requested[request.SKU] {
+			return nil, fmt.Errorf("store: %v is listed more than once", request.SKU)
+		}
+		requested[request.SKU] = true
+		left := invoiced.Quantity - credited[request.SKU]
+		if request.Quantity < 1 || request.Quantity > left {
+			return nil, fmt.Errorf("store: cannot refund %v of %v, %v of %v are left to refund", request.Quantity,
+				request.SKU, left, invoiced.Quantity)
+		}
+		note.Lines = append(note.Lines, creditLine(*invoiced, credited[request.SKU], request.Quantity))
+	}
+	note.total(original.Total.currency)
+	if err := book.record(note, keep); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// Balance is what is still owed on an invoice after its credit notes.
+func (book *InvoiceBook) Balance(number string) (Money, error) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	invoice, found := book.get(number)
+	if !found || invoice.Kind != KindInvoice {
+		return Money{}, fmt.Errorf("store: no invoice %v", number)
+	}
+	balance := invoice.Total
+	for _, note := range book.creditNotes(number) {
+		balance = balance.Sub(note.Total)
+	}
+	return balance, nil
+}
+
+type invoiceFile struct {
+	Seller Party `json:"seller"`
+	Invoices []*Invoice `json:"invoices"`
+}
+
+// FileInvoiceBook keeps the book in a JSON file. A document is only issued
+// once the file holding it has been written, so a failed write leaves no gap
+// in the numbering.
+type FileInvoiceBook struct {
+	*InvoiceBook
+	file *sharedFile
+}
+
+func OpenFileInvoiceBook(path string) (*FileInvoiceBook, error) {
+	book := &FileInvoiceBook{ InvoiceBook: NewInvoiceBook(Party{}) }
+	book.file = newSharedFile(path, book.load)
+	if err := book.file.reload(); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func (book *FileInvoiceBook) load(data []byte) error {
+	var file invoiceFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	sort.SliceStable(file.Invoices, func(i, j int) bool { return file.Invoices[i].Issued.Before(file.Invoices[j].Issued) })
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.seller, book.invoices = file.Seller, file.Invoices
+	return nil
+}
+
+// save writes the book, which must be locked.
+func (book *FileInvoiceBook) save() error {
+	return book.file.write(invoiceFile{ book.seller, book.invoices })
+}
+
+func (book *FileInvoiceBook) SetSeller(seller Party) error {
+	return book.file.change(func() error {
+		if err := book.InvoiceBook.SetSeller(seller); err != nil {
+			return err
+		}
+		book.mutex.Lock()
+		defer book.mutex.Unlock()
+		return book.save()
+	})
+}
+
+func (book *FileInvoiceBook) Issue(order *Order, customer Party) (*Invoice, error) {
+	var invoice *Invoice
+	err := book.file.change(func() error {
+		var err error
+		invoice, err = book.issue(order, customer, book.save)
+		return err
+	})
+	return invoice, err
+}
+
+func (book *FileInvoiceBook) Credit(number, reason string, lines ...CreditLine) (*Invoice, error) {
+	var note *Invoice
+	err := book.file.change(func() error {
+		var err error
+		note, err = book.credit(number, reason, lines, book.save)
+		return err
+	})
+	return note, err
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func (invoice *Invoice) Title() string {
+	if invoice.Kind == KindCreditNote {
+		return "Credit note " + invoice.Number
+	}
+	return "Invoice " + invoice.Number
+}
+
+func percent(rate float64) string {
+	return strconv.FormatFloat(rate * 100, 'f', -1, 64) + "%"
+}
+
+func (tax InvoiceTax) label() string {
+	return fmt.Sprintf("%v %v", tax.Name, percent(tax.Rate))
+}
+
+func (rate ExchangeRate) label() string {
+	return fmt.Sprintf("1 %v = %v %v, rate of %v", rate.From, strconv.FormatFloat(rate.Rate, 'f', -1, 64), rate.To,
+		rate.Effective.Format("2006-01-02"))
+}
+
+func (party Party) lines() []string {
+	lines := append([]string{ party.Name }, party.Address...)
+	if party.TaxID != "" {
+		lines = append(lines, "Tax ID: " + party.TaxID)
+	}
+	if party.Email != "" {
+		lines = append(lines, party.Email)
+	}
+	return lines
+}
+
+// textLines lays the invoice out in 72 columns of fixed-width text, the form
+// used for plain text and for PDF.
+func (invoice *Invoice) textLines() []string {
+	lines := []string{ strings.ToUpper(invoice.Title()), "" }
+	lines = append(lines, fmt.Sprintf("Issued: %v    Order: %v", invoice.Issued.Format("2006-01-02"), invoice.Order))
+	if !invoice.Ordered.IsZero() {
+		lines = append(lines, "Ordered: " + invoice.Ordered.Format("2006-01-02"))
+	}
+	if invoice.Kind == KindCreditNote {
+		lines = append(lines, "Refunds invoice " + invoice.Original)
+		if invoice.Reason != "" {
+			lines = append(lines, "Reason: " + invoice.Reason)
+		}
+	}
+	seller, customer := invoice.Seller.lines(), invoice.Customer.lines()
+	lines = append(lines, "", fmt.Sprintf("%-36v%v", "From:", "To:"))
+	for i := 0; i < len(seller) || i < len(customer); i++ {
+		from, to := "", ""
+		if i < len(seller) {
+			from = seller[i]
+		}
+		if i < len(customer) {
+			to = customer[i]
+		}
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%-36v%v", from, to), " "))
+	}
+	row := func(quantity, description, unit, amount string) string {
+		return fmt.Sprintf("%4v  %-40.40v %12v %12v", quantity, description, unit, amount)
+	}
+	rule := strings.Repeat("-", 72)
+	lines = append(lines, "", row("Qty", "Description", "Unit price", "Amount"), rule)
+	for _, line := range invoice.Lines {
+		lines = append(lines, row(strconv.Itoa(line.Quantity), line.Description, line.UnitPrice.String(),
+			line.Subtotal.String()))
+		for _, d := range line.Discounts {
+			lines = append(lines, row("", "  " + d.Name, "", d.Amount.Neg().String()))
+		}
+		for _, tax := range line.Taxes {
+			lines = append(lines, row("", fmt.Sprintf("  %v on %v", tax.label(), tax.Base), "", tax.Amount.String()))
+		}
+	}
+	lines = append(lines, rule)
+	total := func(label string, amount Money) string {
+		return fmt.Sprintf("%59v %12v", label, amount)
+	}
+	lines = append(lines, total("Subtotal", invoice.Subtotal))
+	if !invoice.Discount.IsZero() {
+		lines = append(lines, total("Discounts", invoice.Discount.Neg()))
+	}
+	lines = append(lines, total("Net", invoice.Net))
+	for _, tax := range invoice.TaxSummary {
+		lines = append(lines, total(fmt.Sprintf("%v on %v", tax.label(), tax.Base), tax.Amount))
+	}
+	label := "Total due"
+	if invoice.Kind == KindCreditNote {
+		label = "Total refunded"
+	}
+	lines = append(lines, total(label, invoice.Total))
+	for _, rate := range invoice.Rates {
+		lines = append(lines, "", "Converted at " + rate.label())
+	}
+	return lines
+}
+
+func (invoice *Invoice) WriteText(w io.Writer) error {
+	_, err := io.WriteString(w, strings.Join(invoice.textLines(), "\n") + "\n")
+	return err
+}
+
+func (invoice *Invoice) WritePDF(w io.Writer) error {
+	return writePDF(w, invoice.Title(), invoice.textLines())
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"neg": Money.Neg,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 0.25em 0.5em; text-align: left; }
+td.amount, th.amount { text-align: right; }
+tr.detail td { color: #555; font-size: 0.9em; }
+tfoot td { border-top: 1px solid #999; }
+.parties { display: flex; gap: 4em; margin: 1em 0 2em; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>Issued {{ .Issued.Format "2 January 2006" }} for order {{ .Order }}{{ if not .Ordered.IsZero }}, placed {{ .Ordered.Format "2 January 2006" }}{{ end }}</p>
+{{ if .Original }}<p>Refunds invoice {{ .Original }}{{ if .Reason }}: {{ .Reason }}{{ end }}</p>{{ end }}
+<div class="parties">
+<div><strong>From</strong><br>{{ range .Seller.Lines }}{{ . }}<br>{{ end }}</div>
+<div><strong>To</strong><br>{{ range .Customer.Lines }}{{ . }}<br>{{ end }}</div>
+</div>
+<table>
+<thead><tr><th>Qty</th><th>Description</th><th class="amount">Unit price</th><th class="amount">Amount</th></tr></thead>
+<tbody>
+{{ range .Lines }}<tr><td>{{ .Quantity }}</td><td>{{ .Description }}</td><td class="amount">{{ .UnitPrice }}</td><td class="amount">{{ .Subtotal }}</td></tr>
+{{ range .Discounts }}<tr class="detail"><td></td><td>{{ .Name }}</td><td></td><td class="amount">{{ neg .Amount }}</td></tr>
+{{ end }}{{ range .Taxes }}<tr class="detail"><td></td><td>{{ .Label }} on {{ .Base }}</td><td></td><td class="amount">{{ .Amount }}</td></tr>
+{{ end }}{{ end }}</tbody>
+<tfoot>
+<tr><td colspan="3">Subtotal</td><td class="amount">{{ .Subtotal }}</td></tr>
+{{ if not .Discount.IsZero }}<tr><td colspan="3">Discounts</td><td class="amount">{{ neg .Discount }}</td></tr>
+{{ end }}<tr><td colspan="3">Net</td><td class="amount">{{ .Net }}</td></tr>
+{{ range .TaxSummary }}<tr><td colspan="3">{{ .Label }} on {{ .Base }}</td><td class="amount">{{ .Amount }}</td></tr>
+{{ end }}<tr><th colspan="3">{{ .TotalLabel }}</th><th class="amount">{{ .Total }}</th></tr>
+</tfoot>
+</table>
+{{ range .Rates }}<p>Converted at {{ .Label }}</p>
+{{ end }}</body>
+</html>
+`))
+
+// invoicePage adds the labels the HTML template needs to an invoice.
+type invoicePage struct {
+	*Invoice
+	Seller, Customer partyPage
+	Lines []linePage
+	TaxSummary []taxPage
+	Rates []ratePage
+}
+
+type partyPage struct{ Lines []string }
+
+type linePage struct {
+	InvoiceLine
+	Taxes []taxPage
+}
+
+type taxPage struct {
+	InvoiceTax
+	Label string
+}
+
+type ratePage struct{ Label string }
+
+func (page invoicePage) TotalLabel() string {
+	if page.Kind == KindCreditNote {
+		return "Total refunded"
+	}
+	return "Total due"
+}
+
+func taxPages(taxes []InvoiceTax) []taxPage {
+	pages := make([]taxPage, len(taxes))
+	for i, tax := range taxes {
+		pages[i] = taxPage{ tax, tax.label() }
+	}
+	return pages
+}
+
+func (invoice *Invoice) WriteHTML(w io.Writer) error {
+	page := invoicePage{ Invoice: invoice, Seller: partyPage{ invoice.Seller.lines() },
+		Customer: partyPage{ invoice.Customer.lines() }, TaxSummary: taxPages(invoice.TaxSummary) }
+	for _, line := range invoice.Lines {
+		page.Lines = append(page.Lines, linePage{ line, taxPages(line.Taxes) })
+	}
+	for _, rate := range invoice.Rates {
+		page.Rates = append(page.Rates, ratePage{ rate.label() })
+	}
+	return invoiceTemplate.Execute(w, page)
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount held as an integer number of minor units (cents
+// for USD) together with its ISO 4217 currency code. The zero value has no
+// currency and can be added to an amount in any currency. Arithmetic that
+// would overflow the int64 minor units panics, as combining currencies does.
+type Money struct {
+	minor int64
+	currency string
+}
+
+type RoundingMode int
+
+const (
+	RoundHalfUp RoundingMode = iota
+	RoundHalfEven
+)
+
+var currencyDigits = map[string]int{
+	"USD": 2, "EUR": 2, "GBP": 2, "CHF": 2, "CAD": 2, "AUD": 2, "JPY": 0,
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥",
+}
+
+// TaxRounding is the rounding mode used when tax is calculated on a price.
+var TaxRounding = RoundHalfUp
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{ minor, currency }
+}
+
+func digits(currency string) int {
+	if d, found := currencyDigits[currency]; found {
+		return d
+	}
+	return 2
+}
+
+func (m Money) Minor() int64 {
+	return m.minor
+}
+
+func (m Money) Currency() string {
+	return m.currency
+}
+
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.minor < 0
+}
+
+func (m Money) Neg() Money {
+	return Money{ subMinor(0, m.minor), m.currency }
+}
+
+// common returns the currency shared by two amounts. Combining amounts in
+// different currencies is a programming error, so it panics rather than
+// silently producing a meaningless total.
+func (m Money) common(other Money) string {
+	if m.currency == "" {
+		return other.currency
+	}
+	if other.currency != "" && other.currency != m.currency {
+		panic(fmt.Sprintf("store: cannot combine %v and %v amounts", m.currency, other.currency))
+	}
+	return m.currency
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{ addMinor(m.minor, other.minor), m.common(other) }
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{ subMinor(m.minor, other.minor), m.common(other) }
+}
+
+func (m Money) Multiply(quantity int64) Money {
+	return Money{ mulMinor(m.minor, quantity), m.currency }
+}
+
+func addMinor(a, b int64) int64 {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		panic(fmt.Sprintf("store: %v + %v overflows", a, b))
+	}
+	return sum
+}
+
+func subMinor(a, b int64) int64 {
+	difference := a - b
+	if (b > 0 && difference > a) || (b < 0 && difference < a) {
+		panic(fmt.Sprintf("store: %v - %v overflows", a, b))
+	}
+	return difference
+}
+
+func mulMinor(a, b int64) int64 {
+	product := a * b
+	if a != 0 && (product / a != b || (a == -1 && b == math.MinInt64)) {
+		panic(fmt.Sprintf("store: %v × %v overflows", a, b))
+	}
+	return product
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than other.
+func (m Money) Cmp(other Money) int {
+	m.common(other)
+	switch {
+	case m.minor < other.minor:
+		return -1
+	case m.minor > other.minor:
+		return 1
+	}
+	return 0
+}
+
+// MultiplyByRate returns m multiplied by rate, rounded to a whole minor unit.
+// The rate is taken as the decimal it prints as, so 0.2 is exactly a fifth.
+// Rates are checked with IsFiniteRate where they are set; a NaN or infinite
+// rate reaching here panics.
+func (m Money) MultiplyByRate(rate float64, mode RoundingMode) Money {
+	return m.multiplyByRat(ratFromFloat(rate), mode)
+}
+
+// IsFiniteRate reports whether rate is a number that amounts can be
+// multiplied by, rather than NaN or an infinity.
+func IsFiniteRate(rate float64) bool {
+	return !math.IsNaN(rate) && !math.IsInf(rate, 0)
+}
+
+func ratFromFloat(rate float64) *big.Rat {
+	if !IsFiniteRate(rate) {
+		panic(fmt.Sprintf("store: cannot multiply by a rate of %v", rate))
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	return r
+}
+
+func (m Money) multiplyByRat(rate *big.Rat, mode RoundingMode) Money {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.minor), rate)
+	return Money{ roundRat(product, mode), m.currency }
+}
+
+func roundRat(value *big.Rat, mode RoundingMode) int64 {
+	num, den := value.Num(), value.Denom()
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	away := false
+	switch twice.Cmp(den) {
+	case 1:
+		away = true
+	case 0:
+		away = mode == RoundHalfUp || quotient.Bit(0) == 1
+	}
+	if away {
+		quotient.Add(quotient, big.NewInt(int64(num.Sign())))
+	}
+	if !quotient.IsInt64() {
+		panic(fmt.Sprintf("store: %v minor units overflows", quotient))
+	}
+	return quotient.Int64()
+}
+
+// Allocate splits m in proportion to ratios without losing any minor units;
+// the leftover units go one at a time to the earliest shares.
+func (m Money) Allocate(ratios ...int) []Money {
+	total := 0
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			panic("store: negative allocation ratio")
+		}
+		total += ratio
+	}
+	shares := make([]Money, len(ratios))
+	if total == 0 {
+		for i := range shares {
+			shares[i] = Money{ 0, m.currency }
+		}
+		return shares
+	}
+	remainder := m.minor
+	for i, ratio := range ratios {
+		share := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(int64(ratio)))
+		shares[i] = Money{ share.Quo(share, big.NewInt(int64(total))).Int64(), m.currency }
+		remainder -= shares[i].minor
+	}
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(shares) {
+		if ratios[i] == 0 {
+			continue
+		}
+		shares[i].minor += step
+		remainder -= step
+	}
+	return shares
+}
+
+// Amount formats the value without a currency, e.g. "1234.50".
+func (m Money) Amount() string {
+	d := digits(m.currency)
+	minor := m.minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	text := strconv.FormatInt(minor, 10)
+	if d == 0 {
+		return sign + text
+	}
+	if len(text) <= d {
+		text = strings.Repeat("0", d - len(text) + 1) + text
+	}
+	return sign + text[:len(text) - d] + "." + text[len(text) - d:]
+}
+
+func (m Money) String() string {
+	amount := m.Amount()
+	sign := ""
+	if strings.HasPrefix(amount, "-") {
+		sign, amount = "-", amount[1:]
+	}
+	if symbol, found := currencySymbols[m.currency]; found {
+		return sign + symbol + amount
+	}
+	if m.currency == "" {
+		return sign + amount
+	}
+	return sign + amount + " " + m.currency
+}
+
+// ParseAmount reads a decimal such as "1,234.5" as an amount in currency. It
+// rejects values with more decimal places than the currency allows, and
+// commas anywhere but between groups of three digits.
+func ParseAmount(text, currency string) (Money, error) {
+	value := strings.TrimSpace(text)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	whole, fraction, _ := strings.Cut(value, ".")
+	whole, grouped := ungroup(whole)
+	d := digits(currency)
+	if !grouped || whole == "" || len(fraction) > d || strings.ContainsAny(whole + fraction, "+-") {
+		return Money{}, fmt.Errorf("store: invalid %v amount %q", currency, text)
+	}
+	minor, err := strconv.ParseInt(whole + fraction + strings.Repeat("0", d - len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("store: invalid %v amount %q", currency, text)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{ minor, currency }, nil
+}
+
+// ungroup removes thousands separators from the whole part of an amount,
+// reporting false unless every comma is followed by exactly three digits
+// and the first group has one to three.
+func ungroup(whole string) (string, bool) {
+	groups := strings.Split(whole, ",")
+	if len(groups) == 1 {
+		return whole, true
+	}
+	for i, group := range groups {
+		if len(group) > 3 || group == "" || (i > 0 && len(group) != 3) {
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
+
+// ParseMoney reads amounts written with a symbol ("$48.95", "€48.95") or an
+// ISO code before or after the number ("USD 48.95", "48.95 CHF").
+func ParseMoney(text string) (Money, error) {
+	value := strings.TrimSpace(text)
+	negative := strings.HasPrefix(value, "-")
+	m, err := parseUnsigned(strings.TrimPrefix(value, "-"))
+	if err != nil {
+		return Money{}, fmt.Errorf("store: cannot parse money %q", text)
+	}
+	if negative {
+		m = m.Neg()
+	}
+	return m, nil
+}
+
+func parseUnsigned(value string) (Money, error) {
+	for code, symbol := range currencySymbols {
+		if strings.HasPrefix(value, symbol) {
+			return ParseAmount(strings.TrimPrefix(value, symbol), code)
+		}
+	}
+	if fields := strings.Fields(value); len(fields) == 2 {
+		if isCurrencyCode(fields[0]) {
+			return ParseAmount(fields[1], fields[0])
+		}
+		if isCurrencyCode(fields[1]) {
+			return ParseAmount(fields[0], fields[1])
+		}
+	}
+	return Money{}, fmt.Errorf("no currency")
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParseMoney is like ParseMoney but panics if the text cannot be parsed.
+// It is intended for prices written as literals.
+func MustParseMoney(text string) Money {
+	m, err := ParseMoney(text)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"math"
+	"testing"
+)
+
+// panics reports whether f panics.
+func panics(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return false
+}
+
+func TestMultiplyByRate(t *testing.T) {
+	tests := []struct {
+		amount string
+		rate float64
+		mode RoundingMode
+		want string
+	}{
+		{ "$10.00", 0.2, RoundHalfUp, "$2.00" },
+		{ "$0.05", 0.5, RoundHalfUp, "$0.03" },
+		{ "$0.05", 0.5, RoundHalfEven, "$0.02" },
+		{ "$0.07", 0.5, RoundHalfEven, "$0.04" },
+		{ "-$0.05", 0.5, RoundHalfUp, "-$0.03" },
+		{ "-$0.05", 0.5, RoundHalfEven, "-$0.02" },
+		{ "$19.99", 0.175, RoundHalfUp, "$3.50" },
+		{ "¥999", 0.1, RoundHalfEven, "¥100" },
+	}
+	for _, test := range tests {
+		got := MustParseMoney(test.amount).MultiplyByRate(test.rate, test.mode)
+		if got.String() != test.want {
+			t.Errorf("%v × %v = %v, want %v", test.amount, test.rate, got, test.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amount string
+		ratios []int
+		want []string
+	}{
+		{ "$10.00", []int{ 1, 1, 1 }, []string{ "$3.34", "$3.33", "$3.33" } },
+		{ "-$10.00", []int{ 1, 1, 1 }, []string{ "-$3.34", "-$3.33", "-$3.33" } },
+		{ "$0.05", []int{ 0, 3, 1 }, []string{ "$0.00", "$0.04", "$0.01" } },
+		{ "$1.00", []int{ 0, 0 }, []string{ "$0.00", "$0.00" } },
+		{ "$92233720368547758.07", []int{ 2, 1 }, []string{ "$61489146912365172.05", "$30744573456182586.02" } },
+	}
+	for _, test := range tests {
+		shares := MustParseMoney(test.amount).Allocate(test.ratios...)
+		total := Money{}
+		for i, share := range shares {
+			total = total.Add(share)
+			if share.String() != test.want[i] {
+				t.Errorf("%v split %v: share %v is %v, want %v", test.amount, test.ratios, i, share, test.want[i])
+			}
+		}
+		if total.String() != test.amount && total.minor != 0 {
+			t.Errorf("%v split %v adds up to %v", test.amount, test.ratios, total)
+		}
+	}
+}
+
+// TestPortionsAddUp refunds an amount a few units at a time, as returns
+// credit a line, and checks that the rounded parts give back the whole.
+func TestPortionsAddUp(t *testing.T) {
+	tests := []struct {
+		amount string
+		units []int
+		want []string
+	}{
+		{ "$10.00", []int{ 1, 1, 1 }, []string{ "$3.33", "$3.34", "$3.33" } },
+		{ "$0.05", []int{ 1, 1 }, []string{ "$0.03", "$0.02" } },
+		{ "-$0.05", []int{ 1, 1 }, []string{ "-$0.03", "-$0.02" } },
+		{ "$2.57", []int{ 1, 2 }, []string{ "$0.86", "$1.71" } },
+		{ "¥1000", []int{ 2, 5 }, []string{ "¥286", "¥714" } },
+	}
+	for _, test := range tests {
+		amount, total := MustParseMoney(test.amount), 0
+		for _, n := range test.units {
+			total += n
+		}
+		refunded, sum := 0, Money{ 0, amount.currency }
+		for i, n := range test.units {
+			part := portion(amount, refunded + n, total).Sub(portion(amount, refunded, total))
+			refunded += n
+			sum = sum.Add(part)
+			if part.String() != test.want[i] {
+				t.Errorf("%v over %v: part %v is %v, want %v", test.amount, test.units, i, part, test.want[i])
+			}
+		}
+		if sum != amount {
+			t.Errorf("%v over %v adds up to %v", test.amount, test.units, sum)
+		}
+	}
+}
+
+func TestMoneyOverflow(t *testing.T) {
+	largest, smallest := NewMoney(math.MaxInt64, "USD"), NewMoney(math.MinInt64, "USD")
+	cent := NewMoney(1, "USD")
+	tests := []struct {
+		name string
+		f func()
+		panics bool
+	}{
+		{ "add", func() { largest.Add(cent) }, true },
+		{ "sub", func() { smallest.Sub(cent) }, true },
+		{ "neg", func() { smallest.Neg() }, true },
+		{ "multiply", func() { largest.Multiply(2) }, true },
+		{ "multiply by -1", func() { smallest.Multiply(-1) }, true },
+		{ "multiply by rate", func() { largest.MultiplyByRate(1.5, RoundHalfUp) }, true },
+		{ "largest", func() { largest.Sub(cent).Add(cent).Multiply(1) }, false },
+		{ "negative", func() { NewMoney(-3, "USD").Multiply(-4) }, false },
+	}
+	for _, test := range tests {
+		if got := panics(test.f); got != test.panics {
+			t.Errorf("%v: panicked %v, want %v", test.name, got, test.panics)
+		}
+	}
+}
+
+func TestNonFiniteRates(t *testing.T) {
+	for _, rate := range []float64{ math.NaN(), math.Inf(1), math.Inf(-1) } {
+		if _, err := NewRuleTaxPolicy(TaxRule{ Name: "VAT", Rate: rate }); err == nil {
+			t.Errorf("tax rule with rate %v accepted", rate)
+		}
+		if _, err := NewExchangeRates(RoundHalfEven, ExchangeRate{ From: "USD", To: "EUR", Rate: rate }); err == nil {
+			t.Errorf("exchange rate %v accepted", rate)
+		}
+		if _, err := NewLoyaltyProgram("USD", rate, 0); err == nil {
+			t.Errorf("earn rate %v accepted", rate)
+		}
+		if !panics(func() { MustParseMoney("$1").MultiplyByRate(rate, RoundHalfUp) }) {
+			t.Errorf("MultiplyByRate(%v) did not panic", rate)
+		}
+	}
+	if _, err := NewExchangeRates(RoundHalfEven, ExchangeRate{ From: "USD", To: "EUR", Rate: 5e-324 }); err == nil {
+		t.Error("exchange rate with an infinite inverse accepted")
+	}
+}
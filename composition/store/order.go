@@ -0,0 +1,379 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	OrderPlaced OrderStatus = "placed"
+	OrderPaid OrderStatus = "paid"
+	OrderShipped OrderStatus = "shipped"
+	OrderCancelled OrderStatus = "cancelled"
+)
+
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPlaced: { OrderPaid, OrderCancelled },
+	OrderPaid: { OrderShipped, OrderCancelled },
+}
+
+// Order is the record of a completed checkout. It cannot be modified; a
+// change of status produces a new Order value.
+type Order struct {
+	number string
+	status OrderStatus
+	placed time.Time
+	location Location
+	lines []PricedLine
+	totals CartTotals
+	customer string
+}
+
+func (order *Order) Number() string {
+	return order.number
+}
+
+func (order *Order) Status() OrderStatus {
+	return order.status
+}
+
+func (order *Order) Placed() time.Time {
+	return order.placed
+}
+
+func (order *Order) Location() Location {
+	return order.location
+}
+
+// Customer is the ID of the customer account the order was placed for, if
+// any.
+func (order *Order) Customer() string {
+	return order.customer
+}
+
+func (order *Order) Lines() []PricedLine {
+	return copyLines(order.lines)
+}
+
+func (order *Order) Totals() CartTotals {
+	totals := order.totals
+	totals.Lines = copyLines(order.lines)
+	totals.Rates = order.Rates()
+	totals.GiftCards = append([]GiftCardPayment(nil), order.totals.GiftCards...)
+	return totals
+}
+
+// Rates are the exchange rates the order was converted at, if it was paid
+// for in a currency other than the catalog's.
+func (order *Order) Rates() []ExchangeRate {
+	return append([]ExchangeRate{}, order.totals.Rates...)
+}
+
+func copyLines(lines []PricedLine) []PricedLine {
+	copied := make([]PricedLine, len(lines))
+	for i, line := range lines {
+		copied[i] = line
+		copied[i].Discounts = append([]AppliedDiscount{}, line.Discounts...)
+		if line.Components != nil {
+			copied[i].Components = append([]BundleComponent{}, line.Components...)
+		}
+		copied[i].Tax.Lines = append([]TaxLine{}, line.Tax.Lines...)
+	}
+	return copied
+}
+
+// WithStatus returns a copy of the order in a new status, if the order's
+// current status allows the change.
+func (order *Order) WithStatus(status OrderStatus) (*Order, error) {
+	for _, allowed := range orderTransitions[order.status] {
+		if allowed == status {
+			changed := *order
+			changed.status = status
+			return &changed, nil
+		}
+	}
+	return nil, fmt.Errorf("store: order %v cannot go from %v to %v", order.number, order.status, status)
+}
+
+// OrderSequence hands out unique order numbers such as ORD-000042.
+type OrderSequence struct {
+	mutex sync.Mutex
+	Prefix string
+	last int
+}
+
+func NewOrderSequence(prefix string, last int) *OrderSequence {
+	return &OrderSequence{ Prefix: prefix, last: last }
+}
+
+func (sequence *OrderSequence) Next() string {
+	sequence.mutex.Lock()
+	defer sequence.mutex.Unlock()
+	sequence.last++
+	return fmt.Sprintf("%v-%06d", sequence.Prefix, sequence.last)
+}
+
+// StockChecker reports how many units of a SKU can be sold.
+type StockChecker interface {
+	Available(sku string) int
+}
+
+type unlimitedStock struct{}
+
+func (unlimitedStock) Available(string) int {
+	return int(^uint(0) >> 1)
+}
+
+// UnlimitedStock never runs out, for shops that do not track inventory.
+var UnlimitedStock StockChecker = unlimitedStock{}
+
+var ErrEmptyCart = errors.New("store: the cart is empty")
+
+type PriceChangedError struct {
+	SKUs []string
+}
+
+func (err *PriceChangedError) Error() string {
+	return "store: prices changed since the cart was built: " + strings.Join(err.SKUs, ", ")
+}
+
+// OutOfStockError may suggest an Alternate SKU that can fill the request.
+type OutOfStockError struct {
+	SKU string
+	Requested, Available int
+	Alternate string
+}
+
+func (err *OutOfStockError) Error() string {
+	message := fmt.Sprintf("store: %v of %v requested but only %v available", err.Requested, err.SKU, err.Available)
+	if err.Alternate != "" {
+		message += ", try " + err.Alternate
+	}
+	return message
+}
+
+// StockReserver is a StockChecker that can also hold stock while a checkout
+// completes. Checkout uses it in preference to checking availability alone.
+type StockReserver interface {
+	StockChecker
+	ReserveCart(cart *Cart, ttl time.Duration) (*Reservation, error)
+	Valid(reservationID string) bool
+	Commit(reservationID string) error
+	Release(reservationID string) error
+}
+
+// CheckoutAccounts are balances a cart draws on, such as loyalty points or
+// gift cards. Check makes sure a cart can draw what it asks for; Hold sets
+// aside what totals, the cart's totals as the order will have them, draw
+// until the order is placed, or until release is called if it is not; Record
+// takes exactly what was held for the order, releasing it even if it cannot,
+// and credits anything the order earns. Customers and GiftCards are such
+// accounts.
+type CheckoutAccounts interface {
+	Check(cart *Cart) error
+	Hold(cart *Cart, totals CartTotals) (release func(), err error)
+	Record(order *Order) error
+}
+
+// Checkout validates and places orders. When Prices is set, price changes
+// that have fallen due are applied before a cart is checked, so an order
+// never goes through at a price that has ended. Carts in a currency of their
+// own take the exchange rates in force when they are checked. Carts for a
+// customer go through Accounts, when it is set, so their points are redeemed
+// and earned, and carts paid partly by gift card go through GiftCards.
+type Checkout struct {
+	Stock StockChecker
+	Numbers *OrderSequence
+	Prices PriceSchedule
+	Accounts CheckoutAccounts
+	GiftCards CheckoutAccounts
+	ReservationTTL time.Duration
+	Now func() time.Time
+}
+
+func NewCheckout(stock StockChecker, numbers *OrderSequence) *Checkout {
+	return &Checkout{ Stock: stock, Numbers: numbers, ReservationTTL: 15 * time.Minute, Now: time.Now }
+}
+
+// Validate checks that every line is still in the catalog at the price the
+// cart recorded and that there is enough stock to fill it.
+func (checkout *Checkout) Validate(cart *Cart) error {
+	return checkout.validate(cart, checkout.Now())
+}
+
+func (checkout *Checkout) validate(cart *Cart, now time.Time) error {
+	if err := checkout.validatePrices(cart, now); err != nil {
+		return err
+	}
+	return checkout.checkStock(cart)
+}
+
+// validatePrices checks the cart against the prices in force at now. With
+// Prices set, those are the prices its history gives for that moment.
+func (checkout *Checkout) validatePrices(cart *Cart, now time.Time) error {
+	if len(cart.lines) == 0 {
+		return ErrEmptyCart
+	}
+	if checkout.Prices != nil {
+		if _, err := checkout.Prices.Update(); err != nil {
+			return err
+		}
+	}
+	if err := cart.updateRates(now); err != nil {
+		return err
+	}
+	for _, accounts := range checkout.accounts(cart) {
+		if err := accounts.Check(cart); err != nil {
+			return err
+		}
+	}
+	changed := []string{}
+	for _, line := range cart.lines {
+		item, found := cart.catalog.Get(line.SKU)
+		if !found || checkout.priceAt(item, line.SKU, now) != line.UnitPrice {
+			changed = append(changed, line.SKU)
+			continue
+		}
+		if deal, found := cart.deals[line.SKU]; found && deal.Product != item.BaseProduct() {
+			changed = append(changed, line.SKU)
+		}
+	}
+	if len(changed) > 0 {
+		return &PriceChangedError{ changed }
+	}
+	return nil
+}
+
+func (checkout *Checkout) priceAt(item Item, sku string, at time.Time) Money {
+	if checkout.Prices != nil {
+		if price, found := checkout.Prices.PriceAt(sku, at); found {
+			return price
+		}
+	}
+	return item.BaseProduct().price
+}
+
+func (checkout *Checkout) checkStock(cart *Cart) error {
+	quantities := cart.stockQuantities()
+	skus := make([]string, 0, len(quantities))
+	for sku := range quantities {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	for _, sku := range skus {
+		if available := checkout.Stock.Available(sku); available < quantities[sku] {
+			return &OutOfStockError{ SKU: sku, Requested: quantities[sku], Available: available }
+		}
+	}
+	return nil
+}
+
+// Reserve holds stock for a cart while the customer completes checkout. The
+// reservation lapses after ReservationTTL unless the order is placed.
+func (checkout *Checkout) Reserve(cart *Cart) (*Reservation, error) {
+	return checkout.reserve(cart, checkout.Now())
+}
+
+func (checkout *Checkout) reserve(cart *Cart, now time.Time) (*Reservation, error) {
+	reserver, canReserve := checkout.Stock.(StockReserver)
+	if !canReserve {
+		return nil, fmt.Errorf("store: the stock source cannot hold reservations")
+	}
+	if err := checkout.validatePrices(cart, now); err != nil {
+		return nil, err
+	}
+	if cart.reservation != "" {
+		reserver.Release(cart.reservation)
+		cart.reservation = ""
+	}
+	reservation, err := reserver.ReserveCart(cart, checkout.ReservationTTL)
+	if err != nil {
+		return nil, err
+	}
+	cart.reservation = reservation.ID
+	return reservation, nil
+}
+
+// Place turns a valid cart into an order. When the stock source supports
+// reservations, the cart's reservation is used, or a new one taken, and then
+// committed so the stock leaves the inventory with the order. Every line is
+// checked and priced at the one moment the order is placed, against the
+// price history when Prices is set. The order is placed even if its points or gift card payments cannot be recorded
+// afterwards; the error is returned with it.
+func (checkout *Checkout) Place(cart *Cart) (*Order, error) {
+	now := checkout.Now()
+	reserver, canReserve := checkout.Stock.(StockReserver)
+	if !canReserve {
+		if err := checkout.validate(cart, now); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := checkout.validatePrices(cart, now); err != nil {
+			return nil, err
+		}
+		if cart.reservation == "" || !reserver.Valid(cart.reservation) {
+			if _, err := checkout.reserve(cart, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+	totals := cart.Totals(now)
+	accounts := checkout.accounts(cart)
+	releases := []func(){}
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for _, a := range accounts {
+		r, err := a.Hold(cart, totals)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	if canReserve {
+		if err := reserver.Commit(cart.reservation); err != nil {
+			release()
+			return nil, err
+		}
+		cart.reservation = ""
+	}
+	order := &Order{
+		number: checkout.Numbers.Next(),
+		status: OrderPlaced,
+		placed: now,
+		location: cart.Location,
+		lines: copyLines(totals.Lines),
+		totals: totals,
+		customer: cart.customer,
+	}
+	order.totals.Lines = nil
+	var err error
+	for _, a := range accounts {
+		if recorded := a.Record(order); recorded != nil && err == nil {
+			err = recorded
+		}
+	}
+	return order, err
+}
+
+// accounts lists the accounts cart draws on, points before gift cards, since
+// the gift cards pay what is left after the points.
+func (checkout *Checkout) accounts(cart *Cart) []CheckoutAccounts {
+	accounts := []CheckoutAccounts{}
+	if checkout.Accounts != nil && cart.customer != "" {
+		accounts = append(accounts, checkout.Accounts)
+	}
+	if checkout.GiftCards != nil && len(cart.giftCards) > 0 {
+		accounts = append(accounts, checkout.GiftCards)
+	}
+	return accounts
+}
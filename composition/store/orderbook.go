@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Orders keeps placed orders so they can be looked up by number.
+type Orders interface {
+	Add(order *Order) error
+	Get(number string) (*Order, bool)
+	List() []*Order
+}
+
+// OrderBook holds orders in the order they were placed.
+type OrderBook struct {
+	mutex sync.Mutex
+	orders []*Order
+}
+
+func NewOrderBook() *OrderBook {
+	return &OrderBook{}
+}
+
+// Add records an order. A number can only be used once.
+func (book *OrderBook) Add(order *Order) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.add(order)
+}
+
+func (book *OrderBook) add(order *Order) error {
+	if _, found := book.get(order.number); found {
+		return fmt.Errorf("store: there is already an order %v", order.number)
+	}
+	book.orders = append(book.orders, order)
+	return nil
+}
+
+func (book *OrderBook) get(number string) (*Order, bool) {
+	for _, order := range book.orders {
+		if order.number == number {
+			return order, true
+		}
+	}
+	return nil, false
+}
+
+func (book *OrderBook) Get(number string) (*Order, bool) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.get(number)
+}
+
+func (book *OrderBook) List() []*Order {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return append([]*Order{}, book.orders...)
+}
+
+// FileOrderBook keeps the orders in a JSON file, so they can still be looked
+// up, invoiced and returned after a restart.
+type FileOrderBook struct {
+	*OrderBook
+	file *sharedFile
+}
+
+func OpenFileOrderBook(path string) (*FileOrderBook, error) {
+	book := &FileOrderBook{ OrderBook: NewOrderBook() }
+	book.file = newSharedFile(path, book.load)
+	if err := book.file.reload(); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func (book *FileOrderBook) load(data []byte) error {
+	orders := []*Order{}
+	if err := json.Unmarshal(data, &orders); err != nil {
+		return err
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.orders = orders
+	return nil
+}
+
+func (book *FileOrderBook) Add(order *Order) error {
+	return book.file.change(func() error {
+		book.mutex.Lock()
+		defer book.mutex.Unlock()
+		if err := book.add(order); err != nil {
+			return err
+		}
+		if err := book.file.write(book.orders); err != nil {
+			book.orders = book.orders[:len(book.orders) - 1]
+			return err
+		}
+		return nil
+	})
+}
+
+// lastNumber returns the highest count among numbers such as ORD-000042
+// that start with prefix, or 0 if there are none.
+func lastNumber(prefix string, numbers ...string) int {
+	last := 0
+	for _, number := range numbers {
+		var count int
+		if !strings.HasPrefix(number, prefix + "-") {
+			continue
+		}
+		if _, err := fmt.Sscanf(number[len(prefix) + 1:], "%d", &count); err == nil && count > last {
+			last = count
+		}
+	}
+	return last
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	pdfLinesPerPage = 64
+	pdfFontSize = 9
+	pdfLeading = 12
+)
+
+// winAnsi holds the characters outside ASCII that the standard PDF fonts
+// can show, in the WinAnsi encoding.
+var winAnsi = map[rune]byte{ '€': 0x80, '–': 0x96, '—': 0x97, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94 }
+
+func pdfText(line string) string {
+	var text strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			text.WriteByte('\\')
+			text.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			text.WriteRune(r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&text, "\\%03o", winAnsi[r])
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&text, "\\%03o", r)
+		default:
+			text.WriteByte('?')
+		}
+	}
+	return text.String()
+}
+
+// writePDF writes lines of fixed-width text onto A4 pages. It uses Courier,
+// one of the fonts every PDF reader has, so no font needs to be embedded.
+func writePDF(w io.Writer, title string, lines []string) error {
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%v\nendobj\n", len(offsets), body)
+	}
+	pages := (len(lines) + pdfLinesPerPage - 1) / pdfLinesPerPage
+	if pages == 0 {
+		pages = 1
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 to 4 are the catalog, the page tree, the font and the
+	// document information; each page then takes two, itself and its text.
+	kids := make([]string, pages)
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", 5 + 2 * i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%v] /Count %d >>", strings.Join(kids, " "), pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%v) /Producer (composition store) >>", pdfText(title)))
+	for page := 0; page < pages; page++ {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n50 792 Td\n", pdfFontSize, pdfLeading)
+		end := (page + 1) * pdfLinesPerPage
+		if end > len(lines) {
+			end = len(lines)
+		}
+		for _, line := range lines[page * pdfLinesPerPage:end] {
+			fmt.Fprintf(&content, "(%v) '\n", pdfText(line))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> "+
+			"/Contents %d 0 R >>", 6 + 2 * page))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%v\nendstream", content.Len(), content.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets) + 1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets) + 1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriceVersion is the price an item has from Effective on. An item's first
+// version has a zero Effective time and stands for the price it had before
+// any change was recorded.
+type PriceVersion struct {
+	Price Money `json:"price"`
+	Effective time.Time `json:"effective"`
+}
+
+// repriced copies item at a new price. Items whose price follows from other
+// items cannot be repriced directly.
+func repriced(item Item, price Money) (Item, error) {
+	p := item.BaseProduct()
+	product := &Product{ p.Name, p.Category, price }
+	switch i := item.(type) {
+	case *Product:
+		return product, nil
+	case *Boat:
+		return &Boat{ product, i.Capacity, i.Motorized }, nil
+	case *RentalBoat:
+		return &RentalBoat{ &Boat{ product, i.Capacity, i.Motorized }, i.IncludeCrew, i.Crew }, nil
+	case *VariantProduct:
+		copied := NewVariantProduct(p.Name, p.Category, price, i.Axes...)
+		for _, v := range i.variants {
+			if _, err := copied.AddVariant(v.SKU, v.Options, v.Delta, v.Stock); err != nil {
+				return nil, err
+			}
+		}
+		return copied, nil
+	case *Bundle:
+		if i.FixedPrice {
+			return i.withPrice(price), nil
+		}
+		return nil, fmt.Errorf("store: bundle %v is priced from its components", p.Name)
+	case *Variant:
+		return nil, fmt.Errorf("store: %v is priced from %v, change that instead", p.Name, i.Parent.Name)
+	}
+	return nil, fmt.Errorf("store: cannot change the price of %v", p.Name)
+}
+
+// PriceSchedule is what the services need of a price history.
+type PriceSchedule interface {
+	Put(sku string, item Item) error
+	Schedule(sku string, price Money, effective time.Time) error
+	Cancel(sku string, effective time.Time) error
+	Update() ([]string, error)
+	PriceAt(sku string, at time.Time) (Money, bool)
+	Versions(sku string) []PriceVersion
+	Report(from, to time.Time) []CategoryPriceChanges
+}
+
+// PriceHistory keeps the prices each catalog item has had and is scheduled
+// to have. A change reaches the catalog when it falls due: at once if it is
+// effective now or earlier, otherwise on the first call to Update after its
+// time.
+type PriceHistory struct {
+	mutex sync.Mutex
+	catalog Catalog
+	versions map[string][]PriceVersion
+	Now func() time.Time
+}
+
+func NewPriceHistory(catalog Catalog) *PriceHistory {
+	return &PriceHistory{ catalog: catalog, versions: map[string][]PriceVersion{}, Now: time.Now }
+}
+
+// Schedule sets the price of sku from effective on, replacing any change
+// already set for that moment. A zero effective time means now.
+func (history *PriceHistory) Schedule(sku string, price Money, effective time.Time) error {
+	item, found := history.catalog.Get(sku)
+	if !found {
+		return fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	current := item.BaseProduct().price
+	if price.currency != current.currency {
+		return fmt.Errorf("store: %v is priced in %v, not %v", sku, current.currency, price.currency)
+	}
+	if price.IsNegative() {
+		return fmt.Errorf("store: %v cannot cost less than nothing", sku)
+	}
+	if _, err := repriced(item, price); err != nil {
+		return err
+	}
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	if effective.IsZero() {
+		effective = history.Now()
+	}
+	restore := history.record(sku, current, price, effective)
+	if _, err := history.update(sku); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// record adds a version for sku, starting the history at current if there is
+// none, and returns a function that undoes it. The history must be locked.
+func (history *PriceHistory) record(sku string, current, price Money, effective time.Time) func() {
+	previous, recorded := history.versions[sku]
+	versions := append([]PriceVersion{}, previous...)
+	if len(versions) == 0 {
+		versions = []PriceVersion{ { current, time.Time{} } }
+	}
+	i := sort.Search(len(versions), func(i int) bool { return !versions[i].Effective.Before(effective) })
+	if i < len(versions) && versions[i].Effective.Equal(effective) {
+		versions[i].Price = price
+	} else {
+		versions = append(versions[:i], append([]PriceVersion{ { price, effective } }, versions[i:]...)...)
+	}
+	history.versions[sku] = versions
+	return func() {
+		if recorded {
+			history.versions[sku] = previous
+		} else {
+			delete(history.versions, sku)
+		}
+	}
+}
+
+// Put puts item in the catalog under sku. If it replaces an item at a
+// different price, the new price is recorded as effective now, so editing a
+// product keeps its old price on record.
+func (history *PriceHistory) Put(sku string, item Item) error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	restore := func() {}
+	if old, found := history.catalog.Get(sku); found && item != nil && item.BaseProduct() != nil {
+		current, price := old.BaseProduct().price, item.BaseProduct().price
+		_, fixed := repriced(old, price)
+		_, stillFixed := repriced(item, price)
+		if current != price && current.currency == price.currency && fixed == nil && stillFixed == nil {
+			restore = history.record(sku, current, price, history.Now())
+		}
+	}
+	if err := history.catalog.Put(sku, item); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// Cancel drops a change that has not yet taken effect.
+func (history *PriceHistory) Cancel(sku string, effective time.Time) error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	versions := history.versions[sku]
+	for i, version := range versions {
+		if !version.Effective.Equal(effective) || version.Effective.IsZero() {
+			continue
+		}
+		if !version.Effective.After(history.Now()) {
+			return fmt.Errorf("store: the price of %v set for %v has already taken effect", sku,
+				effective.Format(time.RFC3339))
+		}
+		history.versions[sku] = append(versions[:i], versions[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("store: no price change for %v at %v", sku, effective.Format(time.RFC3339))
+}
+
+// due returns the version in force at time at.
+func due(versions []PriceVersion, at time.Time) (PriceVersion, bool) {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Effective.After(at) })
+	if i == 0 {
+		return PriceVersion{}, false
+	}
+	return versions[i - 1], true
+}
+
+// update puts sku in the catalog at the price now in force, reporting
+// whether it changed. The history must be locked.
+func (history *PriceHistory) update(sku string) (bool, error) {
+	version, found := due(history.versions[sku], history.Now())
+	item, inCatalog := history.catalog.Get(sku)
+	if !found || !inCatalog || item.BaseProduct().price == version.Price {
+		return false, nil
+	}
+	changed, err := repriced(item, version.Price)
+	if err != nil {
+		return false, err
+	}
+	return true, history.catalog.Put(sku, changed)
+}
+
+// Update applies every change that has fallen due and returns the SKUs whose
+// price changed. It is meant to be called regularly, as by Run.
+func (history *PriceHistory) Update() ([]string, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	skus := make([]string, 0, len(history.versions))
+	for sku := range history.versions {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	changed := []string{}
+	for _, sku := range skus {
+		updated, err := history.update(sku)
+		if err != nil {
+			return changed, err
+		}
+		if updated {
+			changed = append(changed, sku)
+		}
+	}
+	return changed, nil
+}
+
+// Run calls Update every interval until stop is closed, passing any error to
+// report.
+func (history *PriceHistory) Run(interval time.Duration, stop <-chan struct{}, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := history.Update(); err != nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// PriceAt returns the price sku had, or is set to have, at time at. Items
+// with no recorded changes, and items priced from others, report the price
+// the catalog holds now.
+func (history *PriceHistory) PriceAt(sku string, at time.Time) (Money, bool) {
+	history.mutex.Lock()
+	version, found := due(history.versions[sku], at)
+	history.mutex.Unlock()
+	if found {
+		return version.Price, true
+	}
+	if item, inCatalog := history.catalog.Get(sku); inCatalog {
+		return item.BaseProduct().price, true
+	}
+	return Money{}, false
+}
+
+// Versions lists the prices of sku in order, including those scheduled.
+func (history *PriceHistory) Versions(sku string) []PriceVersion {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	if versions, found := history.versions[sku]; found {
+		return append([]PriceVersion{}, versions...)
+	}
+	if item, found := history.catalog.Get(sku); found {
+		return []PriceVersion{ { item.BaseProduct().price, time.Time{} } }
+	}
+	return []PriceVersion{}
+}
+
+type PriceChange struct {
+	SKU string `json:"sku"`
+	Name string `json:"name"`
+	Old Money `json:"old"`
+	New Money `json:"new"`
+	Effective time.Time `json:"effective"`
+}
+
+// CategoryPriceChanges is one category's part of a price change report.
+type CategoryPriceChanges struct {
+	Category string `json:"category"`
+	Changes []PriceChange `json:"changes"`
+	Increases int `json:"increases"`
+	Decreases int `json:"decreases"`
+}
+
+// Report lists the price changes effective from from up to, but not
+// including, to, grouped by category. Scheduled changes in the period are
+// included. Items no longer in the catalog are reported by SKU alone.
+func (history *PriceHistory) Report(from, to time.Time) []CategoryPriceChanges {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	categories := map[string]*CategoryPriceChanges{}
+	for sku, versions := range history.versions {
+		name, category := sku, ""
+		if item, found := history.catalog.Get(sku); found {
+			name, category = item.BaseProduct().Name, item.BaseProduct().Category
+		}
+		for i := 1; i < len(versions); i++ {
+			change := PriceChange{ sku, name, versions[i - 1].Price, versions[i].Price, versions[i].Effective }
+			if change.Effective.Before(from) || !change.Effective.Before(to) || change.Old == change.New {
+				continue
+			}
+			report, found := categories[strings.ToLower(category)]
+			if !found {
+				report = &CategoryPriceChanges{ Category: category, Changes: []PriceChange{} }
+				categories[strings.ToLower(category)] = report
+			}
+			report.Changes = append(report.Changes, change)
+			if change.New.minor > change.Old.minor {
+				report.Increases++
+			} else {
+				report.Decreases++
+			}
+		}
+	}
+	reports := []CategoryPriceChanges{}
+	for _, report := range categories {
+		sort.Slice(report.Changes, func(i, j int) bool {
+			a, b := report.Changes[i], report.Changes[j]
+			if !a.Effective.Equal(b.Effective) {
+				return a.Effective.Before(b.Effective)
+			}
+			return a.SKU < b.SKU
+		})
+		reports = append(reports, *report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Category < reports[j].Category })
+	return reports
+}
+
+// FilePriceHistory is a PriceHistory that rewrites a JSON file whenever a
+// change is scheduled or cancelled.
+type FilePriceHistory struct {
+	*PriceHistory
+	file *sharedFile
+}
+
+func OpenFilePriceHistory(path string, catalog Catalog) (*FilePriceHistory, error) {
+	history := &FilePriceHistory{ PriceHistory: NewPriceHistory(catalog) }
+	history.file = newSharedFile(path, history.load)
+	if err := history.file.reload(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (history *FilePriceHistory) load(data []byte) error {
+	versions := map[string][]PriceVersion{}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return err
+	}
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	history.versions = versions
+	return nil
+}
+
+func (history *FilePriceHistory) Schedule(sku string, price Money, effective time.Time) error {
+	return history.file.change(func() error {
+		if err := history.PriceHistory.Schedule(sku, price, effective); err != nil {
+			return err
+		}
+		return history.save()
+	})
+}
+
+func (history *FilePriceHistory) Put(sku string, item Item) error {
+	return history.file.change(func() error {
+		if err := history.PriceHistory.Put(sku, item); err != nil {
+			return err
+		}
+		return history.save()
+	})
+}
+
+func (history *FilePriceHistory) Cancel(sku string, effective time.Time) error {
+	return history.file.change(func() error {
+		if err := history.PriceHistory.Cancel(sku, effective); err != nil {
+			return err
+		}
+		return history.save()
+	})
+}
+
+func (history *FilePriceHistory) save() error {
+	history.mutex.Lock()
+	versions := make(map[string][]PriceVersion, len(history.versions))
+	for sku, v := range history.versions {
+		versions[sku] = v
+	}
+	history.mutex.Unlock()
+	return history.file.write(versions)
+}
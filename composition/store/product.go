@@ -1,14 +1,32 @@
 package store
 
+import "time"
+
 type Product struct {
 	Name, Category string
-	price float64
+	price Money
 }
 
-func NewProduct(name, category string, price float64) *Product {
+func NewProduct(name, category string, price Money) *Product {
 	return &Product{ name, category, price }
 }
 
-func (p *Product) Price(taxRate float64) float64 {
-	return p.price + (p.price * taxRate)
-}
\ No newline at end of file
+func (p *Product) Price(taxes TaxPolicy, location Location) Money {
+	return p.PriceBreakdown(taxes, location).Gross
+}
+
+func (p *Product) PriceBreakdown(taxes TaxPolicy, location Location) TaxBreakdown {
+	return taxes.Apply(p.price, p.Category, location, Sale)
+}
+
+// PriceIn is Price for a customer paying in currency. The net price is
+// converted at the rate in force at time at and taxed afterwards, so the tax
+// is worked out on the amount the customer actually pays.
+func (p *Product) PriceIn(currency string, rates *ExchangeRates, at time.Time, taxes TaxPolicy,
+	location Location) (Money, ExchangeRate, error) {
+	net, rate, err := rates.Convert(p.price, currency, at)
+	if err != nil {
+		return Money{}, rate, err
+	}
+	return taxes.Apply(net, p.Category, location, Sale).Gross, rate, nil
+}
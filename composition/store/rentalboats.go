@@ -1,5 +1,11 @@
 package store
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 type Crew struct {
 	Captain, FirstOfficer string
 }
@@ -10,6 +16,31 @@ type RentalBoat struct {
 	*Crew
 }
 
-func NewRentalBoat(name string, price float64, capacity int, motorized, crewed bool, captain, firstOfficer string) *RentalBoat{
-	return &RentalBoat{ NewBoat(name, price, capacity, motorized), crewed, &Crew{ captain, firstOfficer } }
+// placeholderName matches the names people type when a boat has no crew.
+var placeholderName = regexp.MustCompile(`(?i)^(n/?a|none|nobody|no crew|tb[acd]|unknown|null|nil|[-?.]+)$`)
+
+// NewRentalBoat names the boat's captain and first officer, who may both be
+// left empty. Placeholders such as "N/A" are rejected, as is a first officer
+// without a captain.
+func NewRentalBoat(name string, price Money, capacity int, motorized, crewed bool,
+		captain, firstOfficer string) (*RentalBoat, error) {
+	captain, firstOfficer = strings.TrimSpace(captain), strings.TrimSpace(firstOfficer)
+	for _, crewName := range []string{ captain, firstOfficer } {
+		if placeholderName.MatchString(crewName) {
+			return nil, fmt.Errorf("store: %q is not a crew member's name; leave it empty if %v has no crew",
+				crewName, name)
+		}
+	}
+	if captain == "" && firstOfficer != "" {
+		return nil, fmt.Errorf("store: %v has a first officer but no captain", name)
+	}
+	return &RentalBoat{ NewBoat(name, price, capacity, motorized), crewed, &Crew{ captain, firstOfficer } }, nil
+}
+
+func (r *RentalBoat) Price(taxes TaxPolicy, location Location) Money {
+	return r.PriceBreakdown(taxes, location).Gross
+}
+
+func (r *RentalBoat) PriceBreakdown(taxes TaxPolicy, location Location) TaxBreakdown {
+	return taxes.Apply(r.price, r.Category, location, Rental)
 }
\ No newline at end of file
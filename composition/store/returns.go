@@ -0,0 +1,601 @@
+package store
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved ReturnStatus = "approved"
+	ReturnRejected ReturnStatus = "rejected"
+	ReturnReceived ReturnStatus = "received"
+	ReturnCompleted ReturnStatus = "completed"
+	ReturnCancelled ReturnStatus = "cancelled"
+)
+
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnRequested: { ReturnApproved, ReturnRejected, ReturnCancelled },
+	ReturnApproved: { ReturnReceived, ReturnCancelled },
+	ReturnReceived: { ReturnCompleted },
+}
+
+// ReturnLine sends back Quantity units of an order line. A line with
+// ExchangeFor set swaps them for another variant of the same product rather
+// than refunding them. Damaged is filled in when the goods arrive.
+type ReturnLine struct {
+	SKU string `json:"sku"`
+	Quantity int `json:"quantity"`
+	ExchangeFor string `json:"exchangeFor,omitempty"`
+	Damaged int `json:"damaged"`
+}
+
+// StockMovement is stock a return put back on the shelves, wrote off or
+// sent out as a replacement.
+type StockMovement struct {
+	SKU string `json:"sku"`
+	Quantity int `json:"quantity"`
+}
+
+// Return follows goods a customer sends back from an order. Once completed
+// it records the refund, worked out from the order's own discounts and
+// taxes, the part of it given back to the gift cards that paid, the loyalty
+// points given back and where the goods went.
+type Return struct {
+	ID string `json:"id"`
+	Order string `json:"order"`
+	Customer string `json:"customer,omitempty"`
+	Status ReturnStatus `json:"status"`
+	Reason string `json:"reason"`
+	Note string `json:"note,omitempty"`
+	Lines []ReturnLine `json:"lines"`
+	Requested time.Time `json:"requested"`
+	Updated time.Time `json:"updated"`
+	Refunds []InvoiceLine `json:"refunds,omitempty"`
+	Refund Money `json:"refund"`
+	CreditNote string `json:"creditNote,omitempty"`
+	GiftCards []GiftCardPayment `json:"giftCards,omitempty"`
+	Points int `json:"points,omitempty"`
+	Restocked []StockMovement `json:"restocked,omitempty"`
+	WrittenOff []StockMovement `json:"writtenOff,omitempty"`
+	Replacements []StockMovement `json:"replacements,omitempty"`
+}
+
+func (r Return) copied() Return {
+	r.Lines = append([]ReturnLine{}, r.Lines...)
+	r.Refunds = append([]InvoiceLine(nil), r.Refunds...)
+	r.GiftCards = append([]GiftCardPayment(nil), r.GiftCards...)
+	r.Restocked = append([]StockMovement(nil), r.Restocked...)
+	r.WrittenOff = append([]StockMovement(nil), r.WrittenOff...)
+	r.Replacements = append([]StockMovement(nil), r.Replacements...)
+	return r
+}
+
+// ReturnStock is the stock that returns go back into and replacements come
+// out of. Inventory is one.
+type ReturnStock interface {
+	Receive(sku, location string, quantity int) error
+	Reserve(quantities map[string]int, ttl time.Duration) (*Reservation, error)
+	Commit(id string) error
+	Release(id string) error
+}
+
+// ReturnAccounts give back what a completed return refunds to them, such as
+// the points redeemed on the returned goods or what gift cards paid for
+// them. Refund may be passed the same return again when completing it is
+// retried, and must give it back only once. Customers and GiftCards are such
+// accounts.
+type ReturnAccounts interface {
+	Refund(r Return) error
+}
+
+// ReturnDesk handles returns against orders. When Stock is set, sound goods
+// go back in at Location and replacements are taken from it; when Invoices
+// is set, refunds on invoiced orders are issued as credit notes. Accounts
+// are given back the points and gift card payments a refund returns. Orders
+// finds the orders of returns made by another process.
+type ReturnDesk struct {
+	mutex sync.Mutex
+	catalog Catalog
+	returns map[string]*Return
+	orders map[string]*Order
+	last int
+	Stock ReturnStock
+	Location string
+	Invoices Invoices
+	Accounts []ReturnAccounts
+	Orders Orders
+	Now func() time.Time
+	keep func() error
+	acquire func() (func(), error)
+}
+
+func NewReturnDesk(catalog Catalog) *ReturnDesk {
+	return &ReturnDesk{ catalog: catalog, returns: map[string]*Return{}, orders: map[string]*Order{},
+		Location: "Returns", Now: time.Now,
+		keep: func() error { return nil }, acquire: func() (func(), error) { return func() {}, nil } }
+}
+
+// lock locks the desk for a change. For returns kept in a file it also locks
+// the file and reads back what another process saved there.
+func (desk *ReturnDesk) lock() (func(), error) {
+	desk.mutex.Lock()
+	release, err := desk.acquire()
+	if err != nil {
+		desk.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		desk.mutex.Unlock()
+	}, nil
+}
+
+// view locks the desk to read it, first reading back the file if another
+// process has changed it. If the file cannot be read, what was last read is
+// used.
+func (desk *ReturnDesk) view() func() {
+	if unlock, err := desk.lock(); err == nil {
+		return unlock
+	}
+	desk.mutex.Lock()
+	return desk.mutex.Unlock
+}
+
+// order finds the order a return was made against. The desk must be locked.
+func (desk *ReturnDesk) order(r *Return) (*Order, error) {
+	if order, found := desk.orders[r.ID]; found {
+		return order, nil
+	}
+	if desk.Orders != nil {
+		if order, found := desk.Orders.Get(r.Order); found {
+			desk.orders[r.ID] = order
+			return order, nil
+		}
+	}
+	return nil, fmt.Errorf("store: order %v of return %v cannot be found", r.Order, r.ID)
+}
+
+func orderLine(order *Order, sku string) (PricedLine, bool) {
+	for _, line := range order.Lines() {
+		if line.SKU == sku {
+			return line, true
+		}
+	}
+	return PricedLine{}, false
+}
+
+// returned counts the units of each SKU of an order in returns that are
+// still open, or that completed returns refunded when refundedOnly is set.
+func (desk *ReturnDesk) returned(order string, refundedOnly bool) map[string]int {
+	counts := map[string]int{}
+	for _, r := range desk.returns {
+		if r.Order != order || r.Status == ReturnRejected || r.Status == ReturnCancelled ||
+			(refundedOnly && r.Status != ReturnCompleted) {
+			continue
+		}
+		for _, line := range r.Lines {
+			if !refundedOnly || line.ExchangeFor == "" {
+				counts[line.SKU] += line.Quantity
+			}
+		}
+	}
+	return counts
+}
+
+// checkExchange makes sure a line can be swapped: both SKUs must be variants
+// of the same product at the same price.
+func (desk *ReturnDesk) checkExchange(line ReturnLine) error {
+	item, _ := desk.catalog.Get(line.SKU)
+	wanted, _ := desk.catalog.Get(line.ExchangeFor)
+	from, isVariant := item.(*Variant)
+	to, wantedVariant := wanted.(*Variant)
+	if !isVariant || !wantedVariant || from.Parent != to.Parent || from.SKU == to.SKU {
+		return fmt.Errorf("store: %v can only be exchanged for another variant of the same product, not %v",
+			line.SKU, line.ExchangeFor)
+	}
+	if from.price != to.price {
+		return fmt.Errorf("store: %v costs %v and %v costs %v, return it for a refund instead", from.SKU, from.price,
+			to.SKU, to.price)
+	}
+	return nil
+}
+
+// Request opens a return for lines of an order.
+func (desk *ReturnDesk) Request(order *Order, reason string, lines ...ReturnLine) (Return, error) {
+	if order.Status() == OrderCancelled {
+		return Return{}, fmt.Errorf("store: order %v was cancelled", order.Number())
+	}
+	lines = append([]ReturnLine{}, lines...)
+	if len(lines) == 0 {
+		return Return{}, fmt.Errorf("store: a return needs at least one line")
+	}
+	unlock, err := desk.lock()
+	if err != nil {
+		return Return{}, err
+	}
+	defer unlock()
+	returned := desk.returned(order.Number(), false)
+	seen := map[string]bool{}
+	for i, line := range lines {
+		ordered, found := orderLine(order, line.SKU)
+		if !found {
+			return Return{}, fmt.Errorf("store: %v is not on order %v", line.SKU, order.Number())
+		}
+		if seen[line.SKU] {
+			return Return{}, fmt.Errorf("store: %v is listed more than once", line.SKU)
+		}
+		seen[line.SKU] = true
+		if left := ordered.Quantity - returned[line.SKU]; line.Quantity < 1 || line.Quantity > left {
+			return Return{}, fmt.Errorf("store: cannot return %v of %v, %v of %v can still be returned", line.Quantity,
+				line.SKU, left, ordered.Quantity)
+		}
+		if line.ExchangeFor != "" {
+			if err := desk.checkExchange(line); err != nil {
+				return Return{}, err
+			}
+		}
+		lines[i].Damaged = 0
+	}
+	desk.last++
+	now := desk.Now()
+	r := &Return{ ID: fmt.Sprintf("RMA-%06d", desk.last), Order: order.Number(), Customer: order.Customer(),
+		Status: ReturnRequested, Reason: reason, Lines: lines, Requested: now, Updated: now }
+	desk.returns[r.ID] = r
+	desk.orders[r.ID] = order
+	if err := desk.keep(); err != nil {
+		delete(desk.returns, r.ID)
+		delete(desk.orders, r.ID)
+		desk.last--
+		return Return{}, err
+	}
+	return r.copied(), nil
+}
+
+// move takes a return to status if its current status allows it.
+func (desk *ReturnDesk) move(id string, status ReturnStatus) (*Return, error) {
+	r, found := desk.returns[id]
+	if !found {
+		return nil, fmt.Errorf("store: no return %v", id)
+	}
+	for _, allowed := range returnTransitions[r.Status] {
+		if allowed == status {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("store: return %v cannot go from %v to %v", id, r.Status, status)
+}
+
+func (desk *ReturnDesk) transition(id string, status ReturnStatus, note string) (Return, error) {
+	unlock, err := desk.lock()
+	if err != nil {
+		return Return{}, err
+	}
+	defer unlock()
+	r, err := desk.move(id, status)
+	if err != nil {
+		return Return{}, err
+	}
+	previous := *r
+	r.Status, r.Updated = status, desk.Now()
+	if note != "" {
+		r.Note = note
+	}
+	if err := desk.keep(); err != nil {
+		*r = previous
+		return Return{}, err
+	}
+	return r.copied(), nil
+}
+
+func (desk *ReturnDesk) Approve(id string) (Return, error) {
+	return desk.transition(id, ReturnApproved, "")
+}
+
+func (desk *ReturnDesk) Reject(id, note string) (Return, error) {
+	return desk.transition(id, ReturnRejected, note)
+}
+
+func (desk *ReturnDesk) Cancel(id string) (Return, error) {
+	return desk.transition(id, ReturnCancelled, "")
+}
+
+// Receive books the goods in. damaged gives the units of each SKU that
+// arrived unfit to sell again; those are written off and the rest restocked.
+// Bundles go back as their components. If the goods cannot all be booked
+// in, or the return cannot be kept, those already booked in are taken out
+// again.
+func (desk *ReturnDesk) Receive(id string, damaged map[string]int) (Return, error) {
+	unlock, err := desk.lock()
+	if err != nil {
+		return Return{}, err
+	}
+	defer unlock()
+	r, err := desk.move(id, ReturnReceived)
+	if err != nil {
+		return Return{}, err
+	}
+	lines := append([]ReturnLine{}, r.Lines...)
+	for sku, count := range damaged {
+		found := false
+		for i := range lines {
+			if lines[i].SKU == sku {
+				if count < 0 || count > lines[i].Quantity {
+					return Return{}, fmt.Errorf("store: %v of %v cannot be damaged, %v came back", count, sku,
+						lines[i].Quantity)
+				}
+				lines[i].Damaged, found = count, true
+			}
+		}
+		if !found {
+			return Return{}, fmt.Errorf("store: %v is not on return %v", sku, id)
+		}
+	}
+	order, err := desk.order(r)
+	if err != nil {
+		return Return{}, err
+	}
+	restocked, writtenOff := map[string]int{}, map[string]int{}
+	for _, line := range lines {
+		ordered, _ := orderLine(order, line.SKU)
+		for _, part := range stockParts(ordered, line.Quantity - line.Damaged) {
+			restocked[part.SKU] += part.Quantity
+		}
+		for _, part := range stockParts(ordered, line.Damaged) {
+			writtenOff[part.SKU] += part.Quantity
+		}
+	}
+	booked := []StockMovement{}
+	if desk.Stock != nil {
+		for _, movement := range movements(restocked) {
+			if err := desk.Stock.Receive(movement.SKU, desk.Location, movement.Quantity); err != nil {
+				desk.unstock(booked)
+				return Return{}, err
+			}
+			booked = append(booked, movement)
+		}
+	}
+	previous := *r
+	r.Lines, r.Restocked, r.WrittenOff = lines, movements(restocked), movements(writtenOff)
+	r.Status, r.Updated = ReturnReceived, desk.Now()
+	if err := desk.keep(); err != nil {
+		*r = previous
+		desk.unstock(booked)
+		return Return{}, err
+	}
+	return r.copied(), nil
+}
+
+// unstock takes goods booked in at the desk's location out again.
+func (desk *ReturnDesk) unstock(booked []StockMovement) {
+	for _, movement := range booked {
+		desk.Stock.Receive(movement.SKU, desk.Location, -movement.Quantity)
+	}
+}
+
+// stockParts lists what quantity units of an order line hold in stock terms.
+func stockParts(line PricedLine, quantity int) []StockMovement {
+	if quantity == 0 {
+		return nil
+	}
+	if len(line.Components) == 0 {
+		return []StockMovement{ { line.SKU, quantity } }
+	}
+	parts := make([]StockMovement, len(line.Components))
+	for i, component := range line.Components {
+		parts[i] = StockMovement{ component.SKU, component.Quantity / line.Quantity * quantity }
+	}
+	return parts
+}
+
+func movements(quantities map[string]int) []StockMovement {
+	list := []StockMovement{}
+	for sku, quantity := range quantities {
+		if quantity > 0 {
+			list = append(list, StockMovement{ sku, quantity })
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SKU < list[j].SKU })
+	return list
+}
+
+// invoiceFor finds the invoice issued for an order.
+func (desk *ReturnDesk) invoiceFor(order string) (*Invoice, bool) {
+	if desk.Invoices == nil {
+		return nil, false
+	}
+	for _, invoice := range desk.Invoices.List() {
+		if invoice.Kind == KindInvoice && invoice.Order == order {
+			return invoice, true
+		}
+	}
+	return nil, false
+}
+
+// Complete settles a received return. Lines being exchanged are sent out
+// again as the chosen variant; the rest are refunded. Each refunded line
+// gives back its share of what the order charged, net of the line's
+// discounts and with its taxes, so returning every unit, over however many
+// returns, refunds the order exactly. The points redeemed and the gift card
+// payments on the order are given back to the Accounts in the same shares.
+// If the order was invoiced the refund is issued as a credit note against
+// the invoice.
+//
+// The replacements are sent out first, as they are the only step that can
+// be undone: if a later step fails they are put back and the return stays
+// received. The credit note and the refunds to the accounts are found by the
+// return's ID, so completing it again after a failure does not give anything
+// back twice.
+func (desk *ReturnDesk) Complete(id string) (Return, error) {
+	unlock, err := desk.lock()
+	if err != nil {
+		return Return{}, err
+	}
+	defer unlock()
+	r, err := desk.move(id, ReturnCompleted)
+	if err != nil {
+		return Return{}, err
+	}
+	order, err := desk.order(r)
+	if err != nil {
+		return Return{}, err
+	}
+	previous := desk.returned(r.Order, true)
+	refunds, credits, replacements := []InvoiceLine{}, []CreditLine{}, map[string]int{}
+	totals := order.Totals()
+	refund := Money{ 0, totals.Total.currency }
+	returning := map[string]int{}
+	for _, line := range r.Lines {
+		if line.ExchangeFor != "" {
+			replacements[line.ExchangeFor] += line.Quantity
+			continue
+		}
+		ordered, _ := orderLine(order, line.SKU)
+		refunded := creditLine(invoiceLine(ordered), previous[line.SKU], line.Quantity)
+		refunds = append(refunds, refunded)
+		credits = append(credits, CreditLine{ line.SKU, line.Quantity })
+		refund = refund.Add(refunded.Gross)
+		returning[line.SKU] += line.Quantity
+	}
+	completed := *r
+	completed.Refunds, completed.Refund, completed.Replacements = refunds, refund, movements(replacements)
+	completed.Points, completed.GiftCards = refundedPayments(order, previous, returning)
+	completed.Status, completed.Updated = ReturnCompleted, desk.Now()
+	var reservation *Reservation
+	if len(replacements) > 0 && desk.Stock != nil {
+		if reservation, err = desk.Stock.Reserve(replacements, time.Minute); err != nil {
+			return Return{}, err
+		}
+		if err := desk.Stock.Commit(reservation.ID); err != nil {
+			desk.Stock.Release(reservation.ID)
+			return Return{}, err
+		}
+	}
+	if err := desk.settle(&completed, credits); err != nil {
+		desk.putBack(reservation)
+		return Return{}, err
+	}
+	previousReturn := *r
+	*r = completed
+	if err := desk.keep(); err != nil {
+		*r = previousReturn
+		desk.putBack(reservation)
+		return Return{}, err
+	}
+	return r.copied(), nil
+}
+
+// settle issues the credit note for a return, or finds the one issued when
+// completing it failed before, and gives back what the accounts are owed.
+func (desk *ReturnDesk) settle(r *Return, credits []CreditLine) error {
+	if invoice, invoiced := desk.invoiceFor(r.Order); invoiced && len(credits) > 0 {
+		note, issued := desk.creditNoteFor(invoice.Number, r.ID)
+		if !issued {
+			var err error
+			if note, err = desk.Invoices.Credit(invoice.Number, fmt.Sprintf("Return %v: %v", r.ID, r.Reason), credits...); err != nil {
+				return err
+			}
+		}
+		r.CreditNote, r.Refund = note.Number, note.Total
+	}
+	for _, accounts := range desk.Accounts {
+		if err := accounts.Refund(*r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// creditNoteFor finds the credit note a return was refunded by.
+func (desk *ReturnDesk) creditNoteFor(invoice, id string) (*Invoice, bool) {
+	for _, note := range desk.Invoices.List() {
+		if note.Kind == KindCreditNote && note.Original == invoice && strings.HasPrefix(note.Reason, "Return " + id + ":") {
+			return note, true
+		}
+	}
+	return nil, false
+}
+
+// putBack returns replacements that were sent out to the shelves they came
+// from.
+func (desk *ReturnDesk) putBack(reservation *Reservation) {
+	if reservation == nil {
+		return
+	}
+	for _, line := range reservation.Lines {
+		desk.Stock.Receive(line.SKU, line.Location, line.Quantity)
+	}
+}
+
+// refundedPayments works out the points and gift card payments given back
+// for returning units of an order when refunded units were given back
+// before. Like creditLine, each is the difference between the shares after
+// and before, so returning everything gives back every point and all that
+// each card paid.
+func refundedPayments(order *Order, refunded, returning map[string]int) (int, []GiftCardPayment) {
+	totals := order.Totals()
+	var credit, creditBefore, creditAfter, grossBefore, grossAfter int64
+	for _, line := range order.Lines() {
+		invoiced := invoiceLine(line)
+		for _, d := range line.Discounts {
+			if d.Name == PointsDiscount {
+				credit += d.Amount.minor
+				creditBefore += portion(d.Amount, refunded[line.SKU], line.Quantity).minor
+				creditAfter += portion(d.Amount, refunded[line.SKU] + returning[line.SKU], line.Quantity).minor
+			}
+		}
+		grossBefore += creditLine(invoiced, 0, refunded[line.SKU]).Gross.minor
+		grossAfter += creditLine(invoiced, 0, refunded[line.SKU] + returning[line.SKU]).Gross.minor
+	}
+	points := int(share(int64(totals.Points), creditAfter, credit) - share(int64(totals.Points), creditBefore, credit))
+	cards := []GiftCardPayment{}
+	for _, payment := range totals.GiftCards {
+		amount := share(payment.Amount.minor, grossAfter, totals.Total.minor) -
+			share(payment.Amount.minor, grossBefore, totals.Total.minor)
+		if amount > 0 {
+			cards = append(cards, GiftCardPayment{ payment.Code, Money{ amount, payment.Amount.currency } })
+		}
+	}
+	return points, cards
+}
+
+// share is part of whole's share of amount, rounded down.
+func share(amount, part, whole int64) int64 {
+	if whole <= 0 || part <= 0 {
+		return 0
+	}
+	if part >= whole {
+		return amount
+	}
+	product := new(big.Int).Mul(big.NewInt(amount), big.NewInt(part))
+	return product.Quo(product, big.NewInt(whole)).Int64()
+}
+
+func (desk *ReturnDesk) Get(id string) (Return, bool) {
+	defer desk.view()()
+	r, found := desk.returns[id]
+	if !found {
+		return Return{}, false
+	}
+	return r.copied(), true
+}
+
+// List returns the returns against an order, or every return when order is
+// empty, oldest first.
+func (desk *ReturnDesk) List(order string) []Return {
+	defer desk.view()()
+	list := []Return{}
+	for _, r := range desk.returns {
+		if order == "" || r.Order == order {
+			list = append(list, r.copied())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
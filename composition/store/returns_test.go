@@ -0,0 +1,241 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+// testReturns opens a shop with kayaks and lifejackets in stock and a
+// customer who has earned 500 points on a first kayak. It places an order
+// for 3 kayaks and 2 lifejackets that redeems 300 points and takes $60 from
+// a gift card, and invoices it.
+func testReturns(t *testing.T, dir string) (*DataDir, *Order, GiftCard) {
+	t.Helper()
+	data, err := OpenDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sku, product := range map[string]*Product{
+		"KAY-1": NewProduct("Kayak", "Watersports", MustParseMoney("$100")),
+		"LIF-1": NewProduct("Lifejacket", "Watersports", MustParseMoney("$25")),
+	} {
+		if err := data.Catalog.Put(sku, product); err != nil {
+			t.Fatal(err)
+		}
+		if err := data.Inventory.Receive(sku, "Shop", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	taxes, err := NewRuleTaxPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer, err := data.Customers.Register("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := data.GiftCards.Issue(MustParseMoney("$100"), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkout := NewCheckout(data.Inventory, data.OrderNumbers("ORD"))
+	checkout.Accounts, checkout.GiftCards = data.Customers, data.GiftCards
+	place := func(points int, payment string, lines map[string]int) *Order {
+		cart := NewCart(data.Catalog, taxes, Location{ Country: "UK" })
+		for sku, quantity := range lines {
+			if err := cart.Add(sku, quantity); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := data.Customers.Apply(cart, customer.ID, points); err != nil {
+			t.Fatal(err)
+		}
+		if payment != "" {
+			if err := data.GiftCards.Apply(cart, card.Code, MustParseMoney(payment)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		order, err := checkout.Place(cart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := data.Orders.Add(order); err != nil {
+			t.Fatal(err)
+		}
+		return order
+	}
+	if err := data.Invoices.SetSeller(Party{ Name: "Boat Shop" }); err != nil {
+		t.Fatal(err)
+	}
+	place(0, "", map[string]int{ "KAY-1": 1 })
+	order := place(300, "$60", map[string]int{ "KAY-1": 3, "LIF-1": 2 })
+	if _, err := data.Invoices.Issue(order, Party{ Name: "Ann" }); err != nil {
+		t.Fatal(err)
+	}
+	return data, order, card
+}
+
+// receive requests, approves and receives a return.
+func receive(t *testing.T, desk *ReturnDesk, order *Order, lines ...ReturnLine) Return {
+	t.Helper()
+	r, err := desk.Request(order, "Too many", lines...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := desk.Approve(r.ID); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = desk.Receive(r.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// failingStock refuses to take back one SKU.
+type failingStock struct {
+	ReturnStock
+	sku string
+}
+
+func (stock failingStock) Receive(sku, location string, quantity int) error {
+	if sku == stock.sku && quantity > 0 {
+		return errors.New("shelf full")
+	}
+	return stock.ReturnStock.Receive(sku, location, quantity)
+}
+
+func TestReceiveUndoesRestocking(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(data *DataDir)
+	}{
+		{ "stock refuses", func(data *DataDir) { data.Returns.Stock = failingStock{ data.Inventory, "LIF-1" } } },
+		{ "return not saved", func(data *DataDir) { breakWrites(t, data.Returns.file) } },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, order, _ := testReturns(t, t.TempDir())
+			r, err := data.Returns.Request(order, "Unwanted", ReturnLine{ SKU: "KAY-1", Quantity: 1 },
+				ReturnLine{ SKU: "LIF-1", Quantity: 1 })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := data.Returns.Approve(r.ID); err != nil {
+				t.Fatal(err)
+			}
+			test.fail(data)
+			if _, err := data.Returns.Receive(r.ID, nil); err == nil {
+				t.Fatal("return received although its goods could not all be kept")
+			}
+			if level := data.Inventory.Level("KAY-1", "Returns"); level.OnHand != 0 {
+				t.Errorf("%v kayaks left in returns stock, want none", level.OnHand)
+			}
+			if r, _ := data.Returns.Get(r.ID); r.Status != ReturnApproved {
+				t.Errorf("return is %v, want approved", r.Status)
+			}
+		})
+	}
+}
+
+func TestCompleteRefundsPointsAndGiftCards(t *testing.T) {
+	data, order, card := testReturns(t, t.TempDir())
+	tests := []struct {
+		name string
+		lines []ReturnLine
+		refund, giftCard string
+		points int
+	}{
+		{ "one kayak", []ReturnLine{ { SKU: "KAY-1", Quantity: 1 } }, "$99.14", "$17.14", 86 },
+		{ "the rest", []ReturnLine{ { SKU: "KAY-1", Quantity: 2 }, { SKU: "LIF-1", Quantity: 2 } },
+			"$247.86", "$42.86", 214 },
+	}
+	for _, test := range tests {
+		r := receive(t, data.Returns.ReturnDesk, order, test.lines...)
+		completed, err := data.Returns.Complete(r.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if completed.Refund.String() != test.refund || completed.Points != test.points ||
+				len(completed.GiftCards) != 1 || completed.GiftCards[0].Amount.String() != test.giftCard {
+			t.Errorf("%v: refunded %v, %v points and %+v, want %v, %v points and %v", test.name, completed.Refund,
+				completed.Points, completed.GiftCards, test.refund, test.points, test.giftCard)
+		}
+	}
+	// The order earned 1735 points, which returning it does not take back.
+	if customer, _ := data.Customers.Get(order.Customer()); customer.Points != 500 + 1735 {
+		t.Errorf("customer has %v points after returning everything, want 2235", customer.Points)
+	}
+	if card, _ := data.GiftCards.Get(card.Code); card.Balance.String() != "$100.00" {
+		t.Errorf("gift card balance %v after returning everything, want $100.00", card.Balance)
+	}
+}
+
+func TestCompleteRetriedRefundsOnce(t *testing.T) {
+	data, order, card := testReturns(t, t.TempDir())
+	r := receive(t, data.Returns.ReturnDesk, order, ReturnLine{ SKU: "KAY-1", Quantity: 3 })
+	path := data.Returns.file.path
+	breakWrites(t, data.Returns.file)
+	if _, err := data.Returns.Complete(r.ID); err == nil {
+		t.Fatal("return completed although it could not be saved")
+	}
+	data.Returns.file.path = path
+	if r, _ := data.Returns.Get(r.ID); r.Status != ReturnReceived {
+		t.Fatalf("return is %v after failing to complete, want received", r.Status)
+	}
+	completed, err := data.Returns.Complete(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := 0
+	for _, invoice := range data.Invoices.List() {
+		if invoice.Kind == KindCreditNote {
+			notes++
+		}
+	}
+	if notes != 1 || completed.CreditNote == "" {
+		t.Errorf("%v credit notes issued, want one", notes)
+	}
+	if want := 500 - 300 + 1735 + completed.Points; completed.Points != 258 {
+		t.Errorf("refunded %v points, want 258", completed.Points)
+	} else if customer, _ := data.Customers.Get(order.Customer()); customer.Points != want {
+		t.Errorf("customer has %v points, want %v", customer.Points, want)
+	}
+	refunded := Money{ 0, "USD" }
+	for _, posting := range data.GiftCards.Movements(card.Code) {
+		if posting.Movement == GiftCardRefunded {
+			refunded = refunded.Add(posting.Amount)
+		}
+	}
+	if refunded.Cmp(completed.GiftCards[0].Amount) != 0 {
+		t.Errorf("gift card refunded %v, want %v", refunded, completed.GiftCards[0].Amount)
+	}
+}
+
+func TestReturnsSharedThroughDataDir(t *testing.T) {
+	dir := t.TempDir()
+	shop, order, _ := testReturns(t, dir)
+	r, err := shop.Returns.Request(order, "Too small", ReturnLine{ SKU: "LIF-1", Quantity: 1 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := OpenDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := office.Returns.Approve(r.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := office.Returns.Receive(r.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := shop.Returns.Get(r.ID); r.Status != ReturnReceived {
+		t.Errorf("shop sees the return as %v, want received", r.Status)
+	}
+	next, err := office.Returns.Request(order, "Too big", ReturnLine{ SKU: "LIF-1", Quantity: 1 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == r.ID {
+		t.Errorf("second return reused %v", r.ID)
+	}
+}
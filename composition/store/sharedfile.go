@@ -0,0 +1,122 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sharedFile is a JSON file that the storefront and the store command may
+// both be writing. Every change is made holding an advisory lock on a .lock
+// file beside it, and starts by reading the file back through load if
+// another process has replaced it since this one last read or wrote it, so
+// neither process saves over what the other saved.
+type sharedFile struct {
+	path string
+	load func(data []byte) error
+	mutex sync.Mutex
+	seen os.FileInfo
+}
+
+func newSharedFile(path string, load func(data []byte) error) *sharedFile {
+	return &sharedFile{ path: path, load: load }
+}
+
+// hold locks the file and brings the data up to date with it. The returned
+// function unlocks it again.
+func (file *sharedFile) hold() (func(), error) {
+	file.mutex.Lock()
+	unlock, err := lockFile(file.path + ".lock")
+	if err != nil {
+		file.mutex.Unlock()
+		return nil, err
+	}
+	release := func() {
+		unlock()
+		file.mutex.Unlock()
+	}
+	if err = file.refresh(); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// reload reads the file if it has changed, as hold does, without changing it.
+func (file *sharedFile) reload() error {
+	release, err := file.hold()
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// change runs fn, which is expected to write the file, holding it.
+func (file *sharedFile) change(fn func() error) error {
+	release, err := file.hold()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+// refresh loads the file unless it is the one last read or written. Each
+// write renames a new file into place, so a file replaced by another
+// process is never the same file, even if its size and time match.
+func (file *sharedFile) refresh() error {
+	info, err := os.Stat(file.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if file.seen != nil && os.SameFile(info, file.seen) && info.ModTime().Equal(file.seen.ModTime()) &&
+			info.Size() == file.seen.Size() {
+		return nil
+	}
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		return err
+	}
+	if err = file.load(data); err != nil {
+		return fmt.Errorf("store: reading %v: %v", file.path, err)
+	}
+	file.seen = info
+	return nil
+}
+
+// write writes value to a temporary file beside the file and renames it over
+// it, so a failed write never leaves a truncated file behind. The file must
+// be held.
+func (file *sharedFile) write(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file.path), filepath.Base(file.path) + ".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	file.seen, err = os.Stat(file.path)
+	return err
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// breakWrites makes every later write to file fail, by pointing it at a
+// directory that cannot be renamed over, while the file still reads as
+// unchanged.
+func breakWrites(t *testing.T, file *sharedFile) {
+	t.Helper()
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "entry"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(blocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	file.path, file.seen = blocked, info
+}
+
+func TestSharedFileReloadsOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	values := [2][]int{}
+	files := [2]*sharedFile{}
+	for i := range files {
+		i := i
+		files[i] = newSharedFile(path, func(data []byte) error {
+			values[i] = nil
+			return json.Unmarshal(data, &values[i])
+		})
+	}
+	tests := []struct {
+		writer int
+		value []int
+	}{
+		{ 0, []int{ 1 } },
+		{ 1, []int{ 1, 2 } },
+		{ 0, []int{ 1, 2, 3 } },
+	}
+	for _, test := range tests {
+		if err := files[test.writer].change(func() error { return files[test.writer].write(test.value) }); err != nil {
+			t.Fatal(err)
+		}
+		reader := 1 - test.writer
+		if err := files[reader].reload(); err != nil {
+			t.Fatal(err)
+		}
+		if len(values[reader]) != len(test.value) {
+			t.Errorf("reader %v has %v after %v wrote %v", reader, values[reader], test.writer, test.value)
+		}
+	}
+}
+
+func TestReadsSeeOtherProcesses(t *testing.T) {
+	dir := t.TempDir()
+	shop, err := OpenDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := OpenDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boat, err := NewRentalBoat("Rowing Boat", MustParseMoney("$50"), 2, false, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := shop.Catalog.Get("RENT-1"); found {
+		t.Fatal("catalog starts with RENT-1")
+	}
+	if err := office.Catalog.Put("RENT-1", boat); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := shop.Catalog.Get("RENT-1"); !found {
+		t.Error("catalog Get missed an item another process added")
+	}
+	if result := shop.Catalog.Query(ProductQuery{}); result.Total != 1 {
+		t.Errorf("catalog Query found %v items, want 1", result.Total)
+	}
+
+	if err := office.Inventory.Receive("RENT-1", "Quay", 3); err != nil {
+		t.Fatal(err)
+	}
+	if available := shop.Inventory.Available("RENT-1"); available != 3 {
+		t.Errorf("inventory Available = %v, want 3", available)
+	}
+
+	if err := office.Crew.Add("C1", "Bob"); err != nil {
+		t.Fatal(err)
+	}
+	if members := shop.Crew.Members(); len(members) != 1 {
+		t.Errorf("crew Members = %v, want Bob", members)
+	}
+
+	if err := office.Calendar.AddBoat("RENT-1", boat, RentalRates{ Daily: MustParseMoney("$100") }); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2030, 6, 1, 9, 0, 0, 0, time.UTC)
+	if free := shop.Calendar.Available(start, start.Add(Day), 1); len(free) != 1 {
+		t.Errorf("calendar Available = %v, want RENT-1", free)
+	}
+	if _, found := shop.Calendar.Rates("RENT-1"); !found {
+		t.Error("calendar Rates missed a boat another process listed")
+	}
+}
@@ -1,15 +1,37 @@
 package store
 
+import (
+	"fmt"
+	"time"
+)
+
 type SpecialDeal struct {
 	Name string
 	*Product
-	price float64
+	discounts []dealDiscount
+	floor Money
+}
+
+func NewSpecialDeal(name string, p *Product, discount Money) (*SpecialDeal, error) {
+	return NewDiscountDeal(name, p, FixedAmount{ name, discount })
 }
 
-func NewSpecialDeal(name string, p *Product, discount float64) *SpecialDeal{
-	return &SpecialDeal{ name, p, p.price - discount }
+// NewDiscountDeal returns an error if a discount cannot apply to p, such as
+// one with an amount in another currency.
+func NewDiscountDeal(name string, p *Product, discounts ...Discount) (*SpecialDeal, error) {
+	if p == nil {
+		return nil, fmt.Errorf("store: deal %v needs a product", name)
+	}
+	deal := &SpecialDeal{ Name: name, Product: p }
+	for _, d := range discounts {
+		if err := deal.AddDiscount(d, Stackable); err != nil {
+			return nil, err
+		}
+	}
+	return deal, nil
 }
 
-func (deal *SpecialDeal) GetDetails() (string, float64, float64) {
-	return deal.Name, deal.price, deal.Price(0)
-}
\ No newline at end of file
+func (deal *SpecialDeal) GetDetails() (string, Money, Money, []AppliedDiscount, error) {
+	price, applied, err := deal.Quote(time.Now(), 1)
+	return deal.Name, price, deal.Price(NoTax, Location{}), applied, err
+}
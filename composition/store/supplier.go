@@ -0,0 +1,484 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Supplier struct {
+	ID string `json:"id"`
+	Name string `json:"name"`
+	City string `json:"city,omitempty"`
+}
+
+// SupplierOffer is what a supplier charges for a SKU, how long delivery
+// takes and the smallest quantity it will ship.
+type SupplierOffer struct {
+	SupplierID string `json:"supplier"`
+	SKU string `json:"sku"`
+	Cost Money `json:"cost"`
+	LeadTime time.Duration `json:"leadTime"`
+	MinOrder int `json:"minOrder,omitempty"`
+	Preferred bool `json:"preferred,omitempty"`
+}
+
+// ReorderPolicy orders Quantity more of a SKU once the stock available and
+// already on order falls to Point.
+type ReorderPolicy struct {
+	Point int `json:"point"`
+	Quantity int `json:"quantity"`
+}
+
+type PurchaseStatus string
+
+const (
+	PurchaseOpen PurchaseStatus = "open"
+	PurchaseReceived PurchaseStatus = "received"
+	PurchaseCancelled PurchaseStatus = "cancelled"
+)
+
+type PurchaseLine struct {
+	SKU string `json:"sku"`
+	Quantity int `json:"quantity"`
+	UnitCost Money `json:"unitCost"`
+	Total Money `json:"total"`
+}
+
+type PurchaseOrder struct {
+	Number string `json:"number"`
+	Supplier Supplier `json:"supplier"`
+	Lines []PurchaseLine `json:"lines"`
+	Total Money `json:"total"`
+	Created time.Time `json:"created"`
+	Expected time.Time `json:"expected"`
+	Status PurchaseStatus `json:"status"`
+	receiving bool
+}
+
+type Purchasing struct {
+	mutex sync.Mutex
+	suppliers map[string]Supplier
+	offers []SupplierOffer
+	policies map[string]ReorderPolicy
+	orders []*PurchaseOrder
+	numbers *OrderSequence
+	Now func() time.Time
+	// Report is told when an order raised by LowStock fails.
+	Report func(error)
+	keep func() error
+	acquire func() (func(), error)
+}
+
+func NewPurchasing() *Purchasing {
+	return &Purchasing{ suppliers: map[string]Supplier{}, policies: map[string]ReorderPolicy{},
+		numbers: NewOrderSequence("PO", 0), Now: time.Now,
+		keep: func() error { return nil }, acquire: func() (func(), error) { return func() {}, nil } }
+}
+
+// lock locks purchasing for a change. For purchasing kept in a file it also
+// locks the file and reads back what another process saved.
+func (purchasing *Purchasing) lock() (func(), error) {
+	purchasing.mutex.Lock()
+	release, err := purchasing.acquire()
+	if err != nil {
+		purchasing.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		purchasing.mutex.Unlock()
+	}, nil
+}
+
+// view locks purchasing to read it, first reading back the file if another
+// process has changed it. If the file cannot be read, what was last read is
+// used.
+func (purchasing *Purchasing) view() func() {
+	if unlock, err := purchasing.lock(); err == nil {
+		return unlock
+	}
+	purchasing.mutex.Lock()
+	return purchasing.mutex.Unlock
+}
+
+// renumber continues the purchase order numbers from the last order kept.
+func (purchasing *Purchasing) renumber() {
+	numbers := make([]string, len(purchasing.orders))
+	for i, order := range purchasing.orders {
+		numbers[i] = order.Number
+	}
+	purchasing.numbers = NewOrderSequence("PO", lastNumber("PO", numbers...))
+}
+
+func (purchasing *Purchasing) AddSupplier(supplier Supplier) error {
+	if supplier.ID == "" || supplier.Name == "" {
+		return fmt.Errorf("store: suppliers need an id and a name")
+	}
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	previous, existed := purchasing.suppliers[supplier.ID]
+	purchasing.suppliers[supplier.ID] = supplier
+	if err := purchasing.keep(); err != nil {
+		if existed {
+			purchasing.suppliers[supplier.ID] = previous
+		} else {
+			delete(purchasing.suppliers, supplier.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Suppliers lists the suppliers, ordered by ID.
+func (purchasing *Purchasing) Suppliers() []Supplier {
+	defer purchasing.view()()
+	suppliers := make([]Supplier, 0, len(purchasing.suppliers))
+	for _, supplier := range purchasing.suppliers {
+		suppliers = append(suppliers, supplier)
+	}
+	sort.Slice(suppliers, func(i, j int) bool { return suppliers[i].ID < suppliers[j].ID })
+	return suppliers
+}
+
+// AddOffer records or replaces a supplier's terms for a SKU. Marking an offer
+// preferred clears the flag on the other offers for that SKU.
+func (purchasing *Purchasing) AddOffer(offer SupplierOffer) error {
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, found := purchasing.suppliers[offer.SupplierID]; !found {
+		return fmt.Errorf("store: no supplier %v", offer.SupplierID)
+	}
+	if offer.Cost.IsNegative() || offer.MinOrder < 0 || offer.LeadTime < 0 {
+		return fmt.Errorf("store: invalid offer for %v from %v", offer.SKU, offer.SupplierID)
+	}
+	kept := []SupplierOffer{}
+	for _, existing := range purchasing.offers {
+		if existing.SKU == offer.SKU && existing.SupplierID == offer.SupplierID {
+			continue
+		}
+		if existing.SKU == offer.SKU && offer.Preferred {
+			existing.Preferred = false
+		}
+		kept = append(kept, existing)
+	}
+	previous := purchasing.offers
+	purchasing.offers = append(kept, offer)
+	if err := purchasing.keep(); err != nil {
+		purchasing.offers = previous
+		return err
+	}
+	return nil
+}
+
+// Offers lists the offers for a SKU, best first: the preferred supplier,
+// then by cost and lead time.
+func (purchasing *Purchasing) Offers(sku string) []SupplierOffer {
+	defer purchasing.view()()
+	return purchasing.offersFor(sku)
+}
+
+func (purchasing *Purchasing) offersFor(sku string) []SupplierOffer {
+	offers := []SupplierOffer{}
+	for _, offer := range purchasing.offers {
+		if offer.SKU == sku {
+			offers = append(offers, offer)
+		}
+	}
+	sort.SliceStable(offers, func(i, j int) bool {
+		a, b := offers[i], offers[j]
+		if a.Preferred != b.Preferred {
+			return a.Preferred
+		}
+		if a.Cost.currency == b.Cost.currency && a.Cost.minor != b.Cost.minor {
+			return a.Cost.minor < b.Cost.minor
+		}
+		return a.LeadTime < b.LeadTime
+	})
+	return offers
+}
+
+func (purchasing *Purchasing) SetReorderPolicy(sku string, policy ReorderPolicy) error {
+	if sku == "" || policy.Point < 0 || policy.Quantity < 0 {
+		return fmt.Errorf("store: invalid reorder policy for %q", sku)
+	}
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	previous, existed := purchasing.policies[sku]
+	purchasing.policies[sku] = policy
+	if err := purchasing.keep(); err != nil {
+		if existed {
+			purchasing.policies[sku] = previous
+		} else {
+			delete(purchasing.policies, sku)
+		}
+		return err
+	}
+	return nil
+}
+
+// Policies returns the reorder policies by SKU.
+func (purchasing *Purchasing) Policies() map[string]ReorderPolicy {
+	defer purchasing.view()()
+	policies := make(map[string]ReorderPolicy, len(purchasing.policies))
+	for sku, policy := range purchasing.policies {
+		policies[sku] = policy
+	}
+	return policies
+}
+
+func (purchasing *Purchasing) onOrder(sku string) int {
+	total := 0
+	for _, order := range purchasing.orders {
+		if order.Status != PurchaseOpen {
+			continue
+		}
+		for _, line := range order.Lines {
+			if line.SKU == sku {
+				total += line.Quantity
+			}
+		}
+	}
+	return total
+}
+
+// Replenish raises purchase orders, one per supplier and currency, for every
+// SKU whose available and on-order stock has fallen to its reorder point.
+// The stock is checked without purchasing locked, since an inventory may
+// report low stock back to it while it is asked.
+func (purchasing *Purchasing) Replenish(stock StockChecker) ([]*PurchaseOrder, error) {
+	skus := []string{}
+	for sku := range purchasing.Policies() {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	levels := map[string]int{}
+	for _, sku := range skus {
+		levels[sku] = stock.Available(sku)
+	}
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return purchasing.raise(skus, levels)
+}
+
+// LowStock lets Purchasing act as an inventory's LowStockListener, ordering
+// as soon as an alert arrives. Failures go to Report.
+func (purchasing *Purchasing) LowStock(sku string, available, threshold int) {
+	unlock, err := purchasing.lock()
+	if err == nil {
+		if _, found := purchasing.policies[sku]; found {
+			_, err = purchasing.raise([]string{ sku }, map[string]int{ sku: available })
+		}
+		unlock()
+	}
+	purchasing.mutex.Lock()
+	report := purchasing.Report
+	purchasing.mutex.Unlock()
+	if err != nil && report != nil {
+		report(err)
+	}
+}
+
+// raise orders the SKUs that need it and saves the orders. Purchasing must be
+// locked.
+func (purchasing *Purchasing) raise(skus []string, available map[string]int) ([]*PurchaseOrder, error) {
+	now := purchasing.Now()
+	bySupplier := map[string]*PurchaseOrder{}
+	raised := []*PurchaseOrder{}
+	missing := []string{}
+	for _, sku := range skus {
+		policy, found := purchasing.policies[sku]
+		if !found || available[sku] + purchasing.onOrder(sku) > policy.Point {
+			continue
+		}
+		offers := purchasing.offersFor(sku)
+		if len(offers) == 0 {
+			missing = append(missing, sku)
+			continue
+		}
+		offer := offers[0]
+		quantity := policy.Quantity
+		if quantity < offer.MinOrder {
+			quantity = offer.MinOrder
+		}
+		if quantity < 1 {
+			continue
+		}
+		key := offer.SupplierID + " " + offer.Cost.currency
+		order, found := bySupplier[key]
+		if !found {
+			order = &PurchaseOrder{
+				Number: purchasing.numbers.Next(),
+				Supplier: purchasing.suppliers[offer.SupplierID],
+				Total: Money{ 0, offer.Cost.currency },
+				Created: now, Status: PurchaseOpen,
+			}
+			bySupplier[key] = order
+			raised = append(raised, order)
+		}
+		line := PurchaseLine{ sku, quantity, offer.Cost, offer.Cost.Multiply(int64(quantity)) }
+		order.Lines = append(order.Lines, line)
+		order.Total = order.Total.Add(line.Total)
+		if expected := now.Add(offer.LeadTime); expected.After(order.Expected) {
+			order.Expected = expected
+		}
+	}
+	if len(raised) > 0 {
+		purchasing.orders = append(purchasing.orders, raised...)
+		if err := purchasing.keep(); err != nil {
+			purchasing.orders = purchasing.orders[:len(purchasing.orders) - len(raised)]
+			purchasing.renumber()
+			return nil, err
+		}
+	}
+	copies := make([]*PurchaseOrder, len(raised))
+	for i, order := range raised {
+		copies[i] = order.copy()
+	}
+	if len(missing) > 0 {
+		return copies, fmt.Errorf("store: no supplier offers %v", missing)
+	}
+	return copies, nil
+}
+
+func (order *PurchaseOrder) copy() *PurchaseOrder {
+	copied := *order
+	copied.Lines = append([]PurchaseLine{}, order.Lines...)
+	return &copied
+}
+
+func (purchasing *Purchasing) Orders() []*PurchaseOrder {
+	defer purchasing.view()()
+	orders := make([]*PurchaseOrder, len(purchasing.orders))
+	for i, order := range purchasing.orders {
+		orders[i] = order.copy()
+	}
+	return orders
+}
+
+func (purchasing *Purchasing) order(number string) *PurchaseOrder {
+	for _, order := range purchasing.orders {
+		if order.Number == number {
+			return order
+		}
+	}
+	return nil
+}
+
+// find returns the open purchase order with number, unless it is being
+// received.
+func (purchasing *Purchasing) find(number string) (*PurchaseOrder, error) {
+	order := purchasing.order(number)
+	if order == nil {
+		return nil, fmt.Errorf("store: no purchase order %v", number)
+	}
+	if order.Status != PurchaseOpen {
+		return nil, fmt.Errorf("store: purchase order %v is %v", number, order.Status)
+	}
+	if order.receiving {
+		return nil, fmt.Errorf("store: purchase order %v is being received", number)
+	}
+	return order, nil
+}
+
+// Receive books the goods on a purchase order into the inventory at location.
+// The order stays open if any line cannot be booked, and the lines already
+// booked are taken out again. Purchasing is not locked while the inventory
+// is, so the goods are taken out again too if another process cancelled or
+// received the order in the meantime.
+func (purchasing *Purchasing) Receive(number string, inventory *Inventory, location string) error {
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return err
+	}
+	order, err := purchasing.find(number)
+	var lines []PurchaseLine
+	if err == nil {
+		order.receiving = true
+		lines = append(lines, order.Lines...)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+	err = receiveLines(lines, inventory, location)
+	unlock, lockErr := purchasing.lock()
+	if lockErr != nil {
+		purchasing.mutex.Lock()
+		if order := purchasing.order(number); order != nil {
+			order.receiving = false
+		}
+		purchasing.mutex.Unlock()
+		if err == nil {
+			returnLines(lines, inventory, location)
+		}
+		return lockErr
+	}
+	defer unlock()
+	order = purchasing.order(number)
+	if order != nil {
+		order.receiving = false
+	}
+	if err != nil {
+		return err
+	}
+	if order == nil || order.Status != PurchaseOpen {
+		returnLines(lines, inventory, location)
+		return fmt.Errorf("store: purchase order %v was changed while it was received", number)
+	}
+	order.Status = PurchaseReceived
+	if err := purchasing.keep(); err != nil {
+		order.Status = PurchaseOpen
+		returnLines(lines, inventory, location)
+		return err
+	}
+	return nil
+}
+
+func receiveLines(lines []PurchaseLine, inventory *Inventory, location string) error {
+	for i, line := range lines {
+		if err := inventory.Receive(line.SKU, location, line.Quantity); err != nil {
+			returnLines(lines[:i], inventory, location)
+			return err
+		}
+	}
+	return nil
+}
+
+// returnLines takes the goods on lines out of the inventory again.
+func returnLines(lines []PurchaseLine, inventory *Inventory, location string) {
+	for _, line := range lines {
+		inventory.Receive(line.SKU, location, -line.Quantity)
+	}
+}
+
+func (purchasing *Purchasing) Cancel(number string) error {
+	unlock, err := purchasing.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	order, err := purchasing.find(number)
+	if err != nil {
+		return err
+	}
+	order.Status = PurchaseCancelled
+	if err := purchasing.keep(); err != nil {
+		order.Status = PurchaseOpen
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// alertingStock reports low stock to purchasing while it is asked, as an
+// inventory does when alerts are pending.
+type alertingStock struct {
+	purchasing *Purchasing
+	available int
+}
+
+func (stock alertingStock) Available(sku string) int {
+	stock.purchasing.LowStock(sku, stock.available, 0)
+	return stock.available
+}
+
+func testPurchasing(t *testing.T, purchasing *Purchasing) {
+	t.Helper()
+	purchasing.Now = func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }
+	if err := purchasing.AddSupplier(Supplier{ ID: "ACME", Name: "Acme Co" }); err != nil {
+		t.Fatal(err)
+	}
+	offer := SupplierOffer{ SupplierID: "ACME", SKU: "KAY-1", Cost: MustParseMoney("$180"), LeadTime: 5 * Day, MinOrder: 4 }
+	if err := purchasing.AddOffer(offer); err != nil {
+		t.Fatal(err)
+	}
+	if err := purchasing.SetReorderPolicy("KAY-1", ReorderPolicy{ Point: 2, Quantity: 3 }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplenishWhileStockReportsBack(t *testing.T) {
+	purchasing := NewPurchasing()
+	testPurchasing(t, purchasing)
+	done := make(chan []*PurchaseOrder)
+	go func() {
+		orders, _ := purchasing.Replenish(alertingStock{ purchasing, 1 })
+		done <- orders
+	}()
+	select {
+	case orders := <-done:
+		if len(orders) != 0 {
+			t.Errorf("Replenish raised %v orders after LowStock had, want none", len(orders))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replenish deadlocked")
+	}
+	if orders := purchasing.Orders(); len(orders) != 1 || orders[0].Lines[0].Quantity != 4 {
+		t.Errorf("orders %+v, want one for the minimum of 4", orders)
+	}
+}
+
+func TestFilePurchasing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "purchasing.json")
+	shop, err := OpenFilePurchasing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPurchasing(t, shop.Purchasing)
+	inventory := NewInventory(nil)
+	orders, err := shop.Replenish(inventory)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("Replenish = %v, %v, want one order", orders, err)
+	}
+
+	office, err := OpenFilePurchasing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := office.Orders(); len(got) != 1 || got[0].Number != orders[0].Number || got[0].Total.String() != "$720.00" {
+		t.Fatalf("orders read back %+v", got)
+	}
+	if err := office.Cancel(orders[0].Number); err != nil {
+		t.Fatal(err)
+	}
+	if err := shop.Receive(orders[0].Number, inventory, "Warehouse"); err == nil {
+		t.Error("received an order another process cancelled")
+	}
+	if available := inventory.Available("KAY-1"); available != 0 {
+		t.Errorf("%v kayaks available, want 0", available)
+	}
+
+	again, err := shop.Replenish(inventory)
+	if err != nil || len(again) != 1 {
+		t.Fatalf("Replenish = %v, %v, want one order", again, err)
+	}
+	if again[0].Number == orders[0].Number {
+		t.Errorf("purchase order number %v used twice", again[0].Number)
+	}
+	if err := office.Receive(again[0].Number, inventory, "Warehouse"); err != nil {
+		t.Fatal(err)
+	}
+	if available := inventory.Available("KAY-1"); available != 4 {
+		t.Errorf("%v kayaks available, want 4", available)
+	}
+	if policies := office.Policies(); policies["KAY-1"].Quantity != 3 {
+		t.Errorf("policies read back %+v", policies)
+	}
+}
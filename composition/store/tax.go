@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SaleType int
+
+const (
+	Sale SaleType = iota
+	Rental
+)
+
+type Location struct {
+	Country, Region string
+}
+
+// TaxRule applies Rate to sales that match all of its non-empty conditions.
+// A compound rule is charged on the net price plus the taxes from the
+// non-compound rules, rather than on the net price alone.
+type TaxRule struct {
+	Name string
+	Rate float64
+	Categories []string
+	Country, Region string
+	SaleTypes []SaleType
+	Compound bool
+}
+
+func (rule TaxRule) validate() error {
+	if rule.Rate < 0 || !IsFiniteRate(rule.Rate) {
+		return fmt.Errorf("store: tax %q has a rate of %v", rule.Name, rule.Rate)
+	}
+	return nil
+}
+
+func (rule TaxRule) applies(category string, location Location, sale SaleType) bool {
+	if rule.Country != "" && !strings.EqualFold(rule.Country, location.Country) {
+		return false
+	}
+	if rule.Region != "" && !strings.EqualFold(rule.Region, location.Region) {
+		return false
+	}
+	if len(rule.Categories) > 0 && !containsFold(rule.Categories, category) {
+		return false
+	}
+	if len(rule.SaleTypes) > 0 {
+		for _, t := range rule.SaleTypes {
+			if t == sale {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+type TaxLine struct {
+	Name string
+	Rate float64
+	Base, Amount Money
+}
+
+type TaxBreakdown struct {
+	Net Money
+	Lines []TaxLine
+	Tax, Gross Money
+}
+
+type TaxPolicy interface {
+	Apply(net Money, category string, location Location, sale SaleType) TaxBreakdown
+}
+
+type RuleTaxPolicy struct {
+	rules []TaxRule
+	exempt []string
+}
+
+// NewRuleTaxPolicy rejects rules with a negative, NaN or infinite rate.
+func NewRuleTaxPolicy(rules ...TaxRule) (*RuleTaxPolicy, error) {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &RuleTaxPolicy{ rules: append([]TaxRule{}, rules...) }, nil
+}
+
+// Exempt marks categories on which no tax is charged at all.
+func (policy *RuleTaxPolicy) Exempt(categories ...string) *RuleTaxPolicy {
+	policy.exempt = append(policy.exempt, categories...)
+	return policy
+}
+
+func (policy *RuleTaxPolicy) Apply(net Money, category string, location Location, sale SaleType) TaxBreakdown {
+	breakdown := TaxBreakdown{ Net: net, Lines: []TaxLine{}, Tax: Money{ 0, net.currency }, Gross: net }
+	if containsFold(policy.exempt, category) {
+		return breakdown
+	}
+	simple := Money{ 0, net.currency }
+	for _, rule := range policy.rules {
+		if !rule.Compound && rule.applies(category, location, sale) {
+			line := TaxLine{ rule.Name, rule.Rate, net, net.MultiplyByRate(rule.Rate, TaxRounding) }
+			breakdown.Lines = append(breakdown.Lines, line)
+			simple = simple.Add(line.Amount)
+		}
+	}
+	compoundBase := net.Add(simple)
+	breakdown.Tax = simple
+	for _, rule := range policy.rules {
+		if rule.Compound && rule.applies(category, location, sale) {
+			line := TaxLine{ rule.Name, rule.Rate, compoundBase,
+				compoundBase.MultiplyByRate(rule.Rate, TaxRounding) }
+			breakdown.Lines = append(breakdown.Lines, line)
+			breakdown.Tax = breakdown.Tax.Add(line.Amount)
+		}
+	}
+	breakdown.Gross = net.Add(breakdown.Tax)
+	return breakdown
+}
+
+// NoTax charges nothing on any sale.
+var NoTax TaxPolicy = &RuleTaxPolicy{}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options picks a value on each axis of a product sold in variants, such as
+// {"size": "M", "colour": "red"}.
+type Options map[string]string
+
+// Variant is one version of a VariantProduct. It is sold and stocked under
+// its own SKU, and its price is the base price of the product plus Delta.
+type Variant struct {
+	*Product
+	SKU string
+	Options Options
+	Delta Money
+	Stock int
+	Parent *VariantProduct
+}
+
+// Label lists the variant's option values in axis order, as in "M, red".
+func (v *Variant) Label() string {
+	values := make([]string, len(v.Parent.Axes))
+	for i, axis := range v.Parent.Axes {
+		values[i] = v.Options[axis]
+	}
+	return strings.Join(values, ", ")
+}
+
+// VariantProduct is a product sold in versions along option axes. The
+// catalog holds it under its own SKU and also answers for the SKU of each
+// variant, but only the variants themselves can go in a cart.
+type VariantProduct struct {
+	*Product
+	Axes []string
+	variants []*Variant
+}
+
+func NewVariantProduct(name, category string, price Money, axes ...string) *VariantProduct {
+	return &VariantProduct{ Product: NewProduct(name, category, price), Axes: axes }
+}
+
+func (product *VariantProduct) checkOptions(options Options) error {
+	if len(options) != len(product.Axes) {
+		return fmt.Errorf("store: %v variants need a value for each of %v", product.Name,
+			strings.Join(product.Axes, ", "))
+	}
+	for _, axis := range product.Axes {
+		if strings.TrimSpace(options[axis]) == "" {
+			return fmt.Errorf("store: %v variants need a value for %v", product.Name, axis)
+		}
+	}
+	return nil
+}
+
+// AddVariant lists a version of the product under sku. The options must give
+// one value on every axis and differ from those of the other variants. A
+// zero delta sells the variant at the base price.
+func (product *VariantProduct) AddVariant(sku string, options Options, delta Money, stock int) (*Variant, error) {
+	if strings.TrimSpace(sku) == "" {
+		return nil, fmt.Errorf("store: a SKU is required for each variant of %v", product.Name)
+	}
+	if err := product.checkOptions(options); err != nil {
+		return nil, err
+	}
+	if _, exists := product.VariantBySKU(sku); exists {
+		return nil, fmt.Errorf("store: %v already has a variant %v", product.Name, sku)
+	}
+	if existing, exists := product.Variant(options); exists {
+		return nil, fmt.Errorf("store: %v already has %v as %v", product.Name, existing.Label(), existing.SKU)
+	}
+	if delta.currency == "" {
+		delta = Money{ delta.minor, product.price.currency }
+	}
+	if delta.currency != product.price.currency {
+		return nil, fmt.Errorf("store: %v is priced in %v, not %v", product.Name, product.price.currency, delta.currency)
+	}
+	price := product.price.Add(delta)
+	if price.IsNegative() {
+		return nil, fmt.Errorf("store: %v of %v would cost less than nothing", sku, product.Name)
+	}
+	if stock < 0 {
+		return nil, fmt.Errorf("store: %v cannot have %v in stock", sku, stock)
+	}
+	copied := Options{}
+	for axis, value := range options {
+		copied[axis] = strings.TrimSpace(value)
+	}
+	variant := &Variant{ SKU: sku, Options: copied, Delta: delta, Stock: stock, Parent: product }
+	variant.Product = NewProduct(fmt.Sprintf("%v (%v)", product.Name, variant.Label()), product.Category, price)
+	product.variants = append(product.variants, variant)
+	return variant, nil
+}
+
+func (product *VariantProduct) Variants() []*Variant {
+	return append([]*Variant{}, product.variants...)
+}
+
+// Variant finds the version with the given options. Values are matched
+// without regard to case.
+func (product *VariantProduct) Variant(options Options) (*Variant, bool) {
+	for _, variant := range product.variants {
+		matched := len(options) == len(variant.Options)
+		for axis, value := range variant.Options {
+			matched = matched && strings.EqualFold(strings.TrimSpace(options[axis]), value)
+		}
+		if matched {
+			return variant, true
+		}
+	}
+	return nil, false
+}
+
+func (product *VariantProduct) VariantBySKU(sku string) (*Variant, bool) {
+	for _, variant := range product.variants {
+		if variant.SKU == sku {
+			return variant, true
+		}
+	}
+	return nil, false
+}
+
+// Values returns the values offered on axis, in the order they were first
+// listed.
+func (product *VariantProduct) Values(axis string) []string {
+	values := []string{}
+	seen := map[string]bool{}
+	for _, variant := range product.variants {
+		if value, found := variant.Options[axis]; found && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// ResolveVariant finds the variant of the product stored under sku that has
+// the given options.
+func ResolveVariant(catalog Catalog, sku string, options Options) (*Variant, error) {
+	item, found := catalog.Get(sku)
+	if !found {
+		return nil, fmt.Errorf("store: no product with SKU %v", sku)
+	}
+	product, hasVariants := item.(*VariantProduct)
+	if !hasVariants {
+		return nil, fmt.Errorf("store: %v does not come in variants", sku)
+	}
+	if err := product.checkOptions(options); err != nil {
+		return nil, err
+	}
+	variant, found := product.Variant(options)
+	if !found {
+		labels := make([]string, 0, len(options))
+		for _, axis := range product.Axes {
+			labels = append(labels, options[axis])
+		}
+		return nil, fmt.Errorf("store: %v is not made in %v", product.Name, strings.Join(labels, ", "))
+	}
+	return variant, nil
+}
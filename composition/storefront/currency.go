@@ -0,0 +1,69 @@
+package storefront
+
+import (
+	"composition/store"
+	"strings"
+	"time"
+)
+
+type exchangeRateList struct {
+	Items []store.ExchangeRate `json:"items"`
+}
+
+// convertedPriceBody gives a catalog item's price, before tax, in the
+// catalog's currency and in the one asked for.
+type convertedPriceBody struct {
+	SKU string `json:"sku"`
+	Price store.Money `json:"price"`
+	Converted store.Money `json:"converted"`
+	Rate store.ExchangeRate `json:"rate"`
+}
+
+func (server *Server) exchangeRates() (*store.ExchangeRates, error) {
+	if server.Rates == nil {
+		return nil, notFound("this shop has no exchange rates")
+	}
+	return server.Rates, nil
+}
+
+func (server *Server) exchangeRatesHandler(r apiRequest) (interface{}, error) {
+	rates, err := server.exchangeRates()
+	if err != nil {
+		return nil, err
+	}
+	currency := r.URL.Query().Get("currency")
+	list := exchangeRateList{ Items: []store.ExchangeRate{} }
+	for _, rate := range rates.Rates() {
+		if currency == "" || strings.EqualFold(rate.From, currency) || strings.EqualFold(rate.To, currency) {
+			list.Items = append(list.Items, rate)
+		}
+	}
+	return list, nil
+}
+
+func (server *Server) convertedPriceHandler(r apiRequest) (interface{}, error) {
+	entry, err := server.entry(r.vars["sku"])
+	if err != nil {
+		return nil, err
+	}
+	rates, err := server.exchangeRates()
+	if err != nil {
+		return nil, err
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		return nil, badRequest("currency is required")
+	}
+	at := time.Now()
+	if r.URL.Query().Get("at") != "" {
+		if at, err = queryTime(r, "at"); err != nil {
+			return nil, err
+		}
+	}
+	converted, rate, err := entry.Item.BaseProduct().PriceIn(currency, rates, at, store.NoTax, store.Location{})
+	if err != nil {
+		return nil, err
+	}
+	return convertedPriceBody{ entry.SKU, entry.Item.BaseProduct().Price(store.NoTax, store.Location{}), converted,
+		rate }, nil
+}
@@ -0,0 +1,140 @@
+package storefront
+
+import (
+	"composition/store"
+	"strings"
+)
+
+type customerList struct {
+	Items []store.Customer `json:"items"`
+}
+
+type customerRequest struct {
+	Name string `json:"name"`
+	Email string `json:"email"`
+	Addresses []store.Address `json:"addresses"`
+}
+
+// cartCustomerRequest names the customer a cart is for and the points they
+// want to spend on it.
+type cartCustomerRequest struct {
+	Customer string `json:"customer"`
+	Points int `json:"points"`
+}
+
+func (server *Server) customers() (*store.Customers, error) {
+	if server.Customers == nil {
+		return nil, notFound("this shop does not keep customer accounts")
+	}
+	return server.Customers, nil
+}
+
+func (server *Server) customer(id string) (store.Customer, error) {
+	customers, err := server.customers()
+	if err != nil {
+		return store.Customer{}, err
+	}
+	customer, found := customers.Get(id)
+	if !found {
+		return store.Customer{}, notFound("no customer %v", id)
+	}
+	return customer, nil
+}
+
+func (server *Server) loyaltyHandler(r apiRequest) (interface{}, error) {
+	customers, err := server.customers()
+	if err != nil {
+		return nil, err
+	}
+	return customers.Program(), nil
+}
+
+func (server *Server) customersHandler(r apiRequest) (interface{}, error) {
+	customers, err := server.customers()
+	if err != nil {
+		return nil, err
+	}
+	list := customerList{ Items: []store.Customer{} }
+	if email := r.URL.Query().Get("email"); email != "" {
+		if customer, found := customers.FindByEmail(email); found {
+			list.Items = append(list.Items, customer)
+		}
+		return list, nil
+	}
+	list.Items = append(list.Items, customers.List()...)
+	return list, nil
+}
+
+func (server *Server) registerHandler(r apiRequest) (interface{}, error) {
+	customers, err := server.customers()
+	if err != nil {
+		return nil, err
+	}
+	var body customerRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return customers.Register(body.Name, body.Email, body.Addresses...)
+}
+
+func (server *Server) customerHandler(r apiRequest) (interface{}, error) {
+	return server.customer(r.vars["id"])
+}
+
+func (server *Server) updateCustomerHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.customer(r.vars["id"]); err != nil {
+		return nil, err
+	}
+	var body customerRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return server.Customers.Update(r.vars["id"], body.Name, body.Email, body.Addresses...)
+}
+
+// customerOrdersHandler lists the customer's orders from Orders. An order
+// whose number is in the history but which was placed for someone else is
+// left out, so a number used again by a shop that does not keep its orders
+// never shows another customer's order.
+func (server *Server) customerOrdersHandler(r apiRequest) (interface{}, error) {
+	customer, err := server.customer(r.vars["id"])
+	if err != nil {
+		return nil, err
+	}
+	list := orderList{ Items: []orderBody{} }
+	for _, number := range customer.Orders {
+		if order, err := server.order(number); err == nil && order.Customer() == customer.ID {
+			list.Items = append(list.Items, toOrderBody(order))
+		}
+	}
+	return list, nil
+}
+
+// cartCustomerHandler prices a cart for a customer. An empty customer takes
+// the cart back to ordinary prices.
+func (server *Server) cartCustomerHandler(r apiRequest) (interface{}, error) {
+	customers, err := server.customers()
+	if err != nil {
+		return nil, err
+	}
+	var body cartCustomerRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(body.Customer)
+	if id != "" {
+		if _, found := customers.Get(id); !found {
+			return nil, notFound("no customer %v", id)
+		}
+	}
+	return server.withCart(r, func(cart *store.Cart) error {
+		if id == "" {
+			if body.Points != 0 {
+				return badRequest("points can only be redeemed for a customer")
+			}
+			cart.SetCustomer("", 0, store.Money{})
+			return nil
+		}
+		return customers.Apply(cart, id, body.Points)
+	})
+}
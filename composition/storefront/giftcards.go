@@ -0,0 +1,158 @@
+package storefront
+
+import (
+	"composition/store"
+	"time"
+)
+
+type giftCardRequest struct {
+	Amount store.Money `json:"amount"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Note string `json:"note"`
+}
+
+type storeCreditRequest struct {
+	Amount store.Money `json:"amount"`
+	Note string `json:"note"`
+}
+
+// giftCardPaymentRequest pays part of a cart by gift card. Without an
+// amount the card pays as much as it can.
+type giftCardPaymentRequest struct {
+	Code string `json:"code"`
+	Amount store.Money `json:"amount"`
+}
+
+// giftCardList shows each card with its code masked, so that the list
+// cannot be used to spend the cards. A card's full code is only shown when
+// it is issued.
+type giftCardList struct {
+	Items []store.GiftCard `json:"items"`
+}
+
+func masked(card store.GiftCard) store.GiftCard {
+	card.Code = store.MaskGiftCardCode(card.Code)
+	return card
+}
+
+type giftCardLedger struct {
+	Items []store.GiftCardPosting `json:"items"`
+}
+
+type giftCardReport struct {
+	Items []store.GiftCardReconciliation `json:"items"`
+}
+
+func (server *Server) giftCards() (*store.GiftCards, error) {
+	if server.GiftCards == nil {
+		return nil, notFound("this shop does not sell gift cards")
+	}
+	return server.GiftCards, nil
+}
+
+func (server *Server) issueGiftCardHandler(r apiRequest) (interface{}, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return nil, err
+	}
+	var body giftCardRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return cards.Issue(body.Amount, body.Expires, body.Note)
+}
+
+func (server *Server) storeCreditHandler(r apiRequest) (interface{}, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := server.customer(r.vars["id"]); err != nil {
+		return nil, err
+	}
+	var body storeCreditRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return cards.IssueCredit(r.vars["id"], body.Amount, body.Note)
+}
+
+func (server *Server) giftCardsHandler(r apiRequest) (interface{}, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return nil, err
+	}
+	customer := r.URL.Query().Get("customer")
+	list := giftCardList{ Items: []store.GiftCard{} }
+	for _, card := range cards.List() {
+		if customer == "" || card.Customer == customer {
+			list.Items = append(list.Items, masked(card))
+		}
+	}
+	return list, nil
+}
+
+func (server *Server) giftCard(code string) (store.GiftCard, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return store.GiftCard{}, err
+	}
+	card, found := cards.Get(code)
+	if !found {
+		return store.GiftCard{}, notFound("no gift card %v", code)
+	}
+	return card, nil
+}
+
+func (server *Server) giftCardHandler(r apiRequest) (interface{}, error) {
+	return server.giftCard(r.vars["code"])
+}
+
+func (server *Server) giftCardMovementsHandler(r apiRequest) (interface{}, error) {
+	card, err := server.giftCard(r.vars["code"])
+	if err != nil {
+		return nil, err
+	}
+	return giftCardLedger{ server.GiftCards.Movements(card.Code) }, nil
+}
+
+func (server *Server) giftCardReportHandler(r apiRequest) (interface{}, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return nil, err
+	}
+	report := giftCardReport{ cards.Reconcile() }
+	for _, r := range report.Items {
+		for i, code := range r.Mismatched {
+			r.Mismatched[i] = store.MaskGiftCardCode(code)
+		}
+	}
+	return report, nil
+}
+
+func (server *Server) payByGiftCardHandler(r apiRequest) (interface{}, error) {
+	cards, err := server.giftCards()
+	if err != nil {
+		return nil, err
+	}
+	var body giftCardPaymentRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if _, err := server.giftCard(body.Code); err != nil {
+		return nil, err
+	}
+	return server.withCart(r, func(cart *store.Cart) error {
+		return cards.Apply(cart, body.Code, body.Amount)
+	})
+}
+
+func (server *Server) removeGiftCardHandler(r apiRequest) (interface{}, error) {
+	code := store.NormalizeGiftCardCode(r.vars["code"])
+	return server.withCart(r, func(cart *store.Cart) error {
+		if err := cart.RemoveGiftCard(code); err != nil {
+			return notFound("gift card %v is not paying for the cart", code)
+		}
+		return nil
+	})
+}
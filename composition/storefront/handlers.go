@@ -0,0 +1,740 @@
+package storefront
+
+import (
+	"composition/store"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var listParameters = []parameter{
+	{ "category", "string", "only items in this category" },
+	{ "minPrice", "string", "lowest price, as a decimal amount in currency" },
+	{ "maxPrice", "string", "highest price, as a decimal amount in currency" },
+	{ "currency", "string", "currency of minPrice and maxPrice" },
+	{ "minCapacity", "integer", "boats carrying at least this many people" },
+	{ "motorized", "boolean", "boats with or without a motor" },
+	{ "sort", "string", "sku, name, price or capacity" },
+	{ "order", "string", "asc or desc" },
+	{ "offset", "integer", "number of items to skip" },
+	{ "limit", "integer", "largest number of items to return" },
+}
+
+var periodParameters = []parameter{
+	{ "start", "string", "RFC 3339 start time" },
+	{ "end", "string", "RFC 3339 end time" },
+}
+
+func (server *Server) table() []route {
+	products := append([]parameter{ { "type", "string", "product, boat, rental or bundle" } }, listParameters...)
+	available := append(append([]parameter{}, periodParameters...), parameter{ "capacity", "integer", "people to carry" })
+	return []route{
+		{ method: "GET", path: "/v1/openapi.json", summary: "This API described as OpenAPI 3",
+			response: "OpenAPI", handle: server.openAPIHandler },
+		{ method: "GET", path: "/v1/products", summary: "List catalog items", query: products,
+			response: "ProductList", handle: server.listHandler("") },
+		{ method: "GET", path: "/v1/products/{sku}", summary: "Get a catalog item",
+			response: "Product", handle: server.productHandler },
+		{ method: "PUT", path: "/v1/products/{sku}", admin: true, summary: "Create or replace a catalog item",
+			body: "Product", response: "Product", handle: server.putProductHandler },
+		{ method: "DELETE", path: "/v1/products/{sku}", admin: true, summary: "Remove a catalog item",
+			status: http.StatusNoContent, handle: server.deleteProductHandler },
+		{ method: "GET", path: "/v1/products/{sku}/prices", summary: "Price history of a catalog item",
+			query: []parameter{ { "at", "string", "RFC 3339 time to give the price at" } },
+			response: "PriceHistory", handle: server.pricesHandler },
+		{ method: "POST", path: "/v1/products/{sku}/prices", admin: true, summary: "Schedule a price change",
+			body: "PriceChangeRequest", response: "PriceHistory", status: http.StatusCreated,
+			handle: server.schedulePriceHandler },
+		{ method: "DELETE", path: "/v1/products/{sku}/prices", admin: true, summary: "Cancel a scheduled price change",
+			query: []parameter{ { "effective", "string", "RFC 3339 time of the change" } },
+			response: "PriceHistory", handle: server.cancelPriceHandler },
+		{ method: "GET", path: "/v1/products/{sku}/price", summary: "Price of a catalog item in another currency",
+			query: []parameter{ { "currency", "string", "currency to give the price in" },
+				{ "at", "string", "RFC 3339 time of the exchange rate to use, now if not given" } },
+			response: "ConvertedPrice", handle: server.convertedPriceHandler },
+		{ method: "GET", path: "/v1/exchange-rates", summary: "List exchange rates",
+			query: []parameter{ { "currency", "string", "only rates from or to this currency" } },
+			response: "ExchangeRateList", handle: server.exchangeRatesHandler },
+		{ method: "GET", path: "/v1/reports/price-changes", admin: true, summary: "Price changes in a period by category",
+			query: []parameter{ { "from", "string", "RFC 3339 start of the period" },
+				{ "to", "string", "RFC 3339 end of the period" } },
+			response: "PriceReport", handle: server.priceReportHandler },
+		{ method: "GET", path: "/v1/boats", summary: "List boats for sale", query: listParameters,
+			response: "ProductList", handle: server.listHandler(store.TypeBoat) },
+		{ method: "GET", path: "/v1/rentals", summary: "List rental boats", query: listParameters,
+			response: "ProductList", handle: server.listHandler(store.TypeRental) },
+		{ method: "GET", path: "/v1/bundles", summary: "List bundles", query: listParameters,
+			response: "ProductList", handle: server.listHandler(store.TypeBundle) },
+		{ method: "GET", path: "/v1/rentals/available", summary: "Rental boats free for a period",
+			query: available, response: "Availability", handle: server.availableHandler },
+		{ method: "PUT", path: "/v1/rentals/{sku}/rates", admin: true, summary: "Set the rates for a rental boat",
+			body: "Rates", response: "Rates", handle: server.ratesHandler },
+		{ method: "GET", path: "/v1/rentals/{sku}/quote", summary: "Price a rental period",
+			query: periodParameters, response: "Quote", handle: server.quoteHandler },
+		{ method: "POST", path: "/v1/rentals/{sku}/bookings", summary: "Book a rental boat",
+			body: "BookingRequest", response: "Booking", status: http.StatusCreated, handle: server.bookHandler },
+		{ method: "GET", path: "/v1/bookings/{id}", admin: true, summary: "Get a booking",
+			response: "Booking", handle: server.bookingHandler },
+		{ method: "DELETE", path: "/v1/bookings/{id}", admin: true, summary: "Cancel a booking and refund it",
+			response: "Booking", handle: server.cancelBookingHandler },
+		{ method: "GET", path: "/v1/deals", summary: "List deals",
+			response: "DealList", handle: server.dealsHandler },
+		{ method: "GET", path: "/v1/deals/{sku}", summary: "Get the deal on a catalog item",
+			response: "Deal", handle: server.dealHandler },
+		{ method: "PUT", path: "/v1/deals/{sku}", admin: true, summary: "Set the deal on a catalog item",
+			body: "Deal", response: "Deal", handle: server.putDealHandler },
+		{ method: "DELETE", path: "/v1/deals/{sku}", admin: true, summary: "Remove the deal on a catalog item",
+			status: http.StatusNoContent, handle: server.deleteDealHandler },
+		{ method: "POST", path: "/v1/carts", summary: "Start a cart",
+			query: []parameter{ { "currency", "string", "currency to total the cart in, if not the catalog's" } },
+			response: "Cart", status: http.StatusCreated, handle: server.newCartHandler },
+		{ method: "GET", path: "/v1/carts/{id}", summary: "Get a cart with its totals",
+			response: "Cart", handle: server.cartHandler },
+		{ method: "POST", path: "/v1/carts/{id}/lines", summary: "Add an item to a cart",
+			body: "LineRequest", response: "Cart", handle: server.addLineHandler },
+		{ method: "PUT", path: "/v1/carts/{id}/lines/{sku}", summary: "Change the quantity of a line",
+			body: "QuantityRequest", response: "Cart", handle: server.setLineHandler },
+		{ method: "DELETE", path: "/v1/carts/{id}/lines/{sku}", summary: "Remove a line from a cart",
+			response: "Cart", handle: server.removeLineHandler },
+		{ method: "PUT", path: "/v1/carts/{id}/customer", admin: true, summary: "Price a cart for a customer, redeeming points",
+			body: "CartCustomerRequest", response: "Cart", handle: server.cartCustomerHandler },
+		{ method: "PUT", path: "/v1/carts/{id}/gift-cards", summary: "Pay part of a cart by gift card",
+			body: "GiftCardPaymentRequest", response: "Cart", handle: server.payByGiftCardHandler },
+		{ method: "DELETE", path: "/v1/carts/{id}/gift-cards/{code}", summary: "Stop paying a cart by a gift card",
+			response: "Cart", handle: server.removeGiftCardHandler },
+		{ method: "POST", path: "/v1/carts/{id}/checkout", summary: "Place an order for a cart",
+			response: "Order", status: http.StatusCreated, handle: server.checkoutHandler },
+		{ method: "GET", path: "/v1/orders", admin: true, summary: "List orders",
+			response: "OrderList", handle: server.ordersHandler },
+		{ method: "GET", path: "/v1/orders/{number}", admin: true, summary: "Get an order",
+			response: "Order", handle: server.orderHandler },
+		{ method: "GET", path: "/v1/loyalty", summary: "The loyalty program",
+			response: "LoyaltyProgram", handle: server.loyaltyHandler },
+		{ method: "GET", path: "/v1/customers", admin: true, summary: "List customer accounts",
+			query: []parameter{ { "email", "string", "only the customer with this email address" } },
+			response: "CustomerList", handle: server.customersHandler },
+		{ method: "POST", path: "/v1/customers", summary: "Open a customer account",
+			body: "CustomerRequest", response: "Customer", status: http.StatusCreated, handle: server.registerHandler },
+		{ method: "GET", path: "/v1/customers/{id}", admin: true, summary: "Get a customer account with its points",
+			response: "Customer", handle: server.customerHandler },
+		{ method: "PUT", path: "/v1/customers/{id}", admin: true, summary: "Change a customer's details",
+			body: "CustomerRequest", response: "Customer", handle: server.updateCustomerHandler },
+		{ method: "GET", path: "/v1/customers/{id}/orders", admin: true, summary: "Orders placed for a customer",
+			response: "OrderList", handle: server.customerOrdersHandler },
+		{ method: "POST", path: "/v1/customers/{id}/store-credit", admin: true, summary: "Give a customer store credit",
+			body: "StoreCreditRequest", response: "GiftCard", status: http.StatusCreated, handle: server.storeCreditHandler },
+		{ method: "GET", path: "/v1/gift-cards", admin: true, summary: "List gift cards with their codes masked",
+			query: []parameter{ { "customer", "string", "only store credit of this customer" } },
+			response: "GiftCardList", handle: server.giftCardsHandler },
+		{ method: "POST", path: "/v1/gift-cards", admin: true, summary: "Issue a gift card, showing its code this once",
+			body: "GiftCardRequest", response: "GiftCard", status: http.StatusCreated, handle: server.issueGiftCardHandler },
+		{ method: "GET", path: "/v1/gift-cards/{code}", summary: "Balance of a gift card",
+			response: "GiftCard", handle: server.giftCardHandler },
+		{ method: "GET", path: "/v1/gift-cards/{code}/movements", summary: "Ledger postings of a gift card",
+			response: "GiftCardLedger", handle: server.giftCardMovementsHandler },
+		{ method: "GET", path: "/v1/reports/gift-cards", admin: true, summary: "Gift card ledger reconciled by currency",
+			response: "GiftCardReport", handle: server.giftCardReportHandler },
+		{ method: "POST", path: "/v1/orders/{number}/invoice", admin: true, summary: "Invoice an order",
+			body: "Party", response: "Invoice", status: http.StatusCreated, handle: server.invoiceOrderHandler },
+		{ method: "POST", path: "/v1/orders/{number}/returns", admin: true, summary: "Ask to return lines of an order",
+			body: "ReturnRequest", response: "Return", status: http.StatusCreated, handle: server.requestReturnHandler },
+		{ method: "GET", path: "/v1/returns", admin: true, summary: "List returns",
+			query: []parameter{ { "order", "string", "only returns against this order" } },
+			response: "ReturnList", handle: server.returnsHandler },
+		{ method: "GET", path: "/v1/returns/{id}", admin: true, summary: "Get a return",
+			response: "Return", handle: server.returnHandler },
+		{ method: "POST", path: "/v1/returns/{id}/approve", admin: true, summary: "Approve a requested return",
+			response: "Return", handle: server.returnAction(server.approveReturn) },
+		{ method: "POST", path: "/v1/returns/{id}/reject", admin: true, summary: "Turn down a requested return",
+			body: "RejectRequest", response: "Return", handle: server.returnAction(server.rejectReturn) },
+		{ method: "POST", path: "/v1/returns/{id}/cancel", admin: true, summary: "Cancel a return before the goods arrive",
+			response: "Return", handle: server.returnAction(server.cancelReturn) },
+		{ method: "POST", path: "/v1/returns/{id}/receive", admin: true, summary: "Book returned goods in, noting any damage",
+			body: "ReceiveRequest", response: "Return", handle: server.returnAction(server.receiveReturn) },
+		{ method: "POST", path: "/v1/returns/{id}/complete", admin: true, summary: "Refund a received return and send exchanges",
+			response: "Return", handle: server.returnAction(server.completeReturn) },
+		{ method: "GET", path: "/v1/invoices", admin: true, summary: "List invoices and credit notes",
+			query: []parameter{ { "order", "string", "only documents for this order" } },
+			response: "InvoiceList", handle: server.invoicesHandler },
+		{ method: "GET", path: "/v1/invoices/{number}", admin: true, summary: "Get an invoice or credit note",
+			query: []parameter{ { "format", "string", "json, or text, html or pdf for a printable document" } },
+			response: "Invoice", handle: server.invoiceHandler },
+		{ method: "POST", path: "/v1/invoices/{number}/credit-notes", admin: true, summary: "Refund lines of an invoice",
+			body: "CreditNoteRequest", response: "Invoice", status: http.StatusCreated,
+			handle: server.creditNoteHandler },
+		{ method: "GET", path: "/v1/suppliers", admin: true, summary: "List suppliers",
+			response: "SupplierList", handle: server.suppliersHandler },
+		{ method: "GET", path: "/v1/purchase-orders", admin: true, summary: "List purchase orders",
+			query: []parameter{ { "status", "string", "open, received or cancelled" } },
+			response: "PurchaseOrderList", handle: server.purchaseOrdersHandler },
+		{ method: "POST", path: "/v1/purchase-orders", admin: true, summary: "Order the stock at its reorder point",
+			response: "PurchaseOrderList", status: http.StatusCreated, handle: server.replenishHandler },
+		{ method: "GET", path: "/v1/purchase-orders/{number}", admin: true, summary: "Get a purchase order",
+			response: "PurchaseOrder", handle: server.purchaseOrderHandler },
+		{ method: "POST", path: "/v1/purchase-orders/{number}/receive", admin: true, summary: "Book the goods on a purchase order into stock",
+			body: "DeliveryRequest", response: "PurchaseOrder", handle: server.receivePurchaseHandler },
+		{ method: "DELETE", path: "/v1/purchase-orders/{number}", admin: true, summary: "Cancel an open purchase order",
+			response: "PurchaseOrder", handle: server.cancelPurchaseHandler },
+	}
+}
+
+type productList struct {
+	Items []store.CatalogEntry `json:"items"`
+	Total int `json:"total"`
+}
+
+func queryInt(r apiRequest, name string) (int, error) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < 0 {
+		return 0, badRequest("%v must be a whole number of zero or more", name)
+	}
+	return value, nil
+}
+
+func queryTime(r apiRequest, name string) (time.Time, error) {
+	value, err := time.Parse(time.RFC3339, r.URL.Query().Get(name))
+	if err != nil {
+		return time.Time{}, badRequest("%v must be an RFC 3339 time", name)
+	}
+	return value, nil
+}
+
+func parseQuery(r apiRequest, itemType string) (store.ProductQuery, error) {
+	values := r.URL.Query()
+	query := store.ProductQuery{ Type: itemType, Category: values.Get("category"), SortBy: values.Get("sort") }
+	if itemType == "" {
+		query.Type = values.Get("type")
+	}
+	switch query.Type {
+	case "", store.TypeProduct, store.TypeBoat, store.TypeRental, store.TypeBundle:
+	default:
+		return query, badRequest("unknown type %q", query.Type)
+	}
+	switch query.SortBy {
+	case "", store.SortBySKU, store.SortByName, store.SortByPrice, store.SortByCapacity:
+	default:
+		return query, badRequest("cannot sort by %q", query.SortBy)
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, badRequest("order must be asc or desc")
+	}
+	for name, target := range map[string]*store.Money{ "minPrice": &query.MinPrice, "maxPrice": &query.MaxPrice } {
+		if text := values.Get(name); text != "" {
+			price, err := store.ParseAmount(text, strings.ToUpper(values.Get("currency")))
+			if err != nil || values.Get("currency") == "" {
+				return query, badRequest("%v needs a decimal amount and a currency", name)
+			}
+			*target = price
+		}
+	}
+	var err error
+	if query.MinCapacity, err = queryInt(r, "minCapacity"); err != nil {
+		return query, err
+	}
+	if text := values.Get("motorized"); text != "" {
+		motorized, err := strconv.ParseBool(text)
+		if err != nil {
+			return query, badRequest("motorized must be true or false")
+		}
+		query.Motorized = &motorized
+	}
+	if query.Offset, err = queryInt(r, "offset"); err != nil {
+		return query, err
+	}
+	if query.Limit, err = queryInt(r, "limit"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+func (server *Server) listHandler(itemType string) func(apiRequest) (interface{}, error) {
+	return func(r apiRequest) (interface{}, error) {
+		query, err := parseQuery(r, itemType)
+		if err != nil {
+			return nil, err
+		}
+		result := server.Catalog.Query(query)
+		return productList{ result.Entries, result.Total }, nil
+	}
+}
+
+func (server *Server) entry(sku string) (store.CatalogEntry, error) {
+	item, found := server.Catalog.Get(sku)
+	if !found {
+		return store.CatalogEntry{}, notFound("no product with SKU %v", sku)
+	}
+	return store.CatalogEntry{ SKU: sku, Item: item }, nil
+}
+
+func (server *Server) productHandler(r apiRequest) (interface{}, error) {
+	return server.entry(r.vars["sku"])
+}
+
+func (server *Server) putProductHandler(r apiRequest) (interface{}, error) {
+	sku := r.vars["sku"]
+	var body json.RawMessage
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	item, err := store.UnmarshalItem(body)
+	if err != nil {
+		return nil, badRequest("%v", strings.TrimPrefix(err.Error(), "store: "))
+	}
+	var current interface{}
+	if existing, err := server.entry(sku); err == nil {
+		current = existing
+	}
+	if err := checkIfMatch(r, current); err != nil {
+		return nil, err
+	}
+	if err := server.Prices.Put(sku, item); err != nil {
+		return nil, err
+	}
+	return store.CatalogEntry{ SKU: sku, Item: item }, nil
+}
+
+func (server *Server) deleteProductHandler(r apiRequest) (interface{}, error) {
+	existing, err := server.entry(r.vars["sku"])
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(r, existing); err != nil {
+		return nil, err
+	}
+	return nil, server.Catalog.Remove(existing.SKU)
+}
+
+func (server *Server) rental(sku string) (*store.RentalBoat, error) {
+	item, found := server.Catalog.Get(sku)
+	rental, isRental := item.(*store.RentalBoat)
+	if !found || !isRental {
+		return nil, notFound("no rental boat with SKU %v", sku)
+	}
+	return rental, nil
+}
+
+func (server *Server) ratesHandler(r apiRequest) (interface{}, error) {
+	rental, err := server.rental(r.vars["sku"])
+	if err != nil {
+		return nil, err
+	}
+	var rates store.RentalRates
+	if err := decode(r, &rates); err != nil {
+		return nil, err
+	}
+	if err := server.Calendar.AddBoat(r.vars["sku"], rental, rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+type quoteBody struct {
+	SKU string `json:"sku"`
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+	Price store.Money `json:"price"`
+}
+
+func (server *Server) quoteHandler(r apiRequest) (interface{}, error) {
+	start, err := queryTime(r, "start")
+	if err != nil {
+		return nil, err
+	}
+	end, err := queryTime(r, "end")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := server.rental(r.vars["sku"]); err != nil {
+		return nil, err
+	}
+	price, err := server.Calendar.Quote(r.vars["sku"], start, end)
+	if err != nil {
+		return nil, err
+	}
+	return quoteBody{ r.vars["sku"], start, end, price }, nil
+}
+
+type availabilityBody struct {
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+	SKUs []string `json:"skus"`
+}
+
+func (server *Server) availableHandler(r apiRequest) (interface{}, error) {
+	start, err := queryTime(r, "start")
+	if err != nil {
+		return nil, err
+	}
+	end, err := queryTime(r, "end")
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, badRequest("end must be after start")
+	}
+	capacity, err := queryInt(r, "capacity")
+	if err != nil {
+		return nil, err
+	}
+	return availabilityBody{ start, end, server.Calendar.Available(start, end, capacity) }, nil
+}
+
+type bookingRequest struct {
+	Customer string `json:"customer"`
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+}
+
+func (server *Server) bookHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.rental(r.vars["sku"]); err != nil {
+		return nil, err
+	}
+	var body bookingRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(body.Customer) == "" {
+		return nil, badRequest("a customer is required")
+	}
+	booking, err := server.Calendar.Book(r.vars["sku"], body.Customer, body.Start, body.End)
+	if err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+func (server *Server) bookingHandler(r apiRequest) (interface{}, error) {
+	booking, found := server.Calendar.Booking(r.vars["id"])
+	if !found {
+		return nil, notFound("no booking %v", r.vars["id"])
+	}
+	return booking, nil
+}
+
+func (server *Server) cancelBookingHandler(r apiRequest) (interface{}, error) {
+	if _, found := server.Calendar.Booking(r.vars["id"]); !found {
+		return nil, notFound("no booking %v", r.vars["id"])
+	}
+	if _, err := server.Calendar.Cancel(r.vars["id"]); err != nil {
+		return nil, err
+	}
+	return server.bookingHandler(r)
+}
+
+type dealList struct {
+	Items []store.DealEntry `json:"items"`
+}
+
+func (server *Server) dealsHandler(r apiRequest) (interface{}, error) {
+	return dealList{ server.Deals.Entries() }, nil
+}
+
+func (server *Server) dealHandler(r apiRequest) (interface{}, error) {
+	deal, found := server.Deals.Get(r.vars["sku"])
+	if !found {
+		return nil, notFound("no deal on %v", r.vars["sku"])
+	}
+	return store.DealEntry{ SKU: r.vars["sku"], Deal: deal }, nil
+}
+
+// putDealHandler attaches a deal to a catalog item. The deal is bound to the
+// product in the catalog, whatever product the body describes.
+func (server *Server) putDealHandler(r apiRequest) (interface{}, error) {
+	sku := r.vars["sku"]
+	if _, err := server.entry(sku); err != nil {
+		return nil, err
+	}
+	deal := &store.SpecialDeal{}
+	if err := decode(r, deal); err != nil {
+		return nil, err
+	}
+	var current interface{}
+	if existing, found := server.Deals.Get(sku); found {
+		current = store.DealEntry{ SKU: sku, Deal: existing }
+	}
+	if err := checkIfMatch(r, current); err != nil {
+		return nil, err
+	}
+	if err := server.Deals.Put(sku, deal); err != nil {
+		return nil, err
+	}
+	return store.DealEntry{ SKU: sku, Deal: deal }, nil
+}
+
+func (server *Server) deleteDealHandler(r apiRequest) (interface{}, error) {
+	if _, found := server.Deals.Get(r.vars["sku"]); !found {
+		return nil, notFound("no deal on %v", r.vars["sku"])
+	}
+	return nil, server.Deals.Remove(r.vars["sku"])
+}
+
+type lineBody struct {
+	SKU string `json:"sku"`
+	Name string `json:"name"`
+	Quantity int `json:"quantity"`
+	Components []store.BundleComponent `json:"components,omitempty"`
+	UnitPrice store.Money `json:"unitPrice"`
+	Subtotal store.Money `json:"subtotal"`
+	Discounts []discountBody `json:"discounts"`
+	Net store.Money `json:"net"`
+	Tax store.Money `json:"tax"`
+	Gross store.Money `json:"gross"`
+}
+
+type discountBody struct {
+	Name string `json:"name"`
+	Amount store.Money `json:"amount"`
+}
+
+type totalsBody struct {
+	Lines []lineBody `json:"lines"`
+	Subtotal store.Money `json:"subtotal"`
+	Discount store.Money `json:"discount"`
+	Net store.Money `json:"net"`
+	Tax store.Money `json:"tax"`
+	Total store.Money `json:"total"`
+	Rates []store.ExchangeRate `json:"exchangeRates,omitempty"`
+	Points int `json:"pointsRedeemed,omitempty"`
+	GiftCards []store.GiftCardPayment `json:"giftCards,omitempty"`
+	Due store.Money `json:"due"`
+}
+
+func toTotalsBody(totals store.CartTotals) totalsBody {
+	body := totalsBody{ Lines: []lineBody{}, Subtotal: totals.Subtotal, Discount: totals.Discount,
+		Net: totals.Net, Tax: totals.Tax, Total: totals.Total, Rates: totals.Rates, Points: totals.Points,
+		GiftCards: totals.GiftCards, Due: totals.Due }
+	for _, line := range totals.Lines {
+		discounts := []discountBody{}
+		for _, d := range line.Discounts {
+			discounts = append(discounts, discountBody{ d.Name, d.Amount })
+		}
+		body.Lines = append(body.Lines, lineBody{ line.SKU, line.Name, line.Quantity, line.Components,
+			line.UnitPrice, line.Subtotal, discounts, line.Net, line.Tax.Tax, line.Gross })
+	}
+	return body
+}
+
+type cartBody struct {
+	ID string `json:"id"`
+	Customer string `json:"customer,omitempty"`
+	totalsBody
+}
+
+// lineRequest names a product and, for products sold in variants, the
+// options that pick one of them.
+type lineRequest struct {
+	SKU string `json:"sku"`
+	Options store.Options `json:"options,omitempty"`
+	Quantity int `json:"quantity"`
+}
+
+type quantityRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+func (server *Server) cartBody(id string, cart *store.Cart) cartBody {
+	return cartBody{ id, cart.Customer(), toTotalsBody(cart.Totals(time.Now())) }
+}
+
+// cart returns the cart with id, marking it used, unless it has been idle too
+// long. The server must be locked.
+func (server *Server) cart(id string) (*store.Cart, error) {
+	open, found := server.carts[id]
+	now := server.Now()
+	if found && now.Sub(open.used) >= server.CartIdle {
+		delete(server.carts, id)
+		found = false
+	}
+	if !found {
+		return nil, notFound("no cart %v", id)
+	}
+	open.used = now
+	return open.cart, nil
+}
+
+// dropIdleCarts forgets the carts that have not been used for CartIdle. The
+// server must be locked.
+func (server *Server) dropIdleCarts() {
+	now := server.Now()
+	for id, open := range server.carts {
+		if now.Sub(open.used) >= server.CartIdle {
+			delete(server.carts, id)
+		}
+	}
+}
+
+// withCart runs f on the cart named in the request while holding the lock,
+// then returns the cart as it stands.
+func (server *Server) withCart(r apiRequest, f func(*store.Cart) error) (interface{}, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	cart, err := server.cart(r.vars["id"])
+	if err != nil {
+		return nil, err
+	}
+	if err := f(cart); err != nil {
+		return nil, err
+	}
+	return server.cartBody(r.vars["id"], cart), nil
+}
+
+func (server *Server) newCartHandler(r apiRequest) (interface{}, error) {
+	cart := store.NewCart(server.Catalog, server.Taxes, server.Location)
+	if currency := strings.ToUpper(r.URL.Query().Get("currency")); currency != "" {
+		if server.Rates == nil {
+			return nil, badRequest("this shop only takes payment in its catalog's currencies")
+		}
+		if err := cart.UseCurrency(currency, server.Rates, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.carts) >= server.MaxCarts {
+		server.dropIdleCarts()
+	}
+	if len(server.carts) >= server.MaxCarts {
+		return nil, apiError(http.StatusServiceUnavailable, "too_many_carts", "the shop is busy, try again later")
+	}
+	id := newID()
+	server.carts[id] = &openCart{ cart, server.Now() }
+	return server.cartBody(id, cart), nil
+}
+
+func (server *Server) cartHandler(r apiRequest) (interface{}, error) {
+	return server.withCart(r, func(*store.Cart) error { return nil })
+}
+
+func (server *Server) addLineHandler(r apiRequest) (interface{}, error) {
+	var body lineRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if _, err := server.entry(body.SKU); err != nil {
+		return nil, err
+	}
+	return server.withCart(r, func(cart *store.Cart) error {
+		sku := body.SKU
+		if len(body.Options) > 0 {
+			variant, err := cart.AddVariant(body.SKU, body.Options, body.Quantity)
+			if err != nil {
+				return err
+			}
+			sku = variant.SKU
+		} else if err := cart.Add(body.SKU, body.Quantity); err != nil {
+			return err
+		}
+		if deal, found := server.Deals.Get(sku); found {
+			return cart.ApplyDeal(sku, deal)
+		}
+		return nil
+	})
+}
+
+func (server *Server) setLineHandler(r apiRequest) (interface{}, error) {
+	var body quantityRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return server.withCart(r, func(cart *store.Cart) error {
+		if !inCart(cart, r.vars["sku"]) {
+			return notFound("%v is not in the cart", r.vars["sku"])
+		}
+		return cart.SetQuantity(r.vars["sku"], body.Quantity)
+	})
+}
+
+func (server *Server) removeLineHandler(r apiRequest) (interface{}, error) {
+	return server.withCart(r, func(cart *store.Cart) error {
+		if !inCart(cart, r.vars["sku"]) {
+			return notFound("%v is not in the cart", r.vars["sku"])
+		}
+		return cart.Remove(r.vars["sku"])
+	})
+}
+
+func inCart(cart *store.Cart, sku string) bool {
+	for _, line := range cart.Lines() {
+		if line.SKU == sku {
+			return true
+		}
+	}
+	return false
+}
+
+type orderBody struct {
+	Number string `json:"number"`
+	Status store.OrderStatus `json:"status"`
+	Placed time.Time `json:"placed"`
+	Customer string `json:"customer,omitempty"`
+	Warning string `json:"warning,omitempty"`
+	totalsBody
+}
+
+type orderList struct {
+	Items []orderBody `json:"items"`
+}
+
+func toOrderBody(order *store.Order) orderBody {
+	return orderBody{ order.Number(), order.Status(), order.Placed(), order.Customer(), "", toTotalsBody(order.Totals()) }
+}
+
+// checkoutHandler places the order for a cart. The cart is taken out of the
+// server while it is checked out, so other requests are not held up while
+// stock, points and gift cards are settled and the cart cannot be checked out
+// twice; it is put back if no order is placed. An order that was placed but
+// not fully recorded is still returned, with a warning, as it has been paid.
+func (server *Server) checkoutHandler(r apiRequest) (interface{}, error) {
+	server.mutex.Lock()
+	cart, err := server.cart(r.vars["id"])
+	delete(server.carts, r.vars["id"])
+	server.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	order, err := server.Checkout.Place(cart)
+	if order == nil {
+		server.mutex.Lock()
+		server.carts[r.vars["id"]] = &openCart{ cart, server.Now() }
+		server.mutex.Unlock()
+		return nil, err
+	}
+	if added := server.Orders.Add(order); added != nil {
+		log.Printf("Keeping order %v: %v", order.Number(), added)
+		err = added
+	} else if err != nil {
+		log.Printf("Recording order %v: %v", order.Number(), err)
+	}
+	body := toOrderBody(order)
+	if err != nil {
+		body.Warning = "the order was placed but has not been fully recorded; the shop has been told"
+	}
+	return body, nil
+}
+
+func (server *Server) ordersHandler(r apiRequest) (interface{}, error) {
+	list := orderList{ Items: []orderBody{} }
+	for _, order := range server.Orders.List() {
+		list.Items = append(list.Items, toOrderBody(order))
+	}
+	return list, nil
+}
+
+func (server *Server) orderHandler(r apiRequest) (interface{}, error) {
+	order, err := server.order(r.vars["number"])
+	if err != nil {
+		return nil, err
+	}
+	return toOrderBody(order), nil
+}
@@ -0,0 +1,111 @@
+package storefront
+
+import (
+	"bytes"
+	"composition/store"
+	"net/http"
+)
+
+type invoiceList struct {
+	Items []*store.Invoice `json:"items"`
+}
+
+type creditNoteRequest struct {
+	Reason string `json:"reason"`
+	Lines []store.CreditLine `json:"lines"`
+}
+
+var invoiceFormats = map[string]struct {
+	contentType string
+	write func(*store.Invoice, *bytes.Buffer) error
+}{
+	"text": { "text/plain; charset=utf-8", func(invoice *store.Invoice, out *bytes.Buffer) error { return invoice.WriteText(out) } },
+	"html": { "text/html; charset=utf-8", func(invoice *store.Invoice, out *bytes.Buffer) error { return invoice.WriteHTML(out) } },
+	"pdf": { "application/pdf", func(invoice *store.Invoice, out *bytes.Buffer) error { return invoice.WritePDF(out) } },
+}
+
+func (server *Server) invoices() (store.Invoices, error) {
+	if server.Invoices == nil {
+		return nil, notFound("this shop does not issue invoices")
+	}
+	return server.Invoices, nil
+}
+
+func (server *Server) order(number string) (*store.Order, error) {
+	if order, found := server.Orders.Get(number); found {
+		return order, nil
+	}
+	return nil, notFound("no order %v", number)
+}
+
+func (server *Server) invoiceOrderHandler(r apiRequest) (interface{}, error) {
+	invoices, err := server.invoices()
+	if err != nil {
+		return nil, err
+	}
+	order, err := server.order(r.vars["number"])
+	if err != nil {
+		return nil, err
+	}
+	var customer store.Party
+	if err := decode(r, &customer); err != nil {
+		return nil, err
+	}
+	return invoices.Issue(order, customer)
+}
+
+func (server *Server) invoicesHandler(r apiRequest) (interface{}, error) {
+	invoices, err := server.invoices()
+	if err != nil {
+		return nil, err
+	}
+	order := r.URL.Query().Get("order")
+	list := invoiceList{ Items: []*store.Invoice{} }
+	for _, invoice := range invoices.List() {
+		if order == "" || invoice.Order == order {
+			list.Items = append(list.Items, invoice)
+		}
+	}
+	return list, nil
+}
+
+// invoiceHandler returns the invoice as JSON, or rendered when the format
+// parameter asks for text, html or pdf.
+func (server *Server) invoiceHandler(r apiRequest) (interface{}, error) {
+	invoices, err := server.invoices()
+	if err != nil {
+		return nil, err
+	}
+	invoice, found := invoices.Get(r.vars["number"])
+	if !found {
+		return nil, notFound("no invoice %v", r.vars["number"])
+	}
+	name := r.URL.Query().Get("format")
+	if name == "" || name == "json" {
+		return invoice, nil
+	}
+	format, known := invoiceFormats[name]
+	if !known {
+		return nil, badRequest("format must be json, text, html or pdf")
+	}
+	var out bytes.Buffer
+	if err := format.write(invoice, &out); err != nil {
+		return nil, apiError(http.StatusInternalServerError, "internal", "%v", err)
+	}
+	return document{ format.contentType, out.Bytes() }, nil
+}
+
+func (server *Server) creditNoteHandler(r apiRequest) (interface{}, error) {
+	invoices, err := server.invoices()
+	if err != nil {
+		return nil, err
+	}
+	if _, found := invoices.Get(r.vars["number"]); !found {
+		return nil, notFound("no invoice %v", r.vars["number"])
+	}
+	var body creditNoteRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return invoices.Credit(r.vars["number"], body.Reason, body.Lines...)
+}
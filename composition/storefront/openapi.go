@@ -0,0 +1,323 @@
+package storefront
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type object = map[string]interface{}
+
+func ref(name string) object {
+	return object{ "$ref": "#/components/schemas/" + name }
+}
+
+func properties(required []string, props object) object {
+	return object{ "type": "object", "required": required, "properties": props }
+}
+
+func listOf(name string) object {
+	return object{ "type": "array", "items": ref(name) }
+}
+
+var (
+	str = object{ "type": "string" }
+	integer = object{ "type": "integer" }
+	boolean = object{ "type": "boolean" }
+	timestamp = object{ "type": "string", "format": "date-time" }
+)
+
+// schemas describes every body named in the route table. NewServer checks
+// that each name a route uses is defined here.
+var schemas = map[string]object{
+	"Money": properties([]string{ "amount", "currency" }, object{
+		"amount": object{ "type": "string", "example": "279.00" },
+		"currency": object{ "type": "string", "example": "USD" },
+	}),
+	"Crew": properties([]string{ "captain" }, object{ "captain": str, "firstOfficer": str }),
+	"Product": properties([]string{ "type", "name", "price" }, object{
+		"sku": object{ "type": "string", "readOnly": true },
+		"type": object{ "type": "string", "enum": []string{ "product", "boat", "rental", "bundle" } },
+		"name": str, "category": str, "price": ref("Money"),
+		"capacity": integer, "motorized": boolean, "includeCrew": boolean, "crew": ref("Crew"),
+		"axes": object{ "type": "array", "items": str, "example": []string{ "size", "colour" } },
+		"variants": listOf("Variant"),
+		"options": object{ "allOf": []object{ ref("Options") }, "readOnly": true },
+		"components": listOf("Component"),
+		"discount": ref("Money"),
+	}),
+	"Options": object{ "type": "object", "additionalProperties": str, "example": object{ "size": "M" } },
+	"Component": properties([]string{ "sku", "quantity" }, object{ "sku": str, "quantity": integer }),
+	"Variant": properties([]string{ "sku", "options" }, object{
+		"sku": str, "options": ref("Options"), "delta": ref("Money"), "stock": integer,
+	}),
+	"ProductList": properties([]string{ "items", "total" }, object{ "items": listOf("Product"), "total": integer }),
+	"Rates": properties(nil, object{ "hourly": ref("Money"), "daily": ref("Money"), "weekly": ref("Money") }),
+	"Quote": properties([]string{ "sku", "start", "end", "price" }, object{
+		"sku": str, "start": timestamp, "end": timestamp, "price": ref("Money"),
+	}),
+	"Availability": properties([]string{ "start", "end", "skus" }, object{
+		"start": timestamp, "end": timestamp, "skus": object{ "type": "array", "items": str },
+	}),
+	"BookingRequest": properties([]string{ "customer", "start", "end" }, object{
+		"customer": str, "start": timestamp, "end": timestamp,
+	}),
+	"Booking": properties([]string{ "id", "sku", "customer", "start", "end", "price", "status" }, object{
+		"id": str, "sku": str, "customer": str, "start": timestamp, "end": timestamp, "price": ref("Money"),
+		"status": object{ "type": "string", "enum": []string{ "confirmed", "cancelled" } },
+		"refund": ref("Money"), "crew": ref("Crew"), "crewIds": object{ "type": "array", "items": str },
+	}),
+	"Discount": properties([]string{ "kind", "label" }, object{
+		"kind": object{ "type": "string", "enum": []string{ "fixed", "percentage", "buyxgety", "tiered" } },
+		"label": str, "stacking": object{ "type": "string", "enum": []string{ "stackable", "exclusive" } },
+		"off": ref("Money"), "rate": object{ "type": "number" }, "buy": integer, "free": integer,
+		"tiers": object{ "type": "array", "items": properties([]string{ "minQuantity", "unitPrice" },
+			object{ "minQuantity": integer, "unitPrice": ref("Money") }) },
+		"validFrom": timestamp, "validUntil": timestamp,
+	}),
+	"Deal": properties([]string{ "deal" }, object{
+		"sku": object{ "type": "string", "readOnly": true },
+		"deal": properties([]string{ "type", "name", "product", "discounts" }, object{
+			"type": object{ "type": "string", "enum": []string{ "deal" } },
+			"name": str, "product": ref("Product"), "floor": ref("Money"), "discounts": listOf("Discount"),
+		}),
+	}),
+	"DealList": properties([]string{ "items" }, object{ "items": listOf("Deal") }),
+	"Line": properties([]string{ "sku", "quantity" }, object{
+		"sku": str, "name": str, "quantity": integer, "unitPrice": ref("Money"), "subtotal": ref("Money"),
+		"components": listOf("Component"),
+		"discounts": object{ "type": "array", "items": properties([]string{ "name", "amount" },
+			object{ "name": str, "amount": ref("Money") }) },
+		"net": ref("Money"), "tax": ref("Money"), "gross": ref("Money"),
+	}),
+	"LineRequest": properties([]string{ "sku", "quantity" }, object{
+		"sku": str, "options": ref("Options"), "quantity": integer,
+	}),
+	"QuantityRequest": properties([]string{ "quantity" }, object{ "quantity": integer }),
+	"Cart": properties([]string{ "id", "lines" }, object{
+		"id": str, "lines": listOf("Line"), "subtotal": ref("Money"), "discount": ref("Money"),
+		"net": ref("Money"), "tax": ref("Money"), "total": ref("Money"), "exchangeRates": listOf("ExchangeRate"),
+		"customer": str, "pointsRedeemed": integer, "giftCards": listOf("GiftCardPayment"), "due": ref("Money"),
+	}),
+	"Order": properties([]string{ "number", "status", "placed", "lines" }, object{
+		"number": str, "placed": timestamp, "customer": str, "warning": str, "pointsRedeemed": integer,
+		"giftCards": listOf("GiftCardPayment"), "due": ref("Money"),
+		"status": object{ "type": "string", "enum": []string{ "placed", "paid", "shipped", "cancelled" } },
+		"lines": listOf("Line"), "subtotal": ref("Money"), "discount": ref("Money"),
+		"net": ref("Money"), "tax": ref("Money"), "total": ref("Money"), "exchangeRates": listOf("ExchangeRate"),
+	}),
+	"OrderList": properties([]string{ "items" }, object{ "items": listOf("Order") }),
+	"PriceVersion": properties([]string{ "price", "effective" }, object{ "price": ref("Money"), "effective": timestamp }),
+	"PriceHistory": properties([]string{ "sku", "versions" }, object{
+		"sku": str, "versions": listOf("PriceVersion"), "at": timestamp, "price": ref("Money"),
+	}),
+	"PriceChangeRequest": properties([]string{ "price" }, object{ "price": ref("Money"), "effective": timestamp }),
+	"PriceReport": properties([]string{ "from", "to", "categories" }, object{
+		"from": timestamp, "to": timestamp,
+		"categories": object{ "type": "array", "items": properties([]string{ "category", "changes" }, object{
+			"category": str, "increases": integer, "decreases": integer,
+			"changes": object{ "type": "array", "items": properties([]string{ "sku", "old", "new", "effective" },
+				object{ "sku": str, "name": str, "old": ref("Money"), "new": ref("Money"), "effective": timestamp }) },
+		}) },
+	}),
+	"ExchangeRate": properties([]string{ "from", "to", "rate", "effective" }, object{
+		"from": object{ "type": "string", "example": "USD" }, "to": object{ "type": "string", "example": "EUR" },
+		"rate": object{ "type": "number", "example": 0.92 }, "effective": timestamp,
+	}),
+	"ExchangeRateList": properties([]string{ "items" }, object{ "items": listOf("ExchangeRate") }),
+	"ConvertedPrice": properties([]string{ "sku", "price", "converted", "rate" }, object{
+		"sku": str, "price": ref("Money"), "converted": ref("Money"), "rate": ref("ExchangeRate"),
+	}),
+	"Party": properties([]string{ "name" }, object{
+		"name": str, "address": object{ "type": "array", "items": str }, "taxId": str, "email": str,
+	}),
+	"InvoiceTax": properties([]string{ "name", "rate", "base", "amount" }, object{
+		"name": str, "rate": object{ "type": "number", "example": 0.2 }, "base": ref("Money"), "amount": ref("Money"),
+	}),
+	"InvoiceLine": properties([]string{ "sku", "description", "quantity", "unitPrice", "net", "gross" }, object{
+		"sku": str, "description": str, "quantity": integer, "unitPrice": ref("Money"), "subtotal": ref("Money"),
+		"discounts": object{ "type": "array", "items": properties([]string{ "name", "amount" },
+			object{ "name": str, "amount": ref("Money") }) },
+		"net": ref("Money"), "taxes": listOf("InvoiceTax"), "tax": ref("Money"), "gross": ref("Money"),
+	}),
+	"Invoice": properties([]string{ "number", "kind", "issued", "order", "seller", "customer", "lines", "total" }, object{
+		"number": object{ "type": "string", "example": "INV-000001" },
+		"kind": object{ "type": "string", "enum": []string{ "invoice", "credit note" } },
+		"issued": timestamp, "order": str, "original": str, "reason": str,
+		"seller": ref("Party"), "customer": ref("Party"), "lines": listOf("InvoiceLine"),
+		"subtotal": ref("Money"), "discount": ref("Money"), "net": ref("Money"), "tax": ref("Money"),
+		"total": ref("Money"), "taxSummary": listOf("InvoiceTax"), "exchangeRates": listOf("ExchangeRate"),
+	}),
+	"InvoiceList": properties([]string{ "items" }, object{ "items": listOf("Invoice") }),
+	"CreditNoteRequest": properties(nil, object{
+		"reason": str, "lines": object{ "type": "array", "items": properties([]string{ "sku", "quantity" },
+			object{ "sku": str, "quantity": integer }) },
+	}),
+	"ReturnLine": properties([]string{ "sku", "quantity" }, object{
+		"sku": str, "quantity": integer, "exchangeFor": str,
+		"damaged": object{ "type": "integer", "readOnly": true },
+	}),
+	"StockMovement": properties([]string{ "sku", "quantity" }, object{ "sku": str, "quantity": integer }),
+	"ReturnRequest": properties([]string{ "lines" }, object{ "reason": str, "lines": listOf("ReturnLine") }),
+	"RejectRequest": properties(nil, object{ "note": str }),
+	"ReceiveRequest": properties(nil, object{
+		"damaged": object{ "type": "object", "additionalProperties": integer, "example": object{ "KAY-1": 1 } },
+	}),
+	"Return": properties([]string{ "id", "order", "status", "lines" }, object{
+		"id": object{ "type": "string", "example": "RMA-000001" }, "order": str, "customer": str,
+		"status": object{ "type": "string", "enum": []string{ "requested", "approved", "rejected", "received",
+			"completed", "cancelled" } },
+		"reason": str, "note": str, "lines": listOf("ReturnLine"), "requested": timestamp, "updated": timestamp,
+		"refunds": listOf("InvoiceLine"), "refund": ref("Money"), "creditNote": str,
+		"giftCards": listOf("GiftCardPayment"), "points": integer,
+		"restocked": listOf("StockMovement"), "writtenOff": listOf("StockMovement"),
+		"replacements": listOf("StockMovement"),
+	}),
+	"ReturnList": properties([]string{ "items" }, object{ "items": listOf("Return") }),
+	"Supplier": properties([]string{ "id", "name" }, object{ "id": str, "name": str, "city": str }),
+	"SupplierList": properties([]string{ "items" }, object{ "items": listOf("Supplier") }),
+	"PurchaseLine": properties([]string{ "sku", "quantity", "unitCost", "total" }, object{
+		"sku": str, "quantity": integer, "unitCost": ref("Money"), "total": ref("Money"),
+	}),
+	"PurchaseOrder": properties([]string{ "number", "supplier", "lines", "total", "status" }, object{
+		"number": object{ "type": "string", "example": "PO-000001" }, "supplier": ref("Supplier"),
+		"lines": listOf("PurchaseLine"), "total": ref("Money"), "created": timestamp, "expected": timestamp,
+		"status": object{ "type": "string", "enum": []string{ "open", "received", "cancelled" } },
+	}),
+	"PurchaseOrderList": properties([]string{ "items" }, object{ "items": listOf("PurchaseOrder") }),
+	"DeliveryRequest": properties(nil, object{ "location": object{ "type": "string", "example": "Warehouse" } }),
+	"Address": properties([]string{ "lines", "country" }, object{
+		"label": object{ "type": "string", "example": "home" },
+		"lines": object{ "type": "array", "items": str }, "country": str, "region": str,
+	}),
+	"CustomerRequest": properties([]string{ "name", "email" }, object{
+		"name": str, "email": str, "addresses": listOf("Address"),
+	}),
+	"PointsEntry": properties([]string{ "time", "kind", "points" }, object{
+		"time": timestamp, "kind": object{ "type": "string", "enum": []string{ "earned", "redeemed", "expired", "refunded" } },
+		"points": integer, "order": str, "expires": timestamp, "remaining": integer,
+	}),
+	"Customer": properties([]string{ "id", "name", "email", "points" }, object{
+		"id": object{ "type": "string", "example": "CUS-000001" }, "name": str, "email": str,
+		"addresses": listOf("Address"), "joined": timestamp,
+		"orders": object{ "type": "array", "items": str },
+		"spend": ref("Money"), "tier": str, "points": integer, "ledger": listOf("PointsEntry"),
+	}),
+	"CustomerList": properties([]string{ "items" }, object{ "items": listOf("Customer") }),
+	"CartCustomerRequest": properties([]string{ "customer" }, object{ "customer": str, "points": integer }),
+	"LoyaltyProgram": properties([]string{ "currency", "earnRate", "tiers" }, object{
+		"currency": str, "earnRate": object{ "type": "number", "example": 0.05 },
+		"expiry": object{ "type": "integer", "description": "nanoseconds points last, or 0 for good" },
+		"tiers": object{ "type": "array", "items": properties([]string{ "name", "minSpend", "discount" }, object{
+			"name": str, "minSpend": ref("Money"), "discount": object{ "type": "number", "example": 0.05 },
+		}) },
+	}),
+	"GiftCardPayment": properties([]string{ "code", "amount" }, object{ "code": str, "amount": ref("Money") }),
+	"GiftCardPaymentRequest": properties([]string{ "code" }, object{ "code": str, "amount": ref("Money") }),
+	"GiftCardRequest": properties([]string{ "amount" }, object{ "amount": ref("Money"), "expires": timestamp, "note": str }),
+	"StoreCreditRequest": properties([]string{ "amount" }, object{ "amount": ref("Money"), "note": str }),
+	"GiftCard": properties([]string{ "code", "initial", "balance", "issued" }, object{
+		"code": object{ "type": "string", "example": "ABCD-EFGH-JKLM-NPQR" },
+		"initial": ref("Money"), "balance": ref("Money"), "issued": timestamp, "expires": timestamp,
+		"customer": str, "note": str,
+	}),
+	"MaskedGiftCard": properties([]string{ "code", "initial", "balance", "issued" }, object{
+		"code": object{ "type": "string", "example": "****-****-****-NPQR",
+			"description": "the code with all but its last four symbols hidden" },
+		"initial": ref("Money"), "balance": ref("Money"), "issued": timestamp, "expires": timestamp,
+		"customer": str, "note": str,
+	}),
+	"GiftCardList": properties([]string{ "items" }, object{ "items": listOf("MaskedGiftCard") }),
+	"GiftCardPosting": properties([]string{ "entry", "time", "movement", "account", "amount" }, object{
+		"entry": integer, "time": timestamp,
+		"movement": object{ "type": "string", "enum": []string{ "issued", "redeemed", "expired", "refunded" } },
+		"account": str, "amount": ref("Money"), "order": str,
+	}),
+	"GiftCardLedger": properties([]string{ "items" }, object{ "items": listOf("GiftCardPosting") }),
+	"GiftCardReport": properties([]string{ "items" }, object{ "items": object{ "type": "array",
+		"items": properties([]string{ "currency", "issued", "refunded", "redeemed", "expired", "outstanding",
+			"difference" }, object{
+			"currency": str, "issued": ref("Money"), "refunded": ref("Money"), "redeemed": ref("Money"),
+			"expired": ref("Money"),
+			"outstanding": ref("Money"), "difference": ref("Money"),
+			"mismatched": object{ "type": "array", "items": object{ "type": "string", "example": "****-****-****-NPQR" } },
+		}) } }),
+	"OpenAPI": object{ "type": "object" },
+	"Error": properties([]string{ "error" }, object{
+		"error": properties([]string{ "code", "message" }, object{ "code": str, "message": str }),
+	}),
+}
+
+// checkSchemas reports any body or response a route names without a schema.
+func checkSchemas(routes []route) error {
+	for _, r := range routes {
+		for _, name := range []string{ r.body, r.response } {
+			if _, found := schemas[name]; name != "" && !found {
+				return fmt.Errorf("storefront: %v %v uses undefined schema %v", r.method, r.path, name)
+			}
+		}
+	}
+	return nil
+}
+
+func jsonContent(name string) object {
+	return object{ "application/json": object{ "schema": ref(name) } }
+}
+
+// OpenAPI builds the OpenAPI 3 document for the server's routes.
+func (server *Server) OpenAPI() object {
+	paths := object{}
+	for _, r := range server.routes {
+		item, found := paths[r.path].(object)
+		if !found {
+			item = object{}
+			paths[r.path] = item
+		}
+		parameters := []object{}
+		for _, segment := range strings.Split(r.path, "/") {
+			if strings.HasPrefix(segment, "{") {
+				parameters = append(parameters, object{ "name": strings.Trim(segment, "{}"), "in": "path",
+					"required": true, "schema": str })
+			}
+		}
+		for _, p := range r.query {
+			parameters = append(parameters, object{ "name": p.name, "in": "query",
+				"description": p.description, "schema": object{ "type": p.kind } })
+		}
+		status := r.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := object{ "description": http.StatusText(status) }
+		if r.response != "" {
+			success["content"] = jsonContent(r.response)
+		}
+		operation := object{
+			"summary": r.summary,
+			"parameters": parameters,
+			"responses": object{
+				strconv.Itoa(status): success,
+				"default": object{ "description": "Error", "content": jsonContent("Error") },
+			},
+		}
+		if r.admin {
+			operation["security"] = []object{ { "adminKey": []string{} } }
+		}
+		if r.body != "" {
+			operation["requestBody"] = object{ "required": true, "content": jsonContent(r.body) }
+		}
+		item[strings.ToLower(r.method)] = operation
+	}
+	return object{
+		"openapi": "3.0.3",
+		"info": object{ "title": "Store API", "version": Version },
+		"paths": paths,
+		"components": object{ "schemas": schemas,
+			"securitySchemes": object{ "adminKey": object{ "type": "http", "scheme": "bearer" } } },
+	}
+}
+
+func (server *Server) openAPIHandler(r apiRequest) (interface{}, error) {
+	return server.OpenAPI(), nil
+}
@@ -0,0 +1,91 @@
+package storefront
+
+import (
+	"composition/store"
+	"time"
+)
+
+type priceHistoryBody struct {
+	SKU string `json:"sku"`
+	Versions []store.PriceVersion `json:"versions"`
+	At *time.Time `json:"at,omitempty"`
+	Price *store.Money `json:"price,omitempty"`
+}
+
+type priceChangeRequest struct {
+	Price store.Money `json:"price"`
+	Effective time.Time `json:"effective"`
+}
+
+type priceReportBody struct {
+	From time.Time `json:"from"`
+	To time.Time `json:"to"`
+	Categories []store.CategoryPriceChanges `json:"categories"`
+}
+
+func (server *Server) priceHistory(sku string) (priceHistoryBody, error) {
+	if _, err := server.entry(sku); err != nil {
+		return priceHistoryBody{}, err
+	}
+	return priceHistoryBody{ SKU: sku, Versions: server.Prices.Versions(sku) }, nil
+}
+
+func (server *Server) pricesHandler(r apiRequest) (interface{}, error) {
+	body, err := server.priceHistory(r.vars["sku"])
+	if err != nil || r.URL.Query().Get("at") == "" {
+		return body, err
+	}
+	at, err := queryTime(r, "at")
+	if err != nil {
+		return nil, err
+	}
+	price, _ := server.Prices.PriceAt(body.SKU, at)
+	body.At, body.Price = &at, &price
+	return body, nil
+}
+
+func (server *Server) schedulePriceHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.entry(r.vars["sku"]); err != nil {
+		return nil, err
+	}
+	var body priceChangeRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if body.Price.Currency() == "" {
+		return nil, badRequest("a price is required")
+	}
+	if err := server.Prices.Schedule(r.vars["sku"], body.Price, body.Effective); err != nil {
+		return nil, err
+	}
+	return server.priceHistory(r.vars["sku"])
+}
+
+func (server *Server) cancelPriceHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.entry(r.vars["sku"]); err != nil {
+		return nil, err
+	}
+	effective, err := queryTime(r, "effective")
+	if err != nil {
+		return nil, err
+	}
+	if err := server.Prices.Cancel(r.vars["sku"], effective); err != nil {
+		return nil, err
+	}
+	return server.priceHistory(r.vars["sku"])
+}
+
+func (server *Server) priceReportHandler(r apiRequest) (interface{}, error) {
+	from, err := queryTime(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := queryTime(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	if !to.After(from) {
+		return nil, badRequest("to must be after from")
+	}
+	return priceReportBody{ from, to, server.Prices.Report(from, to) }, nil
+}
@@ -0,0 +1,105 @@
+package storefront
+
+import (
+	"composition/store"
+)
+
+type supplierList struct {
+	Items []store.Supplier `json:"items"`
+}
+
+type purchaseOrderList struct {
+	Items []*store.PurchaseOrder `json:"items"`
+}
+
+// deliveryRequest names where the goods on a purchase order are booked in.
+type deliveryRequest struct {
+	Location string `json:"location"`
+}
+
+func (server *Server) purchasing() (*store.Purchasing, error) {
+	if server.Purchasing == nil || server.Inventory == nil {
+		return nil, notFound("this shop does not keep purchase orders")
+	}
+	return server.Purchasing, nil
+}
+
+func (server *Server) suppliersHandler(r apiRequest) (interface{}, error) {
+	purchasing, err := server.purchasing()
+	if err != nil {
+		return nil, err
+	}
+	return supplierList{ purchasing.Suppliers() }, nil
+}
+
+func (server *Server) purchaseOrdersHandler(r apiRequest) (interface{}, error) {
+	purchasing, err := server.purchasing()
+	if err != nil {
+		return nil, err
+	}
+	status := r.URL.Query().Get("status")
+	orders := []*store.PurchaseOrder{}
+	for _, order := range purchasing.Orders() {
+		if status == "" || string(order.Status) == status {
+			orders = append(orders, order)
+		}
+	}
+	return purchaseOrderList{ orders }, nil
+}
+
+func (server *Server) purchaseOrder(number string) (*store.PurchaseOrder, error) {
+	purchasing, err := server.purchasing()
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range purchasing.Orders() {
+		if order.Number == number {
+			return order, nil
+		}
+	}
+	return nil, notFound("no purchase order %v", number)
+}
+
+func (server *Server) purchaseOrderHandler(r apiRequest) (interface{}, error) {
+	return server.purchaseOrder(r.vars["number"])
+}
+
+// replenishHandler raises purchase orders for the stock at its reorder
+// point. Orders raised before a SKU without a supplier was found are kept.
+func (server *Server) replenishHandler(r apiRequest) (interface{}, error) {
+	purchasing, err := server.purchasing()
+	if err != nil {
+		return nil, err
+	}
+	orders, err := purchasing.Replenish(server.Inventory)
+	if err != nil && len(orders) == 0 {
+		return nil, err
+	}
+	return purchaseOrderList{ orders }, nil
+}
+
+func (server *Server) receivePurchaseHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.purchaseOrder(r.vars["number"]); err != nil {
+		return nil, err
+	}
+	body := deliveryRequest{ Location: "Warehouse" }
+	if r.ContentLength != 0 {
+		if err := decode(r, &body); err != nil {
+			return nil, err
+		}
+	}
+	if err := server.Purchasing.Receive(r.vars["number"], server.Inventory, body.Location); err != nil {
+		return nil, err
+	}
+	return server.purchaseOrder(r.vars["number"])
+}
+
+func (server *Server) cancelPurchaseHandler(r apiRequest) (interface{}, error) {
+	if _, err := server.purchaseOrder(r.vars["number"]); err != nil {
+		return nil, err
+	}
+	if err := server.Purchasing.Cancel(r.vars["number"]); err != nil {
+		return nil, err
+	}
+	return server.purchaseOrder(r.vars["number"])
+}
@@ -0,0 +1,88 @@
+package storefront
+
+import (
+	"composition/store"
+)
+
+type returnRequest struct {
+	Reason string `json:"reason"`
+	Lines []store.ReturnLine `json:"lines"`
+}
+
+type rejectRequest struct {
+	Note string `json:"note"`
+}
+
+// receiveRequest counts the units of each SKU that came back damaged.
+type receiveRequest struct {
+	Damaged map[string]int `json:"damaged"`
+}
+
+type returnList struct {
+	Items []store.Return `json:"items"`
+}
+
+func (server *Server) requestReturnHandler(r apiRequest) (interface{}, error) {
+	order, err := server.order(r.vars["number"])
+	if err != nil {
+		return nil, err
+	}
+	var body returnRequest
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	return server.Returns.Request(order, body.Reason, body.Lines...)
+}
+
+func (server *Server) returnsHandler(r apiRequest) (interface{}, error) {
+	return returnList{ server.Returns.List(r.URL.Query().Get("order")) }, nil
+}
+
+func (server *Server) returnHandler(r apiRequest) (interface{}, error) {
+	existing, found := server.Returns.Get(r.vars["id"])
+	if !found {
+		return nil, notFound("no return %v", r.vars["id"])
+	}
+	return existing, nil
+}
+
+// returnAction runs one step of a return after checking that it exists, so
+// an unknown ID is a 404 rather than a refused step.
+func (server *Server) returnAction(step func(r apiRequest, id string) (store.Return, error)) func(apiRequest) (interface{}, error) {
+	return func(r apiRequest) (interface{}, error) {
+		if _, err := server.returnHandler(r); err != nil {
+			return nil, err
+		}
+		return step(r, r.vars["id"])
+	}
+}
+
+func (server *Server) approveReturn(r apiRequest, id string) (store.Return, error) {
+	return server.Returns.Approve(id)
+}
+
+func (server *Server) rejectReturn(r apiRequest, id string) (store.Return, error) {
+	var body rejectRequest
+	if err := decode(r, &body); err != nil {
+		return store.Return{}, err
+	}
+	return server.Returns.Reject(id, body.Note)
+}
+
+func (server *Server) cancelReturn(r apiRequest, id string) (store.Return, error) {
+	return server.Returns.Cancel(id)
+}
+
+func (server *Server) receiveReturn(r apiRequest, id string) (store.Return, error) {
+	var body receiveRequest
+	if r.ContentLength != 0 {
+		if err := decode(r, &body); err != nil {
+			return store.Return{}, err
+		}
+	}
+	return server.Returns.Receive(id, body.Damaged)
+}
+
+func (server *Server) completeReturn(r apiRequest, id string) (store.Return, error) {
+	return server.Returns.Complete(id)
+}
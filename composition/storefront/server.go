@@ -0,0 +1,322 @@
+// Package storefront serves the store package as a versioned JSON API.
+package storefront
+
+import (
+	"composition/store"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Version = "v1"
+
+// Server holds the shop the API exposes. Carts live in memory; the catalog,
+// deals, calendar, prices and placed Orders are whatever the caller provides,
+// an OrderBook in memory unless it is set.
+// Catalog changes go through Prices so that price edits are kept on record.
+// Carts can be priced in another currency when Rates is set, and orders
+// invoiced when Invoices is. Returns refund through Invoices too. Customers
+// holds customer accounts; the checkout's Accounts should be the same so
+// that orders earn points. Likewise GiftCards should be the checkout's own.
+// Purchasing raises purchase orders and books their goods into Inventory.
+//
+// Routes that change the shop, rather than a shopper's own cart or booking,
+// and those that list other people's orders and accounts need AdminKey as a
+// bearer token; they are refused when it is not set. At most MaxCarts carts
+// are kept, and a cart untouched for CartIdle is dropped.
+type Server struct {
+	Catalog store.Catalog
+	Deals store.Deals
+	Prices store.PriceSchedule
+	Rates *store.ExchangeRates
+	Invoices store.Invoices
+	Returns *store.ReturnDesk
+	Customers *store.Customers
+	GiftCards *store.GiftCards
+	Taxes store.TaxPolicy
+	Location store.Location
+	Checkout *store.Checkout
+	Calendar store.Calendar
+	Orders store.Orders
+	Purchasing *store.Purchasing
+	Inventory *store.Inventory
+	AdminKey string
+	MaxCarts int
+	CartIdle time.Duration
+	Now func() time.Time
+	mutex sync.Mutex
+	carts map[string]*openCart
+	routes []route
+}
+
+// openCart is a cart with the time it was last used.
+type openCart struct {
+	cart *store.Cart
+	used time.Time
+}
+
+func NewServer(catalog store.Catalog, deals store.Deals, checkout *store.Checkout, calendar store.Calendar,
+	prices store.PriceSchedule) *Server {
+	server := &Server{ Catalog: catalog, Deals: deals, Prices: prices, Taxes: store.NoTax, Checkout: checkout,
+		Calendar: calendar, Orders: store.NewOrderBook(), Returns: store.NewReturnDesk(catalog),
+		MaxCarts: 10000, CartIdle: 24 * time.Hour, Now: time.Now, carts: map[string]*openCart{} }
+	server.routes = server.table()
+	if err := checkSchemas(server.routes); err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// apiRequest is what handlers see: the HTTP request plus the values matched by
+// the {placeholders} in the route's path.
+type apiRequest struct {
+	*http.Request
+	vars map[string]string
+}
+
+// route describes one operation. The same table dispatches requests and
+// produces the OpenAPI document, so the two cannot drift apart.
+type route struct {
+	method, path, summary string
+	query []parameter
+	body, response string
+	status int
+	admin bool
+	handle func(apiRequest) (interface{}, error)
+}
+
+type parameter struct {
+	name, kind, description string
+}
+
+func (r route) match(segments []string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(r.path, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") {
+			vars[strings.Trim(part, "{}")] = segments[i]
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// APIError is the body of every failed request:
+// {"error": {"code": "not_found", "message": "..."}}.
+type APIError struct {
+	Status int `json:"-"`
+	Code string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *APIError) Error() string {
+	return err.Message
+}
+
+func apiError(status int, code, format string, args ...interface{}) *APIError {
+	return &APIError{ status, code, fmt.Sprintf(format, args...) }
+}
+
+func notFound(format string, args ...interface{}) *APIError {
+	return apiError(http.StatusNotFound, "not_found", format, args...)
+}
+
+func badRequest(format string, args ...interface{}) *APIError {
+	return apiError(http.StatusBadRequest, "invalid_request", format, args...)
+}
+
+// classify maps errors from the store package onto HTTP statuses.
+func classify(err error) *APIError {
+	var apiErr *APIError
+	var stockErr *store.OutOfStockError
+	var priceErr *store.PriceChangedError
+	var conflictErr *store.BookingConflictError
+	var pathErr *fs.PathError
+	message := strings.TrimPrefix(err.Error(), "store: ")
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &stockErr):
+		return apiError(http.StatusConflict, "out_of_stock", "%v", message)
+	case errors.As(err, &priceErr):
+		return apiError(http.StatusConflict, "price_changed", "%v", message)
+	case errors.As(err, &conflictErr):
+		return apiError(http.StatusConflict, "booking_conflict", "%v", message)
+	case errors.Is(err, store.ErrEmptyCart):
+		return apiError(http.StatusUnprocessableEntity, "empty_cart", "%v", message)
+	case errors.As(err, &pathErr):
+		return apiError(http.StatusInternalServerError, "internal", "the shop data could not be saved")
+	}
+	return apiError(http.StatusUnprocessableEntity, "rejected", "%v", message)
+}
+
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	allowed := []string{}
+	for _, r := range server.routes {
+		vars, matched := r.match(segments)
+		if !matched {
+			continue
+		}
+		if r.method != request.Method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+		if r.admin && !server.isAdmin(request) {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="store"`)
+			writeError(writer, apiError(http.StatusUnauthorized, "unauthorized",
+				"%v %v needs the admin key", request.Method, request.URL.Path))
+			return
+		}
+		body, err := r.handle(apiRequest{ request, vars })
+		if err != nil {
+			writeError(writer, classify(err))
+			return
+		}
+		server.respond(writer, request, r.status, body)
+		return
+	}
+	if len(allowed) > 0 {
+		writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(writer, apiError(http.StatusMethodNotAllowed, "method_not_allowed",
+			"%v is not supported for %v", request.Method, request.URL.Path))
+		return
+	}
+	writeError(writer, notFound("no such resource %v", request.URL.Path))
+}
+
+// isAdmin reports whether the request carries the admin key. Without a key
+// set, no request does.
+func (server *Server) isAdmin(request *http.Request) bool {
+	key, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if server.AdminKey == "" || !found {
+		return false
+	}
+	given, want := sha256.Sum256([]byte(key)), sha256.Sum256([]byte(server.AdminKey))
+	return subtle.ConstantTimeCompare(given[:], want[:]) == 1
+}
+
+// etag identifies a representation by a hash of its encoding.
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+func etagOf(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return etag(data), nil
+}
+
+// document is a response sent as it is rather than as JSON, such as an
+// invoice rendered as a PDF.
+type document struct {
+	contentType string
+	data []byte
+}
+
+// respond writes body as JSON, or as it is for a document. Successful GETs
+// carry an ETag and answer If-None-Match with 304 Not Modified.
+func (server *Server) respond(writer http.ResponseWriter, request *http.Request, status int, body interface{}) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusNoContent || body == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	contentType := "application/json"
+	data, err := json.Marshal(body)
+	if doc, isDocument := body.(document); isDocument {
+		contentType, data, err = doc.contentType, doc.data, nil
+	}
+	if err != nil {
+		writeError(writer, apiError(http.StatusInternalServerError, "internal", "%v", err))
+		return
+	}
+	tag := etag(data)
+	writer.Header().Set("ETag", tag)
+	if request.Method == http.MethodGet {
+		if match := request.Header.Get("If-None-Match"); match != "" && matchesETag(match, tag) {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.WriteHeader(status)
+	writer.Write(data)
+	if contentType == "application/json" {
+		writer.Write([]byte("\n"))
+	}
+}
+
+func matchesETag(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch refuses a change when the client's If-Match header does not
+// name the current representation of the resource.
+func checkIfMatch(r apiRequest, current interface{}) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	if current == nil {
+		if strings.TrimSpace(header) == "*" {
+			return apiError(http.StatusPreconditionFailed, "precondition_failed", "the resource does not exist")
+		}
+		return nil
+	}
+	tag, err := etagOf(current)
+	if err != nil {
+		return err
+	}
+	if !matchesETag(header, tag) {
+		return apiError(http.StatusPreconditionFailed, "precondition_failed",
+			"the resource has changed, fetch it again before updating")
+	}
+	return nil
+}
+
+func writeError(writer http.ResponseWriter, err *APIError) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(err.Status)
+	json.NewEncoder(writer).Encode(map[string]*APIError{ "error": err })
+}
+
+// decode reads a JSON request body into target, rejecting unknown fields.
+func decode(r apiRequest, target interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1 << 20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return badRequest("invalid request body: %v", strings.TrimPrefix(err.Error(), "store: "))
+	}
+	return nil
+}
+
+func newID() string {
+	data := make([]byte, 12)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
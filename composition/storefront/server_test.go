@@ -0,0 +1,131 @@
+package storefront
+
+import (
+	"composition/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer() *Server {
+	catalog := store.NewMemoryCatalog()
+	catalog.Put("KAY-1", store.NewProduct("Kayak", "Watersports", store.MustParseMoney("$279")))
+	checkout := store.NewCheckout(store.UnlimitedStock, store.NewOrderSequence("ORD", 0))
+	calendar := store.NewBookingCalendar(0)
+	return NewServer(catalog, store.NewMemoryDeals(catalog), checkout, calendar, store.NewPriceHistory(catalog))
+}
+
+func call(server *Server, method, path, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer " + key)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAdminRoutesNeedKey(t *testing.T) {
+	server := testServer()
+	product := `{"type": "product", "name": "Paddle", "price": {"amount": "20.00", "currency": "USD"}}`
+	tests := []struct {
+		method, path, key, body string
+		want int
+	}{
+		{ "GET", "/v1/products/KAY-1", "", "", http.StatusOK },
+		{ "GET", "/v1/customers", "", "", http.StatusUnauthorized },
+		{ "PUT", "/v1/products/PAD-1", "", product, http.StatusUnauthorized },
+		{ "PUT", "/v1/products/PAD-1", "wrong", product, http.StatusUnauthorized },
+		{ "DELETE", "/v1/products/KAY-1", "", "", http.StatusUnauthorized },
+		{ "GET", "/v1/orders", "", "", http.StatusUnauthorized },
+		{ "GET", "/v1/orders", "secret", "", http.StatusOK },
+		{ "POST", "/v1/carts", "", "", http.StatusCreated },
+	}
+	for _, key := range []string{ "", "secret" } {
+		server.AdminKey = key
+		for _, test := range tests {
+			want := test.want
+			if key == "" && test.key != "" && want != http.StatusUnauthorized {
+				want = http.StatusUnauthorized
+			}
+			if got := call(server, test.method, test.path, test.key, test.body).Code; got != want {
+				t.Errorf("admin key %q: %v %v with %q = %v, want %v", key, test.method, test.path, test.key, got, want)
+			}
+		}
+	}
+	server.AdminKey = "secret"
+	if got := call(server, "PUT", "/v1/products/PAD-1", "secret", product).Code; got != http.StatusOK {
+		t.Errorf("PUT with the admin key = %v, want 200", got)
+	}
+}
+
+func TestIdleCartsExpire(t *testing.T) {
+	server := testServer()
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return now }
+	server.MaxCarts, server.CartIdle = 2, time.Hour
+	for i := 0; i < 2; i++ {
+		if got := call(server, "POST", "/v1/carts", "", "").Code; got != http.StatusCreated {
+			t.Fatalf("cart %v = %v", i, got)
+		}
+	}
+	if got := call(server, "POST", "/v1/carts", "", "").Code; got != http.StatusServiceUnavailable {
+		t.Errorf("third cart = %v, want 503", got)
+	}
+	var kept string
+	for id := range server.carts {
+		kept = id
+	}
+	now = now.Add(50 * time.Minute)
+	if got := call(server, "GET", "/v1/carts/" + kept, "", "").Code; got != http.StatusOK {
+		t.Errorf("cart used within the hour = %v", got)
+	}
+	now = now.Add(20 * time.Minute)
+	if got := call(server, "POST", "/v1/carts", "", "").Code; got != http.StatusCreated {
+		t.Errorf("cart after one went idle = %v, want 201", got)
+	}
+	if _, found := server.carts[kept]; !found {
+		t.Error("dropped a cart that was in use")
+	}
+	now = now.Add(time.Hour)
+	if got := call(server, "GET", "/v1/carts/" + kept, "", "").Code; got != http.StatusNotFound {
+		t.Errorf("idle cart = %v, want 404", got)
+	}
+}
+
+func TestGiftCardCodesShownOnce(t *testing.T) {
+	server := testServer()
+	server.AdminKey = "secret"
+	server.GiftCards = store.NewGiftCards()
+	issue := `{"amount": {"amount": "50.00", "currency": "USD"}}`
+	tests := []struct {
+		method, path, key, body string
+		want int
+	}{
+		{ "POST", "/v1/gift-cards", "", issue, http.StatusUnauthorized },
+		{ "GET", "/v1/gift-cards", "", "", http.StatusUnauthorized },
+		{ "GET", "/v1/reports/gift-cards", "", "", http.StatusUnauthorized },
+		{ "POST", "/v1/customers/CUS-1/store-credit", "", issue, http.StatusUnauthorized },
+		{ "POST", "/v1/gift-cards", "secret", issue, http.StatusCreated },
+	}
+	for _, test := range tests {
+		if got := call(server, test.method, test.path, test.key, test.body).Code; got != test.want {
+			t.Errorf("%v %v with %q = %v, want %v", test.method, test.path, test.key, got, test.want)
+		}
+	}
+	code := server.GiftCards.List()[0].Code
+	list := call(server, "GET", "/v1/gift-cards", "secret", "")
+	if list.Code != http.StatusOK || strings.Contains(list.Body.String(), code) ||
+			!strings.Contains(list.Body.String(), store.MaskGiftCardCode(code)) {
+		t.Errorf("list = %v %v, want %v masked", list.Code, list.Body, code)
+	}
+	issued := call(server, "POST", "/v1/gift-cards", "secret", issue)
+	if cards := server.GiftCards.List(); issued.Code != http.StatusCreated || !strings.Contains(issued.Body.String(), cards[1].Code) {
+		t.Errorf("issue = %v %v, want the full code", issued.Code, issued.Body)
+	}
+	if got := call(server, "GET", "/v1/gift-cards/" + code, "", "").Code; got != http.StatusOK {
+		t.Errorf("balance of a card by its code = %v, want 200", got)
+	}
+}
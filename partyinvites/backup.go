@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const archiveVersion = 1
+
+type Event struct {
+	Name string `json:"name"`
+	Date time.Time `json:"date"`
+}
+
+// AggregateCounts records how many replies were received once the personal
+// details behind them have been purged.
+type AggregateCounts struct {
+	Attending int `json:"attending"`
+	Declined int `json:"declined"`
+	PurgedAt time.Time `json:"purgedAt"`
+}
+
+type Archive struct {
+	Version int `json:"version"`
+	Created time.Time `json:"created"`
+	Events []Event `json:"events"`
+	Responses []*Rsvp `json:"responses"`
+	Purged AggregateCounts `json:"purged"`
+}
+
+func writeArchive(writer io.Writer, archive Archive) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+func readArchive(reader io.Reader) (Archive, error) {
+	var archive Archive
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&archive); err != nil {
+		return archive, fmt.Errorf("invalid archive: %v", err)
+	}
+	return archive, archive.validate()
+}
+
+func (archive Archive) validate() error {
+	if archive.Version != archiveVersion {
+		return fmt.Errorf("unsupported archive version %v", archive.Version)
+	}
+	if len(archive.Events) != 1 {
+		return fmt.Errorf("archive must contain exactly one event, found %v", len(archive.Events))
+	}
+	if archive.Purged.Attending < 0 || archive.Purged.Declined < 0 {
+		return fmt.Errorf("archive contains negative aggregate counts")
+	}
+	emails := map[string]bool{}
+	for i, rsvp := range archive.Responses {
+		if rsvp == nil || rsvp.Name == "" || rsvp.Email == "" || rsvp.Phone == "" {
+			return fmt.Errorf("response %v is incomplete", i)
+		}
+		if rsvp.PlusOnes < 0 || rsvp.PlusOnes > maxPlusOnes {
+			return fmt.Errorf("response %v has %v plus-ones", i, rsvp.PlusOnes)
+		}
+		email := strings.ToLower(rsvp.Email)
+		if emails[email] {
+			return fmt.Errorf("response %v duplicates email %v", i, rsvp.Email)
+		}
+		emails[email] = true
+	}
+	return nil
+}
+
+func backupToFile(store *rsvpStore, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = writeArchive(file, store.Snapshot(time.Now())); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// backupFromServer fetches an archive from the running server, using the
+// admin password, and writes it to path once the retention policy has been
+// applied to it.
+func backupFromServer(server, password, path string, retentionDays int) error {
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(server, "/") + "/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth("admin", password)
+	response, err := (&http.Client{ Timeout: time.Minute }).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded %v", response.Status)
+	}
+	archive, err := readArchive(response.Body)
+	if err != nil {
+		return err
+	}
+	store := newRsvpStore(archive.Events[0])
+	store.Restore(archive)
+	store.ApplyRetention(time.Now(), retentionDays)
+	return backupToFile(store, path)
+}
+
+func restoreFromFile(store *rsvpStore, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive, err := readArchive(file)
+	if err != nil {
+		return err
+	}
+	store.Restore(archive)
+	return nil
+}
+
+func (app *application) runRetention(retentionDays int, interval time.Duration) {
+	for {
+		if app.store.ApplyRetention(app.now(), retentionDays) {
+			fmt.Println("Purged guest details under the retention policy")
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (app *application) backupHandler(writer http.ResponseWriter, request *http.Request) {
+	now := app.now().UTC()
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"rsvp-backup-%v.json\"", now.Format("20060102-150405")))
+	writeArchive(writer, app.store.Snapshot(now))
+}
+
+func (app *application) restoreHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	archive, err := readArchive(http.MaxBytesReader(writer, request.Body, 10 << 20))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	app.store.Restore(archive)
+	fmt.Fprintf(writer, "Restored %v responses\n", len(archive.Responses))
+}
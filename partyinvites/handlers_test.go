@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+func testApplication(t *testing.T, guests ...Rsvp) *application {
+	templates, err := loadTemplates(os.DirFS("."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newRsvpStore(Event{ Name: "Test Party" })
+	for _, rsvp := range guests {
+		store.Save(rsvp)
+	}
+	return newApplication(templates, store, newWebhookDispatcher(http.DefaultClient), newSeatingPlan())
+}
+
+// checkGolden compares a response body with testdata/name.golden, rewriting
+// the file instead when the tests run with -update.
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name + ".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%v does not match %v\ngot:\n%v\nwant:\n%s", name, path, got, want)
+	}
+}
+
+func serve(app *application, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	app.routes().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func postForm(target string, values url.Values) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request
+}
+
+func TestWelcomeHandler(t *testing.T) {
+	response := serve(testApplication(t), httptest.NewRequest(http.MethodGet, "/", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %v, want 200", response.Code)
+	}
+	checkGolden(t, "welcome", response.Body.String())
+}
+
+func TestFormHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		request *http.Request
+		saved int
+	}{
+		{ "form_get", httptest.NewRequest(http.MethodGet, "/form", nil), 0 },
+		{ "form_invalid", postForm("/form", url.Values{
+			"name": { "Alice" }, "plusones": { "9" }, "willattend": { "true" },
+		}), 0 },
+		{ "form_thanks", postForm("/form", url.Values{
+			"name": { "Alice" }, "email": { "alice@example.com" }, "phone": { "555-0100" },
+			"plusones": { "2" }, "willattend": { "true" },
+		}), 1 },
+		{ "form_sorry", postForm("/form", url.Values{
+			"name": { "Bob" }, "email": { "bob@example.com" }, "phone": { "555-0101" },
+			"willattend": { "false" },
+		}), 1 },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := testApplication(t)
+			response := serve(app, test.request)
+			if response.Code != http.StatusOK {
+				t.Fatalf("status %v, want 200", response.Code)
+			}
+			checkGolden(t, test.name, response.Body.String())
+			if saved := len(app.store.Responses()); saved != test.saved {
+				t.Errorf("%v replies saved, want %v", saved, test.saved)
+			}
+		})
+	}
+}
+
+func TestFormRejectsOtherMethods(t *testing.T) {
+	response := serve(testApplication(t), httptest.NewRequest(http.MethodDelete, "/form", nil))
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %v, want 405", response.Code)
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	app := testApplication(t,
+		Rsvp{ Name: "Carol", Email: "carol@example.com", Phone: "555-0102", WillAttend: true, PlusOnes: 1 },
+		Rsvp{ Name: "Alice", Email: "alice@example.com", Phone: "555-0100", WillAttend: true },
+		Rsvp{ Name: "Bob", Email: "bob@example.com", Phone: "555-0101", WillAttend: false },
+		Rsvp{ Name: "Dave", Email: "dave@example.com", Phone: "555-0103", WillAttend: true, PlusOnes: 3 },
+	)
+	tests := []struct {
+		name, target string
+	}{
+		{ "list", "/list" },
+		{ "list_sorted_paged", "/list?status=all&sort=name&size=2" },
+		{ "list_search", "/list?q=BOB&status=declined" },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serve(app, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if response.Code != http.StatusOK {
+				t.Fatalf("status %v, want 200", response.Code)
+			}
+			checkGolden(t, test.name, response.Body.String())
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 200
+
+var listColumns = []string{"name", "email", "phone", "status"}
+
+type listQuery struct {
+	Search, Status, Sort, Order string
+	Page, Size int
+}
+
+type listData struct {
+	listQuery
+	Rows []*Rsvp
+	Total, Pages int
+	Purged AggregateCounts
+}
+
+func parseListQuery(values url.Values) listQuery {
+	query := listQuery{
+		Search: strings.TrimSpace(values.Get("q")),
+		Status: values.Get("status"),
+		Sort: values.Get("sort"),
+		Order: values.Get("order"),
+		Page: 1,
+		Size: defaultPageSize,
+	}
+	if query.Status != "all" && query.Status != "declined" {
+		query.Status = "attending"
+	}
+	if !isListColumn(query.Sort) {
+		query.Sort = ""
+	}
+	if query.Order != "desc" {
+		query.Order = "asc"
+	}
+	if page, err := strconv.Atoi(values.Get("page")); err == nil && page > 0 {
+		query.Page = page
+	}
+	if size, err := strconv.Atoi(values.Get("size")); err == nil && size > 0 {
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+		query.Size = size
+	}
+	return query
+}
+
+func isListColumn(name string) bool {
+	for _, column := range listColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (query listQuery) matches(rsvp *Rsvp) bool {
+	switch query.Status {
+	case "attending":
+		if !rsvp.WillAttend {
+			return false
+		}
+	case "declined":
+		if rsvp.WillAttend {
+			return false
+		}
+	}
+	if query.Search == "" {
+		return true
+	}
+	term := strings.ToLower(query.Search)
+	for _, field := range []string{rsvp.Name, rsvp.Email, rsvp.Phone} {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortKey(rsvp *Rsvp, column string) string {
+	switch column {
+	case "name":
+		return strings.ToLower(rsvp.Name)
+	case "email":
+		return strings.ToLower(rsvp.Email)
+	case "phone":
+		return rsvp.Phone
+	case "status":
+		if rsvp.WillAttend {
+			return "0"
+		}
+		return "1"
+	}
+	return ""
+}
+
+// apply filters, sorts and pages the responses. Rows that compare equal keep
+// their insertion order so a page always shows the same guests.
+func (query listQuery) apply(all []*Rsvp) listData {
+	rows := []*Rsvp{}
+	for _, rsvp := range all {
+		if query.matches(rsvp) {
+			rows = append(rows, rsvp)
+		}
+	}
+	if query.Sort != "" {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if query.Order == "desc" {
+				return sortKey(rows[i], query.Sort) > sortKey(rows[j], query.Sort)
+			}
+			return sortKey(rows[i], query.Sort) < sortKey(rows[j], query.Sort)
+		})
+	}
+
+	data := listData{ listQuery: query, Total: len(rows) }
+	data.Pages = (len(rows) + query.Size - 1) / query.Size
+	if data.Pages == 0 {
+		data.Pages = 1
+	}
+	if data.Page > data.Pages {
+		data.Page = data.Pages
+	}
+	start := (data.Page - 1) * query.Size
+	end := start + query.Size
+	if end > len(rows) {
+		end = len(rows)
+	}
+	data.Rows = rows[start:end]
+	return data
+}
+
+func (query listQuery) values() url.Values {
+	values := url.Values{}
+	if query.Search != "" {
+		values.Set("q", query.Search)
+	}
+	if query.Status != "attending" {
+		values.Set("status", query.Status)
+	}
+	if query.Sort != "" {
+		values.Set("sort", query.Sort)
+		if query.Order == "desc" {
+			values.Set("order", "desc")
+		}
+	}
+	if query.Size != defaultPageSize {
+		values.Set("size", strconv.Itoa(query.Size))
+	}
+	if query.Page > 1 {
+		values.Set("page", strconv.Itoa(query.Page))
+	}
+	return values
+}
+
+func (query listQuery) URL() string {
+	encoded := query.values().Encode()
+	if encoded == "" {
+		return "/list"
+	}
+	return "/list?" + encoded
+}
+
+func (data listData) PageURL(page int) string {
+	query := data.listQuery
+	query.Page = page
+	return query.URL()
+}
+
+func (data listData) SortURL(column string) string {
+	query := data.listQuery
+	if query.Sort == column && query.Order == "asc" {
+		query.Order = "desc"
+	} else {
+		query.Order = "asc"
+	}
+	query.Sort = column
+	query.Page = 1
+	return query.URL()
+}
+
+func (data listData) SortIndicator(column string) string {
+	if data.Sort != column {
+		return ""
+	}
+	if data.Order == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+func (data listData) HasPrev() bool {
+	return data.Page > 1
+}
+
+func (data listData) HasNext() bool {
+	return data.Page < data.Pages
+}
+
+func (data listData) Prev() int {
+	return data.Page - 1
+}
+
+func (data listData) Next() int {
+	return data.Page + 1
+}
+
+func (app *application) listHandler(writer http.ResponseWriter, request *http.Request) {
+	query := parseListQuery(request.URL.Query())
+	data := query.apply(app.store.Responses())
+	data.Purged = app.store.Purged()
+	app.render(writer, "list", data)
+}
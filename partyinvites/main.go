@@ -1,38 +1,101 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 type Rsvp struct {
-	Name, Email, Phone string
-	WillAttend bool
+	Name string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	WillAttend bool `json:"willAttend"`
+	PlusOnes int `json:"plusOnes"`
 }
 
-var responses = make([]*Rsvp, 0, 10)
-var templates = make(map[string]*template.Template,3)
+const maxPlusOnes = 4
 
-func loadTemplates() {
-	templateNames := [5]string{"welcome", "form", "thanks", "sorry", "list"}
-	for index, name := range templateNames {
-		t, err := template.ParseFiles("layout.html", name + ".html")
-		if (err==nil) {
-			templates[name] = t
-			fmt.Println("Loaded template", index, name)
-		} else {
-			panic(err)
+var templateNames = []string{"welcome", "form", "thanks", "sorry", "list", "webhooks", "seating", "chart"}
+
+// loadTemplates parses each page together with the shared layout from fsys,
+// which is the working directory when the app runs normally.
+func loadTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(templateNames))
+	for _, name := range templateNames {
+		t, err := template.ParseFS(fsys, "layout.html", name + ".html")
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// application holds everything the handlers depend on, so that tests can
+// supply their own templates, store, webhook dispatcher, seating plan and clock.
+type application struct {
+	templates map[string]*template.Template
+	store *rsvpStore
+	webhooks *webhookDispatcher
+	seating *seatingPlan
+	now func() time.Time
+	adminPassword string
+}
+
+func newApplication(templates map[string]*template.Template, store *rsvpStore,
+		webhooks *webhookDispatcher, seating *seatingPlan) *application {
+	store.OnPurge(webhooks.Forget)
+	store.OnPurge(seating.Forget)
+	store.OnRestore(webhooks.Restored)
+	store.OnRestore(seating.Retain)
+	return &application{ templates: templates, store: store, webhooks: webhooks,
+		seating: seating, now: time.Now }
+}
+
+func (app *application) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", app.welcomeHandler)
+	mux.HandleFunc("/list", app.listHandler)
+	mux.HandleFunc("/form", app.formHandler)
+	mux.HandleFunc("/webhooks", app.requireAdmin(app.webhooksHandler))
+	mux.HandleFunc("/admin/backup", app.requireAdmin(app.backupHandler))
+	mux.HandleFunc("/admin/restore", app.requireAdmin(app.restoreHandler))
+	mux.HandleFunc("/seating", app.seatingHandler)
+	mux.HandleFunc("/seating/chart", app.seatingChartHandler)
+	return mux
+}
+
+// requireAdmin lets a request through only if it carries the admin password
+// as HTTP basic authentication. Without a password the admin pages are shut.
+func (app *application) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		_, password, ok := request.BasicAuth()
+		given, wanted := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(app.adminPassword))
+		if !ok || app.adminPassword == "" || subtle.ConstantTimeCompare(given[:], wanted[:]) != 1 {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="Party admin", charset="UTF-8"`)
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
+		handler(writer, request)
 	}
 }
 
-func welcomeHandler(writer http.ResponseWriter, request *http.Request) {
-	templates["welcome"].Execute(writer, nil)
+func (app *application) render(writer http.ResponseWriter, name string, data interface{}) {
+	if err := app.templates[name].Execute(writer, data); err != nil {
+		fmt.Println("Error rendering", name, err)
+	}
 }
 
-func listHandler(writer http.ResponseWriter, request *http.Request) {
-	templates["list"].Execute(writer, responses)
+func (app *application) welcomeHandler(writer http.ResponseWriter, request *http.Request) {
+	app.render(writer, "welcome", nil)
 }
 
 type formData struct {
@@ -40,18 +103,24 @@ type formData struct {
 	Errors []string
 }
 
-func formHandler(writer http.ResponseWriter, request *http.Request) {
+func (app *application) formHandler(writer http.ResponseWriter, request *http.Request) {
 	if request.Method == http.MethodGet {
-		templates["form"].Execute(writer, formData {
+		app.render(writer, "form", formData {
 			Rsvp: &Rsvp{}, Errors: []string {},
 		})
 	} else if request.Method == http.MethodPost {
 		request.ParseForm()
+		plusOnes := 0
+		var plusOnesErr error
+		if value := request.Form.Get("plusones"); value != "" {
+			plusOnes, plusOnesErr = strconv.Atoi(value)
+		}
 		responseData := Rsvp {
-			Name: request.Form["name"][0],
-			Email: request.Form["email"][0],
-			Phone: request.Form["phone"][0],
-			WillAttend: request.Form["willattend"][0] == "true",
+			Name: request.Form.Get("name"),
+			Email: request.Form.Get("email"),
+			Phone: request.Form.Get("phone"),
+			WillAttend: request.Form.Get("willattend") == "true",
+			PlusOnes: plusOnes,
 		}
 
 		errors := []string{}
@@ -64,31 +133,80 @@ func formHandler(writer http.ResponseWriter, request *http.Request) {
 		if responseData.Phone == "" {
 			errors = append(errors, "Please enter your phone number")
 		}
+		if plusOnesErr != nil || responseData.PlusOnes < 0 || responseData.PlusOnes > maxPlusOnes {
+			errors = append(errors, fmt.Sprintf("You can bring between 0 and %v guests", maxPlusOnes))
+		}
 		if len(errors) > 0 {
-			templates["form"].Execute(writer, formData {
+			app.render(writer, "form", formData {
 				Rsvp: &responseData, Errors: errors,
 			})
 		} else {
-			responses = append(responses, &responseData)
+			event, rsvp := app.store.Save(responseData)
+			app.webhooks.Publish(event, rsvp)
 
 			if responseData.WillAttend {
-				templates["thanks"].Execute(writer, responseData.Name)
+				app.render(writer, "thanks", responseData.Name)
 			} else {
-				templates["sorry"].Execute(writer, responseData.Name)
+				app.render(writer, "sorry", responseData.Name)
 			}
 		}
+	} else {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func main() {
-	loadTemplates()
+	restorePath := flag.String("restore", "", "archive to import before starting")
+	eventDate := flag.String("event-date", "", "date of the party, as YYYY-MM-DD")
+	retentionDays := flag.Int("retention-days", 30, "days after the party before guest details are purged")
+	backupPath := flag.String("backup", "", "fetch the replies from the running server, apply the retention policy, write an archive to this path and exit")
+	server := flag.String("server", "http://localhost:3000", "address of the running server that -backup fetches from")
+	flag.Parse()
 
-	http.HandleFunc("/", welcomeHandler)
-	http.HandleFunc("/list", listHandler)
-	http.HandleFunc("/form", formHandler)
+	if *backupPath != "" {
+		err := backupFromServer(*server, os.Getenv("PARTY_ADMIN_PASSWORD"), *backupPath, *retentionDays)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Wrote archive to", *backupPath)
+		return
+	}
 
-	err := http.ListenAndServe(":3000", nil)
+	store := newRsvpStore(Event{ Name: "Let's Party!" })
+	if *restorePath != "" {
+		if err := restoreFromFile(store, *restorePath); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Restored data from", *restorePath)
+	}
+	if *eventDate != "" {
+		date, err := time.Parse("2006-01-02", *eventDate)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		store.SetEventDate(date)
+	}
+	templates, err := loadTemplates(os.DirFS("."))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Loaded", len(templates), "templates")
+
+	dispatcher := newWebhookDispatcher(newWebhookClient(10 * time.Second))
+	app := newApplication(templates, store, dispatcher, newSeatingPlan())
+	app.adminPassword = os.Getenv("PARTY_ADMIN_PASSWORD")
+	if app.adminPassword == "" {
+		fmt.Println("PARTY_ADMIN_PASSWORD is not set, so the admin pages are shut")
+	}
+
+	go dispatcher.Run(time.Second, nil)
+	go app.runRetention(*retentionDays, time.Hour)
+
+	err = http.ListenAndServe(":3000", app.routes())
 	if (err != nil) {
 		fmt.Println(err)
 	}
-}
\ No newline at end of file
+}
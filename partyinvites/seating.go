@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Table struct {
+	ID int
+	Name string
+	Capacity int
+}
+
+const (
+	seatTogether = "together"
+	keepApart = "apart"
+)
+
+// SeatingConstraint asks for two guests, identified by email, to be seated at
+// the same table or at different tables.
+type SeatingConstraint struct {
+	Kind string
+	First, Second string
+}
+
+type seatingPlan struct {
+	mutex sync.Mutex
+	tables []*Table
+	nextTableID int
+	assignments map[string]int
+	constraints []SeatingConstraint
+	revision int
+}
+
+func newSeatingPlan() *seatingPlan {
+	return &seatingPlan{ assignments: map[string]int{} }
+}
+
+func seats(rsvp *Rsvp) int {
+	return 1 + rsvp.PlusOnes
+}
+
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (plan *seatingPlan) AddTable(name string, capacity int) (*Table, error) {
+	if name == "" {
+		return nil, fmt.Errorf("please enter a table name")
+	}
+	if capacity < 1 {
+		return nil, fmt.Errorf("a table needs at least one seat")
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.nextTableID++
+	table := &Table{ ID: plan.nextTableID, Name: name, Capacity: capacity }
+	plan.tables = append(plan.tables, table)
+	plan.revision++
+	return table, nil
+}
+
+func (plan *seatingPlan) RemoveTable(id int) bool {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	for i, table := range plan.tables {
+		if table.ID == id {
+			plan.tables = append(plan.tables[:i], plan.tables[i+1:]...)
+			plan.revision++
+			for email, tableID := range plan.assignments {
+				if tableID == id {
+					delete(plan.assignments, email)
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (plan *seatingPlan) AddConstraint(kind, first, second string) error {
+	if kind != seatTogether && kind != keepApart {
+		return fmt.Errorf("unknown constraint: %q", kind)
+	}
+	first, second = emailKey(first), emailKey(second)
+	if first == "" || second == "" || first == second {
+		return fmt.Errorf("please choose two different guests")
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	for _, c := range plan.constraints {
+		if (c.First == first && c.Second == second) || (c.First == second && c.Second == first) {
+			return fmt.Errorf("those guests already have a constraint")
+		}
+	}
+	plan.constraints = append(plan.constraints, SeatingConstraint{ kind, first, second })
+	plan.revision++
+	return nil
+}
+
+func (plan *seatingPlan) RemoveConstraint(index int) bool {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if index < 0 || index >= len(plan.constraints) {
+		return false
+	}
+	plan.constraints = append(plan.constraints[:index], plan.constraints[index+1:]...)
+	plan.revision++
+	return true
+}
+
+// Forget clears the assignments and constraints, which name guests by email,
+// keeping the tables.
+func (plan *seatingPlan) Forget() {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.assignments = map[string]int{}
+	plan.constraints = nil
+	plan.revision++
+}
+
+// Retain checks the plan against a new set of replies, as when they are
+// restored from an archive. Assignments of guests who are no longer coming
+// are dropped, as are constraints naming guests with no reply, and guests
+// are unseated in email order from any table they would now overfill.
+func (plan *seatingPlan) Retain(responses []*Rsvp) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	replied, attending := map[string]bool{}, map[string]*Rsvp{}
+	for _, rsvp := range responses {
+		replied[emailKey(rsvp.Email)] = true
+		if rsvp.WillAttend {
+			attending[emailKey(rsvp.Email)] = rsvp
+		}
+	}
+	keys := make([]string, 0, len(plan.assignments))
+	for key := range plan.assignments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	used := map[int]int{}
+	for _, key := range keys {
+		guest, table := attending[key], plan.findTable(plan.assignments[key])
+		if guest == nil || table == nil || used[table.ID] + seats(guest) > table.Capacity {
+			delete(plan.assignments, key)
+			continue
+		}
+		used[table.ID] += seats(guest)
+	}
+	kept := plan.constraints[:0]
+	for _, c := range plan.constraints {
+		if replied[c.First] && replied[c.Second] {
+			kept = append(kept, c)
+		}
+	}
+	plan.constraints = kept
+	plan.revision++
+}
+
+func (plan *seatingPlan) findTable(id int) *Table {
+	for _, table := range plan.tables {
+		if table.ID == id {
+			return table
+		}
+	}
+	return nil
+}
+
+// Assign seats a guest and their plus-ones at a table, or removes them from
+// the plan when tableID is zero.
+func (plan *seatingPlan) Assign(guests []*Rsvp, email string, tableID int) error {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	key := emailKey(email)
+	if tableID == 0 {
+		delete(plan.assignments, key)
+		plan.revision++
+		return nil
+	}
+	table := plan.findTable(tableID)
+	if table == nil {
+		return fmt.Errorf("no table with id %v", tableID)
+	}
+	var guest *Rsvp
+	used := 0
+	for _, rsvp := range guests {
+		if emailKey(rsvp.Email) == key {
+			guest = rsvp
+		} else if plan.assignments[emailKey(rsvp.Email)] == tableID {
+			used += seats(rsvp)
+		}
+	}
+	if guest == nil {
+		return fmt.Errorf("%v is not attending", email)
+	}
+	if used + seats(guest) > table.Capacity {
+		return fmt.Errorf("%v has %v seats left, %v needs %v", table.Name,
+			table.Capacity - used, guest.Name, seats(guest))
+	}
+	plan.assignments[key] = tableID
+	plan.revision++
+	return nil
+}
+
+// maxSeatingSteps bounds the tables AutoAssign tries before it gives up on
+// searching and seats the groups first-fit, largest first.
+const maxSeatingSteps = 100000
+
+// AutoAssign seats every attending guest, keeping anyone linked by a
+// "together" constraint at one table and never placing guests with a "keep
+// apart" constraint side by side. Existing assignments are discarded; if no
+// arrangement satisfies the constraints the plan is left unchanged. The
+// search runs on a copy of the tables and constraints, and fails if the
+// plan is changed before it finishes.
+func (plan *seatingPlan) AutoAssign(guests []*Rsvp) error {
+	plan.mutex.Lock()
+	tables := make([]Table, len(plan.tables))
+	for i, table := range plan.tables {
+		tables[i] = *table
+	}
+	constraints := append([]SeatingConstraint{}, plan.constraints...)
+	revision := plan.revision
+	plan.mutex.Unlock()
+
+	assignments, err := arrangeSeating(guests, tables, constraints)
+	if err != nil {
+		return err
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.revision != revision {
+		return fmt.Errorf("the seating plan changed while the guests were being seated, please try again")
+	}
+	plan.assignments = assignments
+	plan.revision++
+	return nil
+}
+
+// arrangeSeating returns the table ID for each guest's email.
+func arrangeSeating(guests []*Rsvp, tables []Table, constraints []SeatingConstraint) (map[string]int, error) {
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(key string) string {
+		if parent[key] != key {
+			parent[key] = find(parent[key])
+		}
+		return parent[key]
+	}
+	for _, rsvp := range guests {
+		key := emailKey(rsvp.Email)
+		parent[key] = key
+	}
+	for _, c := range constraints {
+		if c.Kind == seatTogether && parent[c.First] != "" && parent[c.Second] != "" {
+			parent[find(c.First)] = find(c.Second)
+		}
+	}
+
+	groupIndex := map[string]int{}
+	groups := [][]string{}
+	sizes := []int{}
+	for _, rsvp := range guests {
+		key := emailKey(rsvp.Email)
+		root := find(key)
+		index, found := groupIndex[root]
+		if !found {
+			index = len(groups)
+			groupIndex[root] = index
+			groups = append(groups, nil)
+			sizes = append(sizes, 0)
+		}
+		groups[index] = append(groups[index], key)
+		sizes[index] += seats(rsvp)
+	}
+
+	apart := make([]map[int]bool, len(groups))
+	for i := range apart {
+		apart[i] = map[int]bool{}
+	}
+	anyApart := false
+	for _, c := range constraints {
+		if c.Kind != keepApart || parent[c.First] == "" || parent[c.Second] == "" {
+			continue
+		}
+		a, b := groupIndex[find(c.First)], groupIndex[find(c.Second)]
+		if a == b {
+			return nil, fmt.Errorf("%v and %v must sit together and apart", c.First, c.Second)
+		}
+		apart[a][b], apart[b][a] = true, true
+		anyApart = true
+	}
+
+	needed, capacity, largest := 0, 0, 0
+	for _, size := range sizes {
+		needed += size
+	}
+	for _, table := range tables {
+		capacity += table.Capacity
+		if table.Capacity > largest {
+			largest = table.Capacity
+		}
+	}
+	if needed > capacity {
+		return nil, fmt.Errorf("%v seats are needed but the tables have %v", needed, capacity)
+	}
+
+	order := make([]int, len(groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] > sizes[order[j]]
+	})
+	if len(order) > 0 && sizes[order[0]] > largest {
+		return nil, fmt.Errorf("%v guests must sit together but the largest table has %v seats",
+			sizes[order[0]], largest)
+	}
+
+	free := make([]int, len(tables))
+	seated := make([]int, len(tables))
+	for i, table := range tables {
+		free[i] = table.Capacity
+	}
+	placed := make([]int, len(groups))
+	fits := func(group, t int) bool {
+		if free[t] < sizes[group] {
+			return false
+		}
+		for other := range apart[group] {
+			if placed[other] == t + 1 {
+				return false
+			}
+		}
+		return true
+	}
+	seat := func(group, t, sign int) {
+		free[t] -= sign * sizes[group]
+		seated[t] += sign
+		if sign > 0 {
+			placed[group] = t + 1
+		} else {
+			placed[group] = 0
+		}
+	}
+
+	// Tables with the same seats left are interchangeable when they are
+	// empty, or when no one needs keeping apart, so only the first is tried.
+	steps := 0
+	var place func(int) bool
+	place = func(n int) bool {
+		if n == len(order) {
+			return true
+		}
+		group := order[n]
+		tried := map[int]bool{}
+		for t := range tables {
+			if steps >= maxSeatingSteps {
+				return false
+			}
+			if !fits(group, t) {
+				continue
+			}
+			interchangeable := !anyApart || seated[t] == 0
+			if interchangeable && tried[free[t]] {
+				continue
+			}
+			if interchangeable {
+				tried[free[t]] = true
+			}
+			steps++
+			seat(group, t, 1)
+			if place(n + 1) {
+				return true
+			}
+			seat(group, t, -1)
+		}
+		return false
+	}
+	if !place(0) {
+		if steps < maxSeatingSteps {
+			return nil, fmt.Errorf("the guests cannot be seated at the available tables")
+		}
+		for i := range placed {
+			placed[i] = 0
+		}
+		for t, table := range tables {
+			free[t], seated[t] = table.Capacity, 0
+		}
+		for _, group := range order {
+			t := 0
+			for t < len(tables) && !fits(group, t) {
+				t++
+			}
+			if t == len(tables) {
+				return nil, fmt.Errorf("no arrangement of the guests was found; seat some of them by hand or add tables")
+			}
+			seat(group, t, 1)
+		}
+	}
+
+	assignments := map[string]int{}
+	for group, keys := range groups {
+		for _, key := range keys {
+			assignments[key] = tables[placed[group] - 1].ID
+		}
+	}
+	return assignments, nil
+}
+
+type TableSeating struct {
+	*Table
+	Guests []*Rsvp
+	Used int
+}
+
+func (seating TableSeating) Free() int {
+	return seating.Capacity - seating.Used
+}
+
+type seatingData struct {
+	Tables []TableSeating
+	Unassigned []*Rsvp
+	Guests []*Rsvp
+	Constraints []SeatingConstraint
+	Errors []string
+}
+
+// arrangement matches the plan against the current attending guests, so that
+// anyone who has since declined drops out of the chart.
+func (plan *seatingPlan) arrangement(guests []*Rsvp) seatingData {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	data := seatingData{ Guests: guests, Unassigned: []*Rsvp{},
+		Constraints: append([]SeatingConstraint{}, plan.constraints...) }
+	index := map[int]int{}
+	for i, table := range plan.tables {
+		index[table.ID] = i
+		data.Tables = append(data.Tables, TableSeating{ Table: table })
+	}
+	for _, rsvp := range guests {
+		tableID, found := plan.assignments[emailKey(rsvp.Email)]
+		if !found {
+			data.Unassigned = append(data.Unassigned, rsvp)
+			continue
+		}
+		seating := &data.Tables[index[tableID]]
+		seating.Guests = append(seating.Guests, rsvp)
+		seating.Used += seats(rsvp)
+	}
+	return data
+}
+
+func attendingGuests(responses []*Rsvp) []*Rsvp {
+	guests := []*Rsvp{}
+	for _, rsvp := range responses {
+		if rsvp.WillAttend {
+			guests = append(guests, rsvp)
+		}
+	}
+	return guests
+}
+
+func (app *application) seatingHandler(writer http.ResponseWriter, request *http.Request) {
+	guests := attendingGuests(app.store.Responses())
+	errors := []string{}
+	if request.Method == http.MethodPost {
+		request.ParseForm()
+		var err error
+		switch request.Form.Get("action") {
+		case "table":
+			capacity, _ := strconv.Atoi(request.Form.Get("capacity"))
+			_, err = app.seating.AddTable(strings.TrimSpace(request.Form.Get("name")), capacity)
+		case "removetable":
+			id, _ := strconv.Atoi(request.Form.Get("id"))
+			if !app.seating.RemoveTable(id) {
+				err = fmt.Errorf("no table with id %v", id)
+			}
+		case "assign":
+			id, _ := strconv.Atoi(request.Form.Get("table"))
+			err = app.seating.Assign(guests, request.Form.Get("email"), id)
+		case "constraint":
+			err = app.seating.AddConstraint(request.Form.Get("kind"),
+				request.Form.Get("first"), request.Form.Get("second"))
+		case "removeconstraint":
+			index, _ := strconv.Atoi(request.Form.Get("index"))
+			if !app.seating.RemoveConstraint(index) {
+				err = fmt.Errorf("no such constraint")
+			}
+		case "auto":
+			err = app.seating.AutoAssign(guests)
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+		if err == nil {
+			http.Redirect(writer, request, "/seating", http.StatusSeeOther)
+			return
+		}
+		errors = append(errors, err.Error())
+	}
+	data := app.seating.arrangement(guests)
+	data.Errors = errors
+	app.render(writer, "seating", data)
+}
+
+func (app *application) seatingChartHandler(writer http.ResponseWriter, request *http.Request) {
+	app.render(writer, "chart", app.seating.arrangement(attendingGuests(app.store.Responses())))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func guestsOfSize(sizes ...int) []*Rsvp {
+	guests := make([]*Rsvp, len(sizes))
+	for i, size := range sizes {
+		guests[i] = &Rsvp{ Name: fmt.Sprint("Guest ", i), Email: fmt.Sprintf("guest%v@example.com", i),
+			Phone: "555-0100", WillAttend: true, PlusOnes: size - 1 }
+	}
+	return guests
+}
+
+func TestAutoAssign(t *testing.T) {
+	tests := []struct {
+		name string
+		capacities []int
+		sizes []int
+		constraints []SeatingConstraint
+		ok bool
+	}{
+		{ "fits", []int{ 4, 4 }, []int{ 3, 2, 2, 1 }, nil, true },
+		{ "too many guests", []int{ 4, 4 }, []int{ 3, 3, 3 }, nil, false },
+		{ "group larger than any table", []int{ 4, 4 }, []int{ 2, 3 },
+			[]SeatingConstraint{ { seatTogether, "guest0@example.com", "guest1@example.com" } }, false },
+		{ "kept apart", []int{ 2, 2 }, []int{ 1, 1, 1, 1 },
+			[]SeatingConstraint{ { keepApart, "guest0@example.com", "guest1@example.com" } }, true },
+		{ "together and apart", []int{ 4, 4 }, []int{ 1, 1, 1 }, []SeatingConstraint{
+			{ seatTogether, "guest0@example.com", "guest1@example.com" },
+			{ seatTogether, "guest1@example.com", "guest2@example.com" },
+			{ keepApart, "guest2@example.com", "guest0@example.com" },
+		}, false },
+		{ "apart with one table", []int{ 4 }, []int{ 1, 1 },
+			[]SeatingConstraint{ { keepApart, "guest0@example.com", "guest1@example.com" } }, false },
+		{ "seats left over but no packing", []int{ 5, 5, 5, 5, 5, 5, 5, 5, 5, 5 },
+			[]int{ 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3 }, nil, false },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := newSeatingPlan()
+			for i, capacity := range test.capacities {
+				if _, err := plan.AddTable(fmt.Sprint("Table ", i), capacity); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, c := range test.constraints {
+				if err := plan.AddConstraint(c.Kind, c.First, c.Second); err != nil && test.ok {
+					t.Fatal(err)
+				}
+			}
+			guests := guestsOfSize(test.sizes...)
+			started := time.Now()
+			err := plan.AutoAssign(guests)
+			if elapsed := time.Since(started); elapsed > 5*time.Second {
+				t.Errorf("AutoAssign took %v", elapsed)
+			}
+			if (err == nil) != test.ok {
+				t.Fatalf("AutoAssign error %v, want ok %v", err, test.ok)
+			}
+			if !test.ok {
+				if len(plan.assignments) != 0 {
+					t.Errorf("failed AutoAssign left %v assignments", len(plan.assignments))
+				}
+				return
+			}
+			data := plan.arrangement(guests)
+			if len(data.Unassigned) != 0 {
+				t.Errorf("%v guests left unseated", len(data.Unassigned))
+			}
+			for _, table := range data.Tables {
+				if table.Free() < 0 {
+					t.Errorf("%v is overfilled by %v", table.Name, -table.Free())
+				}
+			}
+			for _, c := range plan.constraints {
+				if c.Kind == keepApart && plan.assignments[c.First] == plan.assignments[c.Second] {
+					t.Errorf("%v and %v sit together", c.First, c.Second)
+				}
+			}
+		})
+	}
+}
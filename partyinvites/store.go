@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// rsvpStore holds the party details and every reply received, and is safe
+// for use by concurrent handlers.
+type rsvpStore struct {
+	mutex sync.Mutex
+	party Event
+	responses []*Rsvp
+	purged AggregateCounts
+	onPurge []func()
+	onRestore []func([]*Rsvp)
+}
+
+func newRsvpStore(party Event) *rsvpStore {
+	return &rsvpStore{ party: party, responses: make([]*Rsvp, 0, 10) }
+}
+
+func (store *rsvpStore) SetEventDate(date time.Time) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.party.Date = date
+}
+
+// Save stores a response, replacing any earlier reply from the same email
+// address, and returns the webhook event describing the change.
+func (store *rsvpStore) Save(responseData Rsvp) (string, *Rsvp) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, existing := range store.responses {
+		if strings.EqualFold(existing.Email, responseData.Email) {
+			event := eventRsvpUpdated
+			if existing.WillAttend && !responseData.WillAttend {
+				event = eventRsvpWithdrawn
+			}
+			store.responses[i] = &responseData
+			return event, &responseData
+		}
+	}
+	store.responses = append(store.responses, &responseData)
+	return eventRsvpCreated, &responseData
+}
+
+// Responses returns the stored replies in the order they were first received.
+// Saved replies are never modified in place, so the pointers can be read
+// without holding the lock.
+func (store *rsvpStore) Responses() []*Rsvp {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return append([]*Rsvp{}, store.responses...)
+}
+
+func (store *rsvpStore) Purged() AggregateCounts {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.purged
+}
+
+func (store *rsvpStore) Snapshot(now time.Time) Archive {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	copied := make([]*Rsvp, len(store.responses))
+	for i, rsvp := range store.responses {
+		r := *rsvp
+		copied[i] = &r
+	}
+	return Archive{
+		Version: archiveVersion,
+		Created: now.UTC(),
+		Events: []Event{ store.party },
+		Responses: copied,
+		Purged: store.purged,
+	}
+}
+
+// Restore replaces all data with the contents of a validated archive and
+// runs the OnRestore functions with the restored replies.
+func (store *rsvpStore) Restore(archive Archive) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.party = archive.Events[0]
+	store.responses = archive.Responses
+	store.purged = archive.Purged
+	for _, restored := range store.onRestore {
+		restored(append([]*Rsvp{}, store.responses...))
+	}
+}
+
+// OnRestore registers a function that Restore calls with the new replies,
+// so that anything kept about the old ones can be checked against them.
+func (store *rsvpStore) OnRestore(restored func([]*Rsvp)) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.onRestore = append(store.onRestore, restored)
+}
+
+// OnPurge registers a function that ApplyRetention calls after purging, so
+// that copies of guest details held elsewhere are removed with the replies.
+func (store *rsvpStore) OnPurge(purge func()) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.onPurge = append(store.onPurge, purge)
+}
+
+// ApplyRetention removes guest details once retentionDays have passed since
+// the party, keeping only the number of people who replied each way, and
+// runs the OnPurge functions. It reports whether anything was purged.
+func (store *rsvpStore) ApplyRetention(now time.Time, retentionDays int) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.party.Date.IsZero() || retentionDays < 0 || len(store.responses) == 0 {
+		return false
+	}
+	if now.Before(store.party.Date.AddDate(0, 0, retentionDays)) {
+		return false
+	}
+	for _, rsvp := range store.responses {
+		if rsvp.WillAttend {
+			store.purged.Attending++
+		} else {
+			store.purged.Declined++
+		}
+	}
+	store.purged.PurgedAt = now.UTC()
+	store.responses = make([]*Rsvp, 0, 10)
+	for _, purge := range store.onPurge {
+		purge()
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionPurgesCopiesOfGuestDetails(t *testing.T) {
+	party := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := newRsvpStore(Event{ Name: "Test", Date: party })
+	d, target, server, _ := testDispatcher(t, http.StatusServiceUnavailable)
+	seating := newSeatingPlan()
+	newApplication(nil, store, d, seating)
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	table, _ := seating.AddTable("Top", 4)
+	bob := Rsvp{ Name: "Bob", Email: "bob@example.com", Phone: "555-0101", WillAttend: true }
+	for _, rsvp := range []Rsvp{ *testGuest, bob } {
+		event, saved := store.Save(rsvp)
+		d.Publish(event, saved)
+	}
+	d.ProcessDue()
+	if err := seating.Assign(store.Responses(), testGuest.Email, table.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := seating.AddConstraint(keepApart, testGuest.Email, bob.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.ApplyRetention(party.AddDate(0, 0, 29), 30) {
+		t.Fatal("purged before the retention period ended")
+	}
+	if !store.ApplyRetention(party.AddDate(0, 0, 30), 30) {
+		t.Fatal("nothing was purged")
+	}
+	for _, delivery := range d.deliveries {
+		if bytes.Contains(delivery.body, []byte(testGuest.Email)) || delivery.Status == deliveryPending {
+			t.Errorf("delivery %v still holds guest details: %v %s", delivery.ID, delivery.Status, delivery.body)
+		}
+	}
+	if sent := len(target.received()); d.ProcessDue() != 0 || len(target.received()) != sent {
+		t.Error("purged deliveries were retried")
+	}
+	data := seating.arrangement(nil)
+	if len(seating.assignments) != 0 || len(data.Constraints) != 0 || len(data.Tables) != 1 {
+		t.Errorf("seating plan kept %v assignments and %v constraints", len(seating.assignments), len(data.Constraints))
+	}
+}
+
+func TestDeliveryLogIsCapped(t *testing.T) {
+	d, _, server, _ := testDispatcher(t)
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxDeliveryLog + 50; i++ {
+		d.Publish(eventRsvpUpdated, testGuest)
+		d.ProcessDue()
+	}
+	log := d.Deliveries()
+	if len(log) != maxDeliveryLog {
+		t.Fatalf("log holds %v deliveries, want %v", len(log), maxDeliveryLog)
+	}
+	if log[0].ID != maxDeliveryLog + 50 {
+		t.Errorf("newest delivery is %v, want %v", log[0].ID, maxDeliveryLog + 50)
+	}
+}
+
+func TestRestoreChecksSeatingAndWebhooks(t *testing.T) {
+	store := newRsvpStore(Event{ Name: "Test" })
+	d, _, server, _ := testDispatcher(t, http.StatusServiceUnavailable)
+	seating := newSeatingPlan()
+	newApplication(nil, store, d, seating)
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	table, _ := seating.AddTable("Top", 3)
+	bob := Rsvp{ Name: "Bob", Email: "bob@example.com", Phone: "555-0101", WillAttend: true }
+	carol := Rsvp{ Name: "Carol", Email: "carol@example.com", Phone: "555-0102", WillAttend: true }
+	for _, rsvp := range []Rsvp{ *testGuest, bob, carol } {
+		event, saved := store.Save(rsvp)
+		d.Publish(event, saved)
+		if err := seating.Assign(store.Responses(), rsvp.Email, table.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := seating.AddConstraint(keepApart, testGuest.Email, carol.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice now brings two friends, Bob has withdrawn and Carol is gone.
+	alice, withdrawn := *testGuest, bob
+	alice.PlusOnes, withdrawn.WillAttend = 2, false
+	archive := store.Snapshot(time.Now())
+	archive.Responses = []*Rsvp{ &withdrawn, &alice }
+	store.Restore(archive)
+
+	if len(seating.assignments) != 1 || seating.assignments[emailKey(alice.Email)] != table.ID {
+		t.Errorf("assignments %v, want only Alice at %v", seating.assignments, table.ID)
+	}
+	if len(seating.constraints) != 0 {
+		t.Errorf("kept constraints %v naming a guest with no reply", seating.constraints)
+	}
+	for _, delivery := range d.Deliveries() {
+		if delivery.Status == deliveryPending {
+			t.Errorf("delivery %v for a replaced reply is still pending", delivery.ID)
+		}
+	}
+}
+
+func TestBackupFromServer(t *testing.T) {
+	party := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	app := testApplication(t, *testGuest)
+	app.store.SetEventDate(party)
+	app.adminPassword = "letmein"
+	server := httptest.NewServer(app.routes())
+	t.Cleanup(server.Close)
+	path := filepath.Join(t.TempDir(), "backup.json")
+
+	if err := backupFromServer(server.URL, "guess", path, 30); err == nil {
+		t.Error("backup with the wrong password succeeded")
+	}
+	tests := []struct {
+		name string
+		retentionDays, responses, attending int
+	}{
+		{ "kept", 100000, 1, 0 },
+		{ "purged", 0, 0, 1 },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := backupFromServer(server.URL, "letmein", path, test.retentionDays); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			archive, err := readArchive(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(archive.Responses) != test.responses || archive.Purged.Attending != test.attending {
+				t.Errorf("archive has %v responses and %v purged attending, want %v and %v",
+					len(archive.Responses), archive.Purged.Attending, test.responses, test.attending)
+			}
+		})
+	}
+	if len(app.store.Responses()) != 1 {
+		t.Error("backup purged the server's replies")
+	}
+}
+
+func TestAdminPagesNeedPassword(t *testing.T) {
+	app := testApplication(t, *testGuest)
+	app.adminPassword = "letmein"
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/admin/backup", nil),
+		httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewReader(nil)),
+	} {
+		if response := serve(app, request); response.Code != http.StatusUnauthorized {
+			t.Errorf("%v %v status %v, want %v", request.Method, request.URL, response.Code, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	eventRsvpCreated = "rsvp.created"
+	eventRsvpUpdated = "rsvp.updated"
+	eventRsvpWithdrawn = "rsvp.withdrawn"
+	eventPing = "ping"
+)
+
+var webhookEvents = []string{eventRsvpCreated, eventRsvpUpdated, eventRsvpWithdrawn}
+
+const signatureHeader = "X-Party-Signature"
+
+type Webhook struct {
+	ID int
+	URL, Secret string
+	Events []string
+}
+
+func (hook *Webhook) Subscribes(event string) bool {
+	if event == eventPing {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookPayload struct {
+	Delivery int `json:"delivery"`
+	Event string `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Rsvp *Rsvp `json:"rsvp,omitempty"`
+}
+
+const (
+	deliveryPending = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed = "failed"
+)
+
+type WebhookDelivery struct {
+	ID, WebhookID int
+	URL, Event string
+	Status string
+	Attempts int
+	StatusCode int
+	LastError string
+	Created, NextAttempt, Completed time.Time
+	body []byte
+	secret string
+	sending bool
+}
+
+// signPayload returns the value of the signature header for body, which
+// receivers verify by computing the same HMAC with their shared secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkWebhookIP refuses addresses inside this network or the host itself,
+// so a webhook cannot be used to reach services that are not public.
+func checkWebhookIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+			ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("webhooks cannot be sent to %v", ip)
+	}
+	return nil
+}
+
+// newWebhookClient returns a client that gives up on a receiver after
+// timeout and checks the address every host name resolves to, including
+// those reached by redirects.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("webhooks cannot be sent to %v", host)
+			}
+			return checkWebhookIP(ip)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{ Transport: transport, Timeout: timeout }
+}
+
+// maxDeliveryLog is the number of deliveries kept in the log; the oldest
+// finished ones are dropped beyond it.
+const maxDeliveryLog = 500
+
+// webhookDispatcher sends deliveries concurrently, at most Concurrency at a
+// time, and gives up on each request after Timeout.
+type webhookDispatcher struct {
+	mutex sync.Mutex
+	client *http.Client
+	hooks []*Webhook
+	deliveries []*WebhookDelivery
+	nextHookID, nextDeliveryID int
+	MaxAttempts int
+	BaseDelay time.Duration
+	Timeout time.Duration
+	Concurrency int
+	now func() time.Time
+	wake chan struct{}
+	allowPrivate bool
+}
+
+func newWebhookDispatcher(client *http.Client) *webhookDispatcher {
+	return &webhookDispatcher{
+		client: client,
+		MaxAttempts: 6,
+		BaseDelay: 5 * time.Second,
+		Timeout: 10 * time.Second,
+		Concurrency: 8,
+		now: time.Now,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (d *webhookDispatcher) Subscribe(target, secret string, events []string) (*Webhook, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid webhook URL: %q", target)
+	}
+	if !d.allowPrivate {
+		host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return nil, fmt.Errorf("webhooks cannot be sent to %v", host)
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if err := checkWebhookIP(ip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("a signing secret is required")
+	}
+	if len(events) == 0 {
+		events = webhookEvents
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("unknown event: %q", event)
+		}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.nextHookID++
+	hook := &Webhook{ ID: d.nextHookID, URL: target, Secret: secret, Events: events }
+	d.hooks = append(d.hooks, hook)
+	return hook, nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe removes a webhook and abandons any deliveries still queued
+// for it.
+func (d *webhookDispatcher) Unsubscribe(id int) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for i, hook := range d.hooks {
+		if hook.ID == id {
+			d.hooks = append(d.hooks[:i], d.hooks[i+1:]...)
+			for _, delivery := range d.deliveries {
+				if delivery.WebhookID == id && delivery.Status == deliveryPending {
+					delivery.Status = deliveryFailed
+					delivery.LastError = "webhook removed"
+					delivery.Completed = d.now()
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *webhookDispatcher) Hooks() []*Webhook {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]*Webhook{}, d.hooks...)
+}
+
+// Deliveries returns a copy of the delivery log, newest first.
+func (d *webhookDispatcher) Deliveries() []WebhookDelivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	log := make([]WebhookDelivery, 0, len(d.deliveries))
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		log = append(log, *d.deliveries[i])
+	}
+	return log
+}
+
+// Publish queues a delivery of event to every subscribed webhook.
+func (d *webhookDispatcher) Publish(event string, rsvp *Rsvp) {
+	d.mutex.Lock()
+	for _, hook := range d.hooks {
+		if hook.Subscribes(event) {
+			d.enqueue(hook, event, rsvp)
+		}
+	}
+	d.mutex.Unlock()
+	d.notify()
+}
+
+// Test queues a ping delivery to a single webhook.
+func (d *webhookDispatcher) Test(id int) (*WebhookDelivery, error) {
+	d.mutex.Lock()
+	var delivery *WebhookDelivery
+	for _, hook := range d.hooks {
+		if hook.ID == id {
+			delivery = d.enqueue(hook, eventPing, nil)
+		}
+	}
+	d.mutex.Unlock()
+	if delivery == nil {
+		return nil, fmt.Errorf("no webhook with id %v", id)
+	}
+	d.notify()
+	return delivery, nil
+}
+
+func (d *webhookDispatcher) enqueue(hook *Webhook, event string, rsvp *Rsvp) *WebhookDelivery {
+	d.nextDeliveryID++
+	now := d.now()
+	var snapshot *Rsvp
+	if rsvp != nil {
+		copied := *rsvp
+		snapshot = &copied
+	}
+	body, _ := json.Marshal(webhookPayload{
+		Delivery: d.nextDeliveryID, Event: event, Timestamp: now.UTC(), Rsvp: snapshot,
+	})
+	delivery := &WebhookDelivery{
+		ID: d.nextDeliveryID, WebhookID: hook.ID, URL: hook.URL, Event: event,
+		Status: deliveryPending, Created: now, NextAttempt: now,
+		body: body, secret: hook.Secret,
+	}
+	d.deliveries = append(d.deliveries, delivery)
+	d.trim()
+	return delivery
+}
+
+func (d *webhookDispatcher) trim() {
+	kept := d.deliveries[:0]
+	excess := len(d.deliveries) - maxDeliveryLog
+	for _, delivery := range d.deliveries {
+		if excess > 0 && delivery.Status != deliveryPending {
+			excess--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	for i := len(kept); i < len(d.deliveries); i++ {
+		d.deliveries[i] = nil
+	}
+	d.deliveries = kept
+}
+
+// Forget drops the guest details carried by logged deliveries. Deliveries
+// not yet made are abandoned rather than sent without their RSVP.
+func (d *webhookDispatcher) Forget() {
+	d.forget("guest details purged")
+}
+
+// Restored forgets the deliveries made for replies that a restore has
+// replaced, so that none are sent about guests the archive may not have.
+func (d *webhookDispatcher) Restored([]*Rsvp) {
+	d.forget("replies restored from an archive")
+}
+
+func (d *webhookDispatcher) forget(reason string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, delivery := range d.deliveries {
+		if delivery.Event == eventPing {
+			continue
+		}
+		delivery.body = nil
+		if delivery.Status == deliveryPending {
+			delivery.Status = deliveryFailed
+			delivery.LastError = reason
+			delivery.Completed = d.now()
+		}
+	}
+}
+
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessDue attempts every pending delivery whose retry time has passed,
+// waits for the attempts to finish and returns the number made. Deliveries
+// already being sent by an earlier call are left to it.
+func (d *webhookDispatcher) ProcessDue() int {
+	d.mutex.Lock()
+	now := d.now()
+	due := []*WebhookDelivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.Status == deliveryPending && !delivery.sending && !delivery.NextAttempt.After(now) {
+			delivery.sending = true
+			due = append(due, delivery)
+		}
+	}
+	d.mutex.Unlock()
+
+	concurrency := d.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var group sync.WaitGroup
+	for _, delivery := range due {
+		group.Add(1)
+		slots <- struct{}{}
+		go func(delivery *WebhookDelivery) {
+			defer group.Done()
+			d.attempt(delivery)
+			<-slots
+		}(delivery)
+	}
+	group.Wait()
+	return len(due)
+}
+
+// attempt sends a copy of the delivery taken under the lock, and drops the
+// outcome if the delivery was abandoned while the request was in flight.
+func (d *webhookDispatcher) attempt(delivery *WebhookDelivery) {
+	d.mutex.Lock()
+	snapshot := *delivery
+	d.mutex.Unlock()
+	statusCode, err := 0, fmt.Errorf("delivery abandoned")
+	if snapshot.Status == deliveryPending {
+		statusCode, err = d.send(&snapshot)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delivery.sending = false
+	if delivery.Status != deliveryPending {
+		return
+	}
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	if err == nil {
+		delivery.Status = deliveryDelivered
+		delivery.LastError = ""
+		delivery.Completed = d.now()
+		return
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = deliveryFailed
+		delivery.Completed = d.now()
+		return
+	}
+	delivery.NextAttempt = d.now().Add(d.backoff(delivery.Attempts))
+}
+
+// backoff doubles the delay after each failed attempt.
+func (d *webhookDispatcher) backoff(attempts int) time.Duration {
+	return d.BaseDelay << (attempts - 1)
+}
+
+func (d *webhookDispatcher) send(delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Party-Event", delivery.Event)
+	request.Header.Set("X-Party-Delivery", strconv.Itoa(delivery.ID))
+	request.Header.Set(signatureHeader, signPayload(delivery.secret, delivery.body))
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded %v", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Run processes the retry queue until stop is closed.
+func (d *webhookDispatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		d.ProcessDue()
+	}
+}
+
+type webhooksData struct {
+	Hooks []*Webhook
+	Deliveries []WebhookDelivery
+	Events []string
+	Errors []string
+}
+
+func (app *application) webhooksHandler(writer http.ResponseWriter, request *http.Request) {
+	errors := []string{}
+	if request.Method == http.MethodPost {
+		request.ParseForm()
+		var err error
+		switch request.Form.Get("action") {
+		case "add":
+			_, err = app.webhooks.Subscribe(strings.TrimSpace(request.Form.Get("url")),
+				request.Form.Get("secret"), request.Form["events"])
+		case "delete":
+			id, _ := strconv.Atoi(request.Form.Get("id"))
+			if !app.webhooks.Unsubscribe(id) {
+				err = fmt.Errorf("no webhook with id %v", id)
+			}
+		case "test":
+			id, _ := strconv.Atoi(request.Form.Get("id"))
+			_, err = app.webhooks.Test(id)
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+		if err == nil {
+			http.Redirect(writer, request, "/webhooks", http.StatusSeeOther)
+			return
+		}
+		errors = append(errors, err.Error())
+	}
+	app.render(writer, "webhooks", webhooksData{
+		Hooks: app.webhooks.Hooks(), Deliveries: app.webhooks.Deliveries(),
+		Events: webhookEvents, Errors: errors,
+	})
+}
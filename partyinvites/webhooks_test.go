@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook endpoint that answers with the queued status
+// codes in turn, then with 200, and records every request it gets.
+type receiver struct {
+	mutex sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body []byte
+}
+
+func (r *receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, receivedRequest{ request.Header.Clone(), body })
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	writer.WriteHeader(status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]receivedRequest{}, r.requests...)
+}
+
+// testDispatcher returns a dispatcher posting to a local receiver, with a
+// clock the test moves by hand. Loopback addresses are allowed for it.
+func testDispatcher(t *testing.T, statuses ...int) (*webhookDispatcher, *receiver, *httptest.Server, *time.Time) {
+	target := &receiver{ statuses: statuses }
+	server := httptest.NewServer(target)
+	t.Cleanup(server.Close)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	d := newWebhookDispatcher(server.Client())
+	d.now = func() time.Time { return now }
+	d.allowPrivate = true
+	return d, target, server, &now
+}
+
+var testGuest = &Rsvp{ Name: "Alice", Email: "alice@example.com", Phone: "555-0100", WillAttend: true }
+
+func TestWebhookSignature(t *testing.T) {
+	d, target, server, _ := testDispatcher(t)
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(eventRsvpCreated, testGuest)
+	if attempts := d.ProcessDue(); attempts != 1 {
+		t.Fatalf("made %v attempts, want 1", attempts)
+	}
+	requests := target.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %v requests, want 1", len(requests))
+	}
+	got := requests[0]
+	if want := signPayload("s3cret", got.body); got.header.Get(signatureHeader) != want {
+		t.Errorf("signature %q, want %q", got.header.Get(signatureHeader), want)
+	}
+	if got.header.Get(signatureHeader) == signPayload("wrong", got.body) {
+		t.Error("signature does not depend on the secret")
+	}
+	if event := got.header.Get("X-Party-Event"); event != eventRsvpCreated {
+		t.Errorf("event header %q, want %q", event, eventRsvpCreated)
+	}
+	if status := d.Deliveries()[0].Status; status != deliveryDelivered {
+		t.Errorf("delivery is %v, want %v", status, deliveryDelivered)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	d, target, server, now := testDispatcher(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	d.BaseDelay = 5 * time.Second
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(eventRsvpCreated, testGuest)
+
+	steps := []struct {
+		advance time.Duration
+		attempts int
+		status string
+	}{
+		{ 0, 1, deliveryPending },
+		{ 4 * time.Second, 0, deliveryPending },
+		{ time.Second, 1, deliveryPending },
+		{ 9 * time.Second, 0, deliveryPending },
+		{ time.Second, 1, deliveryDelivered },
+	}
+	for i, step := range steps {
+		*now = now.Add(step.advance)
+		if attempts := d.ProcessDue(); attempts != step.attempts {
+			t.Fatalf("step %v: made %v attempts, want %v", i, attempts, step.attempts)
+		}
+		if status := d.Deliveries()[0].Status; status != step.status {
+			t.Fatalf("step %v: delivery is %v, want %v", i, status, step.status)
+		}
+	}
+	if requests := target.received(); len(requests) != 3 {
+		t.Errorf("receiver got %v requests, want 3", len(requests))
+	}
+	if delivery := d.Deliveries()[0]; delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK {
+		t.Errorf("delivery made %v attempts ending in %v", delivery.Attempts, delivery.StatusCode)
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	d, target, server, now := testDispatcher(t, 500, 500, 500)
+	d.MaxAttempts = 3
+	if _, err := d.Subscribe(server.URL, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(eventRsvpCreated, testGuest)
+	for i := 0; i < 5; i++ {
+		d.ProcessDue()
+		*now = now.Add(time.Hour)
+	}
+	if requests := target.received(); len(requests) != 3 {
+		t.Errorf("receiver got %v requests, want 3", len(requests))
+	}
+	if status := d.Deliveries()[0].Status; status != deliveryFailed {
+		t.Errorf("delivery is %v, want %v", status, deliveryFailed)
+	}
+}
+
+func TestUnsubscribeStopsDeliveries(t *testing.T) {
+	d, target, server, now := testDispatcher(t, 500, 500, 500)
+	hook, err := d.Subscribe(server.URL, "s3cret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(eventRsvpCreated, testGuest)
+	d.ProcessDue()
+	if !d.Unsubscribe(hook.ID) {
+		t.Fatal("webhook was not removed")
+	}
+	d.Publish(eventRsvpUpdated, testGuest)
+	*now = now.Add(time.Hour)
+	if attempts := d.ProcessDue(); attempts != 0 {
+		t.Errorf("made %v attempts after unsubscribing, want 0", attempts)
+	}
+	if requests := target.received(); len(requests) != 1 {
+		t.Errorf("receiver got %v requests, want 1", len(requests))
+	}
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].Status != deliveryFailed {
+		t.Errorf("delivery log %+v, want one abandoned delivery", deliveries)
+	}
+}
+
+func TestSubscribeRefusesPrivateAddresses(t *testing.T) {
+	d := newWebhookDispatcher(newWebhookClient(time.Second))
+	tests := []struct {
+		url string
+		ok bool
+	}{
+		{ "https://hooks.example.com/party", true },
+		{ "http://203.0.113.10:8080/hook", true },
+		{ "ftp://hooks.example.com/party", false },
+		{ "http://localhost:3000/", false },
+		{ "http://api.localhost/", false },
+		{ "http://127.0.0.1/", false },
+		{ "http://[::1]/", false },
+		{ "http://10.1.2.3/", false },
+		{ "http://192.168.0.1/", false },
+		{ "http://169.254.169.254/latest/meta-data", false },
+		{ "http://0.0.0.0/", false },
+	}
+	for _, test := range tests {
+		_, err := d.Subscribe(test.url, "s3cret", nil)
+		if (err == nil) != test.ok {
+			t.Errorf("Subscribe(%q) error %v, want ok %v", test.url, err, test.ok)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(&receiver{})
+	t.Cleanup(server.Close)
+	response, err := newWebhookClient(time.Second).Post(server.URL, "application/json", nil)
+	if err == nil {
+		response.Body.Close()
+		t.Fatal("client reached a loopback address")
+	}
+}
+
+func TestSlowReceiverDoesNotHoldUpOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-release:
+		case <-request.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	d, target, server, _ := testDispatcher(t)
+	d.Timeout = 200 * time.Millisecond
+	for _, url := range []string{ slow.URL, server.URL } {
+		if _, err := d.Subscribe(url, "s3cret", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Publish(eventRsvpCreated, testGuest)
+	started := time.Now()
+	if attempts := d.ProcessDue(); attempts != 2 {
+		t.Fatalf("made %v attempts, want 2", attempts)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ProcessDue took %v despite the timeout", elapsed)
+	}
+	if len(target.received()) != 1 {
+		t.Errorf("fast receiver got %v requests, want 1", len(target.received()))
+	}
+	for _, delivery := range d.Deliveries() {
+		switch delivery.URL {
+		case slow.URL:
+			if delivery.Status != deliveryPending || delivery.LastError == "" {
+				t.Errorf("slow delivery %v (%q), want a timed-out retry", delivery.Status, delivery.LastError)
+			}
+		case server.URL:
+			if delivery.Status != deliveryDelivered {
+				t.Errorf("fast delivery %v, want %v", delivery.Status, deliveryDelivered)
+			}
+		}
+	}
+}
+
+func TestWebhooksPageNeedsAdmin(t *testing.T) {
+	app := testApplication(t)
+	app.adminPassword = "letmein"
+	tests := []struct {
+		name, password string
+		status int
+	}{
+		{ "no credentials", "", http.StatusUnauthorized },
+		{ "wrong password", "guess", http.StatusUnauthorized },
+		{ "admin", "letmein", http.StatusOK },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+			if test.password != "" {
+				request.SetBasicAuth("admin", test.password)
+			}
+			if response := serve(app, request); response.Code != test.status {
+				t.Errorf("status %v, want %v", response.Code, test.status)
+			}
+		})
+	}
+	app.adminPassword = ""
+	request := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+	request.SetBasicAuth("admin", "")
+	if response := serve(app, request); response.Code != http.StatusUnauthorized {
+		t.Errorf("status %v with no admin password set, want %v", response.Code, http.StatusUnauthorized)
+	}
+}